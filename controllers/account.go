@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"anshumanbiswas.com/blog/models"
+)
+
+// ForgotPassword renders the form that requests a password reset email.
+func (u Users) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email           string
+		LoggedIn        bool
+		SignupDisabled  bool
+		IsAdmin         bool
+		Description     string
+		CurrentPage     string
+		Username        string
+		Message         string
+		UserPermissions models.UserPermissions
+	}
+	data.Email = r.FormValue("email")
+	data.SignupDisabled, _ = strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
+	data.Description = "Forgot Password - Anshuman Biswas Blog"
+	data.CurrentPage = "signin"
+	data.Message = r.URL.Query().Get("message")
+	data.UserPermissions = models.GetPermissions(models.RoleCommenter)
+	u.Templates.ForgotPassword.Execute(w, r, data)
+}
+
+// ProcessForgotPassword emails a reset link if the address belongs to an
+// account. The response is the same either way so it cannot be used to
+// discover registered emails.
+func (u Users) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	const sent = "If an account exists for that address, a reset link is on its way."
+
+	reset, err := u.PasswordResetService.Create(email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			log.Printf("Failed to create password reset for %s: %v", email, err)
+		}
+		http.Redirect(w, r, "/forgot-password?message="+url.QueryEscape(sent), http.StatusFound)
+		return
+	}
+
+	resetURL := absoluteURL(r, "/reset-password?token="+url.QueryEscape(reset.Token))
+	if err := u.EmailService.SendPasswordReset(email, resetURL, models.DefaultResetDuration); err != nil {
+		log.Printf("Failed to send password reset email to %s: %v", email, err)
+	}
+
+	http.Redirect(w, r, "/forgot-password?message="+url.QueryEscape(sent), http.StatusFound)
+}
+
+// ResetPassword renders the new-password form for a valid reset token.
+func (u Users) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email           string
+		LoggedIn        bool
+		SignupDisabled  bool
+		IsAdmin         bool
+		Description     string
+		CurrentPage     string
+		Username        string
+		Message         string
+		Token           string
+		InvalidToken    bool
+		UserPermissions models.UserPermissions
+	}
+	data.SignupDisabled, _ = strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
+	data.Description = "Reset Password - Anshuman Biswas Blog"
+	data.CurrentPage = "signin"
+	data.Message = r.URL.Query().Get("message")
+	data.Token = r.URL.Query().Get("token")
+	data.UserPermissions = models.GetPermissions(models.RoleCommenter)
+
+	user, err := u.PasswordResetService.Lookup(data.Token)
+	if err != nil {
+		data.InvalidToken = true
+	} else {
+		data.Email = user.Email
+	}
+	u.Templates.ResetPassword.Execute(w, r, data)
+}
+
+// ProcessResetPassword consumes a reset token, sets the new password and
+// signs the user out everywhere.
+func (u Users) ProcessResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	if password == "" || password != confirmPassword {
+		http.Redirect(w, r, "/reset-password?token="+url.QueryEscape(token)+"&message="+url.QueryEscape("Passwords do not match"), http.StatusFound)
+		return
+	}
+
+	user, err := u.PasswordResetService.Consume(token, password)
+	if err != nil {
+		log.Printf("Password reset failed: %v", err)
+		http.Redirect(w, r, "/forgot-password?message="+url.QueryEscape("That reset link is invalid or has expired. Please request a new one."), http.StatusFound)
+		return
+	}
+
+	// Proving control of the mailbox is enough to lift a sign-in lockout.
+	if err := u.LoginThrottle.Unlock(user.UserID, clientIP(r), "password reset"); err != nil {
+		log.Printf("Failed to clear sign-in lockout for user %d: %v", user.UserID, err)
+	}
+
+	u.SessionService.Logout(user.Email)
+	deleteCookie(w, CookieSession, "XXXXXX")
+	deleteCookie(w, CookieUserEmail, "XXXXXXX")
+
+	http.Redirect(w, r, "/signin?message="+url.QueryEscape("Your password has been reset. Please sign in.")+"&email="+url.QueryEscape(user.Email), http.StatusFound)
+}
+
+// VerifyEmail confirms an email address from a verification link. For an
+// email change, this is the point where the new address takes effect.
+func (u Users) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ev, err := u.EmailVerificationService.Consume(r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("Email verification failed: %v", err)
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape("That confirmation link is invalid or has expired."), http.StatusFound)
+		return
+	}
+
+	// Keep the current browser signed in if it belongs to this user, since
+	// sessions are looked up by the email cookie.
+	if user, err := u.isUserLoggedIn(r); err == nil && user.UserID == ev.UserID {
+		setCookie(w, CookieUserEmail, ev.Email)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Email address confirmed: "+ev.Email), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, "/signin?message="+url.QueryEscape("Email address confirmed. You can now sign in.")+"&email="+url.QueryEscape(ev.Email), http.StatusFound)
+}
+
+// sendEmailVerification issues a verification token for email and mails the
+// confirmation link to that address.
+func (u Users) sendEmailVerification(r *http.Request, userID int, email string) error {
+	ev, err := u.EmailVerificationService.Create(userID, email)
+	if err != nil {
+		return fmt.Errorf("send verification: %w", err)
+	}
+	verifyURL := absoluteURL(r, "/verify-email?token="+url.QueryEscape(ev.Token))
+	if err := u.EmailService.SendEmailVerification(ev.Email, verifyURL, models.DefaultVerificationDuration); err != nil {
+		return fmt.Errorf("send verification: %w", err)
+	}
+	return nil
+}
+
+// absoluteURL builds a link for use outside the browser (e.g. in emails).
+// APP_BASE_URL takes precedence over the request's host.
+func absoluteURL(r *http.Request, path string) string {
+	if base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/"); base != "" {
+		return base + path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path)
+}
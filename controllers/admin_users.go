@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type adminUsersPage struct {
+	Email           string
+	LoggedIn        bool
+	Username        string
+	IsAdmin         bool
+	SignupDisabled  bool
+	Description     string
+	CurrentPage     string
+	Flash           string
+	Users           []*models.ManagedUser
+	Invitations     []*models.Invitation
+	Roles           []*models.Role
+	Filter          models.UserFilter
+	CurrentUserID   int
+	UserPermissions models.UserPermissions
+	// DeleteUser and ReassignTo are set on the delete confirmation step.
+	DeleteUser *models.ManagedUser
+	ReassignTo []*models.ManagedUser
+}
+
+func adminUsersRedirect(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/admin/users?message="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// targetUserID reads {userID} and refuses actions an administrator must not
+// take on their own account. It writes the response and returns 0 on error.
+func targetUserID(w http.ResponseWriter, r *http.Request, self *models.User, allowSelf bool) int {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0
+	}
+	if !allowSelf && userID == self.UserID {
+		adminUsersRedirect(w, r, "You can't do that to your own account")
+		return 0
+	}
+	return userID
+}
+
+func (u Users) renderAdminUsers(w http.ResponseWriter, r *http.Request, user *models.User, data adminUsersPage) {
+	roles, err := u.RoleService.GetAllRoles()
+	if err != nil {
+		log.Printf("Error getting roles: %v", err)
+	}
+	data.Email = user.Email
+	data.LoggedIn = true
+	data.Username = user.Username
+	data.IsAdmin = models.IsAdmin(user.Role)
+	data.SignupDisabled = true
+	data.Description = "Manage Users - Anshuman Biswas Blog"
+	data.CurrentPage = "admin-users"
+	data.Roles = roles
+	data.CurrentUserID = user.UserID
+	data.UserPermissions = models.GetPermissions(user.Role)
+	u.Templates.AdminUsers.Execute(w, r, data)
+}
+
+// AdminUsers lists users, filtered by ?q=, ?role= and ?status=.
+func (u Users) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	user := u.requireUserManager(w, r)
+	if user == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	filter := models.UserFilter{Query: q.Get("q"), Status: q.Get("status")}
+	filter.RoleID, _ = strconv.Atoi(q.Get("role"))
+	if filter.Status != models.UserStatusActive && filter.Status != models.UserStatusDeactivated {
+		filter.Status = ""
+	}
+
+	users, err := u.UserService.Search(filter)
+	if err != nil {
+		log.Printf("Error searching users: %v", err)
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	invitations, err := u.InvitationService.Recent(50)
+	if err != nil {
+		log.Printf("Error listing invitations: %v", err)
+	}
+	u.renderAdminUsers(w, r, user, adminUsersPage{
+		Flash:       q.Get("message"),
+		Users:       users,
+		Invitations: invitations,
+		Filter:      filter,
+	})
+}
+
+// InviteUser emails an invitation to join with the chosen role. The account
+// is created when the invitee accepts.
+func (u Users) InviteUser(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	email := models.NormalizeEmail(r.FormValue("email"))
+	roleID, _ := strconv.Atoi(r.FormValue("role_id"))
+	if !strings.Contains(email, "@") {
+		adminUsersRedirect(w, r, "Enter a valid email address")
+		return
+	}
+	if _, err := u.RoleService.GetRole(roleID); err != nil {
+		adminUsersRedirect(w, r, "Choose a role for the new user")
+		return
+	}
+
+	inv, err := u.InvitationService.Create(email, roleID, admin.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrEmailTaken) {
+			adminUsersRedirect(w, r, "A user with that email already exists")
+			return
+		}
+		log.Printf("Failed to create invitation for %s: %v", email, err)
+		adminUsersRedirect(w, r, "Could not create invitation")
+		return
+	}
+	u.audit(r, admin, models.AuditEvent{
+		Action:      models.AuditInvitationCreate,
+		TargetType:  "invitation",
+		TargetID:    strconv.Itoa(inv.ID),
+		TargetLabel: inv.Email,
+		After:       "role: " + inv.RoleName,
+	})
+	if err := u.sendInvitation(r, inv); err != nil {
+		log.Printf("Failed to send invitation to %s: %v", email, err)
+		adminUsersRedirect(w, r, "Invitation created, but the email could not be sent. Try resending it.")
+		return
+	}
+	adminUsersRedirect(w, r, "Invitation sent to "+inv.Email)
+}
+
+// ChangeUserRole assigns a different role to a user.
+func (u Users) ChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	userID := targetUserID(w, r, admin, false)
+	if userID == 0 {
+		return
+	}
+	roleID, _ := strconv.Atoi(r.FormValue("role_id"))
+	role, err := u.RoleService.GetRole(roleID)
+	if err != nil {
+		adminUsersRedirect(w, r, "Unknown role")
+		return
+	}
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		adminUsersRedirect(w, r, "User not found")
+		return
+	}
+	if err := u.UserService.UpdateRole(userID, role.ID); err != nil {
+		log.Printf("Failed to change role of user %d: %v", userID, err)
+		adminUsersRedirect(w, r, "Could not change role")
+		return
+	}
+	u.auditUser(r, admin, models.AuditUserRoleChange, target, "role: "+target.RoleName, "role: "+role.Name)
+	adminUsersRedirect(w, r, "Role changed to "+role.Name)
+}
+
+// DeactivateUser blocks a user from signing in and ends their sessions.
+func (u Users) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	u.setUserActive(w, r, false)
+}
+
+// ReactivateUser lets a deactivated user sign in again.
+func (u Users) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	u.setUserActive(w, r, true)
+}
+
+func (u Users) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	userID := targetUserID(w, r, admin, false)
+	if userID == 0 {
+		return
+	}
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		adminUsersRedirect(w, r, "User not found")
+		return
+	}
+	if err := u.UserService.SetActive(userID, active); err != nil {
+		log.Printf("Failed to set user %d active=%t: %v", userID, active, err)
+		adminUsersRedirect(w, r, "Could not update user")
+		return
+	}
+	if active {
+		u.auditUser(r, admin, models.AuditUserReactivate, target, "deactivated", "active")
+	} else {
+		u.auditUser(r, admin, models.AuditUserDeactivate, target, "active", "deactivated")
+	}
+	if active {
+		adminUsersRedirect(w, r, "User reactivated")
+	} else {
+		adminUsersRedirect(w, r, "User deactivated")
+	}
+}
+
+// ForcePasswordReset signs a user out everywhere and makes them choose a new
+// password, emailing them a reset link.
+func (u Users) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	userID := targetUserID(w, r, admin, false)
+	if userID == 0 {
+		return
+	}
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		adminUsersRedirect(w, r, "User not found")
+		return
+	}
+	if err := u.UserService.RequirePasswordReset(userID); err != nil {
+		log.Printf("Failed to require password reset for user %d: %v", userID, err)
+		adminUsersRedirect(w, r, "Could not force a password reset")
+		return
+	}
+	u.auditUser(r, admin, models.AuditUserForceReset, target, "", "password reset required")
+	if err := u.sendPasswordReset(r, target.Email); err != nil {
+		log.Printf("Failed to send forced password reset to %s: %v", target.Email, err)
+		adminUsersRedirect(w, r, "Password reset required, but the email could not be sent")
+		return
+	}
+	adminUsersRedirect(w, r, "Password reset link sent to "+target.Email)
+}
+
+// sendPasswordReset emails a fresh reset link to email.
+func (u Users) sendPasswordReset(r *http.Request, email string) error {
+	reset, err := u.PasswordResetService.Create(email)
+	if err != nil {
+		return err
+	}
+	resetURL := absoluteURL(r, "/reset-password?token="+url.QueryEscape(reset.Token))
+	return u.EmailService.SendPasswordReset(email, resetURL, models.DefaultResetDuration)
+}
+
+// ConfirmDeleteUser shows what will happen to a user's content and asks who
+// should take it over.
+func (u Users) ConfirmDeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	userID := targetUserID(w, r, admin, false)
+	if userID == 0 {
+		return
+	}
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		adminUsersRedirect(w, r, "User not found")
+		return
+	}
+	active, err := u.UserService.Search(models.UserFilter{Status: models.UserStatusActive})
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	var candidates []*models.ManagedUser
+	for _, mu := range active {
+		if mu.UserID != target.UserID {
+			candidates = append(candidates, mu)
+		}
+	}
+	u.renderAdminUsers(w, r, admin, adminUsersPage{
+		DeleteUser: target,
+		ReassignTo: candidates,
+	})
+}
+
+// DeleteUser deletes a user, handing their posts and slides to reassign_to.
+func (u Users) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	userID := targetUserID(w, r, admin, false)
+	if userID == 0 {
+		return
+	}
+	reassignTo, err := strconv.Atoi(r.FormValue("reassign_to"))
+	if err != nil {
+		adminUsersRedirect(w, r, "Choose who should take over the user's posts and slides")
+		return
+	}
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		adminUsersRedirect(w, r, "User not found")
+		return
+	}
+	if err := u.UserService.Delete(userID, reassignTo); err != nil {
+		log.Printf("Failed to delete user %d: %v", userID, err)
+		msg := "Could not delete user"
+		if errors.Is(err, models.ErrReassignTarget) {
+			msg = "Posts and slides must be reassigned to another active user"
+		}
+		adminUsersRedirect(w, r, msg)
+		return
+	}
+	u.auditUser(r, admin, models.AuditUserDelete, target,
+		fmt.Sprintf("%s, %d post(s), %d slide deck(s)", target.Username, target.PostCount, target.SlideCount),
+		fmt.Sprintf("content reassigned to user %d", reassignTo))
+	adminUsersRedirect(w, r, "User deleted")
+}
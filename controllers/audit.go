@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+)
+
+// recordAudit appends an audit event for a request made by actor, which is
+// nil when nobody is signed in. Failures are logged rather than returned so
+// that auditing never blocks the action being audited.
+func recordAudit(svc *models.AuditService, r *http.Request, actor *models.User, e models.AuditEvent) {
+	if svc == nil {
+		return
+	}
+	if actor != nil {
+		e.ActorID = actor.UserID
+		e.ActorName = actor.Username
+	} else if e.ActorName == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		e.ActorName = "legacy API token"
+	}
+	e.IPAddress = clientIP(r)
+	if err := svc.Record(e); err != nil {
+		log.Printf("Failed to record audit event %s: %v", e.Action, err)
+	}
+}
+
+func (u Users) audit(r *http.Request, actor *models.User, e models.AuditEvent) {
+	recordAudit(u.AuditService, r, actor, e)
+}
+
+// auditPublication records a post being published or unpublished. Edits
+// that leave the published state alone are not audited.
+func (u Users) auditPublication(r *http.Request, actor *models.User, postID int, title string, wasPublished, isPublished bool) {
+	if wasPublished == isPublished {
+		return
+	}
+	e := models.AuditEvent{
+		Action:      models.AuditPostPublish,
+		TargetType:  "post",
+		TargetID:    strconv.Itoa(postID),
+		TargetLabel: title,
+		Before:      "draft",
+		After:       "published",
+	}
+	if !isPublished {
+		e.Action = models.AuditPostUnpublish
+		e.Before, e.After = e.After, e.Before
+	}
+	u.audit(r, actor, e)
+}
+
+// auditUser records a user management action against target.
+func (u Users) auditUser(r *http.Request, actor *models.User, action string, target *models.ManagedUser, before, after string) {
+	u.audit(r, actor, models.AuditEvent{
+		Action:      action,
+		TargetType:  "user",
+		TargetID:    strconv.Itoa(target.UserID),
+		TargetLabel: target.Email,
+		Before:      before,
+		After:       after,
+	})
+}
+
+// auditUserID is auditUser for handlers that only have the target's ID.
+func (u Users) auditUserID(r *http.Request, actor *models.User, action string, userID int, before, after string) {
+	target, err := u.UserService.ManagedUserByID(userID)
+	if err != nil {
+		target = &models.ManagedUser{UserID: userID}
+	}
+	u.auditUser(r, actor, action, target, before, after)
+}
+
+func (u Users) auditToken(r *http.Request, actor *models.User, action string, tokenID int, name string) {
+	u.audit(r, actor, models.AuditEvent{
+		Action:      action,
+		TargetType:  "api_token",
+		TargetID:    strconv.Itoa(tokenID),
+		TargetLabel: name,
+	})
+}
+
+// auditFilterFromQuery reads the audit page filters. Dates are whole days,
+// so "to" includes the day it names.
+func auditFilterFromQuery(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		Action:     q.Get("action"),
+		Actor:      q.Get("actor"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("from")); err == nil {
+		filter.Since = t
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("to")); err == nil {
+		filter.Until = t.AddDate(0, 0, 1)
+	}
+	return filter
+}
+
+// auditPageSize is how many events the audit page shows; exports are capped
+// at auditExportLimit.
+const (
+	auditPageSize    = 200
+	auditExportLimit = 10000
+)
+
+// AdminAudit shows the audit log. With ?format=csv or ?format=json the
+// filtered log is downloaded instead.
+func (u Users) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.IsAdmin(user.Role) {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	filter := auditFilterFromQuery(r)
+	format := r.URL.Query().Get("format")
+	filter.Limit = auditPageSize
+	if format != "" {
+		filter.Limit = auditExportLimit
+	}
+	events, err := u.AuditService.List(filter)
+	if err != nil {
+		log.Printf("Error listing audit events: %v", err)
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "csv":
+		writeAuditCSV(w, events)
+		return
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-log.json"`)
+		if events == nil {
+			events = []*models.AuditEvent{}
+		}
+		json.NewEncoder(w).Encode(events)
+		return
+	}
+
+	q := r.URL.Query()
+	data := struct {
+		Email           string
+		LoggedIn        bool
+		Username        string
+		IsAdmin         bool
+		SignupDisabled  bool
+		Description     string
+		CurrentPage     string
+		Events          []*models.AuditEvent
+		Actions         []string
+		Filter          models.AuditFilter
+		From            string
+		To              string
+		TargetTypes     []string
+		ExportCSV       string
+		ExportJSON      string
+		Truncated       bool
+		UserPermissions models.UserPermissions
+	}{
+		Email:           user.Email,
+		LoggedIn:        true,
+		Username:        user.Username,
+		IsAdmin:         true,
+		SignupDisabled:  true,
+		Description:     "Audit Log - Anshuman Biswas Blog",
+		CurrentPage:     "admin-audit",
+		Events:          events,
+		Actions:         models.AuditActions,
+		Filter:          filter,
+		From:            q.Get("from"),
+		To:              q.Get("to"),
+		TargetTypes:     models.AuditTargetTypes,
+		ExportCSV:       exportURL(r, "csv"),
+		ExportJSON:      exportURL(r, "json"),
+		Truncated:       len(events) == auditPageSize,
+		UserPermissions: models.GetPermissions(user.Role),
+	}
+	u.Templates.AdminAudit.Execute(w, r, data)
+}
+
+// exportURL links to the current filter downloaded in format.
+func exportURL(r *http.Request, format string) string {
+	q := r.URL.Query()
+	q.Set("format", format)
+	return "/admin/audit?" + q.Encode()
+}
+
+func writeAuditCSV(w http.ResponseWriter, events []*models.AuditEvent) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "occurred_at", "actor_id", "actor_name", "action", "target_type",
+		"target_id", "target_label", "ip_address", "before", "after"})
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.OccurredAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(e.ActorID),
+			csvSafe(e.ActorName),
+			e.Action,
+			e.TargetType,
+			csvSafe(e.TargetID),
+			csvSafe(e.TargetLabel),
+			e.IPAddress,
+			csvSafe(e.Before),
+			csvSafe(e.After),
+		})
+	}
+	cw.Flush()
+}
+
+// csvSafe stops spreadsheet applications from treating user-supplied text,
+// such as a category named "=HYPERLINK(...)", as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
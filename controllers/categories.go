@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 
+	authmw "anshumanbiswas.com/blog/middleware"
 	"anshumanbiswas.com/blog/models"
 	"anshumanbiswas.com/blog/utils"
 	"anshumanbiswas.com/blog/views"
@@ -16,6 +17,7 @@ import (
 type Categories struct {
 	CategoryService *models.CategoryService
 	SessionService  *models.SessionService
+	AuditService    *models.AuditService
 	Templates       struct {
 		Manage views.Template
 	}
@@ -138,6 +140,7 @@ func (c *Categories) CreateCategory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to create category: %v", err), http.StatusBadRequest)
 		return
 	}
+	c.audit(r, models.AuditCategoryCreate, category.ID, "", category.Name)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -162,6 +165,7 @@ func (c *Categories) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := c.categoryName(id)
 	category, err := c.CategoryService.Update(id, req.Name)
 	if err != nil {
 		log.Printf("Error updating category: %v", err)
@@ -173,6 +177,8 @@ func (c *Categories) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c.audit(r, models.AuditCategoryUpdate, id, before, category.Name)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(category)
 }
@@ -186,6 +192,7 @@ func (c *Categories) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := c.categoryName(id)
 	err = c.CategoryService.Delete(id)
 	if err != nil {
 		log.Printf("Error deleting category: %v", err)
@@ -197,6 +204,8 @@ func (c *Categories) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c.audit(r, models.AuditCategoryDelete, id, before, "")
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -215,12 +224,13 @@ func (c *Categories) CreateCategoryForm(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err := c.CategoryService.Create(name)
+	category, err := c.CategoryService.Create(name)
 	if err != nil {
 		log.Printf("Error creating category: %v", err)
 		http.Redirect(w, r, "/admin/categories?message=Failed+to+create+category", http.StatusFound)
 		return
 	}
+	c.audit(r, models.AuditCategoryCreate, category.ID, "", category.Name)
 
 	http.Redirect(w, r, "/admin/categories?message=Category+created+successfully", http.StatusFound)
 }
@@ -245,12 +255,14 @@ func (c *Categories) UpdateCategoryForm(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	before := c.categoryName(id)
 	_, err = c.CategoryService.Update(id, name)
 	if err != nil {
 		log.Printf("Error updating category: %v", err)
 		http.Redirect(w, r, "/admin/categories?message=Failed+to+update+category", http.StatusFound)
 		return
 	}
+	c.audit(r, models.AuditCategoryUpdate, id, before, name)
 
 	http.Redirect(w, r, "/admin/categories?message=Category+updated+successfully", http.StatusFound)
 }
@@ -264,12 +276,45 @@ func (c *Categories) DeleteCategoryForm(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	before := c.categoryName(id)
 	err = c.CategoryService.Delete(id)
 	if err != nil {
 		log.Printf("Error deleting category: %v", err)
 		http.Redirect(w, r, "/admin/categories?message=Failed+to+delete+category", http.StatusFound)
 		return
 	}
+	c.audit(r, models.AuditCategoryDelete, id, before, "")
 
 	http.Redirect(w, r, "/admin/categories?message=Category+deleted+successfully", http.StatusFound)
-}
\ No newline at end of file
+}
+
+// categoryName returns the current name of a category, or "" if it cannot be
+// loaded, for the audit log's before summary.
+func (c *Categories) categoryName(id int) string {
+	category, err := c.CategoryService.GetByID(id)
+	if err != nil {
+		return ""
+	}
+	return category.Name
+}
+
+// audit records a category change. The actor is the API token's user for
+// /api/categories and the signed-in user for the admin forms.
+func (c *Categories) audit(r *http.Request, action string, id int, before, after string) {
+	actor := authmw.GetUserFromContext(r.Context())
+	if actor == nil {
+		actor, _ = utils.IsUserLoggedIn(r, c.SessionService)
+	}
+	label := after
+	if label == "" {
+		label = before
+	}
+	recordAudit(c.AuditService, r, actor, models.AuditEvent{
+		Action:      action,
+		TargetType:  "category",
+		TargetID:    strconv.Itoa(id),
+		TargetLabel: label,
+		Before:      before,
+		After:       after,
+	})
+}
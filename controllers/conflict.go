@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/models"
+)
+
+// csrfFormField is the form field gorilla/csrf reads the token from. The
+// conflict page's forms carry their own, so it is not posted again.
+const csrfFormField = "gorilla.csrf.Token"
+
+// editConflict is the page shown, with status 409, when a save loses to one
+// someone else made since the editor was opened. It shows how the user's
+// version differs from the saved one and lets them save a merge of the two
+// or overwrite the saved version.
+type editConflict struct {
+	Email           string
+	LoggedIn        bool
+	Username        string
+	IsAdmin         bool
+	SignupDisabled  bool
+	Description     string
+	CurrentPage     string
+	UserPermissions models.UserPermissions
+
+	Kind        string // "post" or "slide"
+	Title       string // the saved title
+	Action      string // where the editor posts saves
+	EditURL     string // the editor with the saved version
+	Version     int    // the saved version, which both forms save over
+	SavedAt     string
+	Changes     []conflictChange
+	ContentDiff []render.DiffLine // from the saved content to the user's
+	Content     string            // the user's content, to merge into
+	Hidden      []conflictField   // the rest of the user's form, posted again
+}
+
+// conflictChange is a field, other than the content, whose saved value
+// differs from the user's.
+type conflictChange struct {
+	Field, Saved, Yours string
+}
+
+type conflictField struct {
+	Name, Value string
+}
+
+// newEditConflict starts the conflict page for the save in r, which user
+// made over a stale version of a record whose content is now savedContent.
+func newEditConflict(r *http.Request, user *models.User, kind, currentPage, savedContent string) *editConflict {
+	c := &editConflict{
+		Email:           user.Email,
+		LoggedIn:        true,
+		Username:        user.Username,
+		IsAdmin:         models.IsAdmin(user.Role),
+		Description:     "Edit Conflict - Anshuman Biswas Blog",
+		CurrentPage:     currentPage,
+		UserPermissions: models.GetPermissions(user.Role),
+		Kind:            kind,
+		Content:         r.PostForm.Get("content"),
+	}
+	c.SignupDisabled, _ = strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
+	c.ContentDiff = render.DiffText(savedContent, c.Content)
+
+	names := make([]string, 0, len(r.PostForm))
+	for name := range r.PostForm {
+		if name != "content" && name != "version" && name != csrfFormField {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.PostForm[name] {
+			c.Hidden = append(c.Hidden, conflictField{Name: name, Value: v})
+		}
+	}
+	return c
+}
+
+// change records field if its saved value and the user's differ.
+func (c *editConflict) change(field, saved, yours string) {
+	if saved != yours {
+		c.Changes = append(c.Changes, conflictChange{Field: field, Saved: saved, Yours: yours})
+	}
+}
+
+func (c *editConflict) execute(w http.ResponseWriter, r *http.Request, tpl Template) {
+	w.WriteHeader(http.StatusConflict)
+	tpl.Execute(w, r, c)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
+
+// categoryNames lists the names of the categories with the given IDs.
+func categoryNames(all []models.Category, ids []int) string {
+	byID := make(map[int]string, len(all))
+	for _, c := range all {
+		byID[c.ID] = c.Name
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := byID[id]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, "#"+strconv.Itoa(id))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
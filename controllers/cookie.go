@@ -9,6 +9,9 @@ import (
 const (
 	CookieSession   = "session"
 	CookieUserEmail = "user_email"
+	CookieTwoFactor = "two_factor_challenge"
+	CookieWebAuthn  = "webauthn_ceremony"
+	CookieOIDCState = "oidc_state"
 )
 
 func newCookie(name, value string, expire time.Time) *http.Cookie {
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// cspReportLimit caps the size of a violation report body.
+const cspReportLimit = 64 << 10
+
+// cspViolation holds the fields of a violation report worth logging. The
+// legacy report-uri format uses hyphenated keys and the Reporting API uses
+// camelCase ones, so both are decoded.
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	Disposition        string `json:"disposition"`
+	DocumentURL        string `json:"documentURL"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+}
+
+func (v cspViolation) log() {
+	document, blocked, directive := v.DocumentURI, v.BlockedURI, v.ViolatedDirective
+	if document == "" {
+		document = v.DocumentURL
+	}
+	if blocked == "" {
+		blocked = v.BlockedURL
+	}
+	if directive == "" {
+		directive = v.EffectiveDirective
+	}
+	log.Printf("CSP violation (%s): %s blocked %q on %s", v.Disposition, directive, blocked, document)
+}
+
+// CSPReport collects Content-Security-Policy violation reports, sent either
+// as application/csp-report by report-uri or as application/reports+json by
+// the Reporting API, and logs them.
+func CSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, cspReportLimit))
+	if err != nil {
+		http.Error(w, "Could not read report", http.StatusBadRequest)
+		return
+	}
+
+	var legacy struct {
+		Report *cspViolation `json:"csp-report"`
+	}
+	var reports []struct {
+		Type string       `json:"type"`
+		Body cspViolation `json:"body"`
+	}
+	switch {
+	case json.Unmarshal(body, &legacy) == nil && legacy.Report != nil:
+		legacy.Report.log()
+	case json.Unmarshal(body, &reports) == nil:
+		for _, rep := range reports {
+			if rep.Type == "csp-violation" {
+				rep.Body.log()
+			}
+		}
+	default:
+		http.Error(w, "Malformed report", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
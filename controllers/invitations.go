@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// sendInvitation emails the acceptance link for a freshly created or resent
+// invitation.
+func (u Users) sendInvitation(r *http.Request, inv *models.Invitation) error {
+	acceptURL := absoluteURL(r, "/accept-invitation?token="+url.QueryEscape(inv.Token))
+	validFor := time.Until(inv.ExpiresAt).Round(time.Hour)
+	if err := u.EmailService.SendInvitation(inv.Email, acceptURL, validFor); err != nil {
+		return fmt.Errorf("send invitation: %w", err)
+	}
+	return nil
+}
+
+// ResendInvitation emails a new link for an open invitation and restarts its
+// expiry. The old link stops working.
+func (u Users) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+	inv, err := u.InvitationService.Resend(id)
+	if err != nil {
+		log.Printf("Failed to resend invitation %d: %v", id, err)
+		adminUsersRedirect(w, r, "That invitation has already been accepted or revoked")
+		return
+	}
+	u.audit(r, admin, models.AuditEvent{
+		Action:      models.AuditInvitationResend,
+		TargetType:  "invitation",
+		TargetID:    strconv.Itoa(id),
+		TargetLabel: inv.Email,
+		After:       "expires " + inv.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+	if err := u.sendInvitation(r, inv); err != nil {
+		log.Printf("Failed to send invitation to %s: %v", inv.Email, err)
+		adminUsersRedirect(w, r, "Could not send the invitation email")
+		return
+	}
+	adminUsersRedirect(w, r, "Invitation resent to "+inv.Email)
+}
+
+// RevokeInvitation cancels an open invitation.
+func (u Users) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+	if err := u.InvitationService.Revoke(id); err != nil {
+		log.Printf("Failed to revoke invitation %d: %v", id, err)
+		adminUsersRedirect(w, r, "That invitation has already been accepted or revoked")
+		return
+	}
+	e := models.AuditEvent{Action: models.AuditInvitationRevoke, TargetType: "invitation", TargetID: strconv.Itoa(id)}
+	if inv, err := u.InvitationService.ByID(id); err == nil {
+		e.TargetLabel = inv.Email
+	}
+	u.audit(r, admin, e)
+	adminUsersRedirect(w, r, "Invitation revoked")
+}
+
+// AcceptInvitation renders the account creation form for an invitation link.
+// It works whether or not public signup is enabled.
+func (u Users) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email           string
+		LoggedIn        bool
+		SignupDisabled  bool
+		IsAdmin         bool
+		Description     string
+		CurrentPage     string
+		Username        string
+		Message         string
+		Token           string
+		InvalidReason   string
+		RoleName        string
+		UserPermissions models.UserPermissions
+	}
+	data.SignupDisabled, _ = strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
+	data.Description = "Accept Invitation - Anshuman Biswas Blog"
+	data.CurrentPage = "signup"
+	data.Message = r.URL.Query().Get("message")
+	data.Username = r.URL.Query().Get("username")
+	data.Token = r.URL.Query().Get("token")
+	data.UserPermissions = models.GetPermissions(models.RoleCommenter)
+
+	inv, err := u.InvitationService.Lookup(data.Token)
+	if err != nil {
+		data.InvalidReason = invitationErrorMessage(err)
+	} else {
+		data.Email = inv.Email
+		data.RoleName = inv.RoleName
+	}
+	u.Templates.AcceptInvitation.Execute(w, r, data)
+}
+
+// ProcessAcceptInvitation creates the invited account and signs the new user
+// in.
+func (u Users) ProcessAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+
+	retry := func(msg string) {
+		q := url.Values{"token": {token}, "username": {username}, "message": {msg}}
+		http.Redirect(w, r, "/accept-invitation?"+q.Encode(), http.StatusFound)
+	}
+	if username == "" {
+		retry("Choose a username")
+		return
+	}
+	if len(password) < 6 {
+		retry("Passwords must be at least 6 characters")
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		retry("Passwords do not match")
+		return
+	}
+
+	user, err := u.InvitationService.Accept(token, username, password)
+	if err != nil {
+		log.Printf("Invitation acceptance failed: %v", err)
+		retry(invitationErrorMessage(err))
+		return
+	}
+	u.audit(r, user, models.AuditEvent{
+		Action:      models.AuditInvitationAccept,
+		TargetType:  "user",
+		TargetID:    strconv.Itoa(user.UserID),
+		TargetLabel: user.Email,
+	})
+	u.signIn(w, r, user)
+}
+
+func invitationErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrTokenExpired):
+		return "This invitation has expired. Ask the administrator to resend it."
+	case errors.Is(err, models.ErrInvitationClosed):
+		return "This invitation has already been used or was revoked."
+	case errors.Is(err, models.ErrEmailTaken):
+		return "An account with this email address already exists. Sign in instead."
+	case errors.Is(err, models.ErrNotFound):
+		return "This invitation link is invalid."
+	}
+	return "Something went wrong. Please try again."
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// clientIP returns the address failed sign-ins are counted against.
+// X-Forwarded-For is only honoured when TRUST_PROXY_HEADERS is set, since
+// clients can forge it.
+func clientIP(r *http.Request) string {
+	if trust, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY_HEADERS")); trust {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isBadCredentials reports whether an Authenticate error means the email or
+// password was wrong, as opposed to a server error.
+func isBadCredentials(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, bcrypt.ErrMismatchedHashAndPassword)
+}
+
+func throttleMessage(check models.LoginCheck) string {
+	if !check.LockedUntil.IsZero() {
+		return "Too many failed sign-in attempts. Password sign-in for this account is locked until " +
+			check.LockedUntil.Format("15:04 MST") + ". You can reset your password to unlock it now."
+	}
+	seconds := int(check.RetryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("Too many failed sign-in attempts. Please wait %d seconds and try again.", seconds)
+}
+
+// notifyAccountLocked emails the owner of a freshly locked account.
+func (u Users) notifyAccountLocked(r *http.Request, user *models.User) {
+	resetURL := absoluteURL(r, "/forgot-password?email="+url.QueryEscape(user.Email))
+	if err := u.EmailService.SendAccountLocked(user.Email, u.LoginThrottle.Policy.LockoutDuration, resetURL); err != nil {
+		log.Printf("Failed to send lockout notice to %s: %v", user.Email, err)
+	}
+}
+
+// UnlockUser lets an administrator lift a sign-in lockout early.
+func (u Users) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	admin, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.CanManageSecurity(admin.Role) {
+		http.Error(w, "Forbidden: Security access required", http.StatusForbidden)
+		return
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	reason := fmt.Sprintf("unlocked by %s (user %d)", admin.Username, admin.UserID)
+	if err := u.LoginThrottle.Unlock(userID, clientIP(r), reason); err != nil {
+		log.Printf("Failed to unlock user %d: %v", userID, err)
+		http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Could not unlock account"), http.StatusFound)
+		return
+	}
+	u.auditUserID(r, admin, models.AuditUserUnlock, userID, "locked", "unlocked")
+	http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Account unlocked"), http.StatusFound)
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func writePasskeyJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create()
+// so a staff user can register a passkey from their profile.
+func (u Users) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		writePasskeyJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	if !isStaff(user.Role) {
+		writePasskeyJSON(w, http.StatusForbidden, map[string]string{"error": "Passkeys are available to staff accounts only"})
+		return
+	}
+
+	creation, token, err := u.PasskeyService.BeginRegistration(user)
+	if err != nil {
+		log.Printf("Failed to begin passkey registration for user %d: %v", user.UserID, err)
+		writePasskeyJSON(w, http.StatusInternalServerError, map[string]string{"error": "Could not start passkey registration"})
+		return
+	}
+
+	setCookie(w, CookieWebAuthn, token)
+	writePasskeyJSON(w, http.StatusOK, creation)
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores
+// the credential. The passkey's display name comes from the ?name= parameter.
+func (u Users) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		writePasskeyJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	token, err := readCookie(r, CookieWebAuthn)
+	if err != nil {
+		writePasskeyJSON(w, http.StatusBadRequest, map[string]string{"error": "No passkey registration in progress"})
+		return
+	}
+	deleteCookie(w, CookieWebAuthn, "XXXXXX")
+
+	passkey, err := u.PasskeyService.FinishRegistration(user, token, r.URL.Query().Get("name"), r)
+	if err != nil {
+		log.Printf("Failed to finish passkey registration for user %d: %v", user.UserID, err)
+		writePasskeyJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey registration failed"})
+		return
+	}
+
+	writePasskeyJSON(w, http.StatusOK, map[string]interface{}{
+		"id":   passkey.ID,
+		"name": passkey.Name,
+	})
+}
+
+// DeletePasskey removes one of the current user's passkeys.
+func (u Users) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	passkeyID, err := strconv.Atoi(chi.URLParam(r, "passkeyID"))
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := u.PasskeyService.Delete(user.UserID, passkeyID); err != nil {
+		log.Printf("Failed to delete passkey %d for user %d: %v", passkeyID, user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not remove passkey"), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Passkey removed"), http.StatusFound)
+}
+
+// BeginPasskeySignIn returns the options for navigator.credentials.get().
+// No username is needed: the authenticator offers the passkeys it holds.
+func (u Users) BeginPasskeySignIn(w http.ResponseWriter, r *http.Request) {
+	assertion, token, err := u.PasskeyService.BeginLogin()
+	if err != nil {
+		log.Printf("Failed to begin passkey sign-in: %v", err)
+		writePasskeyJSON(w, http.StatusInternalServerError, map[string]string{"error": "Could not start passkey sign-in"})
+		return
+	}
+
+	setCookie(w, CookieWebAuthn, token)
+	writePasskeyJSON(w, http.StatusOK, assertion)
+}
+
+// FinishPasskeySignIn verifies the assertion and creates a normal session.
+// A passkey already proves possession and user verification, so the TOTP
+// step is not asked for again, but accounts that signInRefusal blocks are
+// still turned away.
+func (u Users) FinishPasskeySignIn(w http.ResponseWriter, r *http.Request) {
+	token, err := readCookie(r, CookieWebAuthn)
+	if err != nil {
+		writePasskeyJSON(w, http.StatusBadRequest, map[string]string{"error": "No passkey sign-in in progress"})
+		return
+	}
+	deleteCookie(w, CookieWebAuthn, "XXXXXX")
+
+	user, err := u.PasskeyService.FinishLogin(token, r)
+	if err != nil {
+		log.Printf("Passkey sign-in failed: %v", err)
+		writePasskeyJSON(w, http.StatusUnauthorized, map[string]string{"error": "Passkey sign-in failed"})
+		return
+	}
+	if !isStaff(user.Role) || !user.EmailVerified {
+		writePasskeyJSON(w, http.StatusForbidden, map[string]string{"error": "Passkey sign-in is not available for this account"})
+		return
+	}
+	refusal, err := u.signInRefusal(r, user)
+	if err != nil {
+		log.Printf("Failed to check account status for user %d: %v", user.UserID, err)
+		writePasskeyJSON(w, http.StatusInternalServerError, map[string]string{"error": "Something went wrong"})
+		return
+	}
+	if refusal != "" {
+		writePasskeyJSON(w, http.StatusForbidden, map[string]string{"error": refusal})
+		return
+	}
+
+	if err := u.completeSignIn(w, r, user); err != nil {
+		log.Printf("Failed to create session for user %d: %v", user.UserID, err)
+		writePasskeyJSON(w, http.StatusInternalServerError, map[string]string{"error": "Something went wrong"})
+		return
+	}
+	writePasskeyJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+}
@@ -0,0 +1,624 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"anshumanbiswas.com/blog/internal/render"
+	authmw "anshumanbiswas.com/blog/middleware"
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// APIError is the body of every error response from the /api/v1 endpoints.
+// Fields maps request fields to what is wrong with them, for validation
+// errors. A version conflict carries the post's current version and the
+// request's fields whose values differ from it.
+type APIError struct {
+	Status         int               `json:"status"`
+	Code           string            `json:"code"`
+	Message        string            `json:"message"`
+	Fields         map[string]string `json:"fields,omitempty"`
+	CurrentVersion int               `json:"current_version,omitempty"`
+	Changed        []string          `json:"changed,omitempty"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	writeAPIJSON(w, status, struct {
+		Error APIError `json:"error"`
+	}{APIError{Status: status, Code: code, Message: message, Fields: fields}})
+}
+
+// apiPost is a post as the posts API shows it.
+type apiPost struct {
+	ID               int               `json:"id"`
+	Title            string            `json:"title"`
+	Slug             string            `json:"slug"`
+	URL              string            `json:"url"`
+	Content          string            `json:"content"`
+	AuthorID         int               `json:"author_id"`
+	Author           string            `json:"author"`
+	IsPublished      bool              `json:"is_published"`
+	Featured         bool              `json:"featured"`
+	FeaturedImageURL string            `json:"featured_image_url"`
+	RenderEngine     string            `json:"render_engine"`
+	Categories       []models.Category `json:"categories"`
+	PublicationDate  string            `json:"publication_date"`
+	CreatedAt        string            `json:"created_at"`
+	LastEditDate     string            `json:"last_edit_date"`
+	Version          int               `json:"version"`
+}
+
+func newAPIPost(p models.Post) apiPost {
+	categories := p.Categories
+	if categories == nil {
+		categories = []models.Category{}
+	}
+	return apiPost{
+		ID:               p.ID,
+		Title:            p.Title,
+		Slug:             p.Slug,
+		URL:              "/blog/" + p.Slug,
+		Content:          p.Content,
+		AuthorID:         p.UserID,
+		Author:           p.Username,
+		IsPublished:      p.IsPublished,
+		Featured:         p.Featured,
+		FeaturedImageURL: p.FeaturedImageURL,
+		RenderEngine:     p.RenderEngine,
+		Categories:       categories,
+		PublicationDate:  p.PublishedAt().Format(time.RFC3339),
+		CreatedAt:        p.CreatedAt,
+		LastEditDate:     p.LastEditDate,
+		Version:          p.Version,
+	}
+}
+
+// postInput is the body of a create, replace or patch request. Fields left
+// out are nil: a patch keeps the post's value for them.
+type postInput struct {
+	Title            *string `json:"title"`
+	Content          *string `json:"content"`
+	Slug             *string `json:"slug"`
+	IsPublished      *bool   `json:"is_published"`
+	Featured         *bool   `json:"featured"`
+	FeaturedImageURL *string `json:"featured_image_url"`
+	RenderEngine     *string `json:"render_engine"`
+	CategoryIDs      *[]int  `json:"category_ids"`
+}
+
+// postFields are a post's values after a request is applied to them.
+type postFields struct {
+	Title            string
+	Content          string
+	Slug             string
+	IsPublished      bool
+	Featured         bool
+	FeaturedImageURL string
+	RenderEngine     string
+	CategoryIDs      []int
+}
+
+// apply sets the fields in is on f.
+func (in postInput) apply(f *postFields) {
+	if in.Title != nil {
+		f.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		f.Content = *in.Content
+	}
+	if in.Slug != nil {
+		f.Slug = strings.TrimSpace(*in.Slug)
+	}
+	if in.IsPublished != nil {
+		f.IsPublished = *in.IsPublished
+	}
+	if in.Featured != nil {
+		f.Featured = *in.Featured
+	}
+	if in.FeaturedImageURL != nil {
+		f.FeaturedImageURL = strings.TrimSpace(*in.FeaturedImageURL)
+	}
+	if in.RenderEngine != nil {
+		f.RenderEngine = *in.RenderEngine
+	}
+	if in.CategoryIDs != nil {
+		f.CategoryIDs = *in.CategoryIDs
+	}
+}
+
+var (
+	postSlugRe      = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	postSlugCleanRe = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// validate checks f, returning what is wrong with each bad field.
+func (f postFields) validate() map[string]string {
+	errs := map[string]string{}
+	switch {
+	case f.Title == "":
+		errs["title"] = "is required"
+	case utf8.RuneCountInString(f.Title) > 255:
+		errs["title"] = "must be at most 255 characters"
+	}
+	if strings.TrimSpace(f.Content) == "" {
+		errs["content"] = "is required"
+	}
+	switch {
+	case f.Slug == "" && f.Title != "":
+		errs["slug"] = "is required when the title has no letters or digits"
+	case len(f.Slug) > 255:
+		errs["slug"] = "must be at most 255 characters"
+	case f.Slug != "" && !postSlugRe.MatchString(f.Slug):
+		errs["slug"] = "may only contain lowercase letters, digits and single hyphens"
+	}
+	if f.FeaturedImageURL != "" && !validImageURL(f.FeaturedImageURL) {
+		errs["featured_image_url"] = "must be a path on this site or an http(s) URL"
+	}
+	if f.RenderEngine != "" && !render.ValidEngine(f.RenderEngine) {
+		errs["render_engine"] = fmt.Sprintf("must be one of %s", strings.Join(render.Engines, ", "))
+	}
+	if len(f.CategoryIDs) == 0 {
+		errs["category_ids"] = "must list at least one category"
+	}
+	seen := map[int]bool{}
+	for _, id := range f.CategoryIDs {
+		if id <= 0 || seen[id] {
+			errs["category_ids"] = "must be distinct positive category IDs"
+			break
+		}
+		seen[id] = true
+	}
+	return errs
+}
+
+func validImageURL(s string) bool {
+	if strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+		return true
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// postSlug derives a slug from a title, as the editor does.
+func postSlug(title string) string {
+	return strings.Trim(postSlugCleanRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+}
+
+// decodePostInput reads the request body, answering with an error and
+// returning false if it is not a post.
+func decodePostInput(w http.ResponseWriter, r *http.Request) (postInput, bool) {
+	var in postInput
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("The request body is not a valid post: %v", err), nil)
+		return postInput{}, false
+	}
+	return in, true
+}
+
+// apiPostID reads the postID URL parameter, answering 400 when it is not an
+// ID.
+func apiPostID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "postID"))
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "The post ID must be a positive integer", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// apiEditor returns the user behind the request's API token if their role
+// may edit posts. The legacy token carries no user, so it can only read.
+func (u Users) apiEditor(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user := authmw.GetUserFromContext(r.Context())
+	if user == nil {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Changing posts needs a personal API token", nil)
+		return nil, false
+	}
+	if !models.CanEditPosts(user.Role) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Your role cannot edit posts", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// findPost loads a post with its author and categories, answering 404 or
+// 500 when it cannot. Drafts are only found by users who may edit them.
+func (u Users) findPost(w http.ResponseWriter, r *http.Request, id int) (*models.Post, bool) {
+	posts, err := u.PostService.ListPosts(models.PostQuery{ID: id})
+	if err != nil {
+		log.Printf("Error loading post %d: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Failed to load the post", nil)
+		return nil, false
+	}
+	if len(posts) == 0 {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Post not found", nil)
+		return nil, false
+	}
+	post := &posts[0]
+	if !post.IsPublished {
+		user := authmw.GetUserFromContext(r.Context())
+		if user == nil || !models.CanEditPost(user, post) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Post not found", nil)
+			return nil, false
+		}
+	}
+	return post, true
+}
+
+// checkPostFields validates f for user, including that its categories
+// exist, answering 422 and returning false when it is invalid. Publishing
+// needs the publish permission; a post that was already published can still
+// be edited without it.
+func (u Users) checkPostFields(w http.ResponseWriter, user *models.User, f postFields, wasPublished bool) bool {
+	errs := f.validate()
+	if f.IsPublished && !wasPublished && !models.GetPermissions(user.Role).CanPublishPosts {
+		errs["is_published"] = "your role cannot publish posts"
+	}
+	if _, bad := errs["category_ids"]; !bad {
+		missing, err := u.CategoryService.MissingCategories(f.CategoryIDs)
+		if err != nil {
+			log.Printf("Error checking categories: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Failed to check the categories", nil)
+			return false
+		}
+		if len(missing) > 0 {
+			errs["category_ids"] = fmt.Sprintf("no category has ID %s", joinInts(missing))
+		}
+	}
+	if len(errs) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "The post is invalid", errs)
+		return false
+	}
+	return true
+}
+
+func joinInts(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ", ")
+}
+
+// writeSaveError answers a failed create or update.
+func writeSaveError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrSlugTaken) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "The post is invalid",
+			map[string]string{"slug": "is already used by another post"})
+		return
+	}
+	log.Printf("Error saving post: %v", err)
+	writeAPIError(w, http.StatusInternalServerError, "internal", "Failed to save the post", nil)
+}
+
+// respondWithPost answers with post id as it now is, tagged with its
+// version.
+func (u Users) respondWithPost(w http.ResponseWriter, r *http.Request, id, status int) {
+	post, ok := u.findPost(w, r, id)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", postETag(post.Version))
+	writeAPIJSON(w, status, newAPIPost(*post))
+}
+
+// postETag is the entity tag of a version of a post.
+func postETag(version int) string {
+	return `"v` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion returns the version of a post the If-Match header of r
+// names: current if it is among them. It answers 428 and returns false when
+// the header names no version, as writes must say which one they were made
+// from; "*" names none.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, current int) (int, bool) {
+	version := 0
+	for _, tag := range strings.Split(r.Header.Get("If-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		v, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(tag, `"v`), `"`))
+		if err != nil || v <= 0 || postETag(v) != tag {
+			continue
+		}
+		if v == current || version == 0 {
+			version = v
+		}
+	}
+	if version == 0 {
+		writeAPIError(w, http.StatusPreconditionRequired, "version_required",
+			"Send the ETag of the version you fetched in If-Match", nil)
+		return 0, false
+	}
+	return version, true
+}
+
+// writeVersionConflict answers, with 409 as the editor does, a write made
+// against a version of post that is no longer current. f is the post as an
+// edit would have left it, or nil for a delete.
+func writeVersionConflict(w http.ResponseWriter, post *models.Post, f *postFields) {
+	apiErr := APIError{
+		Status:         http.StatusConflict,
+		Code:           "version_conflict",
+		Message:        "The post has changed since you fetched it; fetch it again and reapply your changes",
+		CurrentVersion: post.Version,
+	}
+	if f != nil {
+		apiErr.Changed = postChanges(post, *f)
+	}
+	w.Header().Set("ETag", postETag(post.Version))
+	writeAPIJSON(w, http.StatusConflict, struct {
+		Error APIError `json:"error"`
+	}{apiErr})
+}
+
+// postChanges lists the fields of f, by their names in requests, whose
+// values differ from post's.
+func postChanges(post *models.Post, f postFields) []string {
+	var changed []string
+	change := func(field string, differs bool) {
+		if differs {
+			changed = append(changed, field)
+		}
+	}
+	change("title", f.Title != post.Title)
+	change("content", f.Content != post.Content)
+	change("slug", f.Slug != post.Slug)
+	change("is_published", f.IsPublished != post.IsPublished)
+	change("featured", f.Featured != post.Featured)
+	change("featured_image_url", f.FeaturedImageURL != post.FeaturedImageURL)
+	change("render_engine", f.RenderEngine != "" && f.RenderEngine != post.RenderEngine)
+	saved := make([]int, len(post.Categories))
+	for i, c := range post.Categories {
+		saved[i] = c.ID
+	}
+	yours := append([]int(nil), f.CategoryIDs...)
+	sort.Ints(saved)
+	sort.Ints(yours)
+	change("category_ids", joinInts(saved) != joinInts(yours))
+	return changed
+}
+
+// APIListPosts - GET /api/v1/posts
+//
+// Lists published posts newest first, with the home feed's filters, a limit
+// and a cursor from the previous page's next_cursor.
+func (u Users) APIListPosts(w http.ResponseWriter, r *http.Request) {
+	q, err := models.ParsePostFilters(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_query", err.Error(), nil)
+		return
+	}
+	q.PublishedOnly = true
+	q.Limit = models.FeedPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 1 || q.Limit > models.MaxPageSize {
+			writeAPIError(w, http.StatusBadRequest, "invalid_query",
+				fmt.Sprintf("limit must be between 1 and %d", models.MaxPageSize), nil)
+			return
+		}
+	}
+
+	page, err := u.PostService.ListPostPage(q)
+	if err != nil {
+		log.Printf("Error listing posts: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Failed to list posts", nil)
+		return
+	}
+	resp := struct {
+		Posts      []apiPost `json:"posts"`
+		NextCursor string    `json:"next_cursor,omitempty"`
+	}{Posts: make([]apiPost, len(page.Posts)), NextCursor: page.NextCursor}
+	for i, p := range page.Posts {
+		resp.Posts[i] = newAPIPost(p)
+	}
+	writeAPIJSON(w, http.StatusOK, resp)
+}
+
+// APIGetPost - GET /api/v1/posts/{postID}
+func (u Users) APIGetPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPostID(w, r)
+	if !ok {
+		return
+	}
+	u.respondWithPost(w, r, id, http.StatusOK)
+}
+
+// APICreatePost - POST /api/v1/posts
+//
+// Creates a post by the token's user. title, content and category_ids are
+// required; the slug defaults to one made from the title.
+func (u Users) APICreatePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := u.apiEditor(w, r)
+	if !ok {
+		return
+	}
+	in, ok := decodePostInput(w, r)
+	if !ok {
+		return
+	}
+	var f postFields
+	in.apply(&f)
+	if f.Slug == "" {
+		f.Slug = postSlug(f.Title)
+	}
+	if !u.checkPostFields(w, user, f, false) {
+		return
+	}
+
+	post, err := u.PostService.Create(user.UserID, f.CategoryIDs[0], f.Title, f.Content, f.IsPublished, f.Featured, f.FeaturedImageURL, f.Slug, models.TrustsHTML(user, user.UserID))
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	u.auditPublication(r, user, post.ID, f.Title, false, f.IsPublished)
+	u.setRenderEngine(post.ID, f.RenderEngine)
+	if err := u.CategoryService.AssignCategoriesToPost(post.ID, f.CategoryIDs); err != nil {
+		log.Printf("Error assigning categories to post %d: %v", post.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "The post was created but its categories could not be set", nil)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/posts/%d", post.ID))
+	u.respondWithPost(w, r, post.ID, http.StatusCreated)
+}
+
+// APIReplacePost - PUT /api/v1/posts/{postID}
+//
+// Replaces every field of a post: fields left out take their defaults, as
+// on create.
+func (u Users) APIReplacePost(w http.ResponseWriter, r *http.Request) {
+	u.apiUpdatePost(w, r, true)
+}
+
+// APIUpdatePost - PATCH /api/v1/posts/{postID}
+//
+// Changes only the fields the request includes.
+func (u Users) APIUpdatePost(w http.ResponseWriter, r *http.Request) {
+	u.apiUpdatePost(w, r, false)
+}
+
+func (u Users) apiUpdatePost(w http.ResponseWriter, r *http.Request, replace bool) {
+	user, ok := u.apiEditor(w, r)
+	if !ok {
+		return
+	}
+	id, ok := apiPostID(w, r)
+	if !ok {
+		return
+	}
+	existing, ok := u.findPost(w, r, id)
+	if !ok {
+		return
+	}
+	if !models.CanEditPost(user, existing) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "You cannot edit this post", nil)
+		return
+	}
+	version, ok := ifMatchVersion(w, r, existing.Version)
+	if !ok {
+		return
+	}
+	in, ok := decodePostInput(w, r)
+	if !ok {
+		return
+	}
+
+	var f postFields
+	if !replace {
+		f = postFields{
+			Title:            existing.Title,
+			Content:          existing.Content,
+			Slug:             existing.Slug,
+			IsPublished:      existing.IsPublished,
+			Featured:         existing.Featured,
+			FeaturedImageURL: existing.FeaturedImageURL,
+		}
+		for _, c := range existing.Categories {
+			f.CategoryIDs = append(f.CategoryIDs, c.ID)
+		}
+	}
+	in.apply(&f)
+	if f.Slug == "" {
+		f.Slug = postSlug(f.Title)
+	}
+	if version != existing.Version {
+		writeVersionConflict(w, existing, &f)
+		return
+	}
+	if !u.checkPostFields(w, user, f, existing.IsPublished) {
+		return
+	}
+
+	if err := u.PostService.Update(id, version, f.CategoryIDs[0], f.Title, f.Content, f.IsPublished, f.Featured, f.FeaturedImageURL, f.Slug, models.TrustsHTML(user, existing.UserID)); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			// Saved by someone else since existing was read.
+			if current, ok := u.findPost(w, r, id); ok {
+				writeVersionConflict(w, current, &f)
+			}
+			return
+		}
+		writeSaveError(w, err)
+		return
+	}
+	u.auditPublication(r, user, id, f.Title, existing.IsPublished, f.IsPublished)
+	u.setRenderEngine(id, f.RenderEngine)
+	if err := u.CategoryService.AssignCategoriesToPost(id, f.CategoryIDs); err != nil {
+		log.Printf("Error updating categories for post %d: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "The post was saved but its categories could not be set", nil)
+		return
+	}
+
+	u.respondWithPost(w, r, id, http.StatusOK)
+}
+
+// APIDeletePost - DELETE /api/v1/posts/{postID}
+func (u Users) APIDeletePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := u.apiEditor(w, r)
+	if !ok {
+		return
+	}
+	id, ok := apiPostID(w, r)
+	if !ok {
+		return
+	}
+	existing, ok := u.findPost(w, r, id)
+	if !ok {
+		return
+	}
+	if !models.CanEditPost(user, existing) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "You cannot delete this post", nil)
+		return
+	}
+	version, ok := ifMatchVersion(w, r, existing.Version)
+	if !ok {
+		return
+	}
+	if version != existing.Version {
+		writeVersionConflict(w, existing, nil)
+		return
+	}
+
+	if err := u.PostService.Delete(id, version); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Post not found", nil)
+			return
+		}
+		if errors.Is(err, models.ErrVersionConflict) {
+			if current, ok := u.findPost(w, r, id); ok {
+				writeVersionConflict(w, current, nil)
+			}
+			return
+		}
+		log.Printf("Error deleting post %d: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Failed to delete the post", nil)
+		return
+	}
+	u.audit(r, user, models.AuditEvent{
+		Action:      models.AuditPostDelete,
+		TargetType:  "post",
+		TargetID:    strconv.Itoa(id),
+		TargetLabel: existing.Title,
+		Before:      existing.Slug,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
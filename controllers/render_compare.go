@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// renderComparison is one post rendered by both engines.
+type renderComparison struct {
+	ID      int               `json:"id"`
+	Title   string            `json:"title"`
+	Slug    string            `json:"slug"`
+	Engine  string            `json:"engine"`
+	Changed bool              `json:"changed"`
+	Diff    []render.DiffLine `json:"diff,omitempty"`
+}
+
+// compareEngines renders post with the legacy and AST engines. Diff lines
+// run from the legacy output to the AST output.
+func compareEngines(post models.Post) renderComparison {
+	legacy := models.RenderPostContent(post.Content, post.TrustedHTML, render.EngineLegacy)
+	tree := models.RenderPostContent(post.Content, post.TrustedHTML, render.EngineAST)
+	diff := render.CompareOutput(legacy, tree)
+	return renderComparison{
+		ID:      post.ID,
+		Title:   post.Title,
+		Slug:    post.Slug,
+		Engine:  post.RenderEngine,
+		Changed: diff != nil,
+		Diff:    diff,
+	}
+}
+
+// RenderCompare lists every post with whether moving it between the legacy
+// and AST engines would change its HTML, and how. ?format=json returns the
+// same report for scripts.
+func (u Users) RenderCompare(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.IsAdmin(user.Role) {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	posts, err := u.PostService.RenderSources()
+	if err != nil {
+		log.Printf("Error loading posts for render comparison: %v", err)
+		http.Error(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+	comparisons := make([]renderComparison, 0, len(posts))
+	changed := 0
+	for _, post := range posts {
+		c := compareEngines(post)
+		if c.Changed {
+			changed++
+		}
+		comparisons = append(comparisons, c)
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(comparisons)
+		return
+	}
+
+	data := struct {
+		Email           string
+		LoggedIn        bool
+		Username        string
+		IsAdmin         bool
+		SignupDisabled  bool
+		Description     string
+		CurrentPage     string
+		Comparisons     []renderComparison
+		Changed         int
+		Cache           *render.CacheStats
+		UserPermissions models.UserPermissions
+	}{
+		Email:           user.Email,
+		LoggedIn:        true,
+		Username:        user.Username,
+		IsAdmin:         true,
+		SignupDisabled:  true,
+		Description:     "Renderer Comparison - Anshuman Biswas Blog",
+		CurrentPage:     "admin-posts",
+		Comparisons:     comparisons,
+		Changed:         changed,
+		UserPermissions: models.GetPermissions(user.Role),
+	}
+	if cache := render.CurrentRenderCache(); cache != nil {
+		stats := cache.Stats()
+		data.Cache = &stats
+	}
+	u.Templates.RenderCompare.Execute(w, r, data)
+}
+
+// SetPostRenderEngine moves a post to the engine named in the form.
+func (u Users) SetPostRenderEngine(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.IsAdmin(user.Role) {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "postID"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+	engine := r.FormValue("render_engine")
+	if !render.ValidEngine(engine) {
+		http.Error(w, "Unknown render engine", http.StatusBadRequest)
+		return
+	}
+	if err := u.PostService.SetRenderEngine(id, engine); err != nil {
+		log.Printf("Error setting post %d render engine: %v", id, err)
+		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/render-compare", http.StatusFound)
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// requireUserManager returns the signed-in user if they may manage users and
+// roles, otherwise it writes the response and returns nil.
+func (u Users) requireUserManager(w http.ResponseWriter, r *http.Request) *models.User {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return nil
+	}
+	if !models.GetPermissions(user.Role).CanManageUsers {
+		http.Error(w, "Forbidden: User management access required", http.StatusForbidden)
+		return nil
+	}
+	return user
+}
+
+// AdminRoles lists roles with their permissions and a form for new ones.
+func (u Users) AdminRoles(w http.ResponseWriter, r *http.Request) {
+	user := u.requireUserManager(w, r)
+	if user == nil {
+		return
+	}
+
+	roles, err := u.RoleService.GetAllRoles()
+	if err != nil {
+		log.Printf("Error getting roles: %v", err)
+		http.Error(w, "Failed to load roles", http.StatusInternalServerError)
+		return
+	}
+	counts, err := u.RoleService.UserCounts()
+	if err != nil {
+		log.Printf("Error counting role users: %v", err)
+		counts = make(map[int]int)
+	}
+
+	data := struct {
+		Email           string
+		LoggedIn        bool
+		Username        string
+		IsAdmin         bool
+		SignupDisabled  bool
+		Description     string
+		CurrentPage     string
+		Flash           string
+		Roles           []*models.Role
+		UserCounts      map[int]int
+		Permissions     []models.PermissionInfo
+		AdminRoleID     int
+		UserPermissions models.UserPermissions
+	}{
+		Email:           user.Email,
+		LoggedIn:        true,
+		Username:        user.Username,
+		IsAdmin:         models.IsAdmin(user.Role),
+		SignupDisabled:  true,
+		Description:     "Roles & Permissions - Anshuman Biswas Blog",
+		CurrentPage:     "admin-roles",
+		Flash:           r.URL.Query().Get("message"),
+		Roles:           roles,
+		UserCounts:      counts,
+		Permissions:     models.AllPermissions,
+		AdminRoleID:     models.RoleAdministrator,
+		UserPermissions: models.GetPermissions(user.Role),
+	}
+
+	u.Templates.AdminRoles.Execute(w, r, data)
+}
+
+// rolePermissionsFromForm reads the checked permission boxes.
+func rolePermissionsFromForm(r *http.Request) models.UserPermissions {
+	r.ParseForm()
+	return models.PermissionsFromNames(r.Form["permissions"])
+}
+
+// roleSummary describes a role and its permissions for the audit log.
+func roleSummary(name string, perms models.UserPermissions) string {
+	names := perms.Names()
+	if len(names) == 0 {
+		return name + ": no permissions"
+	}
+	return name + ": " + strings.Join(names, ", ")
+}
+
+func roleErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrRoleNameTaken):
+		return "A role with that name already exists"
+	case errors.Is(err, models.ErrSystemRole):
+		return "Built-in roles cannot be deleted"
+	case errors.Is(err, models.ErrRoleInUse):
+		return "Move users to another role before deleting this one"
+	case errors.Is(err, models.ErrNotFound):
+		return "Role not found"
+	}
+	return "Could not save role"
+}
+
+// CreateRole adds a custom role.
+func (u Users) CreateRole(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape("Role name is required"), http.StatusFound)
+		return
+	}
+	perms := rolePermissionsFromForm(r)
+	role, err := u.RoleService.CreateRole(name, strings.TrimSpace(r.FormValue("description")), perms)
+	if err != nil {
+		log.Printf("Failed to create role %q: %v", name, err)
+		http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape(roleErrorMessage(err)), http.StatusFound)
+		return
+	}
+	u.audit(r, admin, models.AuditEvent{
+		Action:      models.AuditRoleCreate,
+		TargetType:  "role",
+		TargetID:    strconv.Itoa(role.ID),
+		TargetLabel: name,
+		After:       roleSummary(name, perms),
+	})
+	http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape("Role "+name+" created"), http.StatusFound)
+}
+
+// UpdateRole renames a role and replaces its permissions.
+func (u Users) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	roleID, err := strconv.Atoi(chi.URLParam(r, "roleID"))
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape("Role name is required"), http.StatusFound)
+		return
+	}
+	before := ""
+	if role, err := u.RoleService.GetRole(roleID); err == nil {
+		before = roleSummary(role.Name, role.Permissions)
+	}
+	perms := rolePermissionsFromForm(r)
+	err = u.RoleService.UpdateRole(roleID, name, strings.TrimSpace(r.FormValue("description")), perms)
+	if err != nil {
+		log.Printf("Failed to update role %d: %v", roleID, err)
+		http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape(roleErrorMessage(err)), http.StatusFound)
+		return
+	}
+	after := roleSummary(name, perms)
+	if role, err := u.RoleService.GetRole(roleID); err == nil {
+		after = roleSummary(role.Name, role.Permissions)
+	}
+	u.audit(r, admin, models.AuditEvent{
+		Action:      models.AuditRoleUpdate,
+		TargetType:  "role",
+		TargetID:    strconv.Itoa(roleID),
+		TargetLabel: name,
+		Before:      before,
+		After:       after,
+	})
+	http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape("Role "+name+" updated"), http.StatusFound)
+}
+
+// DeleteRole removes a custom role that no user has.
+func (u Users) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	admin := u.requireUserManager(w, r)
+	if admin == nil {
+		return
+	}
+	roleID, err := strconv.Atoi(chi.URLParam(r, "roleID"))
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	e := models.AuditEvent{Action: models.AuditRoleDelete, TargetType: "role", TargetID: strconv.Itoa(roleID)}
+	if role, err := u.RoleService.GetRole(roleID); err == nil {
+		e.TargetLabel = role.Name
+		e.Before = roleSummary(role.Name, role.Permissions)
+	}
+	if err := u.RoleService.DeleteRole(roleID); err != nil {
+		log.Printf("Failed to delete role %d: %v", roleID, err)
+		http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape(roleErrorMessage(err)), http.StatusFound)
+		return
+	}
+	u.audit(r, admin, e)
+	http.Redirect(w, r, "/admin/roles?message="+url.QueryEscape("Role deleted"), http.StatusFound)
+}
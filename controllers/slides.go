@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -19,10 +20,12 @@ type Slides struct {
 		SlideEditor       views.Template
 		SlidesList        views.Template
 		SlidePresentation views.Template
+		EditConflict      views.Template
 	}
 	SlideService    *models.SlideService
 	SessionService  *models.SessionService
 	CategoryService *models.CategoryService
+	AuditService    *models.AuditService
 }
 
 // AdminSlides displays the admin slides management page
@@ -168,6 +171,13 @@ func (s Slides) CreateSlide(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to create slide", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(s.AuditService, r, user, models.AuditEvent{
+		Action:      models.AuditSlideCreate,
+		TargetType:  "slide",
+		TargetID:    strconv.Itoa(slide.ID),
+		TargetLabel: title,
+		After:       slideSummary(title, slug, isPublished),
+	})
 
 	http.Redirect(w, r, fmt.Sprintf("/admin/slides/%d/edit", slide.ID), http.StatusFound)
 }
@@ -280,16 +290,68 @@ func (s Slides) UpdateSlide(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = s.SlideService.Update(slideID, title, slug, content, isPublished, categoryIDs)
+	before := ""
+	if existing, err := s.SlideService.GetByID(slideID); err == nil {
+		before = slideSummary(existing.Title, existing.Slug, existing.IsPublished)
+	}
+	// The version the editor was opened with; a save since then, or a form
+	// without one, is a conflict.
+	version, _ := strconv.Atoi(r.FormValue("version"))
+	err = s.SlideService.Update(slideID, version, title, slug, content, isPublished, categoryIDs)
+	if errors.Is(err, models.ErrVersionConflict) {
+		s.slideConflict(w, r, user, slideID, isPublished, categoryIDs)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating slide: %v", err)
 		http.Error(w, "Failed to update slide", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(s.AuditService, r, user, models.AuditEvent{
+		Action:      models.AuditSlideUpdate,
+		TargetType:  "slide",
+		TargetID:    strconv.Itoa(slideID),
+		TargetLabel: title,
+		Before:      before,
+		After:       slideSummary(title, slug, isPublished),
+	})
 
 	http.Redirect(w, r, fmt.Sprintf("/admin/slides/%d/edit", slideID), http.StatusFound)
 }
 
+// slideConflict shows user how the slide they tried to save differs from
+// the version saved since they opened the editor.
+func (s Slides) slideConflict(w http.ResponseWriter, r *http.Request, user *models.User, slideID int, isPublished bool, categoryIDs []int) {
+	saved, err := s.SlideService.GetByID(slideID)
+	if err != nil {
+		http.Error(w, "Slide not found", http.StatusNotFound)
+		return
+	}
+	c := newEditConflict(r, user, "slide", "admin-slides", string(saved.ContentHTML))
+	c.Title = saved.Title
+	c.Action = fmt.Sprintf("/admin/slides/%d", slideID)
+	c.EditURL = fmt.Sprintf("/admin/slides/%d/edit", slideID)
+	c.Version = saved.Version
+	c.SavedAt = utils.FormatRelativeTime(saved.UpdatedAt)
+
+	c.change("Title", saved.Title, r.FormValue("title"))
+	if slug := r.FormValue("slug"); slug != "" {
+		c.change("Slug", saved.Slug, slug)
+	}
+	c.change("Published", yesNo(saved.IsPublished), yesNo(isPublished))
+	all, err := s.CategoryService.GetAll()
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+	}
+	savedIDs := make([]int, len(saved.Categories))
+	for i, cat := range saved.Categories {
+		savedIDs[i] = cat.ID
+	}
+	c.change("Categories", categoryNames(all, savedIDs), categoryNames(all, categoryIDs))
+
+	c.execute(w, r, s.Templates.EditConflict)
+}
+
 // DeleteSlide handles slide deletion
 func (s Slides) DeleteSlide(w http.ResponseWriter, r *http.Request) {
 	user, err := s.isUserLoggedIn(r)
@@ -310,12 +372,23 @@ func (s Slides) DeleteSlide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	existing, _ := s.SlideService.GetByID(slideID)
 	err = s.SlideService.Delete(slideID)
 	if err != nil {
 		log.Printf("Error deleting slide: %v", err)
 		http.Error(w, "Failed to delete slide", http.StatusInternalServerError)
 		return
 	}
+	e := models.AuditEvent{
+		Action:     models.AuditSlideDelete,
+		TargetType: "slide",
+		TargetID:   strconv.Itoa(slideID),
+	}
+	if existing != nil {
+		e.TargetLabel = existing.Title
+		e.Before = slideSummary(existing.Title, existing.Slug, existing.IsPublished)
+	}
+	recordAudit(s.AuditService, r, user, e)
 
 	http.Redirect(w, r, "/admin/slides", http.StatusFound)
 }
@@ -471,4 +544,13 @@ func getUsername(user *models.User) string {
 		return ""
 	}
 	return user.Username
-}
\ No newline at end of file
+}
+
+// slideSummary describes a slide deck for the audit log.
+func slideSummary(title, slug string, published bool) string {
+	state := "draft"
+	if published {
+		state = "published"
+	}
+	return fmt.Sprintf("%q /%s (%s)", title, slug, state)
+}
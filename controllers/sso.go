@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/sso"
+	"github.com/go-chi/chi/v5"
+)
+
+func ssoCallbackPath(provider string) string {
+	return "/auth/" + provider + "/callback"
+}
+
+// startSSO sends the browser to the identity provider. linkUserID is set when
+// a signed-in user is linking an identity instead of signing in.
+func (u Users) startSSO(w http.ResponseWriter, r *http.Request, provider *sso.Provider, linkUserID *int) error {
+	nonce, codeVerifier, err := sso.NewLoginSecrets()
+	if err != nil {
+		return err
+	}
+	state, err := u.IdentityService.CreateLoginState(models.OIDCLoginState{
+		Provider:     provider.Config.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+	})
+	if err != nil {
+		return err
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(),
+		absoluteURL(r, ssoCallbackPath(provider.Config.Name)), state, nonce, codeVerifier)
+	if err != nil {
+		return err
+	}
+
+	// The state must come back to the browser that started the flow.
+	setCookie(w, CookieOIDCState, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// SSOLogin starts signing in with an external identity provider.
+func (u Users) SSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := u.SSOProviders.Get(chi.URLParam(r, "provider"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := u.startSSO(w, r, provider, nil); err != nil {
+		log.Printf("Failed to start %s sign-in: %v", provider.Config.Name, err)
+		msg := provider.Config.DisplayName + " sign-in is unavailable right now."
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg), http.StatusFound)
+	}
+}
+
+// LinkIdentity starts linking an external identity to the signed-in user.
+func (u Users) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	provider, ok := u.SSOProviders.Get(chi.URLParam(r, "provider"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := u.startSSO(w, r, provider, &user.UserID); err != nil {
+		log.Printf("Failed to start %s linking for user %d: %v", provider.Config.Name, user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not reach "+provider.Config.DisplayName), http.StatusFound)
+	}
+}
+
+// UnlinkIdentity removes an external identity from the signed-in user.
+func (u Users) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	identityID, err := strconv.Atoi(chi.URLParam(r, "identityID"))
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+	if err := u.IdentityService.Unlink(user.UserID, identityID); err != nil {
+		log.Printf("Failed to unlink identity %d for user %d: %v", identityID, user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not unlink account"), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Account unlinked"), http.StatusFound)
+}
+
+// SSOCallback completes the authorization code flow. Known identities sign
+// in; new ones are linked (when linking) or become new accounts, unless
+// signups are disabled or the email already belongs to a local account.
+func (u Users) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	signinError := func(msg string) {
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	provider, ok := u.SSOProviders.Get(chi.URLParam(r, "provider"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if idpErr := r.URL.Query().Get("error"); idpErr != "" {
+		log.Printf("%s sign-in returned error %q: %s", provider.Config.Name, idpErr, r.URL.Query().Get("error_description"))
+		signinError(provider.Config.DisplayName + " sign-in was cancelled or failed.")
+		return
+	}
+
+	stateParam := r.URL.Query().Get("state")
+	stateCookie, err := readCookie(r, CookieOIDCState)
+	deleteCookie(w, CookieOIDCState, "XXXXXX")
+	if err != nil || stateParam == "" || subtle.ConstantTimeCompare([]byte(stateParam), []byte(stateCookie)) != 1 {
+		signinError("Your sign-in attempt expired. Please try again.")
+		return
+	}
+	state, err := u.IdentityService.ConsumeLoginState(stateParam)
+	if err != nil || state.Provider != provider.Config.Name {
+		signinError("Your sign-in attempt expired. Please try again.")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), absoluteURL(r, ssoCallbackPath(provider.Config.Name)),
+		r.URL.Query().Get("code"), state.Nonce, state.CodeVerifier)
+	if err != nil {
+		log.Printf("%s sign-in failed: %v", provider.Config.Name, err)
+		signinError(provider.Config.DisplayName + " sign-in failed.")
+		return
+	}
+
+	if state.LinkUserID != nil {
+		u.finishLinkIdentity(w, r, provider, identity, *state.LinkUserID)
+		return
+	}
+
+	user, err := u.IdentityService.UserFor(identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		if provider.Config.SyncRoles {
+			if role := provider.RoleFor(identity); role != user.Role {
+				if err := u.UserService.UpdateRole(user.UserID, role); err != nil {
+					log.Printf("Failed to sync role for user %d: %v", user.UserID, err)
+				} else {
+					user.Role = role
+				}
+			}
+		}
+		u.signIn(w, r, user)
+		return
+	case !errors.Is(err, models.ErrNotFound):
+		log.Printf("Failed to look up %s identity: %v", provider.Config.Name, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	// First sign-in with this identity.
+	if identity.Email == "" {
+		signinError(provider.Config.DisplayName + " did not share an email address.")
+		return
+	}
+	if _, err := u.UserService.ByEmail(identity.Email); err == nil {
+		signinError("An account for " + identity.Email + " already exists. Sign in with your password, then link " +
+			provider.Config.DisplayName + " from your profile.")
+		return
+	}
+	if disabled, _ := strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP")); disabled {
+		signinError("Sign-ups are disabled. Ask an administrator for an account, then link " +
+			provider.Config.DisplayName + " from your profile.")
+		return
+	}
+
+	user, err = u.UserService.CreateExternal(identity.Email, ssoUsername(identity), provider.RoleFor(identity), identity.EmailVerified)
+	if err != nil {
+		log.Printf("Failed to create user for %s identity: %v", provider.Config.Name, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if err := u.IdentityService.Link(user.UserID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		log.Printf("Failed to link %s identity to new user %d: %v", provider.Config.Name, user.UserID, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	u.signIn(w, r, user)
+}
+
+func (u Users) finishLinkIdentity(w http.ResponseWriter, r *http.Request, provider *sso.Provider, identity *sso.Identity, userID int) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil || user.UserID != userID {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+
+	msg := provider.Config.DisplayName + " account linked"
+	if err := u.IdentityService.Link(user.UserID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		if errors.Is(err, models.ErrIdentityLinked) {
+			msg = "That " + provider.Config.DisplayName + " account is already linked to a user, or you have already linked one."
+		} else {
+			log.Printf("Failed to link %s identity for user %d: %v", provider.Config.Name, user.UserID, err)
+			msg = "Could not link " + provider.Config.DisplayName + " account"
+		}
+	}
+	http.Redirect(w, r, "/users/me?message="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// ssoUsername picks a display name for an account created from an identity.
+func ssoUsername(identity *sso.Identity) string {
+	if identity.Username != "" {
+		return identity.Username
+	}
+	if identity.Name != "" {
+		return identity.Name
+	}
+	local, _, _ := strings.Cut(identity.Email, "@")
+	return local
+}
@@ -59,3 +59,12 @@ func StaticHandler(tpl Template, sessionService *models.SessionService) http.Han
 		tpl.Execute(w, r, data)
 	}
 }
+
+// Stylesheet serves generated CSS, such as the code highlighting theme.
+func Stylesheet(css string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write([]byte(css))
+	}
+}
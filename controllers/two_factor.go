@@ -0,0 +1,554 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// twoFactorPage is the data for two-factor.gohtml. Mode is one of "setup",
+// "recovery-codes" or "challenge"; Action is where the code form posts to.
+type twoFactorPage struct {
+	Email           string
+	LoggedIn        bool
+	SignupDisabled  bool
+	IsAdmin         bool
+	Description     string
+	CurrentPage     string
+	Username        string
+	Message         string
+	Mode            string
+	Action          string
+	Continue        string
+	Enrollment      *models.TOTPEnrollment
+	RecoveryCodes   []string
+	UserPermissions models.UserPermissions
+}
+
+func (u Users) renderTwoFactor(w http.ResponseWriter, r *http.Request, data twoFactorPage) {
+	data.SignupDisabled, _ = strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
+	if data.Description == "" {
+		data.Description = "Two-Factor Authentication - Anshuman Biswas Blog"
+	}
+	if !data.LoggedIn {
+		data.CurrentPage = "signin"
+		data.UserPermissions = models.GetPermissions(models.RoleCommenter)
+	}
+	u.Templates.TwoFactor.Execute(w, r, data)
+}
+
+// requiresTwoFactor reports whether sign-in must ask user for a second
+// factor: either they have enrolled, or their role enforces 2FA and they will
+// be walked through enrollment.
+func (u Users) requiresTwoFactor(user *models.User) (bool, error) {
+	enabled, err := u.TwoFactorService.IsEnabled(user.UserID)
+	if err != nil || enabled {
+		return enabled, err
+	}
+	return u.TwoFactorService.RoleRequiresTwoFactor(user.Role)
+}
+
+// completeSignIn issues a session for a user who has passed every sign-in
+// step, clears their failed attempts and records the sign-in in the audit
+// log. Failures are only cleared here, not after the password check, so
+// wrong second-factor codes keep counting across challenges.
+func (u Users) completeSignIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	if err := u.LoginThrottle.RecordSuccess(user.Email); err != nil {
+		log.Printf("Failed to clear sign-in failures for %s: %v", user.Email, err)
+	}
+	session, err := u.SessionService.Create(user.UserID)
+	if err != nil {
+		return err
+	}
+	setCookie(w, CookieSession, session.Token)
+	setCookie(w, CookieUserEmail, user.Email)
+	u.audit(r, user, models.AuditEvent{
+		Action:      models.AuditSignIn,
+		TargetType:  "user",
+		TargetID:    strconv.Itoa(user.UserID),
+		TargetLabel: user.Email,
+	})
+	return nil
+}
+
+// challengeUser returns the user behind the pending two-factor cookie.
+func (u Users) challengeUser(r *http.Request) (string, *models.User, error) {
+	token, err := readCookie(r, CookieTwoFactor)
+	if err != nil {
+		return "", nil, err
+	}
+	user, err := u.TwoFactorService.ChallengeUser(token)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, user, nil
+}
+
+// TwoFactorChallenge asks for the second factor after a successful password
+// check. Users whose role enforces 2FA but who have not enrolled yet are
+// walked through enrollment instead.
+func (u Users) TwoFactorChallenge(w http.ResponseWriter, r *http.Request) {
+	_, user, err := u.challengeUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape("Your sign-in attempt expired. Please sign in again."), http.StatusFound)
+		return
+	}
+
+	data := twoFactorPage{
+		Email:   user.Email,
+		Action:  "/signin/2fa",
+		Message: r.URL.Query().Get("message"),
+		Mode:    "challenge",
+	}
+
+	enabled, err := u.TwoFactorService.IsEnabled(user.UserID)
+	if err != nil {
+		log.Printf("Failed to load two-factor status for user %d: %v", user.UserID, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		enrollment, err := u.TwoFactorService.PendingEnrollment(user)
+		if errors.Is(err, models.ErrNotFound) {
+			enrollment, err = u.TwoFactorService.BeginEnrollment(user)
+		}
+		if err != nil {
+			log.Printf("Failed to start two-factor enrollment for user %d: %v", user.UserID, err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+		data.Mode = "setup"
+		data.Enrollment = enrollment
+		if data.Message == "" {
+			data.Message = "Your role requires two-factor authentication. Set it up to finish signing in."
+		}
+	}
+
+	u.renderTwoFactor(w, r, data)
+}
+
+// ProcessTwoFactorChallenge verifies the submitted code (or confirms a forced
+// enrollment) and then creates the session.
+func (u Users) ProcessTwoFactorChallenge(w http.ResponseWriter, r *http.Request) {
+	token, user, err := u.challengeUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape("Your sign-in attempt expired. Please sign in again."), http.StatusFound)
+		return
+	}
+	code := r.FormValue("code")
+
+	// Wrong codes count as failed sign-ins, so the account locks and
+	// progressive delays apply to guessing the second factor too.
+	ip := clientIP(r)
+	check, err := u.LoginThrottle.Check(user.Email, ip)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if !check.Allowed() {
+		if !check.LockedUntil.IsZero() {
+			u.abandonChallenge(w, r, token, throttleMessage(check))
+			return
+		}
+		http.Redirect(w, r, "/signin/2fa?message="+url.QueryEscape(throttleMessage(check)), http.StatusFound)
+		return
+	}
+	remaining, err := u.TwoFactorService.UseChallengeAttempt(token)
+	if err != nil {
+		if !errors.Is(err, models.ErrTooManyTwoFactorAttempts) {
+			log.Printf("Failed to count two-factor attempt for user %d: %v", user.UserID, err)
+		}
+		u.abandonChallenge(w, r, token, "Too many incorrect codes. Please sign in again.")
+		return
+	}
+
+	enabled, err := u.TwoFactorService.IsEnabled(user.UserID)
+	if err != nil {
+		log.Printf("Failed to load two-factor status for user %d: %v", user.UserID, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var recoveryCodes []string
+	if enabled {
+		err = u.TwoFactorService.Verify(user.UserID, code)
+	} else {
+		recoveryCodes, err = u.TwoFactorService.ConfirmEnrollment(user.UserID, code)
+	}
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			log.Printf("Two-factor check failed for user %d: %v", user.UserID, err)
+		}
+		locked, err := u.LoginThrottle.RecordFailure(user.Email, ip)
+		if err != nil {
+			log.Printf("Failed to record two-factor failure for %s: %v", user.Email, err)
+		}
+		u.audit(r, nil, models.AuditEvent{
+			Action:      models.AuditSignInFailed,
+			TargetType:  "user",
+			TargetID:    strconv.Itoa(user.UserID),
+			TargetLabel: user.Email,
+			After:       "wrong two-factor code",
+		})
+		if locked != nil {
+			u.notifyAccountLocked(r, locked)
+			u.abandonChallenge(w, r, token, throttleMessage(models.LoginCheck{LockedUntil: time.Now().Add(u.LoginThrottle.Policy.LockoutDuration)}))
+			return
+		}
+		if remaining == 0 {
+			u.abandonChallenge(w, r, token, "Too many incorrect codes. Please sign in again.")
+			return
+		}
+		http.Redirect(w, r, "/signin/2fa?message="+url.QueryEscape("That code is not valid. Please try again."), http.StatusFound)
+		return
+	}
+
+	// The account may have been deactivated since the password was checked.
+	refusal, err := u.signInRefusal(r, user)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if refusal != "" {
+		u.abandonChallenge(w, r, token, refusal)
+		return
+	}
+
+	if err := u.TwoFactorService.DeleteChallenge(token); err != nil {
+		log.Printf("Failed to delete two-factor challenge for user %d: %v", user.UserID, err)
+	}
+	deleteCookie(w, CookieTwoFactor, "XXXXXX")
+
+	if err := u.completeSignIn(w, r, user); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	if recoveryCodes != nil {
+		u.renderTwoFactor(w, r, twoFactorPage{
+			Email:           user.Email,
+			Username:        user.Username,
+			LoggedIn:        true,
+			IsAdmin:         models.IsAdmin(user.Role),
+			CurrentPage:     "profile",
+			Mode:            "recovery-codes",
+			Continue:        "/",
+			RecoveryCodes:   recoveryCodes,
+			UserPermissions: models.GetPermissions(user.Role),
+		})
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// abandonChallenge ends a sign-in's two-factor step and sends the user back
+// to the password form with msg.
+func (u Users) abandonChallenge(w http.ResponseWriter, r *http.Request, token, msg string) {
+	if err := u.TwoFactorService.DeleteChallenge(token); err != nil {
+		log.Printf("Failed to delete two-factor challenge: %v", err)
+	}
+	deleteCookie(w, CookieTwoFactor, "XXXXXX")
+	http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// BeginTwoFactorSetup starts enrollment from the profile page and shows the
+// QR code to scan.
+func (u Users) BeginTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !isStaff(user.Role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	enrollment, err := u.TwoFactorService.BeginEnrollment(user)
+	if err != nil {
+		log.Printf("Failed to start two-factor enrollment for user %d: %v", user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not start two-factor setup"), http.StatusFound)
+		return
+	}
+
+	u.renderTwoFactor(w, r, twoFactorPage{
+		Email:           user.Email,
+		Username:        user.Username,
+		LoggedIn:        true,
+		IsAdmin:         models.IsAdmin(user.Role),
+		CurrentPage:     "profile",
+		Mode:            "setup",
+		Action:          "/users/2fa/confirm",
+		Enrollment:      enrollment,
+		UserPermissions: models.GetPermissions(user.Role),
+	})
+}
+
+// ConfirmTwoFactorSetup enables 2FA once the user enters a code from their
+// authenticator, then shows the recovery codes exactly once.
+func (u Users) ConfirmTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+
+	data := twoFactorPage{
+		Email:           user.Email,
+		Username:        user.Username,
+		LoggedIn:        true,
+		IsAdmin:         models.IsAdmin(user.Role),
+		CurrentPage:     "profile",
+		UserPermissions: models.GetPermissions(user.Role),
+	}
+
+	codes, err := u.TwoFactorService.ConfirmEnrollment(user.UserID, r.FormValue("code"))
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			log.Printf("Failed to confirm two-factor for user %d: %v", user.UserID, err)
+			http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not enable two-factor authentication"), http.StatusFound)
+			return
+		}
+		enrollment, perr := u.TwoFactorService.PendingEnrollment(user)
+		if perr != nil {
+			log.Printf("Failed to reload two-factor enrollment for user %d: %v", user.UserID, perr)
+			http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not enable two-factor authentication"), http.StatusFound)
+			return
+		}
+		data.Mode = "setup"
+		data.Action = "/users/2fa/confirm"
+		data.Enrollment = enrollment
+		data.Message = "That code is not valid. Please try again."
+		u.renderTwoFactor(w, r, data)
+		return
+	}
+
+	data.Mode = "recovery-codes"
+	data.Continue = "/users/me"
+	data.RecoveryCodes = codes
+	u.renderTwoFactor(w, r, data)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after
+// re-checking their password.
+func (u Users) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if _, err := u.UserService.Authenticate(user.Email, r.FormValue("password")); err != nil {
+		http.Redirect(w, r, "/users/me?message=Password is incorrect", http.StatusFound)
+		return
+	}
+
+	codes, err := u.TwoFactorService.RegenerateRecoveryCodes(user.UserID)
+	if err != nil {
+		log.Printf("Failed to regenerate recovery codes for user %d: %v", user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not regenerate recovery codes"), http.StatusFound)
+		return
+	}
+
+	u.renderTwoFactor(w, r, twoFactorPage{
+		Email:           user.Email,
+		Username:        user.Username,
+		LoggedIn:        true,
+		IsAdmin:         models.IsAdmin(user.Role),
+		CurrentPage:     "profile",
+		Mode:            "recovery-codes",
+		Continue:        "/users/me",
+		RecoveryCodes:   codes,
+		UserPermissions: models.GetPermissions(user.Role),
+	})
+}
+
+// DisableTwoFactor turns 2FA off for the current user, unless their role
+// enforces it.
+func (u Users) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if _, err := u.UserService.Authenticate(user.Email, r.FormValue("password")); err != nil {
+		http.Redirect(w, r, "/users/me?message=Password is incorrect", http.StatusFound)
+		return
+	}
+
+	required, err := u.TwoFactorService.RoleRequiresTwoFactor(user.Role)
+	if err != nil {
+		log.Printf("Failed to check two-factor requirement for role %d: %v", user.Role, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not disable two-factor authentication"), http.StatusFound)
+		return
+	}
+	if required {
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Two-factor authentication is required for your role"), http.StatusFound)
+		return
+	}
+
+	if err := u.TwoFactorService.Disable(user.UserID); err != nil {
+		log.Printf("Failed to disable two-factor for user %d: %v", user.UserID, err)
+		http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Could not disable two-factor authentication"), http.StatusFound)
+		return
+	}
+	u.auditUserID(r, user, models.AuditUserTwoFactorDisable, user.UserID, "", "two-factor authentication disabled")
+	http.Redirect(w, r, "/users/me?message="+url.QueryEscape("Two-factor authentication disabled"), http.StatusFound)
+}
+
+// AdminSecurity lists per-role 2FA enforcement and the users who have 2FA
+// enabled.
+func (u Users) AdminSecurity(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.CanManageSecurity(user.Role) {
+		http.Error(w, "Forbidden: Security access required", http.StatusForbidden)
+		return
+	}
+
+	roles, err := u.RoleService.GetAllRoles()
+	if err != nil {
+		log.Printf("Error getting roles: %v", err)
+		http.Error(w, "Failed to load roles", http.StatusInternalServerError)
+		return
+	}
+	users, err := u.UserService.GetAllUsers()
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	enabled, err := u.TwoFactorService.EnabledUserIDs()
+	if err != nil {
+		log.Printf("Error getting two-factor users: %v", err)
+		enabled = make(map[int]bool)
+	}
+	locked, err := u.LoginThrottle.LockedAccounts()
+	if err != nil {
+		log.Printf("Error getting locked accounts: %v", err)
+	}
+	events, err := u.LoginThrottle.Events.Recent(50)
+	if err != nil {
+		log.Printf("Error getting security events: %v", err)
+	}
+
+	data := struct {
+		Email            string
+		LoggedIn         bool
+		Username         string
+		IsAdmin          bool
+		SignupDisabled   bool
+		Description      string
+		CurrentPage      string
+		Flash            string
+		Roles            []*models.Role
+		Users            []*models.User
+		TwoFactorEnabled map[int]bool
+		LockedAccounts   []*models.LockedAccount
+		SecurityEvents   []*models.SecurityEvent
+		UserPermissions  models.UserPermissions
+	}{
+		Email:            user.Email,
+		LoggedIn:         true,
+		Username:         user.Username,
+		IsAdmin:          true,
+		SignupDisabled:   true,
+		Description:      "Security Settings - Anshuman Biswas Blog",
+		CurrentPage:      "admin-security",
+		Flash:            r.URL.Query().Get("message"),
+		Roles:            roles,
+		Users:            users,
+		TwoFactorEnabled: enabled,
+		LockedAccounts:   locked,
+		SecurityEvents:   events,
+		UserPermissions:  models.GetPermissions(user.Role),
+	}
+
+	u.Templates.AdminSecurity.Execute(w, r, data)
+}
+
+// SetRoleTwoFactor turns 2FA enforcement on or off for a role.
+func (u Users) SetRoleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.CanManageSecurity(user.Role) {
+		http.Error(w, "Forbidden: Security access required", http.StatusForbidden)
+		return
+	}
+
+	roleID, err := strconv.Atoi(chi.URLParam(r, "roleID"))
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	required := r.FormValue("require_two_factor") == "true"
+
+	if err := u.TwoFactorService.SetRoleRequirement(roleID, required); err != nil {
+		log.Printf("Error updating two-factor requirement for role %d: %v", roleID, err)
+		http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Failed to update role"), http.StatusFound)
+		return
+	}
+	e := models.AuditEvent{
+		Action:     models.AuditRoleTwoFactor,
+		TargetType: "role",
+		TargetID:   strconv.Itoa(roleID),
+		After:      "two-factor optional",
+	}
+	if required {
+		e.After = "two-factor required"
+	}
+	if role, err := u.RoleService.GetRole(roleID); err == nil {
+		e.TargetLabel = role.Name
+	}
+	u.audit(r, user, e)
+	http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Role updated"), http.StatusFound)
+}
+
+// ResetUserTwoFactor removes a user's 2FA enrollment so they can set it up
+// again, e.g. after losing their device and recovery codes.
+func (u Users) ResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := u.isUserLoggedIn(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	if !models.CanManageSecurity(user.Role) {
+		http.Error(w, "Forbidden: Security access required", http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := u.TwoFactorService.Disable(userID); err != nil {
+		log.Printf("Error resetting two-factor for user %d: %v", userID, err)
+		http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Failed to reset two-factor authentication"), http.StatusFound)
+		return
+	}
+	u.auditUserID(r, user, models.AuditUserTwoFactorReset, userID, "", "two-factor enrollment removed")
+	http.Redirect(w, r, "/admin/security?message="+url.QueryEscape("Two-factor authentication reset"), http.StatusFound)
+}
+
+// isStaff reports whether a role can enroll in two-factor authentication.
+func isStaff(roleID int) bool {
+	return models.CanEditPosts(roleID) || models.IsAdmin(roleID)
+}
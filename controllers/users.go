@@ -4,20 +4,27 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"html/template"
 
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/mail"
+	authmw "anshumanbiswas.com/blog/middleware"
 	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/sso"
 	"anshumanbiswas.com/blog/utils"
 	"github.com/go-chi/chi/v5"
 )
@@ -72,21 +79,42 @@ func (u Users) Disabled(w http.ResponseWriter, r *http.Request) {
 
 type Users struct {
 	Templates struct {
-		New        Template
-		SignIn     Template
-		Home       Template
-		LoggedIn   Template
-		Profile    Template
-		AdminPosts Template
-		UserPosts  Template
-		APIAccess  Template
-		PostEditor Template
-	}
-	UserService     *models.UserService
-	SessionService  *models.SessionService
-	PostService     *models.PostService
-	APITokenService *models.APITokenService
-	CategoryService *models.CategoryService
+		New              Template
+		SignIn           Template
+		Home             Template
+		LoggedIn         Template
+		Profile          Template
+		AdminPosts       Template
+		UserPosts        Template
+		APIAccess        Template
+		PostEditor       Template
+		ForgotPassword   Template
+		ResetPassword    Template
+		TwoFactor        Template
+		AdminSecurity    Template
+		AdminRoles       Template
+		AdminUsers       Template
+		AdminAudit       Template
+		RenderCompare    Template
+		AcceptInvitation Template
+		EditConflict     Template
+	}
+	UserService              *models.UserService
+	SessionService           *models.SessionService
+	PostService              *models.PostService
+	APITokenService          *models.APITokenService
+	CategoryService          *models.CategoryService
+	PasswordResetService     *models.PasswordResetService
+	EmailVerificationService *models.EmailVerificationService
+	InvitationService        *models.InvitationService
+	EmailService             *mail.EmailService
+	TwoFactorService         *models.TwoFactorService
+	RoleService              *models.RoleService
+	PasskeyService           *models.PasskeyService
+	IdentityService          *models.IdentityService
+	SSOProviders             *sso.Registry
+	LoginThrottle            *models.LoginThrottleService
+	AuditService             *models.AuditService
 }
 
 // UploadImage handles image uploads (cover or inline). Returns JSON {url}
@@ -312,7 +340,8 @@ func (u Users) UploadMultipleImages(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// PreviewRender returns rendered HTML for editor preview using server pipeline
+// PreviewRender returns rendered HTML for editor preview using server
+// pipeline, with any shortcodes that failed to expand
 func (u Users) PreviewRender(w http.ResponseWriter, r *http.Request) {
 	user, err := u.isUserLoggedIn(r)
 	if err != nil || (!models.CanEditPosts(user.Role) && !models.IsAdmin(user.Role)) {
@@ -324,9 +353,27 @@ func (u Users) PreviewRender(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	content := r.FormValue("content")
-	html := models.RenderContent(content)
+	engine := r.FormValue("render_engine")
+	if !render.ValidEngine(engine) {
+		engine = render.EngineLegacy
+	}
+	html := models.RenderPostContent(content, models.GetPermissions(user.Role).CanPostRawHTML, engine)
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"html": html})
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"html":   html,
+		"errors": render.ShortcodeErrors(content),
+	})
+}
+
+// setRenderEngine records the renderer chosen for a post. Saves that leave
+// the render_engine field out keep the post's current engine.
+func (u Users) setRenderEngine(postID int, engine string) {
+	if engine == "" {
+		return
+	}
+	if err := u.PostService.SetRenderEngine(postID, engine); err != nil {
+		log.Printf("Error setting post %d render engine: %v", postID, err)
+	}
 }
 
 // CreatePostFromFile creates a blog post from a file (API endpoint)
@@ -359,8 +406,13 @@ func (u Users) CreatePostFromFile(w http.ResponseWriter, r *http.Request) {
 		title = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
 	}
 
-	userIDStr := r.FormValue("user_id")
-	userID, _ := strconv.Atoi(userIDStr)
+	// A personal token's user is the author; only user managers may name
+	// someone else. The legacy token has no user, so the form names one.
+	apiUser := authmw.GetUserFromContext(r.Context())
+	userID, _ := strconv.Atoi(r.FormValue("user_id"))
+	if apiUser != nil && (userID == 0 || !models.GetPermissions(apiUser.Role).CanManageUsers) {
+		userID = apiUser.UserID
+	}
 	if userID == 0 {
 		userID = 2 // Default to admin user
 	}
@@ -372,6 +424,9 @@ func (u Users) CreatePostFromFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	isPublished := r.FormValue("is_published") == "true"
+	if apiUser != nil && !models.GetPermissions(apiUser.Role).CanPublishPosts {
+		isPublished = false
+	}
 	featured := r.FormValue("featured") == "true"
 	featuredImageURL := r.FormValue("featured_image_url")
 
@@ -382,11 +437,13 @@ func (u Users) CreatePostFromFile(w http.ResponseWriter, r *http.Request) {
 	slug = strings.Trim(slug, "-")
 
 	// Create post
-	post, err := u.PostService.Create(userID, categoryID, title, string(content), isPublished, featured, featuredImageURL, slug)
+	post, err := u.PostService.Create(userID, categoryID, title, string(content), isPublished, featured, featuredImageURL, slug, models.TrustsHTML(apiUser, userID))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create post: %v", err), http.StatusInternalServerError)
 		return
 	}
+	u.auditPublication(r, apiUser, post.ID, title, false, isPublished)
+	u.setRenderEngine(post.ID, r.FormValue("render_engine"))
 
 	resp := map[string]interface{}{
 		"id":      post.ID,
@@ -488,9 +545,31 @@ func (u Users) Home(w http.ResponseWriter, r *http.Request) {
 		Description     string
 		CurrentPage     string
 		UserPermissions models.UserPermissions
+		// Filters are the feed filters in effect, FeedQuery the same
+		// encoded for the next page's requests.
+		Filters       url.Values
+		FeedQuery     string
+		OlderPostsURL string
 	}
 
-	posts, _ := u.GetTopPosts()
+	q, err := models.ParsePostFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := u.PostService.GetFeedPage(q)
+	if err != nil {
+		log.Printf("Error loading home feed: %v", err)
+		http.Error(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+	data.Filters = q.Values()
+	data.FeedQuery = data.Filters.Encode()
+	if posts.NextCursor != "" {
+		next := q.Values()
+		next.Set("cursor", posts.NextCursor)
+		data.OlderPostsURL = "/?" + next.Encode()
+	}
 
 	// Get signup disabled setting from environment
 	isSignupDisabled, _ := strconv.ParseBool(os.Getenv("APP_DISABLE_SIGNUP"))
@@ -530,16 +609,24 @@ func (u Users) Home(w http.ResponseWriter, r *http.Request) {
 	u.Templates.Home.Execute(w, r, data)
 }
 
+// LoadMorePosts returns the next page of the home feed as JSON, after the
+// cursor parameter and with the same filters as Home. Clients that still
+// send offset get offset pagination.
 func (u Users) LoadMorePosts(w http.ResponseWriter, r *http.Request) {
-	// Get offset from query parameters
-	offsetStr := r.URL.Query().Get("offset")
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil {
-		offset = 0
+	query := r.URL.Query()
+	var posts *models.PostsList
+	var err error
+	if offsetStr := query.Get("offset"); offsetStr != "" && query.Get("cursor") == "" {
+		offset, _ := strconv.Atoi(offsetStr)
+		posts, err = u.PostService.GetTopPostsWithPagination(models.FeedPageSize, offset)
+	} else {
+		q, perr := models.ParsePostFilters(query)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		posts, err = u.PostService.GetFeedPage(q)
 	}
-
-	// Get posts with pagination (5 posts at a time)
-	posts, err := u.PostService.GetTopPostsWithPagination(5, offset)
 	if err != nil {
 		http.Error(w, "Failed to load posts", http.StatusInternalServerError)
 		return
@@ -559,6 +646,8 @@ func (u Users) SignIn(w http.ResponseWriter, r *http.Request) {
 		Description     string
 		CurrentPage     string
 		Username        string
+		Message         string
+		Providers       []*sso.Provider
 		UserPermissions models.UserPermissions
 	}
 	data.Email = r.FormValue("email")
@@ -568,6 +657,8 @@ func (u Users) SignIn(w http.ResponseWriter, r *http.Request) {
 	data.Description = "Sign in to Anshuman Biswas Blog"
 	data.CurrentPage = "signin"
 	data.Username = ""
+	data.Message = r.URL.Query().Get("message")
+	data.Providers = u.SSOProviders.Providers()
 	data.UserPermissions = models.GetPermissions(models.RoleCommenter)
 	u.Templates.SignIn.Execute(w, r, data)
 }
@@ -580,20 +671,121 @@ func (u Users) ProcessSignIn(w http.ResponseWriter, r *http.Request) {
 	data.Email = r.FormValue("email")
 	data.Password = r.FormValue("password")
 
+	signinError := func(msg string) {
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg)+"&email="+url.QueryEscape(data.Email), http.StatusFound)
+	}
+
+	ip := clientIP(r)
+	check, err := u.LoginThrottle.Check(data.Email, ip)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if !check.Allowed() {
+		signinError(throttleMessage(check))
+		return
+	}
+
 	user, err := u.UserService.Authenticate(data.Email, data.Password)
+	if err != nil {
+		if !isBadCredentials(err) {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+		locked, err := u.LoginThrottle.RecordFailure(data.Email, ip)
+		if err != nil {
+			log.Printf("Failed to record sign-in failure for %s: %v", data.Email, err)
+		}
+		u.audit(r, nil, models.AuditEvent{
+			Action:      models.AuditSignInFailed,
+			TargetType:  "user",
+			TargetLabel: models.NormalizeEmail(data.Email),
+		})
+		if locked != nil {
+			u.notifyAccountLocked(r, locked)
+			signinError(throttleMessage(models.LoginCheck{LockedUntil: time.Now().Add(u.LoginThrottle.Policy.LockoutDuration)}))
+			return
+		}
+		signinError("Invalid email or password.")
+		return
+	}
+	u.signIn(w, r, user)
+}
+
+// signInRefusal returns why user may not sign in, whichever credential they
+// used: their account is deactivated, or an administrator has required a new
+// password, in which case a reset link is emailed. It returns "" when the
+// sign-in may go ahead.
+func (u Users) signInRefusal(r *http.Request, user *models.User) (string, error) {
+	status, err := u.UserService.Status(user.UserID)
+	if err != nil {
+		return "", err
+	}
+	if status.Deactivated {
+		return "This account has been deactivated. Contact the site administrator.", nil
+	}
+	if status.PasswordResetRequired {
+		if err := u.sendPasswordReset(r, user.Email); err != nil {
+			log.Printf("Failed to send required password reset to %s: %v", user.Email, err)
+		}
+		return "You need to choose a new password. We've emailed you a reset link.", nil
+	}
+	return "", nil
+}
+
+// signIn finishes signing in a user whose primary credential (password or
+// external identity) has been checked: it turns away accounts that
+// signInRefusal blocks, then enforces email verification and the two-factor
+// step before issuing a session.
+func (u Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) {
+	refusal, err := u.signInRefusal(r, user)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
-	session, err := u.SessionService.Create(user.UserID)
+	if refusal != "" {
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape(refusal)+"&email="+url.QueryEscape(user.Email), http.StatusFound)
+		return
+	}
+
+	if !user.EmailVerified {
+		// The credential was correct, so it's safe to send a fresh link.
+		if err := u.sendEmailVerification(r, user.UserID, user.Email); err != nil {
+			log.Printf("Failed to resend verification to %s: %v", user.Email, err)
+		}
+		msg := "Please confirm your email address before signing in. We've sent you a new confirmation link."
+		http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg)+"&email="+url.QueryEscape(user.Email), http.StatusFound)
+		return
+	}
+
+	// Accounts with 2FA (or whose role enforces it) get a second step before
+	// any session is issued.
+	needsSecondFactor, err := u.requiresTwoFactor(user)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
-	setCookie(w, CookieSession, session.Token)
-	setCookie(w, CookieUserEmail, data.Email)
+	if needsSecondFactor {
+		token, err := u.TwoFactorService.CreateChallenge(user.UserID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+		setCookie(w, CookieTwoFactor, token)
+		http.Redirect(w, r, "/signin/2fa", http.StatusFound)
+		return
+	}
+
+	if err := u.completeSignIn(w, r, user); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -609,17 +801,13 @@ func (u Users) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
-	session, err := u.SessionService.Create(user.UserID)
-	if err != nil {
-		fmt.Println(err)
-		// TODO: Long term, we should show a warning about not being able to sign the user in.
-		http.Redirect(w, r, "/signin", http.StatusFound)
-		return
-	}
-	setCookie(w, CookieSession, session.Token)
-	setCookie(w, CookieUserEmail, email)
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	// New accounts can sign in once they confirm their email address.
+	if err := u.sendEmailVerification(r, user.UserID, user.Email); err != nil {
+		log.Printf("Failed to send verification to %s: %v", user.Email, err)
+	}
+	msg := "Account created. Check your inbox to confirm your email address, then sign in."
+	http.Redirect(w, r, "/signin?message="+url.QueryEscape(msg)+"&email="+url.QueryEscape(user.Email), http.StatusFound)
 }
 
 // DeleteImage handles deletion of uploaded images
@@ -695,6 +883,15 @@ func (u Users) CurrentUser(w http.ResponseWriter, r *http.Request) {
 		CurrentPage     string
 		Message         string
 		UserPermissions models.UserPermissions
+
+		TwoFactorAvailable bool
+		TwoFactorEnabled   bool
+		TwoFactorRequired  bool
+		RecoveryCodesLeft  int
+		Passkeys           []*models.Passkey
+
+		LinkedIdentities []*models.LinkedIdentity
+		Providers        []*sso.Provider
 	}
 
 	data.Email = user.Email
@@ -707,6 +904,31 @@ func (u Users) CurrentUser(w http.ResponseWriter, r *http.Request) {
 	data.Message = r.URL.Query().Get("message")
 	data.UserPermissions = models.GetPermissions(user.Role)
 
+	// Two-factor authentication is offered to staff accounts
+	data.TwoFactorAvailable = isStaff(user.Role)
+	if data.TwoFactorAvailable {
+		data.TwoFactorEnabled, err = u.TwoFactorService.IsEnabled(user.UserID)
+		if err != nil {
+			log.Printf("Failed to load two-factor status for user %d: %v", user.UserID, err)
+		}
+		data.TwoFactorRequired, _ = u.TwoFactorService.RoleRequiresTwoFactor(user.Role)
+		if data.TwoFactorEnabled {
+			data.RecoveryCodesLeft, _ = u.TwoFactorService.RemainingRecoveryCodes(user.UserID)
+		}
+		data.Passkeys, err = u.PasskeyService.List(user.UserID)
+		if err != nil {
+			log.Printf("Failed to load passkeys for user %d: %v", user.UserID, err)
+		}
+	}
+
+	data.Providers = u.SSOProviders.Providers()
+	if len(data.Providers) > 0 {
+		data.LinkedIdentities, err = u.IdentityService.ListForUser(user.UserID)
+		if err != nil {
+			log.Printf("Failed to load linked identities for user %d: %v", user.UserID, err)
+		}
+	}
+
 	u.Templates.Profile.Execute(w, r, data)
 }
 
@@ -719,6 +941,14 @@ func (u Users) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user, err := u.isUserLoggedIn(r); err == nil {
+		u.audit(r, user, models.AuditEvent{
+			Action:      models.AuditSignOut,
+			TargetType:  "user",
+			TargetID:    strconv.Itoa(user.UserID),
+			TargetLabel: user.Email,
+		})
+	}
 	u.SessionService.Logout(email)
 
 	deleteCookie(w, CookieSession, "XXXXXX")
@@ -756,6 +986,13 @@ func (u Users) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Accounts created through the API are provisioned by an administrator,
+	// so their email address is trusted.
+	if err := u.UserService.MarkEmailVerified(user.UserID); err != nil {
+		log.Printf("Failed to mark user %d as verified: %v", user.UserID, err)
+	}
+	user.EmailVerified = true
+
 	// Return created user
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
@@ -801,7 +1038,7 @@ func (u Users) UpdateEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newEmail := r.FormValue("new_email")
+	newEmail := strings.TrimSpace(r.FormValue("new_email"))
 	password := r.FormValue("password")
 
 	// Verify password
@@ -811,17 +1048,16 @@ func (u Users) UpdateEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update email
-	err = u.UserService.UpdateEmail(user.UserID, newEmail)
+	// The new address only takes effect once it is confirmed from its inbox
+	err = u.sendEmailVerification(r, user.UserID, newEmail)
 	if err != nil {
+		log.Printf("Failed to send email change verification for user %d: %v", user.UserID, err)
 		http.Redirect(w, r, "/users/me?message=Failed to update email", http.StatusFound)
 		return
 	}
 
-	// Update cookie with new email
-	setCookie(w, CookieUserEmail, newEmail)
-
-	http.Redirect(w, r, "/users/me?message=Email updated successfully", http.StatusFound)
+	msg := "We sent a confirmation link to " + newEmail + ". Your email will change once you confirm it."
+	http.Redirect(w, r, "/users/me?message="+url.QueryEscape(msg), http.StatusFound)
 }
 
 // AdminPosts shows all posts for admin users
@@ -974,7 +1210,8 @@ func (u Users) CreatePost(w http.ResponseWriter, r *http.Request) {
 	featuredImageURL := r.FormValue("featured_image_url")
 	featured := r.FormValue("featured") == "on"
 	slug := r.FormValue("slug")
-	isPublished := r.FormValue("is_published") == "on"
+	// Roles without publish rights can only save drafts.
+	isPublished := r.FormValue("is_published") == "on" && models.GetPermissions(user.Role).CanPublishPosts
 
 	// Parse multiple categories
 	categoryIDStrings := r.Form["categories"] // Get all category values
@@ -1004,11 +1241,13 @@ func (u Users) CreatePost(w http.ResponseWriter, r *http.Request) {
 		slug = strings.ReplaceAll(slug, "--", "-")
 	}
 
-	post, err := u.PostService.Create(user.UserID, categoryID, title, content, isPublished, featured, featuredImageURL, slug)
+	post, err := u.PostService.Create(user.UserID, categoryID, title, content, isPublished, featured, featuredImageURL, slug, models.TrustsHTML(user, user.UserID))
 	if err != nil {
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
 		return
 	}
+	u.auditPublication(r, user, post.ID, title, false, isPublished)
+	u.setRenderEngine(post.ID, r.FormValue("render_engine"))
 
 	// Assign categories to the post
 	if err := u.CategoryService.AssignCategoriesToPost(post.ID, categoryIDs); err != nil {
@@ -1039,6 +1278,10 @@ func (u Users) EditPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	if !models.CanEditPost(user, post) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	// Ensure ContentHTML for prefill
 	post.ContentHTML = template.HTML(post.Content)
 
@@ -1105,12 +1348,22 @@ func (u Users) UpdatePost(w http.ResponseWriter, r *http.Request) {
 
 	idStr := chi.URLParam(r, "postID")
 	id, _ := strconv.Atoi(idStr)
+	existing, err := u.PostService.GetByID(id)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if !models.CanEditPost(user, existing) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	title := r.FormValue("title")
 	content := r.FormValue("content")
 	featuredImageURL := r.FormValue("featured_image_url")
 	featured := r.FormValue("featured") == "on"
 	slug := r.FormValue("slug")
-	isPublished := r.FormValue("is_published") == "on"
+	isPublished := r.FormValue("is_published") == "on" && models.GetPermissions(user.Role).CanPublishPosts
 
 	// Parse multiple categories
 	categoryIDStrings := r.Form["categories"] // Get all category values
@@ -1139,10 +1392,19 @@ func (u Users) UpdatePost(w http.ResponseWriter, r *http.Request) {
 		slug = strings.ReplaceAll(slug, "--", "-")
 	}
 
-	if err := u.PostService.Update(id, categoryID, title, content, isPublished, featured, featuredImageURL, slug); err != nil {
+	// The version the editor was opened with; a save since then, or a form
+	// without one, is a conflict.
+	version, _ := strconv.Atoi(r.FormValue("version"))
+	if err := u.PostService.Update(id, version, categoryID, title, content, isPublished, featured, featuredImageURL, slug, models.TrustsHTML(user, existing.UserID)); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			u.postConflict(w, r, user, id, slug, isPublished, featured, categoryIDs)
+			return
+		}
 		http.Error(w, "Failed to update post", http.StatusInternalServerError)
 		return
 	}
+	u.auditPublication(r, user, id, title, existing.IsPublished, isPublished)
+	u.setRenderEngine(id, r.FormValue("render_engine"))
 
 	// Update categories for the post
 	if err := u.CategoryService.AssignCategoriesToPost(id, categoryIDs); err != nil {
@@ -1153,6 +1415,46 @@ func (u Users) UpdatePost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/blog/"+slug, http.StatusFound)
 }
 
+// postConflict shows user how the post they tried to save differs from the
+// version saved since they opened the editor.
+func (u Users) postConflict(w http.ResponseWriter, r *http.Request, user *models.User, id int, slug string, isPublished, featured bool, categoryIDs []int) {
+	saved, err := u.PostService.GetByID(id)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	c := newEditConflict(r, user, "post", "admin-posts", saved.Content)
+	c.Title = saved.Title
+	c.Action = fmt.Sprintf("/admin/posts/%d", id)
+	c.EditURL = fmt.Sprintf("/admin/posts/%d/edit", id)
+	c.Version = saved.Version
+	c.SavedAt = utils.FormatRelativeTime(saved.LastEditDate)
+
+	c.change("Title", saved.Title, r.FormValue("title"))
+	c.change("Slug", saved.Slug, slug)
+	c.change("Published", yesNo(saved.IsPublished), yesNo(isPublished))
+	c.change("Featured", yesNo(saved.Featured), yesNo(featured))
+	c.change("Featured image", saved.FeaturedImageURL, r.FormValue("featured_image_url"))
+	if engine := r.FormValue("render_engine"); engine != "" {
+		c.change("Renderer", saved.RenderEngine, engine)
+	}
+	all, err := u.CategoryService.GetAll()
+	if err != nil {
+		log.Printf("Error loading categories: %v", err)
+	}
+	savedCategories, err := u.CategoryService.GetCategoriesByPostID(id)
+	if err != nil {
+		log.Printf("Error loading post categories: %v", err)
+	}
+	savedIDs := make([]int, len(savedCategories))
+	for i, cat := range savedCategories {
+		savedIDs[i] = cat.ID
+	}
+	c.change("Categories", categoryNames(all, savedIDs), categoryNames(all, categoryIDs))
+
+	c.execute(w, r, u.Templates.EditConflict)
+}
+
 // APIAccess shows the API access management page
 func (u Users) APIAccess(w http.ResponseWriter, r *http.Request) {
 	user, err := u.isUserLoggedIn(r)
@@ -1217,6 +1519,7 @@ func (u Users) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/api-access?message=Failed to create API token", http.StatusFound)
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenCreate, token.ID, tokenName)
 
 	// For security, we show the token only once after creation
 	http.Redirect(w, r, fmt.Sprintf("/api-access?message=Token created successfully: %s&new_token=%s", tokenName, token.Token), http.StatusFound)
@@ -1242,6 +1545,7 @@ func (u Users) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/api-access?message=Failed to revoke token", http.StatusFound)
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenRevoke, tokenID, "")
 
 	http.Redirect(w, r, "/api-access?message=Token revoked successfully", http.StatusFound)
 }
@@ -1266,6 +1570,7 @@ func (u Users) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/api-access?message=Failed to delete token", http.StatusFound)
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenDelete, tokenID, "")
 
 	http.Redirect(w, r, "/api-access?message=Token deleted successfully", http.StatusFound)
 }
@@ -1317,6 +1622,7 @@ func (u Users) CreateAPITokenJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create API token"})
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenCreate, token.ID, tokenName)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1349,6 +1655,7 @@ func (u Users) RevokeAPITokenJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to revoke token"})
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenRevoke, tokenID, "")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1380,6 +1687,7 @@ func (u Users) DeleteAPITokenJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete token"})
 		return
 	}
+	u.auditToken(r, user, models.AuditTokenDelete, tokenID, "")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3,20 +3,40 @@ module anshumanbiswas.com/blog
 go 1.21.0
 
 require (
+	github.com/alecthomas/chroma/v2 v2.14.0
+	github.com/coreos/go-oidc/v3 v3.12.0
 	github.com/go-chi/chi/v5 v5.0.10
+	github.com/go-jose/go-jose/v4 v4.0.2
+	github.com/go-webauthn/webauthn v0.10.2
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/pquerna/otp v1.5.0
 	github.com/russross/blackfriday/v2 v2.1.0
+	github.com/yuin/goldmark v1.7.8
 	go.uber.org/zap v1.25.0
+	golang.org/x/net v0.27.0
+	golang.org/x/oauth2 v0.21.0
 )
 
 require (
-	github.com/gorilla/securecookie v1.1.1 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
+	github.com/dlclark/regexp2 v1.11.0 // indirect
+	github.com/fxamacker/cbor/v2 v2.6.0 // indirect
+	github.com/go-webauthn/x v0.1.9 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+	github.com/google/go-tpm v0.9.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	github.com/gorilla/securecookie v1.1.2 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.22.0 // indirect
 )
 
 require (
-	github.com/gorilla/csrf v1.7.1
+	github.com/gorilla/csrf v1.7.3
 	github.com/lib/pq v1.10.9
-	github.com/stretchr/testify v1.8.4 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/crypto v0.13.0
+	golang.org/x/crypto v0.25.0
 )
@@ -0,0 +1,268 @@
+package render
+
+import (
+	"encoding/base64"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// admonitionStyles are the Tailwind classes for each admonition kind, in
+// the form addBlockquoteClasses uses for quotes. GitHub's kinds come first.
+var admonitionStyles = map[string]string{
+	"note":      "border-blue-500 bg-blue-50 dark:border-blue-400 dark:bg-blue-900/20",
+	"tip":       "border-green-500 bg-green-50 dark:border-green-400 dark:bg-green-900/20",
+	"important": "border-purple-500 bg-purple-50 dark:border-purple-400 dark:bg-purple-900/20",
+	"warning":   "border-amber-500 bg-amber-50 dark:border-amber-400 dark:bg-amber-900/20",
+	"caution":   "border-red-500 bg-red-50 dark:border-red-400 dark:bg-red-900/20",
+	"info":      "border-sky-500 bg-sky-50 dark:border-sky-400 dark:bg-sky-900/20",
+	"danger":    "border-red-500 bg-red-50 dark:border-red-400 dark:bg-red-900/20",
+}
+
+// admonitionOpen starts an admonition box. The title is plain text; when
+// empty the kind is used.
+func admonitionOpen(kind, title string) string {
+	if title == "" {
+		title = strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	return `<div class="admonition admonition-` + kind + ` p-4 my-4 border-s-4 ` + admonitionStyles[kind] + `">` +
+		`<p class="admonition-title font-semibold mb-2">` + html.EscapeString(title) + "</p>"
+}
+
+var (
+	// admonitionFenceRe opens a ::: container, ":::warning Title", and
+	// admonitionCloseRe closes it with the same number of colons.
+	admonitionFenceRe = regexp.MustCompile(`^(:{3,})[ \t]*([A-Za-z]+)(?:[ \t]+(.*?))?[ \t]*$`)
+	admonitionCloseRe = regexp.MustCompile(`^(:{3,})[ \t]*$`)
+	// admonitionMarkerRe is GitHub's first line of an admonition quote,
+	// "[!NOTE]", optionally followed by a title.
+	admonitionMarkerRe = regexp.MustCompile(`^\[!([A-Za-z]+)\][ \t]*(.*?)[ \t]*$`)
+	// admonitionQuoteRe matches a quoted line and captures what follows
+	// the ">".
+	admonitionQuoteRe = regexp.MustCompile(`^[ ]{0,3}>[ ]?(.*)$`)
+)
+
+// admonitionKind returns the lower-case kind if name is a known one.
+func admonitionKind(name string) (string, bool) {
+	kind := strings.ToLower(name)
+	_, ok := admonitionStyles[kind]
+	return kind, ok
+}
+
+// Admonitions are carried through Markdown rendering as comments, like
+// shortcodes, so their content is still Markdown.
+func admonitionOpenMarker(kind, title string) string {
+	return "<!--admonition:" + kind + ":" + base64.RawStdEncoding.EncodeToString([]byte(title)) + "-->"
+}
+
+const admonitionCloseMarker = "<!--/admonition-->"
+
+// markAdmonitions replaces GitHub admonition quotes, "> [!WARNING] Title",
+// and ::: containers, ":::warning Title" to ":::", with markers around
+// their content for insertAdmonitionHTML. Code is left alone, as are
+// unknown kinds and unclosed containers.
+func markAdmonitions(s string) string {
+	if !strings.Contains(s, ":::") && !strings.Contains(s, "[!") {
+		return s
+	}
+	var stash []string
+	masked := skipCodeRe.ReplaceAllStringFunc(s, func(m string) string {
+		stash = append(stash, m)
+		return placeholder("ADMONITION_CODE", len(stash)-1)
+	})
+	restore := func(s string) string {
+		for i, m := range stash {
+			s = strings.ReplaceAll(s, placeholder("ADMONITION_CODE", i), m)
+		}
+		return s
+	}
+	lines := markAdmonitionLines(strings.Split(masked, "\n"), restore)
+	return restore(strings.Join(lines, "\n"))
+}
+
+func markAdmonitionLines(lines []string, restore func(string) string) []string {
+	var out []string
+	wrap := func(kind, title string, body []string) {
+		out = append(out, "", admonitionOpenMarker(kind, title), "")
+		out = append(out, body...)
+		out = append(out, "", admonitionCloseMarker, "")
+	}
+	for i := 0; i < len(lines); i++ {
+		if q := admonitionQuoteRe.FindStringSubmatch(lines[i]); q != nil {
+			m := admonitionMarkerRe.FindStringSubmatch(q[1])
+			if m == nil {
+				out = append(out, lines[i])
+				continue
+			}
+			kind, ok := admonitionKind(m[1])
+			if !ok {
+				out = append(out, lines[i])
+				continue
+			}
+			// The rest of the quote is the content. Code in it was masked
+			// with its ">"s, so those are removed from the restored text.
+			var body []string
+			for i+1 < len(lines) && admonitionQuoteRe.MatchString(lines[i+1]) {
+				i++
+				body = append(body, lines[i])
+			}
+			quoted := strings.Split(restore(strings.Join(body, "\n")), "\n")
+			for j, line := range quoted {
+				if q := admonitionQuoteRe.FindStringSubmatch(line); q != nil {
+					quoted[j] = q[1]
+				}
+			}
+			wrap(kind, m[2], []string{markAdmonitions(strings.Join(quoted, "\n"))})
+			continue
+		}
+		m := admonitionFenceRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			out = append(out, lines[i])
+			continue
+		}
+		kind, ok := admonitionKind(m[2])
+		end := -1
+		for j := i + 1; ok && j < len(lines); j++ {
+			if c := admonitionCloseRe.FindStringSubmatch(lines[j]); c != nil && c[1] == m[1] {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			out = append(out, lines[i])
+			continue
+		}
+		wrap(kind, m[3], markAdmonitionLines(lines[i+1:end], restore))
+		i = end
+	}
+	return out
+}
+
+var admonitionMarkerHTMLRe = regexp.MustCompile(`<p>\s*(<!--admonition:[a-z]+:[A-Za-z0-9+/]*-->|<!--/admonition-->)\s*</p>|<!--admonition:([a-z]+):([A-Za-z0-9+/]*)-->|<!--/admonition-->`)
+
+// insertAdmonitionHTML turns the markers left by markAdmonitions into
+// admonition boxes.
+func insertAdmonitionHTML(s string) string {
+	if !strings.Contains(s, "admonition") {
+		return s
+	}
+	return admonitionMarkerHTMLRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := admonitionMarkerHTMLRe.FindStringSubmatch(m)
+		if sub[1] != "" {
+			sub = admonitionMarkerHTMLRe.FindStringSubmatch(sub[1])
+		}
+		if sub[2] == "" {
+			return "</div>"
+		}
+		title, _ := base64.RawStdEncoding.DecodeString(sub[3])
+		return admonitionOpen(sub[2], string(title))
+	})
+}
+
+// ---- AST engine ----
+
+var kindAdmonition = ast.NewNodeKind("Admonition")
+
+type admonitionBlock struct {
+	ast.BaseBlock
+	kind, title string
+	fence       string // the colons that close a ::: container
+}
+
+func (n *admonitionBlock) Kind() ast.NodeKind { return kindAdmonition }
+func (n *admonitionBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Kind": n.kind, "Title": n.title}, nil)
+}
+
+// admonitionBlockParser reads ::: containers. They nest by using more
+// colons on the outer one.
+type admonitionBlockParser struct{}
+
+func (admonitionBlockParser) Trigger() []byte { return []byte{':'} }
+
+func (admonitionBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	m := admonitionFenceRe.FindStringSubmatch(strings.TrimRight(string(line[pos:]), "\r\n"))
+	if m == nil {
+		return nil, parser.NoChildren
+	}
+	kind, ok := admonitionKind(m[2])
+	if !ok {
+		return nil, parser.NoChildren
+	}
+	reader.Advance(segment.Len() - 1)
+	return &admonitionBlock{kind: kind, title: m[3], fence: m[1]}, parser.HasChildren
+}
+
+func (admonitionBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if c := admonitionCloseRe.FindStringSubmatch(strings.TrimSpace(string(line))); c != nil && c[1] == node.(*admonitionBlock).fence {
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (admonitionBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+func (admonitionBlockParser) CanInterruptParagraph() bool                                { return true }
+func (admonitionBlockParser) CanAcceptIndentedLine() bool                                { return false }
+
+// admonitionExtension adds the ::: container parser.
+type admonitionExtension struct{}
+
+func (admonitionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(util.Prioritized(admonitionBlockParser{}, 760)))
+}
+
+// admonitionNodes turns GitHub admonition quotes into admonition boxes;
+// the ::: containers are parsed as boxes already.
+func admonitionNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool { return n.Kind() == ast.KindBlockquote }) {
+		p, ok := n.FirstChild().(*ast.Paragraph)
+		if !ok || p.Lines().Len() == 0 {
+			continue
+		}
+		first := p.Lines().At(0)
+		m := admonitionMarkerRe.FindStringSubmatch(strings.TrimRight(string(first.Value(source)), "\r\n"))
+		if m == nil {
+			continue
+		}
+		kind, ok := admonitionKind(m[1])
+		if !ok {
+			continue
+		}
+		// Drop the marker line from the paragraph, or the paragraph if
+		// that was all of it.
+		rest := false
+		for c := p.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			t, isText := c.(*ast.Text)
+			p.RemoveChild(p, c)
+			if isText && (t.SoftLineBreak() || t.HardLineBreak()) {
+				rest = next != nil
+				break
+			}
+			c = next
+		}
+		if !rest {
+			n.RemoveChild(n, p)
+		}
+		box := &admonitionBlock{kind: kind, title: m[2]}
+		for c := n.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			box.AppendChild(box, c)
+			c = next
+		}
+		n.Parent().ReplaceChild(n.Parent(), n, box)
+	}
+}
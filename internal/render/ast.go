@@ -0,0 +1,428 @@
+package render
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Engines turn post source into HTML. A post picks one with its
+// render_engine column so existing content keeps rendering the same way
+// until it is moved over.
+const (
+	// EngineLegacy runs the regex filters around blackfriday. It repairs
+	// WYSIWYG-mangled HTML but can also rewrite code and attributes.
+	EngineLegacy = "legacy"
+	// EngineAST parses CommonMark with the GFM extensions and applies the
+	// blog's transforms to the syntax tree, so code is never touched.
+	EngineAST = "ast"
+)
+
+// Engines lists the valid engine names, default first.
+var Engines = []string{EngineLegacy, EngineAST}
+
+// ValidEngine reports whether name is one of Engines.
+func ValidEngine(name string) bool {
+	for _, e := range Engines {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// treeTransform is the AST engine's counterpart of a Filter. It shares the
+// filter's name so RendererOptions.Filters switches both off together.
+type treeTransform struct {
+	name string
+	fn   func(doc *ast.Document, source []byte)
+	// disabled matches the filter's Registration.Disabled.
+	disabled bool
+}
+
+var treeTransforms = []treeTransform{
+	// The renderer expands shortcodes in the source when this is on.
+	{FilterShortcodes, shortcodeNodes, false},
+	{FilterMoreTag, removeMoreTagNode, false},
+	// The math parsers are added with this transform.
+	{FilterMath, mathNodes, false},
+	{FilterMermaid, mermaidNodes, false},
+	{FilterTaskList, markTaskItems, false},
+	{FilterYouTube, youTubeNodes, false},
+	{FilterOEmbed, oembedNodes, false},
+	{FilterListClasses, addListNodeClasses, false},
+	// The ::: container parser is added with this transform.
+	{FilterAdmonitions, admonitionNodes, false},
+	{FilterBlockquoteClasses, addBlockquoteNodeClasses, false},
+	{FilterLightbox, lightboxImageNodes, false},
+	{FilterHighlight, highlightNodes, true},
+	{FilterTOC, tocNodes, false},
+}
+
+func (tr treeTransform) enabled(opt RendererOptions) bool {
+	on, ok := opt.Filters[tr.name]
+	if !ok {
+		on = !tr.disabled
+	}
+	return on
+}
+
+type treeTransformer []treeTransform
+
+func (t treeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	for _, tr := range t {
+		tr.fn(doc, reader.Source())
+	}
+}
+
+// newASTMarkdown builds the goldmark instance for opt, with the transforms
+// opt.Filters leaves on.
+func newASTMarkdown(opt RendererOptions) goldmark.Markdown {
+	var transforms treeTransformer
+	extensions := []goldmark.Extender{
+		extension.GFM,
+		extension.DefinitionList,
+		extension.NewFootnote(
+			extension.WithFootnoteBacklinkHTML(footnoteReturn),
+			extension.WithFootnoteBacklinkClass("footnote-return"),
+		),
+	}
+	for _, tr := range treeTransforms {
+		if tr.enabled(opt) {
+			transforms = append(transforms, tr)
+			switch tr.name {
+			case FilterMath:
+				extensions = append(extensions, mathExtension{})
+			case FilterAdmonitions:
+				extensions = append(extensions, admonitionExtension{})
+			}
+		}
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(util.Prioritized(transforms, 1000)),
+		),
+		goldmark.WithRendererOptions(
+			// Raw HTML is left to the sanitizer, as with the legacy engine.
+			html.WithUnsafe(),
+			renderer.WithNodeRenderers(util.Prioritized(nodeRenderer{}, 100)),
+		),
+	)
+}
+
+func renderAST(md goldmark.Markdown, content string) string {
+	var buf bytes.Buffer
+	ctx := parser.NewContext(parser.WithIDs(astIDs{headingIDs{}}))
+	if err := md.Convert([]byte(content), &buf, parser.WithContext(ctx)); err != nil {
+		// Convert only fails when writing to buf does.
+		return ""
+	}
+	return buf.String()
+}
+
+// ---- Custom nodes ----
+
+var (
+	kindMermaid   = ast.NewNodeKind("Mermaid")
+	kindYouTube   = ast.NewNodeKind("YouTube")
+	kindCodeBlock = ast.NewNodeKind("HighlightedCode")
+)
+
+type mermaidBlock struct {
+	ast.BaseBlock
+	code []byte
+}
+
+func (n *mermaidBlock) Kind() ast.NodeKind { return kindMermaid }
+func (n *mermaidBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type youTubeBlock struct {
+	ast.BaseBlock
+	id string
+}
+
+func (n *youTubeBlock) Kind() ast.NodeKind { return kindYouTube }
+func (n *youTubeBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"ID": n.id}, nil)
+}
+
+type codeBlock struct {
+	ast.BaseBlock
+	code []byte
+	lang string
+	meta codeMeta
+}
+
+func (n *codeBlock) Kind() ast.NodeKind { return kindCodeBlock }
+func (n *codeBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Lang": n.lang}, nil)
+}
+
+// nodeRenderer writes the custom nodes, task checkboxes in the same form as
+// taskListToHTML and the footnote list as the legacy engine styles it.
+type nodeRenderer struct{}
+
+func (nodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMermaid, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(`<div class="mermaid">`)
+			_, _ = w.Write(util.EscapeHTML(n.(*mermaidBlock).code))
+			_, _ = w.WriteString("</div>\n")
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindYouTube, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(youTubeIframe(n.(*youTubeBlock).id) + "\n")
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindCodeBlock, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			block := n.(*codeBlock)
+			_, _ = w.WriteString(highlightCode(string(block.code), block.lang, block.meta) + "\n")
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindTOC, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(tocHTML(n.(*tocBlock).entries) + "\n")
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindEmbed, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(n.(*embedBlock).html + "\n")
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindShortcode, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			switch n := n.(type) {
+			case *shortcodeBlock:
+				_, _ = w.WriteString(n.html + "\n")
+			case *shortcodeInline:
+				_, _ = w.WriteString(n.html)
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindAdmonition, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			block := n.(*admonitionBlock)
+			_, _ = w.WriteString(admonitionOpen(block.kind, block.title) + "\n")
+		} else {
+			_, _ = w.WriteString("</div>\n")
+		}
+		return ast.WalkContinue, nil
+	})
+	reg.Register(east.KindFootnoteList, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(`<div class="` + footnotesClass + `">` + "\n<hr>\n<ol")
+			html.RenderAttributes(w, n, html.ListAttributeFilter)
+			_, _ = w.WriteString(">\n")
+		} else {
+			_, _ = w.WriteString("</ol>\n</div>\n")
+		}
+		return ast.WalkContinue, nil
+	})
+	reg.Register(east.KindTaskCheckBox, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			chk := `<input type="checkbox" disabled` + ternary(n.(*east.TaskCheckBox).IsChecked, " checked", "") + ` class="mr-2 align-middle">`
+			_, _ = w.WriteString(chk)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// ---- Transforms ----
+
+// collect returns the nodes under doc for which match is true, so callers
+// can restructure the tree without disturbing the walk.
+func collect(doc ast.Node, match func(ast.Node) bool) []ast.Node {
+	var found []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && match(n) {
+			found = append(found, n)
+		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+// onlyChild returns n's single child, or nil.
+func onlyChild(n ast.Node) ast.Node {
+	if n.ChildCount() != 1 {
+		return nil
+	}
+	return n.FirstChild()
+}
+
+func segmentsText(segs *text.Segments, source []byte) string {
+	var b strings.Builder
+	for i := 0; i < segs.Len(); i++ {
+		seg := segs.At(i)
+		b.Write(seg.Value(source))
+	}
+	return b.String()
+}
+
+// removeMoreTagNode drops the first <more--> marker, as replaceMoreTag does.
+func removeMoreTagNode(doc *ast.Document, source []byte) {
+	marker := collect(doc, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.RawHTML:
+			return segmentsText(n.Segments, source) == "<more-->"
+		case *ast.HTMLBlock:
+			return strings.TrimSpace(segmentsText(n.Lines(), source)) == "<more-->"
+		}
+		return false
+	})
+	if len(marker) == 0 {
+		return
+	}
+	n := marker[0]
+	parent := n.Parent()
+	parent.RemoveChild(parent, n)
+	if _, ok := parent.(*ast.Paragraph); ok && parent.ChildCount() == 0 {
+		parent.Parent().RemoveChild(parent.Parent(), parent)
+	}
+}
+
+// mermaidNodes turns ```mermaid blocks into diagram containers.
+func mermaidNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		fc, ok := n.(*ast.FencedCodeBlock)
+		return ok && string(fc.Language(source)) == "mermaid"
+	}) {
+		block := &mermaidBlock{code: n.Lines().Value(source)}
+		n.Parent().ReplaceChild(n.Parent(), n, block)
+	}
+}
+
+// markTaskItems gives list items that start with a checkbox the task-item
+// class.
+func markTaskItems(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		_, ok := n.(*east.TaskCheckBox)
+		return ok
+	}) {
+		if item, ok := n.Parent().Parent().(*ast.ListItem); ok {
+			if _, has := item.AttributeString("class"); !has {
+				item.SetAttributeString("class", []byte("task-item"))
+			}
+		}
+	}
+}
+
+var youTubeURLRe = regexp.MustCompile(`^https?://(?:www\.)?(?:youtube\.com/watch\?v=|youtu\.be/)([A-Za-z0-9_-]+)`)
+
+// youTubeNodes replaces paragraphs holding nothing but a YouTube link with
+// an embedded player.
+func youTubeNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		_, ok := n.(*ast.Paragraph)
+		return ok
+	}) {
+		var url string
+		switch link := onlyChild(n).(type) {
+		case *ast.Link:
+			url = string(link.Destination)
+		case *ast.AutoLink:
+			url = string(link.URL(source))
+		default:
+			continue
+		}
+		if m := youTubeURLRe.FindStringSubmatch(url); m != nil {
+			n.Parent().ReplaceChild(n.Parent(), n, &youTubeBlock{id: m[1]})
+		}
+	}
+}
+
+func setClassIfMissing(n ast.Node, class string) {
+	if _, has := n.AttributeString("class"); !has {
+		n.SetAttributeString("class", []byte(class))
+	}
+}
+
+// addListNodeClasses is addListClasses on the tree.
+func addListNodeClasses(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		switch n.Kind() {
+		case ast.KindList, ast.KindListItem, east.KindFootnoteList, east.KindFootnote, east.KindDefinitionList, east.KindDefinitionTerm, east.KindDefinitionDescription:
+			return true
+		}
+		return false
+	}) {
+		switch n := n.(type) {
+		case *ast.List:
+			setClassIfMissing(n, ternary(n.IsOrdered(), "list-decimal pl-2", "list-disc pl-2"))
+		case *ast.ListItem, *east.Footnote:
+			setClassIfMissing(n, "mb-2")
+		case *east.FootnoteList:
+			setClassIfMissing(n, "list-decimal pl-2")
+		case *east.DefinitionList:
+			setClassIfMissing(n, definitionListClass)
+		case *east.DefinitionTerm:
+			setClassIfMissing(n, definitionTermClass)
+		case *east.DefinitionDescription:
+			setClassIfMissing(n, definitionDescriptionClass)
+		}
+	}
+}
+
+// addBlockquoteNodeClasses is addBlockquoteClasses on the tree.
+func addBlockquoteNodeClasses(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool { return n.Kind() == ast.KindBlockquote }) {
+		setClassIfMissing(n, blockquoteClass)
+	}
+}
+
+// lightboxImageNodes links images that stand alone in a paragraph to
+// themselves for the lightbox, as wrapImageGalleries does.
+func lightboxImageNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		_, ok := n.(*ast.Paragraph)
+		return ok
+	}) {
+		img, ok := onlyChild(n).(*ast.Image)
+		if !ok {
+			continue
+		}
+		link := ast.NewLink()
+		link.Destination = img.Destination
+		link.SetAttributeString("data-lightbox", []byte("article-images"))
+		link.SetAttributeString("rel", []byte("lightbox[article-images]"))
+		n.ReplaceChild(n, img, link)
+		link.AppendChild(link, img)
+	}
+}
+
+// highlightNodes replaces fenced code blocks with server-highlighted ones.
+// The info string after the language is read by parseFenceMeta.
+func highlightNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool { return n.Kind() == ast.KindFencedCodeBlock }) {
+		fc := n.(*ast.FencedCodeBlock)
+		var info string
+		if fc.Info != nil {
+			info = string(fc.Info.Segment.Value(source))
+		}
+		lang, meta, _ := strings.Cut(strings.TrimSpace(info), " ")
+		block := &codeBlock{code: fc.Lines().Value(source), lang: lang, meta: parseFenceMeta(meta)}
+		n.Parent().ReplaceChild(n.Parent(), n, block)
+	}
+}
@@ -0,0 +1,177 @@
+package render
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Built-in filter names, for ordering constraints and configuration.
+const (
+	FilterNormalize          = "normalize"
+	FilterAdmonitions        = "admonitions"
+	FilterMath               = "math"
+	FilterShortcodes         = "shortcodes"
+	FilterStripStyleSnippets = "strip_style_snippets"
+	FilterMoreTag            = "more_tag"
+	FilterUnwrapLists        = "unwrap_list_containers"
+	FilterListSeparation     = "list_separation"
+	FilterLooseHTML          = "loose_markdown_html"
+	FilterPipeTables         = "inline_pipe_tables"
+	FilterFences             = "fences"
+
+	FilterMermaid           = "mermaid"
+	FilterTaskList          = "task_list"
+	FilterYouTube           = "youtube"
+	FilterListClasses       = "list_classes"
+	FilterBlockquoteClasses = "blockquote_classes"
+	FilterInlineEmphasis    = "inline_emphasis"
+	FilterLightbox          = "lightbox"
+	FilterHighlight         = "highlight"
+	FilterOEmbed            = "oembed"
+	FilterTOC               = "toc"
+	FilterShortcodeHTML     = "shortcode_html"
+	FilterAdmonitionHTML    = "admonition_html"
+)
+
+// builtinFilters are registered in run order; the constraints record the
+// dependencies between them so plugins can be slotted in safely.
+var builtinFilters = []Registration{
+	{Filter: NewFilter(FilterNormalize, StagePre, normalizeWhitespaceAndBreaks)},
+	// "> [!NOTE]" quotes and :::warning containers -> markers around their
+	// Markdown, before anything can merge or rewrite the quote lines.
+	{Filter: NewFilter(FilterAdmonitions, StagePre, markAdmonitions), After: []string{FilterNormalize}, Before: []string{FilterMath, FilterShortcodes, FilterLooseHTML}},
+	// $x$, $$x$$ and ```math -> MathML, before anything can rewrite the TeX.
+	{Filter: NewFilter(FilterMath, StagePre, renderMath), After: []string{FilterNormalize}, Before: []string{FilterFences}},
+	// {{< name >}} -> markers for the shortcode HTML; code is left alone.
+	{Filter: NewFilter(FilterShortcodes, StagePre, expandShortcodeSource), After: []string{FilterNormalize, FilterMath}, Before: []string{FilterFences}},
+	{Filter: NewFilter(FilterStripStyleSnippets, StagePre, stripStyleSnippets), After: []string{FilterNormalize}},
+	{Filter: NewFilter(FilterMoreTag, StagePre, replaceMoreTag), After: []string{FilterNormalize}},
+	// <div>- item</div> -> "- item"
+	{Filter: NewFilter(FilterUnwrapLists, StagePre, unwrapListLikeContainers), After: []string{FilterNormalize}},
+	// blank line before first -/1.
+	{Filter: NewFilter(FilterListSeparation, StagePre, ensureListSeparation), After: []string{FilterUnwrapLists}},
+	// headings/quotes from HTML-wrapped lines, etc.
+	{Filter: NewFilter(FilterLooseHTML, StagePre, preprocessLooseMarkdownHTML), After: []string{FilterListSeparation}},
+	{Filter: NewFilter(FilterPipeTables, StagePre, normalizeInlinePipeTables), After: []string{FilterLooseHTML}},
+	// ```lang -> <pre><code class="language-lang">...</code></pre>
+	{Filter: NewFilter(FilterFences, StagePre, convertFences), After: []string{FilterStripStyleSnippets, FilterPipeTables}},
+
+	{Filter: NewFilter(FilterMermaid, StagePost, transformMermaidBlocks)},
+	{Filter: NewFilter(FilterTaskList, StagePost, taskListToHTML), Before: []string{FilterListClasses}},
+	{Filter: NewFilter(FilterYouTube, StagePost, embedYouTube), Before: []string{FilterLightbox}},
+	{Filter: NewFilter(FilterListClasses, StagePost, addListClasses)},
+	{Filter: NewFilter(FilterBlockquoteClasses, StagePost, addBlockquoteClasses)},
+	{Filter: NewFilter(FilterInlineEmphasis, StagePost, convertInlineEmphasisInHTML), After: []string{FilterMermaid}},
+	{Filter: NewFilter(FilterLightbox, StagePost, wrapImageGalleries)},
+	// Links to tweets, Vimeo, CodePen... -> cached embeds or link cards, once
+	// the filters that rewrite text and images are done.
+	{Filter: NewFilter(FilterOEmbed, StagePost, embedOEmbedLinks), After: []string{FilterYouTube, FilterInlineEmphasis, FilterLightbox}},
+	// Heading IDs and anchors, and the [TOC] marker; last so headings are final.
+	{Filter: NewFilter(FilterTOC, StagePost, addHeadingAnchors), After: []string{FilterInlineEmphasis, FilterLightbox}},
+	// Off by default: code is left to Prism in the browser.
+	{Filter: NewFilter(FilterHighlight, StagePost, highlightCodeBlocks), After: []string{FilterMermaid, FilterInlineEmphasis}, Disabled: true},
+	// Shortcode HTML goes in after every filter that could rewrite it.
+	{Filter: NewFilter(FilterShortcodeHTML, StagePost, insertShortcodeHTML), After: []string{FilterInlineEmphasis, FilterLightbox, FilterTOC, FilterHighlight}},
+	{Filter: NewFilter(FilterAdmonitionHTML, StagePost, insertAdmonitionHTML), After: []string{FilterInlineEmphasis, FilterLightbox, FilterTOC, FilterHighlight}},
+}
+
+// slugRe is the form of slide deck slugs.
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// builtinShortcodes are the shortcodes every post can use.
+var builtinShortcodes = []Shortcode{
+	{
+		// Gist embeds need GitHub's script, which the sanitizer removes, so
+		// a gist is shown as a link card.
+		Name: "gist",
+		Args: []ShortcodeArg{
+			{Name: "user", Required: true, Pattern: regexp.MustCompile(`^[A-Za-z0-9-]+$`)},
+			{Name: "id", Required: true, Pattern: regexp.MustCompile(`^[0-9a-fA-F]+$`)},
+			{Name: "file"},
+		},
+		Render: gistCard,
+	},
+	{
+		Name: "figure",
+		Args: []ShortcodeArg{
+			{Name: "src", Kind: ArgURL, Required: true},
+			{Name: "caption"},
+			{Name: "alt"},
+			{Name: "width", Kind: ArgInt},
+		},
+		Render: figureHTML,
+	},
+	{
+		Name:   "slide",
+		Args:   []ShortcodeArg{{Name: "deck", Required: true, Pattern: slugRe}},
+		Render: slideEmbed,
+	},
+	{
+		Name: "callout",
+		Args: []ShortcodeArg{
+			{Name: "kind", Default: "note", OneOf: []string{"note", "tip", "info", "warning", "danger"}},
+			{Name: "title"},
+		},
+		Wrap: callout,
+	},
+}
+
+func gistCard(args ShortcodeArgs) (string, error) {
+	name := args.String("user") + "/" + args.String("id")
+	href := "https://gist.github.com/" + name
+	if file := args.String("file"); file != "" {
+		// GitHub's anchor for one file of a gist.
+		href += "#file-" + headingSlug(file)
+		name += " · " + file
+	}
+	return `<p class="gist-card"><a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener">` +
+		`View gist ` + html.EscapeString(name) + ` on GitHub</a></p>`, nil
+}
+
+func figureHTML(args ShortcodeArgs) (string, error) {
+	alt := args.String("alt")
+	if alt == "" {
+		alt = args.String("caption")
+	}
+	var b strings.Builder
+	b.WriteString(`<figure class="figure"><img src="` + html.EscapeString(args.String("src")) + `" alt="` + html.EscapeString(alt) + `" loading="lazy"`)
+	if args.Has("width") {
+		b.WriteString(` width="` + strconv.Itoa(args.Int("width")) + `"`)
+	}
+	b.WriteString(">")
+	if caption := args.String("caption"); caption != "" {
+		b.WriteString("<figcaption>" + html.EscapeString(caption) + "</figcaption>")
+	}
+	b.WriteString("</figure>")
+	return b.String(), nil
+}
+
+func slideEmbed(args ShortcodeArgs) (string, error) {
+	href := "/slides/" + args.String("deck")
+	return `<div class="slide-embed"><iframe src="` + href + `" title="Slides" loading="lazy" allowfullscreen></iframe>` +
+		`<p><a href="` + href + `">Open the slides</a></p></div>`, nil
+}
+
+func callout(args ShortcodeArgs) (string, string, error) {
+	kind := args.String("kind")
+	title := args.String("title")
+	if title == "" {
+		title = strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	return `<div class="callout callout-` + kind + `"><p class="callout-title">` + html.EscapeString(title) + "</p>", "</div>", nil
+}
+
+func init() {
+	for _, reg := range builtinFilters {
+		if err := Register(reg); err != nil {
+			panic(err)
+		}
+	}
+	for _, sc := range builtinShortcodes {
+		if err := RegisterShortcode(sc); err != nil {
+			panic(err)
+		}
+	}
+}
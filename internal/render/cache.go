@@ -0,0 +1,182 @@
+package render
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RenderVersion is part of every cache key. Bump it when a change to the
+// filters or transforms changes their output, so cached HTML, including
+// HTML persisted with the post, is rendered again.
+const RenderVersion = 2
+
+// EmbedRenderTTL bounds how long HTML for content with oEmbed provider links
+// is cached: it changes when an embed is fetched or refreshed, which the
+// content hash cannot see.
+const EmbedRenderTTL = 10 * time.Minute
+
+// CacheKey identifies rendered HTML: the hash of the source and the version
+// of the renderer that produced it.
+type CacheKey struct {
+	Hash    [sha256.Size]byte
+	Version string
+}
+
+// String is the key in the form stored next to persisted HTML.
+func (k CacheKey) String() string {
+	return k.Version + ":" + hex.EncodeToString(k.Hash[:])
+}
+
+// CacheStats are a RenderCache's counters since it was created.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+}
+
+// HitRate is the percentage of lookups that were hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return 100 * float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// RenderCache keeps the most recently used rendered HTML in memory.
+type RenderCache struct {
+	mu        sync.Mutex
+	capacity  int
+	order     *list.List // front is most recently used
+	items     map[CacheKey]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	key     CacheKey
+	html    string
+	expires time.Time // zero for entries that do not expire
+}
+
+// NewRenderCache returns a cache holding up to capacity entries.
+func NewRenderCache(capacity int) *RenderCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RenderCache{capacity: capacity, order: list.New(), items: map[CacheKey]*list.Element{}}
+}
+
+// Get returns the HTML cached under key.
+func (c *RenderCache) Get(key CacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.hits++
+			return e.html, true
+		}
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+	c.misses++
+	return "", false
+}
+
+// Add caches html under key, for ttl if it is positive, evicting the least
+// recently used entry when the cache is full.
+func (c *RenderCache) Add(key CacheKey, html string, ttl time.Duration) {
+	e := &cacheEntry{key: key, html: html}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+// Purge empties the cache, keeping the counters.
+func (c *RenderCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = map[CacheKey]*list.Element{}
+}
+
+// Stats returns the cache's counters.
+func (c *RenderCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Entries: c.order.Len(), Capacity: c.capacity}
+}
+
+var renderCache struct {
+	sync.RWMutex
+	cache *RenderCache
+}
+
+// SetRenderCache sets the cache Renderer.Render uses; nil turns caching off.
+func SetRenderCache(c *RenderCache) {
+	renderCache.Lock()
+	renderCache.cache = c
+	renderCache.Unlock()
+}
+
+// CurrentRenderCache returns the cache set with SetRenderCache, or nil.
+func CurrentRenderCache() *RenderCache {
+	renderCache.RLock()
+	defer renderCache.RUnlock()
+	return renderCache.cache
+}
+
+// rendererVersion identifies what a renderer does to content: the engine,
+// the sanitizer and the filters or transforms it runs, in order.
+func rendererVersion(opt RendererOptions, steps []string) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(RenderVersion) + "|" + opt.Engine + "|" + strconv.FormatBool(opt.SanitizeHTML) + "|" + strings.Join(steps, ",")))
+	return "v" + strconv.Itoa(RenderVersion) + "-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// CacheKey returns the key r's HTML for content is cached under.
+func (r *Renderer) CacheKey(content string) CacheKey {
+	return CacheKey{Hash: sha256.Sum256([]byte(content)), Version: r.version}
+}
+
+var embedLinkRe = regexp.MustCompile(`https://[^\s"'<>()\[\]]+`)
+
+// HasEmbeds reports whether content links to an oEmbed provider, so its
+// HTML depends on the embed cache as well as on the content.
+func HasEmbeds(content string) bool {
+	if !strings.Contains(content, "https://") {
+		return false
+	}
+	r := currentOEmbedResolver()
+	for _, link := range embedLinkRe.FindAllString(content, -1) {
+		if _, ok := r.provider(link); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package render
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DiffLine is one line of a comparison between two renderings or texts.
+type DiffLine struct {
+	// Op is "-" for a line only in the old version, "+" for one only in
+	// the new version, " " for shared context and "~" for skipped shared
+	// lines.
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// diffContext is how many shared lines a diff keeps around changes.
+const diffContext = 2
+
+// maxDiffLines bounds the line-by-line diff, whose time grows with the
+// length times the number of changes; longer texts that differ are
+// reported as one replacement.
+const maxDiffLines = 3000
+
+var betweenTagsRe = regexp.MustCompile(`>\s*<`)
+
+// outputLines splits rendered HTML at every tag boundary, so whitespace
+// between elements, which does not change the page, does not count as a
+// difference.
+func outputLines(html string) []string {
+	html = betweenTagsRe.ReplaceAllString(strings.TrimSpace(html), ">\n<")
+	lines := strings.Split(html, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return lines
+}
+
+// CompareOutput diffs two renderings of the same content, ignoring
+// whitespace between tags. It returns nil when they would display the same.
+func CompareOutput(before, after string) []DiffLine {
+	a, b := outputLines(before), outputLines(after)
+	if strings.Join(a, "\n") == strings.Join(b, "\n") {
+		return nil
+	}
+	return diffLines(a, b)
+}
+
+// DiffText diffs two texts line by line, such as two versions of a post's
+// source. It returns nil when they are equal.
+func DiffText(before, after string) []DiffLine {
+	before = strings.ReplaceAll(before, "\r\n", "\n")
+	after = strings.ReplaceAll(after, "\r\n", "\n")
+	if before == after {
+		return nil
+	}
+	return diffLines(strings.Split(before, "\n"), strings.Split(after, "\n"))
+}
+
+// diffLines diffs a against b, keeping diffContext shared lines around the
+// changes.
+func diffLines(a, b []string) []DiffLine {
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		var diff []DiffLine
+		for _, l := range a {
+			diff = append(diff, DiffLine{"-", l})
+		}
+		for _, l := range b {
+			diff = append(diff, DiffLine{"+", l})
+		}
+		return diff
+	}
+	var full []DiffLine
+	appendDiff(&full, a, b)
+	return trimContext(groupChanges(full))
+}
+
+// groupChanges reorders each run of changed lines so that its removed lines
+// come before its added ones, however the diff interleaved them.
+func groupChanges(full []DiffLine) []DiffLine {
+	for i := 0; i < len(full); {
+		if full[i].Op == " " {
+			i++
+			continue
+		}
+		j := i
+		for j < len(full) && full[j].Op != " " {
+			j++
+		}
+		run := full[i:j]
+		sort.SliceStable(run, func(x, y int) bool { return run[x].Op == "-" && run[y].Op == "+" })
+		i = j
+	}
+	return full
+}
+
+// appendDiff appends a shortest edit script from a to b to full. It is Myers' linear-space
+// algorithm: split both sides at the middle snake of a shortest edit path
+// and recurse, so memory stays proportional to len(a)+len(b).
+func appendDiff(full *[]DiffLine, a, b []string) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*full = append(*full, DiffLine{" ", a[0]})
+		a, b = a[1:], b[1:]
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	shared := a[len(a)-n:]
+	a, b = a[:len(a)-n], b[:len(b)-n]
+
+	switch {
+	case len(a) == 0:
+		for _, l := range b {
+			*full = append(*full, DiffLine{"+", l})
+		}
+	case len(b) == 0:
+		for _, l := range a {
+			*full = append(*full, DiffLine{"-", l})
+		}
+	default:
+		// With the shared ends trimmed, at least two edits remain, so both
+		// halves are strictly smaller than a and b.
+		x, y, u, v := middleSnake(a, b)
+		appendDiff(full, a[:x], b[:y])
+		for _, l := range a[x:u] {
+			*full = append(*full, DiffLine{" ", l})
+		}
+		appendDiff(full, a[u:], b[v:])
+	}
+	for _, l := range shared {
+		*full = append(*full, DiffLine{" ", l})
+	}
+}
+
+// middleSnake finds the run of shared lines, from a[x], b[y] to a[u], b[v],
+// in the middle of a shortest edit path from a to b, by searching forwards
+// from the start and backwards from the end until the two searches meet.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	// fwd[off+k] is the furthest x reached on diagonal x-y == k going
+	// forwards; bwd[off+k] is how many lines the backward search has
+	// consumed from the ends on diagonal k of the reversed sequences, which
+	// is diagonal delta-k going forwards.
+	off := n + m + 1
+	fwd := make([]int, 2*off+1)
+	bwd := make([]int, 2*off+1)
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			fwd[off+k] = x
+			if delta%2 != 0 && delta-k >= -(d-1) && delta-k <= d-1 && x+bwd[off+delta-k] >= n {
+				return sx, sy, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			} else {
+				x = bwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x, y = x+1, y+1
+			}
+			bwd[off+k] = x
+			if delta%2 == 0 && delta-k >= -d && delta-k <= d && x+fwd[off+delta-k] >= n {
+				return n - x, m - y, n - sx, m - sy
+			}
+		}
+	}
+	panic("render: no middle snake")
+}
+
+// trimContext replaces runs of shared lines further than diffContext from
+// any change with a single "~" marker.
+func trimContext(full []DiffLine) []DiffLine {
+	keep := make([]bool, len(full))
+	for i, l := range full {
+		if l.Op == " " {
+			continue
+		}
+		for k := max(0, i-diffContext); k <= min(len(full)-1, i+diffContext); k++ {
+			keep[k] = true
+		}
+	}
+	var diff []DiffLine
+	for i, l := range full {
+		if keep[i] {
+			diff = append(diff, l)
+		} else if len(diff) == 0 || diff[len(diff)-1].Op != "~" {
+			diff = append(diff, DiffLine{"~", "…"})
+		}
+	}
+	return diff
+}
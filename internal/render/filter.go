@@ -0,0 +1,249 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Stage says where in the pipeline a filter runs.
+type Stage int
+
+const (
+	// StagePre filters rewrite the source before Markdown rendering.
+	StagePre Stage = iota
+	// StagePost filters rewrite the HTML Markdown produced.
+	StagePost
+)
+
+func (s Stage) String() string {
+	if s == StagePre {
+		return "pre"
+	}
+	return "post"
+}
+
+// Filter is one step of the render pipeline.
+type Filter interface {
+	// Name identifies the filter in ordering constraints, configuration and
+	// debug output. It must be unique.
+	Name() string
+	Stage() Stage
+	Apply(s string) string
+}
+
+type funcFilter struct {
+	name  string
+	stage Stage
+	fn    func(string) string
+}
+
+func (f funcFilter) Name() string          { return f.name }
+func (f funcFilter) Stage() Stage          { return f.stage }
+func (f funcFilter) Apply(s string) string { return f.fn(s) }
+
+// NewFilter makes a Filter from a plain function.
+func NewFilter(name string, stage Stage, fn func(string) string) Filter {
+	return funcFilter{name: name, stage: stage, fn: fn}
+}
+
+// Registration adds a filter to a Registry.
+type Registration struct {
+	Filter Filter
+	// After and Before name filters in the same stage that must run before
+	// or after this one. Names that aren't registered are ignored.
+	After  []string
+	Before []string
+	// Disabled leaves the filter off unless configuration turns it on.
+	Disabled bool
+}
+
+// Registry holds the filters available to renderers. Filters within a
+// stage run in an order that satisfies every After/Before constraint, and
+// otherwise in registration order.
+type Registry struct {
+	mu   sync.RWMutex
+	regs []Registration
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry holds the built-in filters and any added with Register.
+var DefaultRegistry = NewRegistry()
+
+// Register adds a filter to DefaultRegistry.
+func Register(reg Registration) error {
+	return DefaultRegistry.Register(reg)
+}
+
+// Register adds a filter. It fails if the name is taken or the filter's
+// constraints would make the order impossible.
+func (r *Registry) Register(reg Registration) error {
+	if reg.Filter == nil || reg.Filter.Name() == "" {
+		return fmt.Errorf("register filter: filter must have a name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := reg.Filter.Name()
+	for _, existing := range r.regs {
+		if existing.Filter.Name() == name {
+			return fmt.Errorf("register filter %q: already registered", name)
+		}
+	}
+	regs := append(r.regs[:len(r.regs):len(r.regs)], reg)
+	if _, err := order(regs, reg.Filter.Stage()); err != nil {
+		return fmt.Errorf("register filter %q: %w", name, err)
+	}
+	r.regs = regs
+	return nil
+}
+
+// Names returns every registered filter name, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.regs))
+	for _, reg := range r.regs {
+		names = append(names, reg.Filter.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline returns the enabled filters for stage in run order. enabled
+// overrides each filter's registered default by name.
+func (r *Registry) Pipeline(stage Stage, enabled map[string]bool) []Filter {
+	r.mu.RLock()
+	ordered, _ := order(r.regs, stage) // Register rejects impossible orders
+	r.mu.RUnlock()
+
+	var filters []Filter
+	for _, reg := range ordered {
+		on, ok := enabled[reg.Filter.Name()]
+		if !ok {
+			on = !reg.Disabled
+		}
+		if on {
+			filters = append(filters, reg.Filter)
+		}
+	}
+	return filters
+}
+
+// order sorts the registrations for stage topologically, preferring
+// registration order between unconstrained filters.
+func order(regs []Registration, stage Stage) ([]Registration, error) {
+	var stageRegs []Registration
+	index := map[string]int{}
+	for _, reg := range regs {
+		if reg.Filter.Stage() == stage {
+			index[reg.Filter.Name()] = len(stageRegs)
+			stageRegs = append(stageRegs, reg)
+		}
+	}
+
+	// edges[i] lists the filters that must run after filter i.
+	edges := make([][]int, len(stageRegs))
+	pending := make([]int, len(stageRegs))
+	for i, reg := range stageRegs {
+		for _, name := range reg.After {
+			if j, ok := index[name]; ok {
+				edges[j] = append(edges[j], i)
+				pending[i]++
+			}
+		}
+		for _, name := range reg.Before {
+			if j, ok := index[name]; ok {
+				edges[i] = append(edges[i], j)
+				pending[j]++
+			}
+		}
+	}
+
+	ordered := make([]Registration, 0, len(stageRegs))
+	done := make([]bool, len(stageRegs))
+	for len(ordered) < len(stageRegs) {
+		next := -1
+		for i := range stageRegs {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var stuck []string
+			for i, reg := range stageRegs {
+				if !done[i] {
+					stuck = append(stuck, reg.Filter.Name())
+				}
+			}
+			return nil, fmt.Errorf("ordering cycle among %s filters %s", stage, strings.Join(stuck, ", "))
+		}
+		done[next] = true
+		ordered = append(ordered, stageRegs[next])
+		for _, j := range edges[next] {
+			pending[j]--
+		}
+	}
+	return ordered, nil
+}
+
+// ParseFilterConfig reads a comma-separated list of filter names to turn on
+// or off, such as "youtube=off,lightbox=on", and checks every name against
+// the registry.
+func (r *Registry) ParseFilterConfig(s string) (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, name := range r.Names() {
+		known[name] = true
+	}
+	enabled := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("filter setting %q: want name=on or name=off", item)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("filter setting %q: unknown filter %q", item, name)
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "on", "true", "1":
+			enabled[name] = true
+		case "off", "false", "0":
+			enabled[name] = false
+		default:
+			return nil, fmt.Errorf("filter setting %q: want on or off", item)
+		}
+	}
+	return enabled, nil
+}
+
+var configured struct {
+	sync.RWMutex
+	filters map[string]bool
+}
+
+// Configure sets the filter overrides DefaultOptions starts from.
+func Configure(filters map[string]bool) {
+	configured.Lock()
+	configured.filters = filters
+	configured.Unlock()
+}
+
+func configuredFilters() map[string]bool {
+	configured.RLock()
+	defer configured.RUnlock()
+	filters := make(map[string]bool, len(configured.filters))
+	for name, on := range configured.filters {
+		filters[name] = on
+	}
+	return filters
+}
@@ -31,9 +31,25 @@ func addListClasses(content string) string {
 		attrs := liRe.FindStringSubmatch(m)[1]
 		return withClass("li", "mb-2", attrs)
 	})
+	for tag, classes := range map[string]string{"dl": definitionListClass, "dt": definitionTermClass, "dd": definitionDescriptionClass} {
+		re := regexp.MustCompile(`(?i)<` + tag + `\b([^>]*)>`)
+		content = re.ReplaceAllStringFunc(content, func(m string) string {
+			return withClass(tag, classes, re.FindStringSubmatch(m)[1])
+		})
+	}
 	return content
 }
 
+// Definition list classes, shared with addListNodeClasses.
+const (
+	definitionListClass        = "my-4"
+	definitionTermClass        = "font-semibold mt-2"
+	definitionDescriptionClass = "ms-6 mb-2 text-gray-700 dark:text-gray-300"
+)
+
+// blockquoteClass styles quotes, shared with addBlockquoteNodeClasses.
+const blockquoteClass = "p-4 my-4 border-s-4 border-gray-300 bg-gray-50 dark:border-gray-500 dark:bg-gray-800"
+
 // 2) Blockquote classes
 func addBlockquoteClasses(content string) string {
 	bqRe := regexp.MustCompile(`(?i)<blockquote\b([^>]*)>`)
@@ -42,7 +58,7 @@ func addBlockquoteClasses(content string) string {
 		if strings.Contains(strings.ToLower(attrs), "class=") {
 			return "<blockquote" + attrs + ">"
 		}
-		return `<blockquote class="p-4 my-4 border-s-4 border-gray-300 bg-gray-50 dark:border-gray-500 dark:bg-gray-800"` + attrs + ">"
+		return `<blockquote class="` + blockquoteClass + `"` + attrs + ">"
 	})
 }
 
@@ -78,12 +94,14 @@ func embedYouTube(html string) string {
 		if len(sub) != 3 {
 			return m
 		}
-		id := sub[2]
-		iframe := `<div class="aspect-video w-full max-w-3xl"><iframe src="https://www.youtube.com/embed/` + id + `" title="YouTube video" frameborder="0" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture; web-share" allowfullscreen style="width:100%;height:100%"></iframe></div>`
-		return iframe
+		return youTubeIframe(sub[2])
 	})
 }
 
+func youTubeIframe(id string) string {
+	return `<div class="aspect-video w-full max-w-3xl"><iframe src="https://www.youtube.com/embed/` + id + `" title="YouTube video" frameborder="0" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture; web-share" allowfullscreen style="width:100%;height:100%"></iframe></div>`
+}
+
 // 5) Convert Prism-style mermaid code blocks to <div class="mermaid">
 func transformMermaidBlocks(html string) string {
 	re := regexp.MustCompile(`(?is)<pre><code class="language-mermaid">([\s\S]*?)</code></pre>`)
@@ -92,7 +110,7 @@ func transformMermaidBlocks(html string) string {
 
 // 6) Emphasis conversion inside already-rendered HTML text nodes
 func convertInlineEmphasisInHTML(html string) string {
-	codeRe := regexp.MustCompile("(?is)(<pre[\\s\\S]*?</pre>|<code[\\s\\S]*?</code>)")
+	codeRe := regexp.MustCompile("(?is)(<pre[\\s\\S]*?</pre>|<code[\\s\\S]*?</code>|<math[\\s\\S]*?</math>)")
 	var stash []string
 	html = codeRe.ReplaceAllStringFunc(html, func(m string) string {
 		stash = append(stash, m)
@@ -179,7 +197,7 @@ func wrapImageGalleries(html string) string {
 			return m
 		}
 		src := im[2]
-		return `<p><a href="` + src + `" data-lightbox="article-images" rel="lightbox[article-images]">` + im[0][3:len(im[0])-4] + `</a></p>`
+		return `<p><a href="` + src + `" data-lightbox="article-images" rel="lightbox[article-images]">` + im[0] + `</a></p>`
 	})
 
 	return html
@@ -2,6 +2,7 @@ package render
 
 import (
 	"fmt"
+	"html"
 	"regexp"
 	"strings"
 )
@@ -188,8 +189,16 @@ func convertFences(s string) string {
 			return m
 		}
 		lang := strings.TrimSpace(sm[1])
-		code := cleanStyleHeader(sm[2])
-		return fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, lang, escapeCode(code))
+		code := sm[2]
+		// Meta such as {3-5} or title="main.go" after the language is kept
+		// for highlightCodeBlocks.
+		pre := "<pre>"
+		if nl := strings.Index(code, "\n"); nl > 0 && isFenceMeta(code[:nl]) {
+			pre = `<pre data-meta="` + html.EscapeString(strings.TrimSpace(code[:nl])) + `">`
+			code = code[nl+1:]
+		}
+		code = cleanStyleHeader(code)
+		return fmt.Sprintf(`%s<code class="language-%s">%s</code></pre>`, pre, lang, escapeCode(code))
 	})
 }
 
@@ -0,0 +1,231 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// codeMeta is what a fence's info string says beyond the language:
+//
+//	```go {3-5,8} title="main.go" nolinenos
+//
+// Ranges mark lines to highlight, title (or filename) adds a caption, and
+// nolinenos drops the line numbers. A language of "diff" or "diff-<lang>"
+// colours lines starting with + and -.
+type codeMeta struct {
+	Ranges    [][2]int
+	Title     string
+	NoLineNos bool
+}
+
+var (
+	fenceMetaTokenRe = regexp.MustCompile(`\{[\d,\s-]*\}|\w+="[^"]*"|\w+=\S+|\w+`)
+	// fenceMetaRe matches a whole info string made only of meta tokens, so
+	// ordinary code on a fence's first line is never taken for meta.
+	fenceMetaRe = regexp.MustCompile(`^\s*(?:(?:\{[\d,\s-]*\}|(?:title|filename)=(?:"[^"]*"|\S+)|nolinenos|linenos)\s*)+$`)
+)
+
+// isFenceMeta reports whether s is a valid meta string for parseFenceMeta.
+func isFenceMeta(s string) bool {
+	return fenceMetaRe.MatchString(s)
+}
+
+// parseFenceMeta reads the part of an info string after the language.
+// Unknown tokens are ignored.
+func parseFenceMeta(s string) codeMeta {
+	var meta codeMeta
+	for _, tok := range fenceMetaTokenRe.FindAllString(s, -1) {
+		switch {
+		case strings.HasPrefix(tok, "{"):
+			meta.Ranges = append(meta.Ranges, parseLineRanges(strings.Trim(tok, "{}"))...)
+		case strings.HasPrefix(tok, "title=") || strings.HasPrefix(tok, "filename="):
+			_, v, _ := strings.Cut(tok, "=")
+			meta.Title = strings.Trim(v, `"`)
+		case tok == "nolinenos":
+			meta.NoLineNos = true
+		case tok == "linenos":
+			meta.NoLineNos = false
+		}
+	}
+	return meta
+}
+
+// parseLineRanges reads "1,3-5" into [[1 1] [3 5]], skipping bad parts.
+func parseLineRanges(s string) [][2]int {
+	var ranges [][2]int
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		a, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			continue
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || b < a {
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{a, b})
+	}
+	return ranges
+}
+
+func (m codeMeta) highlighted(line int) bool {
+	for _, r := range m.Ranges {
+		if line >= r[0] && line <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightCode renders a code block with chroma's CSS classes (see
+// HighlightCSS), one <span class="line"> per line. Unknown languages are
+// rendered as plain text with the same structure.
+func highlightCode(code, lang string, meta codeMeta) string {
+	code = strings.TrimSuffix(code, "\n")
+	diff := lang == "diff" || strings.HasPrefix(lang, "diff-")
+	// markers holds each line's +/- column in diff blocks.
+	var markers []byte
+	stripMarkers := diff && lang != "diff"
+	if diff {
+		lines := strings.Split(code, "\n")
+		for i, l := range lines {
+			marker := byte(' ')
+			if l != "" && strings.ContainsRune("+- ", rune(l[0])) &&
+				!strings.HasPrefix(l, "+++") && !strings.HasPrefix(l, "---") {
+				marker = l[0]
+			}
+			markers = append(markers, marker)
+			if stripMarkers && l != "" && strings.ContainsRune("+- ", rune(l[0])) {
+				// diff-<lang>: take the column off so the code lexes as <lang>.
+				lines[i] = l[1:]
+			}
+		}
+		if stripMarkers {
+			code = strings.Join(lines, "\n")
+			lang = strings.TrimPrefix(lang, "diff-")
+		}
+	}
+
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+	var lines [][]chroma.Token
+	if it, err := lexer.Tokenise(nil, code+"\n"); err == nil {
+		lines = chroma.SplitTokensIntoLines(it.Tokens())
+	} else {
+		for _, l := range strings.Split(code, "\n") {
+			lines = append(lines, []chroma.Token{{Type: chroma.Text, Value: l + "\n"}})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<figure class="code-block">`)
+	if meta.Title != "" {
+		b.WriteString(`<figcaption class="code-title">` + html.EscapeString(meta.Title) + `</figcaption>`)
+	}
+	// No language-* class: Prism would highlight the block again.
+	b.WriteString(`<pre class="chroma"><code>`)
+	for i, tokens := range lines {
+		n := i + 1
+		class := "line"
+		if meta.highlighted(n) {
+			class += " hl"
+		}
+		marker := byte(' ')
+		if i < len(markers) {
+			marker = markers[i]
+		}
+		switch marker {
+		case '+':
+			class += " gi"
+		case '-':
+			class += " gd"
+		}
+		b.WriteString(`<span class="` + class + `">`)
+		if !meta.NoLineNos && len(lines) > 1 {
+			fmt.Fprintf(&b, `<span class="ln">%d</span>`, n)
+		}
+		if stripMarkers {
+			b.WriteString(`<span class="dm">` + string(marker) + `</span>`)
+		}
+		b.WriteString(`<span class="cl">`)
+		for _, tok := range tokens {
+			text := html.EscapeString(strings.TrimSuffix(tok.Value, "\n"))
+			if text == "" {
+				continue
+			}
+			if class := chroma.StandardTypes[tok.Type]; class != "" {
+				b.WriteString(`<span class="` + class + `">` + text + `</span>`)
+			} else {
+				b.WriteString(text)
+			}
+		}
+		b.WriteString("</span></span>\n")
+	}
+	b.WriteString(`</code></pre></figure>`)
+	return b.String()
+}
+
+// codeBlockRe matches the blocks convertFences emits, with or without the
+// data-meta attribute it adds for fences that carry meta.
+var codeBlockRe = regexp.MustCompile(`(?s)<pre(?: data-meta="([^"]*)")?><code class="language-([a-zA-Z0-9_+-]*)">(.*?)</code></pre>`)
+
+// highlightCodeBlocks highlights the fenced code blocks in rendered HTML.
+// Blocks with neither a language nor meta are left for the browser.
+func highlightCodeBlocks(s string) string {
+	return codeBlockRe.ReplaceAllStringFunc(s, func(m string) string {
+		sm := codeBlockRe.FindStringSubmatch(m)
+		meta, lang := html.UnescapeString(sm[1]), sm[2]
+		if lang == "" && meta == "" {
+			return m
+		}
+		return highlightCode(html.UnescapeString(sm[3]), lang, parseFenceMeta(meta))
+	})
+}
+
+// HighlightCSS returns the stylesheet for highlighted code: the light chroma
+// style for the page, the dark one under the .dark class the site's theme
+// toggle sets, and the line number, highlight and diff rules both share.
+func HighlightCSS(light, dark string) (string, error) {
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true))
+	var b strings.Builder
+	for _, theme := range []struct{ name, scope string }{{light, ""}, {dark, ".dark "}} {
+		style := styles.Get(theme.name)
+		if style == nil || (style == styles.Fallback && theme.name != styles.Fallback.Name) {
+			return "", fmt.Errorf("highlight css: unknown style %q", theme.name)
+		}
+		var css strings.Builder
+		if err := formatter.WriteCSS(&css, style); err != nil {
+			return "", fmt.Errorf("highlight css: %w", err)
+		}
+		for _, rule := range strings.SplitAfter(css.String(), "\n") {
+			// .bg is only used by chroma's own pre wrapper.
+			if !strings.HasPrefix(rule, "/* Background */") {
+				b.WriteString(strings.Replace(rule, " .chroma", " "+theme.scope+".chroma", 1))
+			}
+		}
+	}
+	b.WriteString(`.code-block { margin: 1.5rem 0; }
+.code-block .code-title { font-family: ui-monospace, monospace; font-size: .8rem; padding: .35rem .9rem; border-radius: .375rem .375rem 0 0; background: rgba(127,127,127,.15); }
+.code-block .code-title + pre { margin-top: 0; border-top-left-radius: 0; border-top-right-radius: 0; }
+.code-block pre.chroma { padding: .9rem 0; overflow-x: auto; }
+.code-block .line { display: block; padding: 0 .9rem; }
+.code-block .ln, .code-block .dm { user-select: none; }
+.code-block .line.hl { background: rgba(255,213,0,.18); }
+.code-block .line.gi { background: rgba(46,160,67,.15); }
+.code-block .line.gd { background: rgba(248,81,73,.15); }
+`)
+	return b.String(), nil
+}
@@ -0,0 +1,281 @@
+package render
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathHTML renders a formula as MathML. A formula texToMathML cannot parse
+// is shown as its source, with the reason in the title.
+func mathHTML(tex string, display bool) string {
+	out, err := texToMathML(tex, display)
+	if err == nil {
+		return out
+	}
+	title := ` title="` + mathEscape("Could not render formula: "+err.Error()) + `"`
+	if display {
+		return `<pre class="math-tex"` + title + "><code>" + mathEscape("$$"+tex+"$$") + "</code></pre>"
+	}
+	return `<code class="math-tex"` + title + ">" + mathEscape("$"+tex+"$") + "</code>"
+}
+
+func displayMath(tex string) string {
+	return `<div class="math-display">` + mathHTML(strings.TrimSpace(tex), true) + "</div>"
+}
+
+// skipCodeRe matches the code the source filters leave alone: fences, in
+// the same form convertFences reads them, inline code and HTML code
+// elements.
+var skipCodeRe = regexp.MustCompile("(?s)```([a-zA-Z0-9_-]*)\\s*(.*?)```|<pre[\\s>].*?</pre>|<code[\\s>].*?</code>|`[^`\n]+`")
+
+// renderMath replaces $…$, $$…$$ and ```math fences in Markdown source with
+// MathML, outside code. The MathML has Markdown punctuation escaped (see
+// mathEscape) and display math is set off by blank lines, so the rest of
+// the pipeline passes it through.
+func renderMath(s string) string {
+	if !strings.Contains(s, "$") && !strings.Contains(s, "```math") {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range skipCodeRe.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(renderMathInText(s[last:m[0]]))
+		if m[2] >= 0 && s[m[2]:m[3]] == "math" {
+			b.WriteString("\n\n" + displayMath(s[m[4]:m[5]]) + "\n\n")
+		} else {
+			b.WriteString(s[m[0]:m[1]])
+		}
+		last = m[1]
+	}
+	b.WriteString(renderMathInText(s[last:]))
+	return b.String()
+}
+
+func renderMathInText(s string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			// \$ is a dollar sign; Markdown drops the backslash later.
+			b.WriteString(s[i : i+2])
+			i += 2
+			continue
+		case s[i] != '$':
+			b.WriteByte(s[i])
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "$$"):
+			if end := displayMathEnd(s, i+2); end > 0 {
+				b.WriteString("\n\n" + displayMath(s[i+2:end]) + "\n\n")
+				i = end + 2
+				continue
+			}
+			b.WriteString("$$")
+			i += 2
+			continue
+		}
+		if end := inlineMathEnd(s, i+1); end > 0 {
+			b.WriteString(mathHTML(s[i+1:end], false))
+			i = end + 1
+			continue
+		}
+		b.WriteByte('$')
+		i++
+	}
+	return b.String()
+}
+
+// displayMathEnd returns the index of the $$ closing display math that
+// starts at start, or -1 if the paragraph ends first.
+func displayMathEnd(s string, start int) int {
+	end := strings.Index(s[start:], "$$")
+	if end <= 0 || strings.Contains(s[start:start+end], "\n\n") || strings.TrimSpace(s[start:start+end]) == "" {
+		return -1
+	}
+	return start + end
+}
+
+// inlineMathEnd returns the index of the $ closing inline math that starts
+// at start, or -1. As in Pandoc, the opening $ must be followed by a
+// non-space and the closing one preceded by a non-space and not followed by
+// a digit, so prices such as "$5 and $10" stay text.
+func inlineMathEnd(s string, start int) int {
+	if start >= len(s) || strings.IndexByte(" \t\n$", s[start]) >= 0 {
+		return -1
+	}
+	for j := start; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '\n':
+			if j+1 < len(s) && s[j+1] == '\n' {
+				return -1
+			}
+		case '$':
+			if strings.IndexByte(" \t\n", s[j-1]) < 0 && (j+1 == len(s) || !isDigit(s[j+1])) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// ---- AST engine ----
+
+var (
+	kindMath      = ast.NewNodeKind("Math")
+	kindMathBlock = ast.NewNodeKind("MathBlock")
+)
+
+type mathInline struct {
+	ast.BaseInline
+	tex     string
+	display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMath }
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex}, nil)
+}
+
+type mathBlock struct {
+	ast.BaseBlock
+	tex    string
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex}, nil)
+}
+
+// mathInlineParser reads $…$ and $$…$$ within a line, by the rules of
+// inlineMathEnd. Code spans are parsed first, so they keep their dollars.
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	s := string(line)
+	if strings.HasPrefix(s, "$$") {
+		end := displayMathEnd(s, 2)
+		if end < 0 {
+			return nil
+		}
+		block.Advance(end + 2)
+		return &mathInline{tex: s[2:end], display: true}
+	}
+	end := inlineMathEnd(s, 1)
+	if end < 0 {
+		return nil
+	}
+	block.Advance(end + 1)
+	return &mathInline{tex: s[1:end]}
+}
+
+// mathBlockParser reads display math that starts a line with $$ and runs to
+// the next $$. Math left open at a blank line is shown as source.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	body := bytes.TrimRight(line[pos+2:], " \t\r\n")
+	node := &mathBlock{}
+	if i := bytes.Index(body, []byte("$$")); i >= 0 {
+		if i+2 != len(body) {
+			// Text after the closing $$: leave it to the inline parser.
+			return nil, parser.NoChildren
+		}
+		node.tex, node.closed = string(body[:i]), true
+	} else {
+		node.tex = string(body) + "\n"
+	}
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if util.IsBlank(line) {
+		return parser.Close
+	}
+	if i := bytes.Index(line, []byte("$$")); i >= 0 {
+		n.tex += string(line[:i])
+		n.closed = true
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	n.tex += string(line)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+func (mathBlockParser) CanInterruptParagraph() bool                                { return true }
+func (mathBlockParser) CanAcceptIndentedLine() bool                                { return false }
+
+// mathNodes turns ```math fences into display math.
+func mathNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		fc, ok := n.(*ast.FencedCodeBlock)
+		return ok && string(fc.Language(source)) == "math"
+	}) {
+		block := &mathBlock{tex: string(n.Lines().Value(source)), closed: true}
+		n.Parent().ReplaceChild(n.Parent(), n, block)
+	}
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			m := n.(*mathInline)
+			_, _ = w.WriteString(mathHTML(m.tex, m.display))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindMathBlock, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			m := n.(*mathBlock)
+			if m.closed {
+				_, _ = w.WriteString(displayMath(m.tex) + "\n")
+			} else {
+				_, _ = w.WriteString(`<pre class="math-tex"><code>` + mathEscape("$$"+m.tex) + "</code></pre>\n")
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// mathExtension adds the math parsers and renderer to a goldmark instance.
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 750)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 450)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 100)))
+}
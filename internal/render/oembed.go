@@ -0,0 +1,361 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// OEmbedProvider is a site whose links, standing alone in a paragraph, are
+// turned into embeds through its oEmbed endpoint.
+type OEmbedProvider struct {
+	Name string
+	// Endpoint is the provider's oEmbed API. Providers without one, and
+	// links whose embed is not cached yet, are shown as link cards.
+	Endpoint string
+	// URLs matches the links the provider embeds.
+	URLs *regexp.Regexp
+	// FrameHosts are the hosts the provider's iframes load from.
+	FrameHosts []string
+}
+
+// OEmbedProviders are the providers the oembed filter knows. Their frame
+// hosts are allowed by SanitizePolicy.
+var OEmbedProviders = []OEmbedProvider{
+	{
+		Name:     "Twitter",
+		Endpoint: "https://publish.twitter.com/oembed",
+		URLs:     regexp.MustCompile(`^https://(?:www\.|mobile\.)?(?:twitter|x)\.com/\w+/status/\d+`),
+	},
+	{
+		Name:       "Vimeo",
+		Endpoint:   "https://vimeo.com/api/oembed.json",
+		URLs:       regexp.MustCompile(`^https://(?:www\.)?vimeo\.com/\d+`),
+		FrameHosts: []string{"player.vimeo.com"},
+	},
+	{
+		Name:       "CodePen",
+		Endpoint:   "https://codepen.io/api/oembed",
+		URLs:       regexp.MustCompile(`^https://codepen\.io/[\w-]+/pen/\w+`),
+		FrameHosts: []string{"codepen.io"},
+	},
+	{
+		Name:       "SoundCloud",
+		Endpoint:   "https://soundcloud.com/oembed",
+		URLs:       regexp.MustCompile(`^https://(?:www\.|m\.)?soundcloud\.com/[\w-]+/[\w-]+`),
+		FrameHosts: []string{"w.soundcloud.com"},
+	},
+	// GitHub has no oEmbed endpoint and gists embed with a script, which
+	// the sanitizer removes.
+	{
+		Name: "GitHub Gist",
+		URLs: regexp.MustCompile(`^https://gist\.github\.com/[\w-]+/[0-9a-fA-F]+`),
+	},
+}
+
+// Embed is a resolved link, as cached.
+type Embed struct {
+	URL      string
+	Provider string
+	Title    string
+	// HTML is the provider's embed code, already sanitized.
+	HTML string
+	// Failed records that resolution failed; the link card is shown until
+	// Expires, when it is tried again.
+	Failed  bool
+	Expires time.Time
+}
+
+// EmbedStore caches resolved embeds between renders and restarts.
+type EmbedStore interface {
+	// Embed returns the cached embed for url; ok is false if there is none.
+	Embed(url string) (e Embed, ok bool, err error)
+	SaveEmbed(e Embed) error
+}
+
+// Defaults for OEmbedResolver.
+const (
+	DefaultEmbedTTL        = 7 * 24 * time.Hour
+	DefaultEmbedRetryAfter = time.Hour
+	maxOEmbedResponse      = 1 << 20
+)
+
+// OEmbedResolver looks up embeds for the oembed filter and fetches missing
+// or expired ones in the background, so rendering never waits on a
+// provider: until an embed is cached the link is shown as a card, and an
+// expired embed is shown while it is refreshed.
+type OEmbedResolver struct {
+	Store EmbedStore
+	// Providers can be replaced, for instance to point at a fake provider
+	// in tests.
+	Providers []OEmbedProvider
+	Client    *http.Client
+	// TTL is how long a fetched embed is used before it is refreshed, and
+	// RetryAfter how long a failure is remembered.
+	TTL        time.Duration
+	RetryAfter time.Duration
+
+	mu      sync.Mutex
+	pending map[string]bool
+	queue   chan string
+}
+
+// NewOEmbedResolver returns a resolver caching in store with the default
+// providers and TTLs. Call Run to start fetching.
+func NewOEmbedResolver(store EmbedStore) *OEmbedResolver {
+	return &OEmbedResolver{
+		Store:      store,
+		Providers:  OEmbedProviders,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		TTL:        DefaultEmbedTTL,
+		RetryAfter: DefaultEmbedRetryAfter,
+		pending:    map[string]bool{},
+		queue:      make(chan string, 256),
+	}
+}
+
+var oembed struct {
+	sync.RWMutex
+	resolver *OEmbedResolver
+}
+
+// SetOEmbedResolver sets where the oembed filter looks up embeds. Without
+// one, provider links are shown as link cards.
+func SetOEmbedResolver(r *OEmbedResolver) {
+	oembed.Lock()
+	oembed.resolver = r
+	oembed.Unlock()
+}
+
+func currentOEmbedResolver() *OEmbedResolver {
+	oembed.RLock()
+	defer oembed.RUnlock()
+	return oembed.resolver
+}
+
+func (r *OEmbedResolver) provider(link string) (OEmbedProvider, bool) {
+	providers := OEmbedProviders
+	if r != nil && r.Providers != nil {
+		providers = r.Providers
+	}
+	for _, p := range providers {
+		if p.URLs.MatchString(link) {
+			return p, true
+		}
+	}
+	return OEmbedProvider{}, false
+}
+
+// lookup returns the cached embed for link, queueing a fetch when there is
+// none or it has expired.
+func (r *OEmbedResolver) lookup(link string) (Embed, bool) {
+	e, ok, err := r.Store.Embed(link)
+	if err != nil {
+		return Embed{}, false
+	}
+	if !ok || !time.Now().Before(e.Expires) {
+		r.enqueue(link)
+	}
+	return e, ok && !e.Failed
+}
+
+func (r *OEmbedResolver) enqueue(link string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[link] {
+		return
+	}
+	select {
+	case r.queue <- link:
+		r.pending[link] = true
+	default:
+		// Full: the link is queued again the next time it is rendered.
+	}
+}
+
+// Run fetches queued links until ctx is done.
+func (r *OEmbedResolver) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case link := <-r.queue:
+			if _, err := r.Resolve(ctx, link); err != nil {
+				log.Printf("oembed %s: %v", link, err)
+			}
+			r.mu.Lock()
+			delete(r.pending, link)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// oembedResponse holds the oEmbed fields the blog uses.
+type oembedResponse struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	HTML  string `json:"html"`
+	URL   string `json:"url"` // the image of a photo
+}
+
+// Resolve fetches link's embed from its provider and caches it. A failure
+// is cached too, for RetryAfter, so the provider is not asked again on
+// every render.
+func (r *OEmbedResolver) Resolve(ctx context.Context, link string) (Embed, error) {
+	p, ok := r.provider(link)
+	if !ok || p.Endpoint == "" {
+		return Embed{}, fmt.Errorf("resolve %s: no oEmbed provider", link)
+	}
+	e := Embed{URL: link, Provider: p.Name}
+	resp, err := r.fetch(ctx, p, link)
+	if err == nil {
+		e.Title = resp.Title
+		raw := resp.HTML
+		if resp.Type == "photo" && resp.URL != "" {
+			raw = `<img src="` + html.EscapeString(resp.URL) + `" alt="` + html.EscapeString(resp.Title) + `">`
+		}
+		e.HTML = sanitizeEmbed(raw)
+		if strings.TrimSpace(e.HTML) == "" {
+			err = errors.New("no embeddable HTML")
+		}
+	}
+	if err != nil {
+		e.HTML, e.Failed = "", true
+		// A refresh that fails keeps the embed that was working.
+		if old, ok, _ := r.Store.Embed(link); ok && !old.Failed {
+			e = old
+		}
+		e.Expires = time.Now().Add(r.RetryAfter)
+	} else {
+		e.Expires = time.Now().Add(r.TTL)
+	}
+	if serr := r.Store.SaveEmbed(e); serr != nil && err == nil {
+		err = serr
+	}
+	if err != nil {
+		return e, fmt.Errorf("resolve %s: %w", link, err)
+	}
+	return e, nil
+}
+
+func (r *OEmbedResolver) fetch(ctx context.Context, p OEmbedProvider, link string) (oembedResponse, error) {
+	endpoint, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return oembedResponse{}, err
+	}
+	q := endpoint.Query()
+	q.Set("url", link)
+	q.Set("format", "json")
+	q.Set("maxwidth", "720")
+	endpoint.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return oembedResponse{}, err
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return oembedResponse{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return oembedResponse{}, fmt.Errorf("provider returned %s", res.Status)
+	}
+	var out oembedResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxOEmbedResponse)).Decode(&out); err != nil {
+		return oembedResponse{}, fmt.Errorf("decode response: %w", err)
+	}
+	return out, nil
+}
+
+// embedHTML is what a standalone link to a provider becomes: the cached
+// embed, or a link card. ok is false for links no provider handles.
+func embedHTML(link string) (out string, ok bool) {
+	r := currentOEmbedResolver()
+	p, ok := r.provider(link)
+	if !ok {
+		return "", false
+	}
+	title := ""
+	if r != nil && p.Endpoint != "" {
+		e, found := r.lookup(link)
+		if found {
+			return `<div class="embed embed-` + headingSlug(p.Name) + `">` + e.HTML + "</div>", true
+		}
+		title = e.Title
+	}
+	return linkCard(p.Name, link, title), true
+}
+
+func linkCard(provider, link, title string) string {
+	if title == "" {
+		title = link
+	}
+	return `<div class="embed-card"><a href="` + html.EscapeString(link) + `" target="_blank" rel="noopener">` +
+		`<span class="embed-provider">` + html.EscapeString(provider) + `</span> ` +
+		`<span class="embed-title">` + html.EscapeString(title) + `</span></a></div>`
+}
+
+var standaloneLinkRe = regexp.MustCompile(`(?is)<p>\s*<a[^>]+href="(https://[^"]+)"[^>]*>[^<]*</a>\s*</p>`)
+
+// embedOEmbedLinks replaces paragraphs holding only a provider link with
+// the embed.
+func embedOEmbedLinks(s string) string {
+	return standaloneLinkRe.ReplaceAllStringFunc(s, func(m string) string {
+		link := html.UnescapeString(standaloneLinkRe.FindStringSubmatch(m)[1])
+		if out, ok := embedHTML(link); ok {
+			return out
+		}
+		return m
+	})
+}
+
+// ---- AST engine ----
+
+var kindEmbed = ast.NewNodeKind("Embed")
+
+type embedBlock struct {
+	ast.BaseBlock
+	html string
+}
+
+func (n *embedBlock) Kind() ast.NodeKind { return kindEmbed }
+func (n *embedBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// oembedNodes is embedOEmbedLinks on the tree.
+func oembedNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		_, ok := n.(*ast.Paragraph)
+		return ok
+	}) {
+		var link string
+		switch l := onlyChild(n).(type) {
+		case *ast.Link:
+			link = string(l.Destination)
+		case *ast.AutoLink:
+			link = string(l.URL(source))
+		default:
+			continue
+		}
+		if out, ok := embedHTML(link); ok {
+			n.Parent().ReplaceChild(n.Parent(), n, &embedBlock{html: out})
+		}
+	}
+}
@@ -3,119 +3,159 @@ package render
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/russross/blackfriday/v2"
+	"github.com/yuin/goldmark"
 )
 
 // Options to toggle features without touching code.
 type RendererOptions struct {
-	AddListClasses       bool // add Tailwind classes to <ul>/<ol>/<li>
-	AddBlockquoteClasses bool // style blockquotes
-	EnableLightbox       bool // wrap images in anchors inside image galleries or standalone <img>
-	EnableYouTubeEmbeds  bool // turn plain YT links into iframes
-	EnableTaskListHTML   bool // turn - [x] into checkboxes
-	EnableMermaid        bool // convert ```mermaid to <div class="mermaid">
-	ProtectInlineCSS     bool // strip one-line CSS outsiders (pre code{...}) pasted accidentally
+	// Filters turns registered filters on or off by name; filters not
+	// listed keep their registered default.
+	Filters      map[string]bool
+	SanitizeHTML bool // drop scripts and other HTML outside SanitizePolicy
+	// Registry supplies the filters; nil means DefaultRegistry.
+	Registry *Registry
+	// Engine is EngineLegacy (the default when empty) or EngineAST. The AST
+	// engine runs tree transforms instead of the registry's filters.
+	Engine string
 }
 
-// Default sane options for the blog.
+// Default sane options for the blog, including any filter settings passed
+// to Configure.
 func DefaultOptions() RendererOptions {
 	return RendererOptions{
-		AddListClasses:       true,
-		AddBlockquoteClasses: true,
-		EnableLightbox:       true,
-		EnableYouTubeEmbeds:  true,
-		EnableTaskListHTML:   true,
-		EnableMermaid:        true,
-		ProtectInlineCSS:     true,
+		Filters:      configuredFilters(),
+		SanitizeHTML: true,
 	}
 }
 
 type Renderer struct {
-	Opt RendererOptions
+	Opt  RendererOptions
+	pre  []Filter
+	post []Filter
+	md   goldmark.Markdown // set for EngineAST
+	// shortcodes expands shortcodes before md parses the source.
+	shortcodes bool
+	// version is the CacheKey version of the renderer's output.
+	version string
 }
 
 func NewRenderer(opt RendererOptions) *Renderer {
-	return &Renderer{Opt: opt}
+	if opt.Engine == EngineAST {
+		var steps []string
+		for _, tr := range treeTransforms {
+			if tr.enabled(opt) {
+				steps = append(steps, tr.name)
+			}
+		}
+		return &Renderer{
+			Opt:        opt,
+			md:         newASTMarkdown(opt),
+			shortcodes: treeTransform{name: FilterShortcodes}.enabled(opt),
+			version:    rendererVersion(opt, steps),
+		}
+	}
+	reg := opt.Registry
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	r := &Renderer{
+		Opt:  opt,
+		pre:  reg.Pipeline(StagePre, opt.Filters),
+		post: reg.Pipeline(StagePost, opt.Filters),
+	}
+	var steps []string
+	for _, f := range append(r.pre, r.post...) {
+		steps = append(steps, f.Name())
+	}
+	r.version = rendererVersion(opt, steps)
+	return r
 }
 
-// Render runs the full pipeline and returns final HTML.
+// Render runs the full pipeline and returns final HTML, from the render
+// cache when one is set.
 func (r *Renderer) Render(content string) string {
+	cache := CurrentRenderCache()
+	if cache == nil {
+		html, _ := r.RenderWithDebug(content, false)
+		return html
+	}
+	key := r.CacheKey(content)
+	if html, ok := cache.Get(key); ok {
+		return html
+	}
 	html, _ := r.RenderWithDebug(content, false)
+	var ttl time.Duration
+	if HasEmbeds(content) {
+		ttl = EmbedRenderTTL
+	}
+	cache.Add(key, html, ttl)
 	return html
 }
 
-// RenderWithDebug returns the final HTML and (optionally) every stage output for inspection.
+// RenderWithDebug returns the final HTML and (optionally) every stage output
+// for inspection, keyed by position and filter name ("03_more_tag").
 func (r *Renderer) RenderWithDebug(content string, includeStages bool) (string, map[string]string) {
 	stages := map[string]string{}
-
+	n := 0
 	stage := func(name, s string) string {
 		if includeStages {
-			stages[name] = s
+			key := itoa(n) + "_" + name
+			if n < 10 {
+				key = "0" + key
+			}
+			stages[key] = s
 		}
+		n++
 		return s
 	}
 
-	// --- PRE ---
-	s := content
-	s = stage("00_raw", s)
-	s = normalizeWhitespaceAndBreaks(s)
-	s = stage("01_normalized", s)
+	s := stage("raw", content)
 
-	if r.Opt.ProtectInlineCSS {
-		s = stripStyleSnippets(s)
-		s = stage("02_strip_style_snippets", s)
+	if r.md != nil {
+		if r.shortcodes {
+			s = stage(FilterShortcodes, expandShortcodeSource(s))
+		}
+		s = stage("ast", renderAST(r.md, s))
+		if r.Opt.SanitizeHTML {
+			s = stage("sanitized", sanitizeHTML(s))
+		}
+		return s, stages
 	}
 
-	s = replaceMoreTag(s)
-	s = stage("03_more_tag", s)
-
-	s = unwrapListLikeContainers(s)    // <div>- item</div> -> "- item"
-	s = ensureListSeparation(s)        // blank line before first -/1.
-	s = preprocessLooseMarkdownHTML(s) // headings/quotes from HTML-wrapped lines, etc.
-	s = normalizeInlinePipeTables(s)
-	s = convertFences(s) // ```lang -> <pre><code class="language-lang">...</code></pre>
-	s = stage("04_preprocessed", s)
+	// --- PRE ---
+	for _, f := range r.pre {
+		s = stage(f.Name(), f.Apply(s))
+	}
 
 	// --- MARKDOWN ---
-	md := renderMarkdown(s)
-	md = stage("05_markdown", md)
+	s = stage("markdown", renderMarkdown(s))
 
 	// --- POST ---
-	if r.Opt.EnableMermaid {
-		md = transformMermaidBlocks(md)
-		md = stage("06_mermaid", md)
-	}
-	if r.Opt.EnableTaskListHTML {
-		md = taskListToHTML(md)
-		md = stage("07_tasklist", md)
-	}
-	if r.Opt.EnableYouTubeEmbeds {
-		md = embedYouTube(md)
-		md = stage("08_youtube", md)
+	for _, f := range r.post {
+		s = stage(f.Name(), f.Apply(s))
 	}
-	if r.Opt.AddListClasses {
-		md = addListClasses(md)
-		md = stage("09_list_classes", md)
-	}
-	if r.Opt.AddBlockquoteClasses {
-		md = addBlockquoteClasses(md)
-		md = stage("10_blockquote_classes", md)
-	}
-	md = convertInlineEmphasisInHTML(md)
-	md = stage("11_inline_emphasis", md)
 
-	if r.Opt.EnableLightbox {
-		md = wrapImageGalleries(md)
-		md = stage("12_lightbox", md)
+	// --- SANITIZE ---
+	// Always last, so no filter can reintroduce what it removes.
+	if r.Opt.SanitizeHTML {
+		s = stage("sanitized", sanitizeHTML(s))
 	}
 
-	final := md
-	return final, stages
+	return s, stages
 }
 
 // ---- Markdown renderer ----
 
+// footnotesClass styles the footnote list both engines put at the end of a
+// post. Each note links back to its reference with footnoteReturn.
+const (
+	footnotesClass = "footnotes mt-8 pt-4 text-sm text-gray-600 dark:text-gray-400"
+	footnoteReturn = "↩"
+)
+
 func renderMarkdown(content string) string {
 	if !strings.HasSuffix(content, "\n") {
 		content += "\n"
@@ -124,10 +164,14 @@ func renderMarkdown(content string) string {
 		blackfriday.AutoHeadingIDs |
 		blackfriday.FencedCode |
 		blackfriday.Tables |
-		blackfriday.Strikethrough
-	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{})
+		blackfriday.Strikethrough |
+		blackfriday.Footnotes
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags:                      blackfriday.FootnoteReturnLinks,
+		FootnoteReturnLinkContents: footnoteReturn,
+	})
 	out := blackfriday.Run([]byte(content), blackfriday.WithExtensions(exts), blackfriday.WithRenderer(renderer))
-	return string(out)
+	return strings.Replace(string(out), `<div class="footnotes">`, `<div class="`+footnotesClass+`">`, 1)
 }
 
 // ---- Generic helpers shared by filters ----
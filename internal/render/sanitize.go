@@ -0,0 +1,230 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// youTubeEmbedRe matches the iframe sources embedYouTube produces.
+var youTubeEmbedRe = regexp.MustCompile(`^https://www\.youtube(?:-nocookie)?\.com/embed/[A-Za-z0-9_-]+(?:\?[^"]*)?$`)
+
+// slideEmbedRe matches the slide decks the slide shortcode embeds.
+var slideEmbedRe = regexp.MustCompile(`^/slides/[a-z0-9-]+$`)
+
+// oembedFrameRe matches iframes from the OEmbedProviders frame hosts.
+var oembedFrameRe = func() *regexp.Regexp {
+	var hosts []string
+	for _, p := range OEmbedProviders {
+		for _, h := range p.FrameHosts {
+			hosts = append(hosts, regexp.QuoteMeta(h))
+		}
+	}
+	return regexp.MustCompile(`^https://(?:` + strings.Join(hosts, "|") + `)/[^"]*$`)
+}()
+
+var (
+	classMu sync.RWMutex
+	// contentClasses are the classes sanitized posts keep: those the
+	// filters and registered shortcodes emit, and the editor's image
+	// galleries. A post cannot borrow the site's own classes to pass for
+	// its interface.
+	contentClasses = map[string]bool{}
+	// contentClassRe matches the classes the filters make from a name.
+	contentClassRe = regexp.MustCompile(`^(?:language-[A-Za-z0-9_+-]+|admonition-[a-z]+|callout-[a-z]+|embed-[a-z0-9-]+|toc-depth-[0-9])$`)
+)
+
+func init() {
+	allowClasses(strings.Fields("list-disc list-decimal pl-2 mb-2 task-item mr-2 align-middle " +
+		"admonition admonition-title font-semibold callout callout-title " +
+		"mermaid image-gallery aspect-video w-full max-w-3xl gist-card figure slide-embed " +
+		"embed embed-card embed-provider embed-title " +
+		"code-block code-title chroma line hl gi gd ln dm cl math-tex math-display shortcode-error " +
+		"footnote-ref footnote-return heading-anchor toc toc-title")...)
+	for _, classes := range []string{footnotesClass, blockquoteClass,
+		definitionListClass, definitionTermClass, definitionDescriptionClass} {
+		allowClasses(strings.Fields(classes)...)
+	}
+	for _, classes := range admonitionStyles {
+		allowClasses(strings.Fields(classes)...)
+	}
+	for _, class := range chroma.StandardTypes {
+		allowClasses(class)
+	}
+}
+
+// allowClasses adds to the classes sanitized posts keep.
+func allowClasses(names ...string) {
+	classMu.Lock()
+	defer classMu.Unlock()
+	for _, n := range names {
+		contentClasses[n] = true
+	}
+}
+
+func allowedClass(name string) bool {
+	classMu.RLock()
+	defer classMu.RUnlock()
+	return contentClasses[name] || contentClassRe.MatchString(name)
+}
+
+// contentIDPrefix is put before the ids of headings and footnotes, so a
+// post's ids cannot clash with the page's own elements or globals.
+const contentIDPrefix = "user-content-"
+
+var (
+	contentIDRe   = regexp.MustCompile(`(?i)(<(?:h[1-6]|li|sup)\b[^>]*?\sid=")([^"]*)(")`)
+	fragmentRefRe = regexp.MustCompile(`(\shref="#)([^"]*)(")`)
+	prefixedIDRe  = regexp.MustCompile(`^` + contentIDPrefix + `[A-Za-z0-9_:.-]+$`)
+	// sanitizedTagRe and sanitizedAttrRe read the policy's output, which
+	// quotes and escapes every attribute value.
+	sanitizedTagRe  = regexp.MustCompile(`<([a-z][a-z0-9]*)\b[^>]*>`)
+	sanitizedAttrRe = regexp.MustCompile(` ([^\s="]+)="([^"]*)"`)
+)
+
+// prefixContentIDs puts contentIDPrefix before heading and footnote ids and
+// before the fragments of links to them, such as the TOC's and footnotes'.
+func prefixContentIDs(html string) string {
+	ids := map[string]bool{}
+	html = contentIDRe.ReplaceAllStringFunc(html, func(m string) string {
+		g := contentIDRe.FindStringSubmatch(m)
+		id := strings.TrimPrefix(g[2], contentIDPrefix)
+		ids[id] = true
+		return g[1] + contentIDPrefix + id + g[3]
+	})
+	return fragmentRefRe.ReplaceAllStringFunc(html, func(m string) string {
+		g := fragmentRefRe.FindStringSubmatch(m)
+		if !ids[g[2]] {
+			return m
+		}
+		return g[1] + contentIDPrefix + g[2] + g[3]
+	})
+}
+
+// SanitizePolicy returns the allow-list applied to rendered posts: ordinary
+// user-generated HTML plus what the filters emit (classes, IDs, lightbox
+// anchors, mermaid divs, YouTube, slide and oEmbed iframes, task-list
+// checkboxes, MathML, the [TOC] nav and shortcode figures). Scripts, event
+// handlers, forms and javascript: URLs are removed. sanitizeHTML narrows
+// its classes and IDs further.
+func SanitizePolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+
+	p.AllowAttrs("class").Globally()
+	p.AllowStyles("color", "background-color", "text-align", "font-weight", "font-style",
+		"text-decoration", "width", "height", "max-width").Globally()
+
+	// Lightbox anchors from wrapImageGalleries.
+	p.AllowAttrs("rel", "target").OnElements("a")
+	p.AllowAttrs("data-lightbox", "data-title").OnElements("a")
+
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("img", "iframe")
+
+	// YouTube embeds from embedYouTube, decks from the slide shortcode and
+	// oEmbed provider players; other iframes are dropped.
+	p.AllowElements("iframe")
+	p.AllowAttrs("src").Matching(regexp.MustCompile(youTubeEmbedRe.String() + "|" + slideEmbedRe.String() + "|" + oembedFrameRe.String())).OnElements("iframe")
+	p.AllowAttrs("title", "frameborder", "allow", "allowfullscreen").OnElements("iframe")
+	p.AllowAttrs("width", "height").Matching(regexp.MustCompile(`^[0-9]+%?$`)).OnElements("iframe")
+	p.AllowAttrs("scrolling").Matching(regexp.MustCompile(`^(yes|no|auto)$`)).OnElements("iframe")
+	p.AllowElements("figure", "figcaption")
+
+	// The table of contents from addHeadingAnchors.
+	p.AllowElements("nav")
+
+	// Read-only task-list checkboxes from taskListToHTML.
+	p.AllowElements("input")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	// MathML from texToMathML.
+	mathElements := []string{"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext",
+		"mspace", "msup", "msub", "msubsup", "mfrac", "msqrt", "mroot", "mover", "munder",
+		"munderover", "mtable", "mtr", "mtd", "menclose"}
+	p.AllowElements(mathElements...)
+	p.AllowNoAttrs().OnElements(mathElements...)
+	p.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	p.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+	p.AllowAttrs("mathvariant").Matching(regexp.MustCompile(`^[a-z-]+$`)).OnElements("mi")
+	mathBool := regexp.MustCompile(`^(true|false)$`)
+	p.AllowAttrs("stretchy", "fence", "largeop", "movablelimits").Matching(mathBool).OnElements("mo")
+	p.AllowAttrs("accent").Matching(mathBool).OnElements("mover")
+	p.AllowAttrs("accentunder").Matching(mathBool).OnElements("munder")
+	p.AllowAttrs("displaystyle").Matching(mathBool).OnElements("mtable")
+	mathLength := regexp.MustCompile(`^-?[0-9.]+em$|^0$`)
+	p.AllowAttrs("minsize", "maxsize").Matching(mathLength).OnElements("mo")
+	p.AllowAttrs("width").Matching(mathLength).OnElements("mspace")
+	p.AllowAttrs("linethickness").Matching(mathLength).OnElements("mfrac")
+	p.AllowAttrs("columnspacing").Matching(mathLength).OnElements("mtable")
+	p.AllowAttrs("columnalign").Matching(regexp.MustCompile(`^(left|center|right)( (left|center|right))*$`)).OnElements("mtable")
+	p.AllowAttrs("notation").Matching(regexp.MustCompile(`^box$`)).OnElements("menclose")
+	// The error on formulas and shortcodes shown as source.
+	p.AllowAttrs("title").OnElements("code", "pre")
+
+	return p
+}
+
+var defaultPolicy = SanitizePolicy()
+
+// embedPolicy is applied to HTML from oEmbed providers before it is cached:
+// ordinary formatting, such as a tweet's quoted text, and iframes from the
+// provider frame hosts. Provider scripts are removed.
+var embedPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.AllowAttrs("class").OnElements("blockquote", "p", "a")
+	p.AllowElements("iframe")
+	p.AllowAttrs("src").Matching(oembedFrameRe).OnElements("iframe")
+	p.AllowAttrs("title", "frameborder", "allow", "allowfullscreen").OnElements("iframe")
+	p.AllowAttrs("width", "height").Matching(regexp.MustCompile(`^[0-9]+%?$`)).OnElements("iframe")
+	p.AllowAttrs("scrolling").Matching(regexp.MustCompile(`^(yes|no|auto)$`)).OnElements("iframe")
+	return p
+}()
+
+func sanitizeEmbed(html string) string {
+	return embedPolicy.Sanitize(html)
+}
+
+// dropForeignAttrs removes the ids and classes the policy allows on any
+// element but posts may not use: ids other than the prefixed ones of
+// headings and footnotes, and classes that are not allowedClass.
+func dropForeignAttrs(html string) string {
+	return sanitizedTagRe.ReplaceAllStringFunc(html, func(tag string) string {
+		elem := sanitizedTagRe.FindStringSubmatch(tag)[1]
+		return sanitizedAttrRe.ReplaceAllStringFunc(tag, func(attr string) string {
+			g := sanitizedAttrRe.FindStringSubmatch(attr)
+			switch g[1] {
+			case "id":
+				switch elem {
+				case "h1", "h2", "h3", "h4", "h5", "h6", "li", "sup":
+					if prefixedIDRe.MatchString(g[2]) {
+						return attr
+					}
+				}
+				return ""
+			case "class":
+				var kept []string
+				for _, c := range strings.Fields(g[2]) {
+					if allowedClass(c) {
+						kept = append(kept, c)
+					}
+				}
+				if len(kept) == 0 {
+					return ""
+				}
+				return ` class="` + strings.Join(kept, " ") + `"`
+			}
+			return attr
+		})
+	})
+}
+
+// sanitizeHTML prefixes the content IDs, applies the default policy and
+// drops the ids and classes posts may not use.
+func sanitizeHTML(html string) string {
+	return dropForeignAttrs(defaultPolicy.Sanitize(prefixContentIDs(html)))
+}
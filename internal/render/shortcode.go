@@ -0,0 +1,488 @@
+package render
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// ArgKind is the type a shortcode argument must have.
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgInt
+	ArgBool
+	// ArgURL is an http(s) URL or a path on this site.
+	ArgURL
+)
+
+func (k ArgKind) String() string {
+	switch k {
+	case ArgInt:
+		return "an integer"
+	case ArgBool:
+		return "true or false"
+	case ArgURL:
+		return "a URL"
+	}
+	return "text"
+}
+
+// ShortcodeArg declares one argument. Arguments can be given by position,
+// in the order they are declared, or by name as name=value; values with
+// spaces are quoted.
+type ShortcodeArg struct {
+	Name     string
+	Kind     ArgKind
+	Required bool
+	// Default is used when an optional argument is left out.
+	Default string
+	// OneOf, if set, lists the values allowed.
+	OneOf []string
+	// Pattern, if set, must match the value.
+	Pattern *regexp.Regexp
+}
+
+// Shortcode is a macro posts call as {{< name args >}}. It sets Render,
+// or Wrap for a paired shortcode, {{< name >}}Markdown{{< /name >}},
+// whose HTML goes around the rendered Markdown.
+type Shortcode struct {
+	Name   string
+	Args   []ShortcodeArg
+	Render func(args ShortcodeArgs) (string, error)
+	Wrap   func(args ShortcodeArgs) (open, close string, err error)
+	// Classes lists the classes its HTML uses; the sanitizer removes
+	// others.
+	Classes []string
+}
+
+// ShortcodeArgs are the checked arguments of one call. Optional arguments
+// that were left out without a default read as the zero value.
+type ShortcodeArgs struct {
+	values map[string]string
+}
+
+// Has reports whether the argument was given or has a default.
+func (a ShortcodeArgs) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+func (a ShortcodeArgs) String(name string) string { return a.values[name] }
+
+func (a ShortcodeArgs) Int(name string) int {
+	n, _ := strconv.Atoi(a.values[name])
+	return n
+}
+
+func (a ShortcodeArgs) Bool(name string) bool {
+	b, _ := strconv.ParseBool(a.values[name])
+	return b
+}
+
+// ShortcodeError is a shortcode that could not be expanded. It is shown in
+// the post as the shortcode's source, and listed in the editor preview.
+type ShortcodeError struct {
+	Line      int    `json:"line"`
+	Shortcode string `json:"shortcode"`
+	Message   string `json:"message"`
+}
+
+func (e ShortcodeError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Shortcode, e.Message)
+}
+
+var shortcodeNameRe = regexp.MustCompile(`^[A-Za-z][\w-]*$`)
+
+// ShortcodeRegistry holds the shortcodes posts can use.
+type ShortcodeRegistry struct {
+	mu    sync.RWMutex
+	codes map[string]Shortcode
+}
+
+// NewShortcodeRegistry returns an empty registry.
+func NewShortcodeRegistry() *ShortcodeRegistry {
+	return &ShortcodeRegistry{codes: map[string]Shortcode{}}
+}
+
+// DefaultShortcodes holds the built-in shortcodes and any added with
+// RegisterShortcode. The shortcodes filter expands these.
+var DefaultShortcodes = NewShortcodeRegistry()
+
+// RegisterShortcode adds a shortcode to DefaultShortcodes.
+func RegisterShortcode(sc Shortcode) error {
+	return DefaultShortcodes.Register(sc)
+}
+
+// Register adds a shortcode. It fails if the name is taken or the
+// declaration is malformed.
+func (r *ShortcodeRegistry) Register(sc Shortcode) error {
+	if !shortcodeNameRe.MatchString(sc.Name) {
+		return fmt.Errorf("register shortcode %q: invalid name", sc.Name)
+	}
+	if (sc.Render == nil) == (sc.Wrap == nil) {
+		return fmt.Errorf("register shortcode %q: exactly one of Render and Wrap must be set", sc.Name)
+	}
+	seen := map[string]bool{}
+	for _, arg := range sc.Args {
+		if !shortcodeNameRe.MatchString(arg.Name) || seen[arg.Name] {
+			return fmt.Errorf("register shortcode %q: invalid or repeated argument %q", sc.Name, arg.Name)
+		}
+		seen[arg.Name] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.codes[sc.Name]; ok {
+		return fmt.Errorf("register shortcode %q: already registered", sc.Name)
+	}
+	r.codes[sc.Name] = sc
+	allowClasses(sc.Classes...)
+	return nil
+}
+
+func (r *ShortcodeRegistry) lookup(name string) (Shortcode, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sc, ok := r.codes[name]
+	return sc, ok
+}
+
+// ---- Arguments ----
+
+var shortcodeArgRe = regexp.MustCompile(`^\s*(?:([A-Za-z][\w-]*)=)?("(?:[^"\\]|\\.)*"|[^\s"]+)`)
+
+// parseArgs reads and checks the arguments of one call against sc.Args.
+func (sc Shortcode) parseArgs(raw string) (ShortcodeArgs, error) {
+	values := map[string]string{}
+	next := 0
+	for rest := raw; strings.TrimSpace(rest) != ""; {
+		m := shortcodeArgRe.FindStringSubmatch(rest)
+		if m == nil {
+			return ShortcodeArgs{}, fmt.Errorf("cannot read arguments %q", strings.TrimSpace(rest))
+		}
+		rest = rest[len(m[0]):]
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return ShortcodeArgs{}, fmt.Errorf("bad quoting in %s", value)
+			}
+			value = v
+		}
+		name := m[1]
+		if name == "" {
+			for next < len(sc.Args) && hasKey(values, sc.Args[next].Name) {
+				next++
+			}
+			if next == len(sc.Args) {
+				return ShortcodeArgs{}, fmt.Errorf("too many arguments")
+			}
+			name = sc.Args[next].Name
+		} else if !sc.hasArg(name) {
+			return ShortcodeArgs{}, fmt.Errorf("unknown argument %q", name)
+		} else if hasKey(values, name) {
+			return ShortcodeArgs{}, fmt.Errorf("argument %q given twice", name)
+		}
+		values[name] = value
+	}
+	for _, arg := range sc.Args {
+		v, ok := values[arg.Name]
+		if !ok {
+			if arg.Required {
+				return ShortcodeArgs{}, fmt.Errorf("missing argument %q", arg.Name)
+			}
+			if arg.Default != "" {
+				values[arg.Name] = arg.Default
+			}
+			continue
+		}
+		if err := arg.check(v); err != nil {
+			return ShortcodeArgs{}, fmt.Errorf("argument %q: %w", arg.Name, err)
+		}
+	}
+	return ShortcodeArgs{values: values}, nil
+}
+
+func hasKey(m map[string]string, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func (sc Shortcode) hasArg(name string) bool {
+	for _, arg := range sc.Args {
+		if arg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (arg ShortcodeArg) check(v string) error {
+	ok := true
+	switch arg.Kind {
+	case ArgInt:
+		_, err := strconv.Atoi(v)
+		ok = err == nil
+	case ArgBool:
+		_, err := strconv.ParseBool(v)
+		ok = err == nil
+	case ArgURL:
+		ok = isShortcodeURL(v)
+	}
+	if !ok {
+		return fmt.Errorf("%q is not %s", v, arg.Kind)
+	}
+	if len(arg.OneOf) > 0 {
+		found := false
+		for _, allowed := range arg.OneOf {
+			found = found || v == allowed
+		}
+		if !found {
+			return fmt.Errorf("%q is not one of %s", v, strings.Join(arg.OneOf, ", "))
+		}
+	}
+	if arg.Pattern != nil && !arg.Pattern.MatchString(v) {
+		return fmt.Errorf("%q is not valid", v)
+	}
+	return nil
+}
+
+// isShortcodeURL accepts absolute http(s) URLs and site paths.
+func isShortcodeURL(v string) bool {
+	u, err := url.Parse(v)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return u.Host != ""
+	}
+	return u.Scheme == "" && strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "//")
+}
+
+// ---- Expansion ----
+
+var (
+	shortcodeTagRe = regexp.MustCompile(`\{\{<\s*(/?)\s*([A-Za-z][\w-]*)(.*?)>\}\}`)
+	// shortcodeHTMLRe is the marker expandShortcodes leaves for the HTML,
+	// alone in a paragraph or inline.
+	shortcodeHTMLRe = regexp.MustCompile(`<p>\s*<!--shortcode:([A-Za-z0-9+/]*)-->\s*</p>|<!--shortcode:([A-Za-z0-9+/]*)-->`)
+)
+
+// shortcodeMarker carries a shortcode's HTML through Markdown rendering in
+// a comment, so no filter rewrites it.
+func shortcodeMarker(html string) string {
+	return "<!--shortcode:" + base64.RawStdEncoding.EncodeToString([]byte(html)) + "-->"
+}
+
+func decodeShortcodeMarker(enc string) string {
+	b, _ := base64.RawStdEncoding.DecodeString(enc)
+	return string(b)
+}
+
+type shortcodeTag struct {
+	start, end int
+	closing    bool
+	name, args string
+}
+
+type shortcodeExpander struct {
+	reg  *ShortcodeRegistry
+	src  string
+	errs []ShortcodeError
+}
+
+// expandShortcodes replaces the shortcodes in Markdown source, outside
+// code, with markers for their HTML. A paired shortcode's content stays
+// Markdown between its two markers. Calls that fail are listed and shown
+// as their source.
+func (r *ShortcodeRegistry) expandShortcodes(s string) (string, []ShortcodeError) {
+	if !strings.Contains(s, "{{<") {
+		return s, nil
+	}
+	code := skipCodeRe.FindAllStringIndex(s, -1)
+	var tags []shortcodeTag
+	for _, m := range shortcodeTagRe.FindAllStringSubmatchIndex(s, -1) {
+		inCode := false
+		for _, c := range code {
+			inCode = inCode || m[0] >= c[0] && m[0] < c[1]
+		}
+		if !inCode {
+			tags = append(tags, shortcodeTag{start: m[0], end: m[1], closing: m[3] > m[2], name: s[m[4]:m[5]], args: s[m[6]:m[7]]})
+		}
+	}
+	x := &shortcodeExpander{reg: r, src: s}
+	var b strings.Builder
+	x.expand(&b, 0, len(s), tags)
+	return b.String(), x.errs
+}
+
+// expand writes src[from:to], in which tags are the shortcodes.
+func (x *shortcodeExpander) expand(b *strings.Builder, from, to int, tags []shortcodeTag) {
+	pos := from
+	for i := 0; i < len(tags); i++ {
+		t := tags[i]
+		b.WriteString(x.src[pos:t.start])
+		pos = t.end
+		sc, ok := x.reg.lookup(t.name)
+		if t.closing {
+			x.fail(b, t, "closing tag without an opening one")
+			continue
+		}
+		if !ok {
+			x.fail(b, t, "unknown shortcode")
+			continue
+		}
+		args, err := sc.parseArgs(t.args)
+		if sc.Render != nil {
+			out := ""
+			if err == nil {
+				out, err = sc.Render(args)
+			}
+			if err != nil {
+				x.fail(b, t, err.Error())
+				continue
+			}
+			b.WriteString(shortcodeMarker(out))
+			continue
+		}
+		end := matchingClose(tags, i)
+		if end < 0 {
+			x.fail(b, t, "missing {{< /"+t.name+" >}}")
+			continue
+		}
+		var open, close string
+		if err == nil {
+			open, close, err = sc.Wrap(args)
+		}
+		// On an error the content is still shown, without the wrapper.
+		if err != nil {
+			x.fail(b, t, err.Error())
+		} else {
+			b.WriteString("\n\n" + shortcodeMarker(open) + "\n\n")
+		}
+		x.expand(b, t.end, tags[end].start, tags[i+1:end])
+		if err == nil {
+			b.WriteString("\n\n" + shortcodeMarker(close) + "\n\n")
+		}
+		pos = tags[end].end
+		i = end
+	}
+	b.WriteString(x.src[pos:to])
+}
+
+// matchingClose returns the index of the tag closing tags[open], allowing
+// for nested pairs of the same name, or -1.
+func matchingClose(tags []shortcodeTag, open int) int {
+	depth := 0
+	for j := open + 1; j < len(tags); j++ {
+		if tags[j].name != tags[open].name {
+			continue
+		}
+		if !tags[j].closing {
+			depth++
+		} else if depth == 0 {
+			return j
+		} else {
+			depth--
+		}
+	}
+	return -1
+}
+
+func (x *shortcodeExpander) fail(b *strings.Builder, t shortcodeTag, msg string) {
+	x.errs = append(x.errs, ShortcodeError{
+		Line:      strings.Count(x.src[:t.start], "\n") + 1,
+		Shortcode: t.name,
+		Message:   msg,
+	})
+	b.WriteString(shortcodeMarker(`<code class="shortcode-error" title="` + html.EscapeString(msg) + `">` +
+		html.EscapeString(x.src[t.start:t.end]) + "</code>"))
+}
+
+// ShortcodeErrors lists the shortcodes in content that cannot be expanded,
+// for the editor preview. It is empty when the shortcodes filter is off.
+func ShortcodeErrors(content string) []ShortcodeError {
+	if on, ok := configuredFilters()[FilterShortcodes]; ok && !on {
+		return nil
+	}
+	_, errs := DefaultShortcodes.expandShortcodes(content)
+	return errs
+}
+
+// expandShortcodeSource is the shortcodes pre filter.
+func expandShortcodeSource(s string) string {
+	out, _ := DefaultShortcodes.expandShortcodes(s)
+	return out
+}
+
+// insertShortcodeHTML replaces the markers in rendered HTML with the
+// shortcodes' HTML. It runs after the other filters, which would otherwise
+// rewrite it.
+func insertShortcodeHTML(s string) string {
+	if !strings.Contains(s, "<!--shortcode:") {
+		return s
+	}
+	return shortcodeHTMLRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := shortcodeHTMLRe.FindStringSubmatch(m)
+		return decodeShortcodeMarker(sub[1] + sub[2])
+	})
+}
+
+// ---- AST engine ----
+
+var kindShortcode = ast.NewNodeKind("Shortcode")
+
+type shortcodeBlock struct {
+	ast.BaseBlock
+	html string
+}
+
+func (n *shortcodeBlock) Kind() ast.NodeKind { return kindShortcode }
+func (n *shortcodeBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type shortcodeInline struct {
+	ast.BaseInline
+	html string
+}
+
+func (n *shortcodeInline) Kind() ast.NodeKind { return kindShortcode }
+func (n *shortcodeInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// shortcodeNodes replaces the markers the renderer's expansion left in the
+// source with nodes for the shortcodes' HTML.
+func shortcodeNodes(doc *ast.Document, source []byte) {
+	for _, n := range collect(doc, func(n ast.Node) bool {
+		return n.Kind() == ast.KindHTMLBlock || n.Kind() == ast.KindRawHTML
+	}) {
+		parent := n.Parent()
+		if n.Kind() == ast.KindHTMLBlock {
+			raw := segmentsText(n.Lines(), source)
+			if strings.Contains(raw, "<!--shortcode:") {
+				parent.ReplaceChild(parent, n, &shortcodeBlock{html: insertShortcodeHTML(raw)})
+			}
+			continue
+		}
+		raw := segmentsText(n.(*ast.RawHTML).Segments, source)
+		if !strings.HasPrefix(raw, "<!--shortcode:") {
+			continue
+		}
+		out := insertShortcodeHTML(raw)
+		if parent.Kind() == ast.KindParagraph && parent.ChildCount() == 1 {
+			parent.Parent().ReplaceChild(parent.Parent(), parent, &shortcodeBlock{html: out})
+		} else {
+			parent.ReplaceChild(parent, n, &shortcodeInline{html: out})
+		}
+	}
+}
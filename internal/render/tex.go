@@ -0,0 +1,836 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on formulas, so a post cannot make rendering arbitrarily slow.
+const (
+	maxTeXLength = 4000
+	maxTeXDepth  = 50
+)
+
+// texToMathML converts a TeX formula to a <math> element, with the source
+// kept in an annotation. It understands the LaTeX math most posts use:
+// Greek letters and symbols, scripts and limits, \frac, \sqrt, accents,
+// fonts, \text, \left…\right and the matrix, cases and aligned
+// environments. Anything else is an error, so callers can show the source
+// instead.
+func texToMathML(tex string, display bool) (string, error) {
+	if len(tex) > maxTeXLength {
+		return "", fmt.Errorf("formula is longer than %d characters", maxTeXLength)
+	}
+	p := &texParser{src: tex, display: display}
+	items, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if p.hasPrefix(`\\`) {
+		// A bare line break in display math stacks lines, as gathered does.
+		p = &texParser{src: tex, display: display}
+		var table string
+		if table, err = p.parseRows("", "center", false); err != nil {
+			return "", err
+		}
+		items = []string{table}
+	}
+	if !p.atEnd() {
+		return "", fmt.Errorf("unexpected %q", p.rest(10))
+	}
+
+	var b strings.Builder
+	b.WriteString("<math")
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString("><semantics>")
+	b.WriteString(mrow(items))
+	b.WriteString(`<annotation encoding="application/x-tex">` + mathEscape(tex) + "</annotation>")
+	b.WriteString("</semantics></math>")
+	return b.String(), nil
+}
+
+// mathEscape escapes text for MathML that is placed in Markdown source
+// before rendering: besides the HTML specials it turns Markdown punctuation
+// into named references, which blackfriday passes through (it re-escapes
+// numeric ones), so neither it nor the emphasis filter touch formulas.
+// Newlines become spaces so the line-based repair filters see one line.
+func mathEscape(s string) string {
+	return mathEscaper.Replace(s)
+}
+
+var mathEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;", "\n", " ",
+	"*", "&ast;", "_", "&lowbar;", "`", "&grave;", "[", "&lsqb;", "]", "&rsqb;",
+	`\`, "&bsol;", "|", "&verbar;", "$", "&dollar;", "#", "&num;",
+)
+
+type texParser struct {
+	src     string
+	pos     int
+	depth   int
+	display bool
+	// variant is the mathvariant set by \mathbb and friends for letters.
+	variant string
+}
+
+var errTeXDepth = errors.New("formula is nested too deeply")
+
+func (p *texParser) atEnd() bool             { return p.pos >= len(p.src) }
+func (p *texParser) peek() byte              { return p.src[p.pos] }
+func (p *texParser) hasPrefix(s string) bool { return strings.HasPrefix(p.src[p.pos:], s) }
+
+func (p *texParser) rest(n int) string {
+	r := p.src[p.pos:]
+	if len(r) > n {
+		r = r[:n] + "…"
+	}
+	return r
+}
+
+func (p *texParser) skipSpace() {
+	for !p.atEnd() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *texParser) enter() error {
+	p.depth++
+	if p.depth > maxTeXDepth {
+		return errTeXDepth
+	}
+	return nil
+}
+
+func (p *texParser) leave() { p.depth-- }
+
+// peekCommand returns the name of the command at the current position
+// without consuming it, or "" if there is none.
+func (p *texParser) peekCommand() string {
+	save := p.pos
+	defer func() { p.pos = save }()
+	if p.atEnd() || p.peek() != '\\' {
+		return ""
+	}
+	p.pos++
+	return p.readCommandName()
+}
+
+// readCommandName reads a command name after its backslash: a run of
+// letters, or a single other character.
+func (p *texParser) readCommandName() string {
+	start := p.pos
+	for !p.atEnd() && isTeXLetter(p.peek()) {
+		p.pos++
+	}
+	if p.pos == start && !p.atEnd() {
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+	}
+	return p.src[start:p.pos]
+}
+
+func isTeXLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool     { return c >= '0' && c <= '9' }
+
+// atListEnd reports whether the current position closes a list: the end of
+// input or a group, a column or row separator, \right or \end.
+func (p *texParser) atListEnd() bool {
+	if p.atEnd() || p.peek() == '}' || p.peek() == '&' || p.hasPrefix(`\\`) {
+		return true
+	}
+	cmd := p.peekCommand()
+	return cmd == "right" || cmd == "end"
+}
+
+// parseList parses atoms and their scripts up to the end of the list,
+// which it leaves unconsumed.
+func (p *texParser) parseList() ([]string, error) {
+	var items []string
+	for {
+		p.skipSpace()
+		if p.atListEnd() {
+			return items, nil
+		}
+		atom, limits, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if atom, err = p.parseScripts(atom, limits); err != nil {
+			return nil, err
+		}
+		if atom != "" {
+			items = append(items, atom)
+		}
+	}
+}
+
+// parseScripts attaches any ^, _ and ' that follow base. limits puts them
+// above and below instead, as for \sum in display math.
+func (p *texParser) parseScripts(base string, limits bool) (string, error) {
+	var sup []string
+	var sub string
+	haveSub := false
+	primes := 0
+	for {
+		p.skipSpace()
+		if p.atEnd() {
+			break
+		}
+		c := p.peek()
+		if c == '\'' {
+			p.pos++
+			primes++
+			sup = append(sup, "<mo>′</mo>")
+			continue
+		}
+		if c != '^' && c != '_' {
+			break
+		}
+		p.pos++
+		if c == '^' && len(sup) > primes || c == '_' && haveSub {
+			return "", fmt.Errorf("double %c", c)
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", err
+		}
+		if c == '^' {
+			sup = append(sup, arg)
+		} else {
+			sub, haveSub = arg, true
+		}
+	}
+	haveSup := len(sup) > 0
+	if !haveSup && !haveSub {
+		return base, nil
+	}
+	if base == "" {
+		base = "<mrow></mrow>"
+	}
+	over := mrow(sup)
+	switch {
+	case haveSup && haveSub && limits:
+		return "<munderover>" + base + sub + over + "</munderover>", nil
+	case haveSup && haveSub:
+		return "<msubsup>" + base + sub + over + "</msubsup>", nil
+	case haveSub && limits:
+		return "<munder>" + base + sub + "</munder>", nil
+	case haveSub:
+		return "<msub>" + base + sub + "</msub>", nil
+	case limits:
+		return "<mover>" + base + over + "</mover>", nil
+	}
+	return "<msup>" + base + over + "</msup>", nil
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+// parseArg parses a command argument or script: a group or one token.
+func (p *texParser) parseArg() (string, error) {
+	p.skipSpace()
+	if p.atEnd() || p.peek() == '}' || p.peek() == '&' {
+		return "", errors.New("missing argument")
+	}
+	atom, _, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	if atom == "" {
+		atom = "<mrow></mrow>"
+	}
+	return atom, nil
+}
+
+// parseGroup parses {…} into a single element.
+func (p *texParser) parseGroup() (string, error) {
+	if err := p.enter(); err != nil {
+		return "", err
+	}
+	defer p.leave()
+	p.pos++ // {
+	items, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if p.atEnd() || p.peek() != '}' {
+		return "", errors.New("missing }")
+	}
+	p.pos++
+	if len(items) == 0 {
+		return "<mrow></mrow>", nil
+	}
+	return mrow(items), nil
+}
+
+// readBraced reads the raw text of a {…} argument.
+func (p *texParser) readBraced() (string, error) {
+	p.skipSpace()
+	if p.atEnd() || p.peek() != '{' {
+		return "", errors.New("missing {")
+	}
+	depth := 0
+	start := p.pos + 1
+	for ; !p.atEnd(); p.pos++ {
+		switch p.peek() {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+	return "", errors.New("missing }")
+}
+
+// readOptional reads the raw text of an optional […] argument, if present.
+func (p *texParser) readOptional() (string, bool) {
+	p.skipSpace()
+	if p.atEnd() || p.peek() != '[' {
+		return "", false
+	}
+	depth := 0
+	for i := p.pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ']':
+			if depth == 0 {
+				opt := p.src[p.pos+1 : i]
+				p.pos = i + 1
+				return opt, true
+			}
+		}
+	}
+	return "", false
+}
+
+// sub parses src as a separate formula with the same settings.
+func (p *texParser) sub(src string) (string, error) {
+	q := &texParser{src: src, depth: p.depth, display: p.display, variant: p.variant}
+	if err := q.enter(); err != nil {
+		return "", err
+	}
+	items, err := q.parseList()
+	if err != nil {
+		return "", err
+	}
+	if !q.atEnd() {
+		return "", fmt.Errorf("unexpected %q", q.rest(10))
+	}
+	return mrow(items), nil
+}
+
+func (p *texParser) mi(s string) string {
+	if p.variant != "" {
+		return `<mi mathvariant="` + p.variant + `">` + mathEscape(s) + "</mi>"
+	}
+	return "<mi>" + mathEscape(s) + "</mi>"
+}
+
+func mo(s string) string { return "<mo>" + mathEscape(s) + "</mo>" }
+
+// texOperators maps ASCII operator characters to what they display as.
+var texOperators = map[byte]string{
+	'+': "+", '-': "−", '=': "=", '<': "<", '>': ">", '(': "(", ')': ")",
+	'[': "[", ']': "]", '|': "|", ',': ",", ';': ";", ':': ":", '!': "!",
+	'?': "?", '/': "/", '*': "∗", '.': ".", '@': "@",
+}
+
+// parseAtom parses one token or group. limits reports whether scripts on it
+// go above and below.
+func (p *texParser) parseAtom() (atom string, limits bool, err error) {
+	c := p.peek()
+	switch {
+	case c == '{':
+		atom, err = p.parseGroup()
+		return atom, false, err
+	case c == '\\':
+		p.pos++
+		return p.parseCommand()
+	case c == '^' || c == '_' || c == '\'':
+		// A script with no base, such as {}^{14}C.
+		return "", false, nil
+	case isTeXLetter(c):
+		p.pos++
+		return p.mi(string(c)), false, nil
+	case isDigit(c) || c == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+		start := p.pos
+		for !p.atEnd() && (isDigit(p.peek()) || p.peek() == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return "<mn>" + p.src[start:p.pos] + "</mn>", false, nil
+	case c == '~':
+		p.pos++
+		return `<mspace width="0.3333em"></mspace>`, false, nil
+	}
+	if op, ok := texOperators[c]; ok {
+		p.pos++
+		return mo(op), false, nil
+	}
+	if c < utf8.RuneSelf {
+		return "", false, fmt.Errorf("unexpected %q", string(c))
+	}
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	if unicode.IsLetter(r) {
+		return p.mi(string(r)), false, nil
+	}
+	if unicode.IsDigit(r) {
+		return "<mn>" + string(r) + "</mn>", false, nil
+	}
+	return mo(string(r)), false, nil
+}
+
+// parseDelimiter reads the delimiter after \left, \right or \big.
+func (p *texParser) parseDelimiter() (string, error) {
+	p.skipSpace()
+	if p.atEnd() {
+		return "", errors.New("missing delimiter")
+	}
+	c := p.peek()
+	if c == '\\' {
+		p.pos++
+		name := p.readCommandName()
+		if d, ok := texDelimiters[name]; ok {
+			return d, nil
+		}
+		return "", fmt.Errorf(`unknown delimiter \%s`, name)
+	}
+	if strings.IndexByte("()[]|/.<>", c) >= 0 {
+		p.pos++
+		switch c {
+		case '.':
+			return "", nil
+		case '<':
+			return "⟨", nil
+		case '>':
+			return "⟩", nil
+		}
+		return string(c), nil
+	}
+	return "", fmt.Errorf("unknown delimiter %q", string(c))
+}
+
+var texDelimiters = map[string]string{
+	"{": "{", "}": "}", "|": "‖", "langle": "⟨", "rangle": "⟩", "lfloor": "⌊",
+	"rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "vert": "|", "Vert": "‖",
+	"lvert": "|", "rvert": "|", "lVert": "‖", "rVert": "‖", "backslash": "∖",
+	"uparrow": "↑", "downarrow": "↓", "updownarrow": "↕",
+}
+
+// bigDelimiterSizes are the heights of \big and its larger forms.
+var bigDelimiterSizes = map[string]string{"big": "1.2em", "Big": "1.8em", "bigg": "2.4em", "Bigg": "3em"}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em",
+	"!": "-0.1667em", " ": "0.3333em", "quad": "1em", "qquad": "2em",
+}
+
+var texFontVariants = map[string]string{
+	"mathrm": "normal", "mathit": "italic", "mathbf": "bold", "mathbb": "double-struck",
+	"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace", "boldsymbol": "bold-italic", "bm": "bold-italic",
+}
+
+var texAccents = map[string]struct {
+	mark    string
+	stretch bool
+}{
+	"hat": {"^", false}, "widehat": {"^", true}, "check": {"ˇ", false}, "tilde": {"˜", false},
+	"widetilde": {"˜", true}, "bar": {"¯", false}, "overline": {"‾", true}, "vec": {"→", false},
+	"overrightarrow": {"→", true}, "overleftarrow": {"←", true}, "dot": {"˙", false},
+	"ddot": {"¨", false}, "breve": {"˘", false}, "acute": {"´", false}, "grave": {"`", false},
+	"overbrace": {"⏞", true},
+}
+
+// texNegations are the symbols \not combines into one character.
+var texNegations = map[string]string{
+	"=": "≠", "in": "∉", "equiv": "≢", "subset": "⊄", "supset": "⊅",
+	"subseteq": "⊈", "supseteq": "⊉", "leq": "≰", "geq": "≱", "sim": "≁",
+	"approx": "≉", "mid": "∤", "parallel": "∦", "<": "≮", ">": "≯",
+}
+
+// parseCommand parses the command after a backslash.
+func (p *texParser) parseCommand() (string, bool, error) {
+	name := p.readCommandName()
+	if s, ok := texIdentifiers[name]; ok {
+		if unicode.IsUpper([]rune(s)[0]) && unicode.In([]rune(s)[0], unicode.Greek) {
+			// Capital Greek is upright.
+			return `<mi mathvariant="normal">` + s + "</mi>", false, nil
+		}
+		return p.mi(s), false, nil
+	}
+	if s, ok := texSymbols[name]; ok {
+		return mo(s), false, nil
+	}
+	if s, ok := texBigOperators[name]; ok {
+		integral := strings.Contains(name, "int")
+		return `<mo largeop="true" movablelimits="true">` + s + "</mo>", p.display && !integral, nil
+	}
+	if limits, ok := texFunctions[name]; ok {
+		return "<mi>" + name + "</mi>", limits && p.display, nil
+	}
+	if width, ok := texSpaces[name]; ok {
+		return `<mspace width="` + width + `"></mspace>`, false, nil
+	}
+	if variant, ok := texFontVariants[name]; ok {
+		saved := p.variant
+		p.variant = variant
+		arg, err := p.parseArg()
+		p.variant = saved
+		return arg, false, err
+	}
+	if acc, ok := texAccents[name]; ok {
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		stretchy := "false"
+		if acc.stretch {
+			stretchy = "true"
+		}
+		return `<mover accent="true">` + base + `<mo stretchy="` + stretchy + `">` + mathEscape(acc.mark) + "</mo></mover>", false, nil
+	}
+	if size, ok := bigDelimiterSizes[strings.TrimRight(name, "lrm")]; ok {
+		d, err := p.parseDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mo minsize="` + size + `" maxsize="` + size + `">` + mathEscape(d) + "</mo>", false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom":
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "binom" {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`, false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "sqrt":
+		index, hasIndex := p.readOptional()
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if !hasIndex {
+			return "<msqrt>" + base + "</msqrt>", false, nil
+		}
+		idx, err := p.sub(index)
+		if err != nil {
+			return "", false, err
+		}
+		return "<mroot>" + base + idx + "</mroot>", false, nil
+	case "text", "textrm", "textit", "textbf", "textnormal", "mbox", "hbox":
+		text, err := p.readBraced()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + mathEscape(strings.NewReplacer(`\{`, "{", `\}`, "}", `\$`, "$", `\%`, "%", `\&`, "&", `\_`, "_").Replace(text)) + "</mtext>", false, nil
+	case "operatorname":
+		text, err := p.readBraced()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mi>" + mathEscape(text) + "</mi>", false, nil
+	case "underline", "underbrace":
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		mark := "_"
+		if name == "underbrace" {
+			mark = "⏟"
+		}
+		return `<munder accentunder="true">` + base + `<mo stretchy="true">` + mathEscape(mark) + "</mo></munder>", name == "underbrace", nil
+	case "overset", "stackrel", "underset":
+		over, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "underset" {
+			return "<munder>" + base + over + "</munder>", false, nil
+		}
+		return "<mover>" + base + over + "</mover>", false, nil
+	case "boxed":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<menclose notation="box">` + arg + "</menclose>", false, nil
+	case "left":
+		return p.parseFenced()
+	case "begin":
+		env, err := p.readBraced()
+		if err != nil {
+			return "", false, err
+		}
+		atom, err := p.parseEnvironment(env)
+		return atom, false, err
+	case "not":
+		p.skipSpace()
+		next := ""
+		if !p.atEnd() && p.peek() == '\\' {
+			p.pos++
+			next = p.readCommandName()
+		} else if !p.atEnd() {
+			next = string(p.peek())
+			p.pos++
+		}
+		if s, ok := texNegations[next]; ok {
+			return mo(s), false, nil
+		}
+		return "", false, fmt.Errorf(`cannot negate %q`, next)
+	case "pmod":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mi>mod</mi><mspace width="0.3333em"></mspace>` + arg + "<mo>)</mo></mrow>", false, nil
+	case "bmod", "mod":
+		return "<mo>mod</mo>", false, nil
+	case "displaystyle", "textstyle", "limits", "nolimits":
+		// Layout hints MathML works out for itself.
+		return "", false, nil
+	case "right":
+		return "", false, errors.New(`\right without \left`)
+	case "end":
+		return "", false, errors.New(`\end without \begin`)
+	}
+	return "", false, fmt.Errorf(`unknown command \%s`, name)
+}
+
+// parseFenced parses the rest of \left… \right….
+func (p *texParser) parseFenced() (string, bool, error) {
+	if err := p.enter(); err != nil {
+		return "", false, err
+	}
+	defer p.leave()
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	items, err := p.parseList()
+	if err != nil {
+		return "", false, err
+	}
+	if p.peekCommand() != "right" {
+		return "", false, errors.New(`\left without \right`)
+	}
+	p.pos++
+	p.readCommandName()
+	closing, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if open != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + mathEscape(open) + "</mo>")
+	}
+	b.WriteString(strings.Join(items, ""))
+	if closing != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + mathEscape(closing) + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return b.String(), false, nil
+}
+
+// parseEnvironment parses the body of \begin{name} through \end{name}.
+func (p *texParser) parseEnvironment(name string) (string, error) {
+	open, closing, align := "", "", "center"
+	aligned := false
+	switch name {
+	case "matrix", "smallmatrix":
+	case "pmatrix":
+		open, closing = "(", ")"
+	case "bmatrix":
+		open, closing = "[", "]"
+	case "Bmatrix":
+		open, closing = "{", "}"
+	case "vmatrix":
+		open, closing = "|", "|"
+	case "Vmatrix":
+		open, closing = "‖", "‖"
+	case "cases":
+		open, align = "{", "left"
+	case "aligned", "align", "align*", "split", "alignedat":
+		aligned = true
+	case "gathered", "gather", "gather*":
+	case "array":
+		if _, err := p.readBraced(); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown environment %q", name)
+	}
+	table, err := p.parseRows(name, align, aligned)
+	if err != nil {
+		return "", err
+	}
+	if open == "" && closing == "" {
+		return table, nil
+	}
+	s := "<mrow>"
+	if open != "" {
+		s += `<mo fence="true" stretchy="true">` + mathEscape(open) + "</mo>"
+	}
+	s += table
+	if closing != "" {
+		s += `<mo fence="true" stretchy="true">` + mathEscape(closing) + "</mo>"
+	}
+	return s + "</mrow>", nil
+}
+
+// parseRows parses cells separated by & and rows separated by \\ into an
+// <mtable>, up to \end{env}, or the end of input when env is "". aligned
+// alternates right- and left-aligned columns, as the align environments do.
+func (p *texParser) parseRows(env, align string, aligned bool) (string, error) {
+	if err := p.enter(); err != nil {
+		return "", err
+	}
+	defer p.leave()
+	var rows [][]string
+	var row []string
+	for {
+		items, err := p.parseList()
+		if err != nil {
+			return "", err
+		}
+		row = append(row, strings.Join(items, ""))
+		switch {
+		case !p.atEnd() && p.peek() == '&':
+			p.pos++
+			continue
+		case p.hasPrefix(`\\`):
+			p.pos += 2
+			p.readOptional() // row spacing such as \\[2pt]
+			rows = append(rows, row)
+			row = nil
+			continue
+		case env != "" && p.peekCommand() == "end":
+			p.pos++
+			p.readCommandName()
+			end, err := p.readBraced()
+			if err != nil {
+				return "", err
+			}
+			if end != env {
+				return "", fmt.Errorf(`\begin{%s} ended by \end{%s}`, env, end)
+			}
+		case env == "" && p.atEnd():
+		default:
+			if env == "" {
+				return "", fmt.Errorf("unexpected %q", p.rest(10))
+			}
+			return "", fmt.Errorf(`\begin{%s} without \end`, env)
+		}
+		break
+	}
+	// A trailing \\ leaves an empty last row.
+	if len(row) > 1 || row[0] != "" || len(rows) == 0 {
+		rows = append(rows, row)
+	}
+
+	var b strings.Builder
+	b.WriteString("<mtable")
+	if aligned {
+		b.WriteString(` columnalign="right left right left right left" columnspacing="0em" displaystyle="true"`)
+	} else if align != "center" {
+		b.WriteString(` columnalign="` + align + `"`)
+	}
+	b.WriteString(">")
+	for _, r := range rows {
+		b.WriteString("<mtr>")
+		for _, cell := range r {
+			b.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return b.String(), nil
+}
+
+// texIdentifiers are commands that stand for a letter-like symbol.
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘", "imath": "ı",
+	"jmath": "ȷ",
+}
+
+// texSymbols are commands that stand for an operator, relation or
+// punctuation.
+var texSymbols = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"prec": "≺", "succ": "≻", "preceq": "⪯", "succeq": "⪰", "doteq": "≐",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "iff": "⟺", "implies": "⟹",
+	"impliedby": "⟸", "mapsto": "↦", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"longmapsto": "⟼", "uparrow": "↑", "downarrow": "↓", "hookrightarrow": "↪",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "cup": "∪", "cap": "∩", "setminus": "∖", "land": "∧", "wedge": "∧",
+	"lor": "∨", "vee": "∨", "neg": "¬", "lnot": "¬", "forall": "∀", "exists": "∃",
+	"nexists": "∄", "therefore": "∴", "because": "∵", "top": "⊤", "bot": "⊥", "perp": "⊥",
+	"parallel": "∥", "mid": "∣", "angle": "∠", "triangle": "△", "degree": "°", "prime": "′",
+	"vdash": "⊢", "models": "⊨", "ldots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"dots": "…", "langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈",
+	"rceil": "⌉", "lbrace": "{", "rbrace": "}", "vert": "|", "Vert": "‖", "|": "‖",
+	"{": "{", "}": "}", "#": "#", "%": "%", "&": "&", "_": "_", "$": "$", "backslash": "∖",
+	"colon": ":", "dagger": "†", "ddagger": "‡", "checkmark": "✓",
+}
+
+// texBigOperators take limits above and below in display math, except the
+// integrals.
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭",
+	"oint": "∮", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+	"bigvee": "⋁", "bigwedge": "⋀", "bigsqcup": "⨆",
+}
+
+// texFunctions are set upright; true marks those that take limits below in
+// display math, like \lim.
+var texFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false,
+	"tanh": false, "coth": false, "log": false, "lg": false, "ln": false, "exp": false,
+	"deg": false, "dim": false, "ker": false, "arg": false, "hom": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true,
+	"inf": true, "det": true, "gcd": true, "Pr": true, "argmax": true, "argmin": true,
+}
@@ -0,0 +1,279 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// TOCEntry is one heading of a post's outline.
+type TOCEntry struct {
+	Level int // 1-6, from <hN>
+	// Depth is how far the heading is nested below the outline's top
+	// level, starting at 0.
+	Depth int
+	ID    string
+	Text  string
+}
+
+// DefaultTOCDepth is how many heading levels an outline shows unless the
+// post asks for another depth.
+const DefaultTOCDepth = 3
+
+var (
+	// tocDepthRe finds a depth set by the marker, [TOC depth=2], or by a
+	// comment that sets it without placing a TOC, <!-- toc depth=2 -->.
+	tocDepthRe  = regexp.MustCompile(`(?i)\[TOC\s+depth=([1-6])\]|<!--\s*toc\s+depth=([1-6])\s*-->`)
+	tocMarkerRe = regexp.MustCompile(`(?i)^\[TOC(?:\s+depth=[1-6])?\]$`)
+	// tocParagraphRe is the marker as rendered into a paragraph.
+	tocParagraphRe = regexp.MustCompile(`(?i)<p>\s*\[TOC(?:\s+depth=[1-6])?\]\s*</p>`)
+
+	headingRe       = regexp.MustCompile(`(?is)<h([1-6])\b([^>]*)>(.*?)</h[1-6]>`)
+	headingIDAttrRe = regexp.MustCompile(`(?i)\sid="([^"]*)"`)
+	headingAnchorRe = regexp.MustCompile(`(?is)<a [^>]*class="heading-anchor"[^>]*>.*?</a>`)
+	tagRe           = regexp.MustCompile(`<[^>]*>`)
+	// validIDRe is what sanitizeHTML lets through after contentIDPrefix.
+	validIDRe = regexp.MustCompile(`^[A-Za-z0-9_:.-]+$`)
+)
+
+// TOCDepth returns the outline depth content asks for, or DefaultTOCDepth.
+func TOCDepth(content string) int {
+	if m := tocDepthRe.FindStringSubmatch(content); m != nil {
+		d, _ := strconv.Atoi(m[1] + m[2])
+		return d
+	}
+	return DefaultTOCDepth
+}
+
+// headingSlug makes an ID from heading text the way blackfriday's
+// AutoHeadingIDs does, keeping to ASCII so the sanitizer accepts it.
+func headingSlug(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// headingIDs hands out unique heading IDs, numbering repeats as GitHub
+// does: intro, intro-1, intro-2. It maps each ID handed out to the next
+// suffix to try when it repeats, so a run of repeats doesn't rescan the
+// numbers already taken.
+type headingIDs map[string]int
+
+func (ids headingIDs) unique(id string) string {
+	n, taken := ids[id]
+	if !taken {
+		ids[id] = 1
+		return id
+	}
+	base := id
+	for ; taken; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+		_, taken = ids[id]
+	}
+	ids[base] = n
+	ids[id] = 1
+	return id
+}
+
+// astIDs generates goldmark's automatic heading IDs, numbering repeats with
+// headingIDs since goldmark's own generator rescans every taken suffix. The
+// IDs are goldmark's; tocNodes replaces them when the TOC filter is on.
+type astIDs struct {
+	ids headingIDs
+}
+
+func (a astIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var id []byte
+	// Multi-byte characters are dropped, as goldmark does.
+	for _, c := range util.TrimRightSpace(util.TrimLeftSpace(value)) {
+		switch {
+		case 'A' <= c && c <= 'Z':
+			id = append(id, c+'a'-'A')
+		case util.IsAlphaNumeric(c):
+			id = append(id, c)
+		case util.IsSpace(c) || c == '-' || c == '_':
+			id = append(id, '-')
+		}
+	}
+	if len(id) == 0 {
+		if kind == ast.KindHeading {
+			id = []byte("heading")
+		} else {
+			id = []byte("id")
+		}
+	}
+	return []byte(a.ids.unique(string(id)))
+}
+
+func (a astIDs) Put(value []byte) {
+	if _, taken := a.ids[string(value)]; !taken {
+		a.ids[string(value)] = 1
+	}
+}
+
+// outlineDepths sets each entry's Depth and drops those nested deeper than
+// depth levels below the top one.
+func outlineDepths(entries []TOCEntry, depth int) []TOCEntry {
+	top := 7
+	for _, e := range entries {
+		top = min(top, e.Level)
+	}
+	var out []TOCEntry
+	for _, e := range entries {
+		e.Depth = e.Level - top
+		if e.Depth < depth {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func anchorLink(id string) string {
+	return `<a class="heading-anchor" href="#` + id + `" title="Link to this section">#</a>`
+}
+
+// tocHTML renders the inline table of contents a [TOC] marker stands for.
+func tocHTML(entries []TOCEntry) string {
+	var b strings.Builder
+	b.WriteString(`<nav class="toc"><p class="toc-title">Contents</p><ul>`)
+	for _, e := range entries {
+		fmt.Fprintf(&b, `<li class="toc-depth-%d"><a href="#%s">%s</a></li>`, e.Depth, e.ID, html.EscapeString(e.Text))
+	}
+	b.WriteString("</ul></nav>")
+	return b.String()
+}
+
+// headingText is the visible text of a heading's inner HTML.
+func headingText(inner string) string {
+	inner = headingAnchorRe.ReplaceAllString(inner, "")
+	return strings.Join(strings.Fields(html.UnescapeString(tagRe.ReplaceAllString(inner, ""))), " ")
+}
+
+// Outline lists the headings of rendered post HTML, to the given depth.
+func Outline(rendered string, depth int) []TOCEntry {
+	var entries []TOCEntry
+	for _, m := range headingRe.FindAllStringSubmatch(rendered, -1) {
+		id := headingIDAttrRe.FindStringSubmatch(m[2])
+		if id == nil {
+			continue
+		}
+		level, _ := strconv.Atoi(m[1])
+		entries = append(entries, TOCEntry{Level: level, ID: id[1], Text: headingText(m[3])})
+	}
+	return outlineDepths(entries, depth)
+}
+
+// addHeadingAnchors gives every heading a unique ID and a hover anchor
+// link, and replaces a [TOC] paragraph with the table of contents. IDs
+// blackfriday already set are kept; headings from HTML get one from their
+// text.
+func addHeadingAnchors(s string) string {
+	seen := headingIDs{}
+	var entries []TOCEntry
+	s = headingRe.ReplaceAllStringFunc(s, func(h string) string {
+		m := headingRe.FindStringSubmatch(h)
+		level, _ := strconv.Atoi(m[1])
+		attrs, inner := m[2], m[3]
+		text := headingText(inner)
+		id := headingSlug(text)
+		if old := headingIDAttrRe.FindStringSubmatch(attrs); old != nil {
+			attrs = headingIDAttrRe.ReplaceAllString(attrs, "")
+			if validIDRe.MatchString(old[1]) {
+				id = old[1]
+			}
+		}
+		id = seen.unique(id)
+		entries = append(entries, TOCEntry{Level: level, ID: id, Text: text})
+		return fmt.Sprintf(`<h%s id="%s"%s>%s%s</h%s>`, m[1], id, attrs, inner, anchorLink(id), m[1])
+	})
+	if loc := tocParagraphRe.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]] + tocHTML(outlineDepths(entries, TOCDepth(s))) + s[loc[1]:]
+	}
+	return s
+}
+
+// ---- AST engine ----
+
+var kindTOC = ast.NewNodeKind("TOC")
+
+type tocBlock struct {
+	ast.BaseBlock
+	entries []TOCEntry
+}
+
+func (n *tocBlock) Kind() ast.NodeKind { return kindTOC }
+func (n *tocBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// nodeText is the plain text under n.
+func nodeText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			b.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		case *mathInline:
+			b.WriteString(c.tex)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// tocNodes is addHeadingAnchors on the tree. goldmark's own heading IDs are
+// replaced so both engines produce the same anchors.
+func tocNodes(doc *ast.Document, source []byte) {
+	seen := headingIDs{}
+	var entries []TOCEntry
+	for _, n := range collect(doc, func(n ast.Node) bool { return n.Kind() == ast.KindHeading }) {
+		h := n.(*ast.Heading)
+		text := nodeText(h, source)
+		id := seen.unique(headingSlug(text))
+		h.SetAttributeString("id", []byte(id))
+		entries = append(entries, TOCEntry{Level: h.Level, ID: id, Text: text})
+
+		link := ast.NewLink()
+		link.Destination = []byte("#" + id)
+		link.Title = []byte("Link to this section")
+		link.SetAttributeString("class", []byte("heading-anchor"))
+		link.AppendChild(link, ast.NewString([]byte("#")))
+		h.AppendChild(h, link)
+	}
+	markers := collect(doc, func(n ast.Node) bool {
+		p, ok := n.(*ast.Paragraph)
+		return ok && tocMarkerRe.MatchString(strings.TrimSpace(string(p.Lines().Value(source))))
+	})
+	if len(markers) > 0 {
+		n := markers[0]
+		toc := &tocBlock{entries: outlineDepths(entries, TOCDepth(string(source)))}
+		n.Parent().ReplaceChild(n.Parent(), n, toc)
+	}
+}
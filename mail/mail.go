@@ -0,0 +1,157 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email is a single message with a plain-text and an optional HTML body.
+type Email struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers an Email.
+type Sender interface {
+	Send(email Email) error
+}
+
+// SMTPConfig holds the connection details for an SMTP relay. Username and
+// Password may be left empty for relays that do not require auth (e.g. a
+// local MailHog instance).
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPConfigFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD
+// and MAIL_FROM. The boolean is false when SMTP_HOST is not set.
+func SMTPConfigFromEnv() (SMTPConfig, bool) {
+	cfg := SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+	if cfg.Host == "" {
+		return cfg, false
+	}
+	if port, err := strconv.Atoi(os.Getenv("SMTP_PORT")); err == nil {
+		cfg.Port = port
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@" + cfg.Host
+	}
+	return cfg, true
+}
+
+// NewSenderFromEnv returns an SMTP sender when SMTP_HOST is configured and a
+// LogSender otherwise, so links still show up in the server log during
+// development.
+func NewSenderFromEnv() Sender {
+	cfg, ok := SMTPConfigFromEnv()
+	if !ok {
+		log.Println("SMTP_HOST not set; outgoing email will be written to the log")
+		return &LogSender{From: "no-reply@localhost"}
+	}
+	return &SMTPSender{Config: cfg}
+}
+
+type SMTPSender struct {
+	Config SMTPConfig
+}
+
+func (s *SMTPSender) Send(email Email) error {
+	if email.From == "" {
+		email.From = s.Config.From
+	}
+	msg, err := buildMessage(email)
+	if err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.Config.Username != "" {
+		auth = smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Config.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Config.Host, s.Config.Port)
+	if err := smtp.SendMail(addr, auth, email.From, []string{email.To}, msg); err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+	return nil
+}
+
+// LogSender writes messages to the standard logger instead of delivering them.
+type LogSender struct {
+	From string
+}
+
+func (s *LogSender) Send(email Email) error {
+	if email.From == "" {
+		email.From = s.From
+	}
+	log.Printf("[mail] to=%s subject=%q\n%s", email.To, email.Subject, email.Text)
+	return nil
+}
+
+// buildMessage renders an RFC 5322 message with a multipart/alternative body.
+func buildMessage(email Email) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + email.From,
+		"To: " + email.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	if err := writePart(mw, "text/plain; charset=utf-8", email.Text); err != nil {
+		return nil, err
+	}
+	if email.HTML != "" {
+		if err := writePart(mw, "text/html; charset=utf-8", email.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writePart(mw *multipart.Writer, contentType, body string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, body); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,96 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// EmailService sends the transactional emails used by account flows.
+type EmailService struct {
+	Sender Sender
+	From   string
+}
+
+func NewEmailService(sender Sender) *EmailService {
+	return &EmailService{Sender: sender}
+}
+
+type linkData struct {
+	Email     string
+	URL       string
+	ExpiresIn string
+}
+
+// SendPasswordReset emails a single-use password reset link.
+func (es *EmailService) SendPasswordReset(to, resetURL string, expiresIn time.Duration) error {
+	return es.sendLink(to, "Reset your password", "password_reset", linkData{
+		Email:     to,
+		URL:       resetURL,
+		ExpiresIn: humanDuration(expiresIn),
+	})
+}
+
+// SendEmailVerification emails a link confirming ownership of an address.
+func (es *EmailService) SendEmailVerification(to, verifyURL string, expiresIn time.Duration) error {
+	return es.sendLink(to, "Confirm your email address", "email_verification", linkData{
+		Email:     to,
+		URL:       verifyURL,
+		ExpiresIn: humanDuration(expiresIn),
+	})
+}
+
+// SendInvitation emails an invitee the link that creates their account.
+func (es *EmailService) SendInvitation(to, acceptURL string, expiresIn time.Duration) error {
+	return es.sendLink(to, "You've been invited to Anshuman Biswas Blog", "invitation", linkData{
+		Email:     to,
+		URL:       acceptURL,
+		ExpiresIn: humanDuration(expiresIn),
+	})
+}
+
+// SendAccountLocked tells the owner of an account that password sign-in has
+// been locked after repeated failures, and links to a password reset.
+func (es *EmailService) SendAccountLocked(to string, lockedFor time.Duration, resetURL string) error {
+	return es.sendLink(to, "Your account has been temporarily locked", "account_locked", linkData{
+		Email:     to,
+		URL:       resetURL,
+		ExpiresIn: humanDuration(lockedFor),
+	})
+}
+
+func (es *EmailService) sendLink(to, subject, tpl string, data linkData) error {
+	text, html, err := Render(tpl, data)
+	if err != nil {
+		return fmt.Errorf("%s email: %w", tpl, err)
+	}
+	err = es.Sender.Send(Email{
+		From:    es.From,
+		To:      to,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	})
+	if err != nil {
+		return fmt.Errorf("%s email: %w", tpl, err)
+	}
+	return nil
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	case d >= time.Hour && d%time.Hour == 0:
+		hours := int(d / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	default:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	}
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// Render executes the named email template in both its text (name.txt) and
+// HTML (name.gohtml) variants. HTML templates share layout.gohtml.
+func Render(name string, data interface{}) (text string, html string, err error) {
+	textTpl, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return "", "", fmt.Errorf("render %s: %w", name, err)
+	}
+	var textBuf bytes.Buffer
+	if err := textTpl.Execute(&textBuf, data); err != nil {
+		return "", "", fmt.Errorf("render %s: %w", name, err)
+	}
+
+	htmlTpl, err := htmltemplate.ParseFS(templateFS, "templates/layout.gohtml", "templates/"+name+".gohtml")
+	if err != nil {
+		return "", "", fmt.Errorf("render %s: %w", name, err)
+	}
+	var htmlBuf bytes.Buffer
+	if err := htmlTpl.ExecuteTemplate(&htmlBuf, "layout", data); err != nil {
+		return "", "", fmt.Errorf("render %s: %w", name, err)
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}
@@ -2,18 +2,27 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"anshumanbiswas.com/blog/controllers"
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/mail"
 	authmw "anshumanbiswas.com/blog/middleware"
 	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/rand"
+	"anshumanbiswas.com/blog/sso"
 	"anshumanbiswas.com/blog/templates"
 	"anshumanbiswas.com/blog/views"
 	"github.com/go-chi/chi/v5"
@@ -28,6 +37,122 @@ func getAppPort() string {
 	return port
 }
 
+// getCSRFKey returns the 32-byte key used to sign CSRF tokens. CSRF_KEY must
+// be a hex-encoded 32-byte value; without it a random key is generated, which
+// invalidates any open forms whenever the server restarts.
+func getCSRFKey() []byte {
+	if key := os.Getenv("CSRF_KEY"); key != "" {
+		decoded, err := hex.DecodeString(key)
+		if err != nil || len(decoded) != 32 {
+			log.Fatal("CSRF_KEY must be a hex-encoded 32-byte value")
+		}
+		return decoded
+	}
+	key, err := rand.Bytes(32)
+	if err != nil {
+		log.Fatalf("Could not generate CSRF key: %v", err)
+	}
+	log.Println("CSRF_KEY not set; using a random key for this process")
+	return key
+}
+
+// getPasskeyConfig returns the WebAuthn relying party settings. Passkeys are
+// bound to WEBAUTHN_RP_ID (a registrable domain) and may only be used from
+// the origins in WEBAUTHN_RP_ORIGINS; both default to APP_BASE_URL, or to
+// localhost for development.
+func getPasskeyConfig() models.PasskeyConfig {
+	origin := os.Getenv("APP_BASE_URL")
+	if origin == "" {
+		origin = "http://localhost:" + getAppPort()
+	}
+	origin = strings.TrimRight(origin, "/")
+
+	cfg := models.PasskeyConfig{
+		RPID:    os.Getenv("WEBAUTHN_RP_ID"),
+		RPName:  "Anshuman Biswas Blog",
+		Origins: []string{origin},
+	}
+	if origins := os.Getenv("WEBAUTHN_RP_ORIGINS"); origins != "" {
+		cfg.Origins = strings.Split(origins, ",")
+	}
+	if cfg.RPID == "" {
+		if u, err := url.Parse(cfg.Origins[0]); err == nil {
+			cfg.RPID = u.Hostname()
+		}
+	}
+	return cfg
+}
+
+// getSecurityConfig returns the security header settings. CSP_MODE is
+// enforce, report-only (the default) or off; CSP_POLICY replaces the default
+// policy and must keep "{nonce}" in script-src for the inline scripts in the
+// templates; HSTS_MAX_AGE is in seconds, with 0 turning HSTS off.
+func getSecurityConfig() authmw.SecurityConfig {
+	cfg := authmw.DefaultSecurityConfig()
+	switch mode := os.Getenv("CSP_MODE"); mode {
+	case "":
+	case authmw.CSPEnforce, authmw.CSPReportOnly, authmw.CSPOff:
+		cfg.CSPMode = mode
+	default:
+		log.Fatalf("CSP_MODE must be %s, %s or %s", authmw.CSPEnforce, authmw.CSPReportOnly, authmw.CSPOff)
+	}
+	if policy := os.Getenv("CSP_POLICY"); policy != "" {
+		cfg.CSP = policy
+	}
+	if v := os.Getenv("HSTS_MAX_AGE"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			log.Fatal("HSTS_MAX_AGE must be a number of seconds")
+		}
+		cfg.HSTSMaxAge = time.Duration(seconds) * time.Second
+	}
+	if v := os.Getenv("FRAME_OPTIONS"); v != "" {
+		cfg.FrameOptions = v
+	}
+	return cfg
+}
+
+// getRenderFilters reads RENDER_FILTERS, a comma-separated list such as
+// "youtube=off,lightbox=on" that turns post render filters on or off.
+func getRenderFilters() map[string]bool {
+	filters, err := render.DefaultRegistry.ParseFilterConfig(os.Getenv("RENDER_FILTERS"))
+	if err != nil {
+		log.Fatalf("RENDER_FILTERS: %v (known filters: %s)", err, strings.Join(render.DefaultRegistry.Names(), ", "))
+	}
+	return filters
+}
+
+// getRenderCacheSize reads RENDER_CACHE_SIZE, how many rendered posts and
+// previews are kept in memory; 0 turns the cache off. It defaults to 500.
+func getRenderCacheSize() int {
+	v := os.Getenv("RENDER_CACHE_SIZE")
+	if v == "" {
+		return 500
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		log.Fatal("RENDER_CACHE_SIZE must be a number of entries")
+	}
+	return size
+}
+
+// getCodeHighlightCSS builds the stylesheet for server-highlighted code from
+// the chroma styles named by CODE_THEME and CODE_THEME_DARK.
+func getCodeHighlightCSS() string {
+	light, dark := os.Getenv("CODE_THEME"), os.Getenv("CODE_THEME_DARK")
+	if light == "" {
+		light = "github"
+	}
+	if dark == "" {
+		dark = "github-dark"
+	}
+	css, err := render.HighlightCSS(light, dark)
+	if err != nil {
+		log.Fatalf("CODE_THEME: %v", err)
+	}
+	return css
+}
+
 func main() {
 	sugar := sugarLog()
 
@@ -45,6 +170,23 @@ func main() {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
+	render.Configure(getRenderFilters())
+	if size := getRenderCacheSize(); size > 0 {
+		render.SetRenderCache(render.NewRenderCache(size))
+	}
+
+	// Security headers and the per-request CSP nonce used by the templates.
+	r.Use(authmw.SecurityHeaders(getSecurityConfig()))
+
+	// CSRF protection for all cookie-authenticated state-changing routes.
+	// Bearer-token API requests are exempt inside the middleware. It assumes
+	// HTTPS unless CSRF_SECURE=false, for local development over plain HTTP.
+	csrfSecure, err := strconv.ParseBool(os.Getenv("CSRF_SECURE"))
+	if err != nil {
+		csrfSecure = true
+	}
+	r.Use(authmw.CSRFProtect(getCSRFKey(), csrfSecure))
+
 	dbUser, dbPassword, dbName, dbHost, dbPort :=
 		os.Getenv("PG_USER"),
 		os.Getenv("PG_PASSWORD"),
@@ -59,6 +201,12 @@ func main() {
 	}
 	defer database.Conn.Close()
 
+	// Post links to oEmbed providers render from the cache; missing embeds
+	// are fetched in the background.
+	embeds := render.NewOEmbedResolver(&models.EmbedService{DB: DB})
+	go embeds.Run(context.Background())
+	render.SetOEmbedResolver(embeds)
+
 	userService := models.UserService{
 		DB: DB,
 	}
@@ -71,6 +219,59 @@ func main() {
 		DB: DB,
 	}
 
+	passwordResetService := models.PasswordResetService{
+		DB: DB,
+	}
+
+	emailVerificationService := models.EmailVerificationService{
+		DB: DB,
+	}
+
+	invitationService := models.InvitationService{
+		DB: DB,
+	}
+
+	auditService := models.AuditService{
+		DB: DB,
+	}
+
+	twoFactorService := models.TwoFactorService{
+		DB:     DB,
+		Issuer: os.Getenv("TOTP_ISSUER"),
+	}
+
+	roleService := models.RoleService{
+		DB: DB,
+	}
+	// Permissions are read from role_permissions; the built-in defaults
+	// apply until they load.
+	if err := roleService.SeedDefaultPermissions(); err != nil {
+		log.Printf("Could not load role permissions, using defaults: %v", err)
+	}
+
+	passkeyService, err := models.NewPasskeyService(DB, getPasskeyConfig())
+	if err != nil {
+		log.Fatalf("Could not configure passkeys: %v", err)
+	}
+
+	identityService := models.IdentityService{
+		DB: DB,
+	}
+
+	ssoConfigs, err := sso.ConfigsFromEnv()
+	if err != nil {
+		log.Fatalf("Could not configure OpenID Connect providers: %v", err)
+	}
+	for _, cfg := range ssoConfigs {
+		fmt.Printf("OpenID Connect provider enabled: %s (%s)\n", cfg.Name, cfg.Issuer)
+	}
+
+	emailService := mail.NewEmailService(mail.NewSenderFromEnv())
+	emailService.From = os.Getenv("MAIL_FROM")
+
+	r.Post(authmw.CSPReportPath, controllers.CSPReport)
+	r.Get("/code-highlight.css", controllers.Stylesheet(getCodeHighlightCSS()))
+
 	r.Get("/about", controllers.StaticHandler(
 		views.Must(views.ParseFS(templates.FS, "about.gohtml", "tailwind.gohtml")), &sessionService))
 
@@ -95,6 +296,7 @@ func main() {
 
 	// Initialize BlogService
 	blogService := models.NewBlogService(DB)
+	blogService.PersistHTML = os.Getenv("RENDER_CACHE_PERSIST") == "true"
 
 	// Initialize CategoryService
 	categoryService := models.CategoryService{
@@ -113,6 +315,18 @@ func main() {
 		PostService:     &postService,
 		APITokenService: &apiTokenService,
 		CategoryService: &categoryService,
+
+		PasswordResetService:     &passwordResetService,
+		EmailVerificationService: &emailVerificationService,
+		InvitationService:        &invitationService,
+		EmailService:             emailService,
+		TwoFactorService:         &twoFactorService,
+		RoleService:              &roleService,
+		PasskeyService:           passkeyService,
+		IdentityService:          &identityService,
+		SSOProviders:             sso.NewRegistry(ssoConfigs),
+		LoginThrottle:            models.NewLoginThrottleService(DB),
+		AuditService:             &auditService,
 	}
 
 	// Initialize Blog controller
@@ -125,6 +339,7 @@ func main() {
 	categoriesC := controllers.Categories{
 		CategoryService: &categoryService,
 		SessionService:  &sessionService,
+		AuditService:    &auditService,
 	}
 
 	// Initialize Slides controller
@@ -132,6 +347,7 @@ func main() {
 		SlideService:    &slideService,
 		SessionService:  &sessionService,
 		CategoryService: &categoryService,
+		AuditService:    &auditService,
 	}
 
 	usersC.Templates.New = views.Must(views.ParseFS(
@@ -148,6 +364,12 @@ func main() {
 		r.Post("/signup", usersC.Create)
 	}
 
+	// Invitations work whether or not public signup is enabled.
+	usersC.Templates.AcceptInvitation = views.Must(views.ParseFS(
+		templates.FS, "accept-invitation.gohtml", "tailwind.gohtml"))
+	r.Get("/accept-invitation", usersC.AcceptInvitation)
+	r.Post("/accept-invitation", usersC.ProcessAcceptInvitation)
+
 	usersC.Templates.SignIn = views.Must(views.ParseFS(
 		templates.FS, "signin.gohtml", "tailwind.gohtml"))
 
@@ -157,6 +379,28 @@ func main() {
 	r.Get("/signin", usersC.SignIn)
 	r.Post("/signin", usersC.ProcessSignIn)
 
+	usersC.Templates.TwoFactor = views.Must(views.ParseFS(
+		templates.FS, "two-factor.gohtml", "tailwind.gohtml"))
+
+	r.Get("/signin/2fa", usersC.TwoFactorChallenge)
+	r.Post("/signin/2fa", usersC.ProcessTwoFactorChallenge)
+	r.Post("/signin/passkey/begin", usersC.BeginPasskeySignIn)
+	r.Post("/signin/passkey/finish", usersC.FinishPasskeySignIn)
+	r.Get("/auth/{provider}/login", usersC.SSOLogin)
+	r.Get("/auth/{provider}/callback", usersC.SSOCallback)
+
+	usersC.Templates.ForgotPassword = views.Must(views.ParseFS(
+		templates.FS, "forgot-password.gohtml", "tailwind.gohtml"))
+
+	usersC.Templates.ResetPassword = views.Must(views.ParseFS(
+		templates.FS, "reset-password.gohtml", "tailwind.gohtml"))
+
+	r.Get("/forgot-password", usersC.ForgotPassword)
+	r.Post("/forgot-password", usersC.ProcessForgotPassword)
+	r.Get("/reset-password", usersC.ResetPassword)
+	r.Post("/reset-password", usersC.ProcessResetPassword)
+	r.Get("/verify-email", usersC.VerifyEmail)
+
 	usersC.Templates.Home = views.Must(views.ParseFS(
 		templates.FS, "home.gohtml", "tailwind.gohtml"))
 
@@ -175,6 +419,12 @@ func main() {
 	usersC.Templates.PostEditor = views.Must(views.ParseFS(
 		templates.FS, "post-editor.gohtml", "tailwind.gohtml"))
 
+	// Shown when a post or slide save loses to one made in the meantime
+	editConflictTpl := views.Must(views.ParseFS(
+		templates.FS, "edit-conflict.gohtml", "tailwind.gohtml"))
+	usersC.Templates.EditConflict = editConflictTpl
+	slidesC.Templates.EditConflict = editConflictTpl
+
 	categoriesC.Templates.Manage = views.Must(views.ParseFS(
 		templates.FS, "admin-categories.gohtml", "tailwind.gohtml"))
 
@@ -203,9 +453,50 @@ func main() {
 	r.Get("/admin/uploads/list", usersC.ListUploadedImages)
 	r.Delete("/admin/uploads", usersC.DeleteImage)
 	r.Post("/admin/preview", usersC.PreviewRender)
+
+	// Renderer comparison between the legacy and AST engines
+	usersC.Templates.RenderCompare = views.Must(views.ParseFS(
+		templates.FS, "admin-render-compare.gohtml", "tailwind.gohtml"))
+	r.Get("/admin/render-compare", usersC.RenderCompare)
+	r.Post("/admin/render-compare/{postID}", usersC.SetPostRenderEngine)
 	r.Get("/my-posts", usersC.UserPosts)
 	r.Get("/api-access", usersC.APIAccess)
 
+	// Security Settings Routes (two-factor enforcement and resets)
+	usersC.Templates.AdminSecurity = views.Must(views.ParseFS(
+		templates.FS, "admin-security.gohtml", "tailwind.gohtml"))
+	r.Get("/admin/security", usersC.AdminSecurity)
+	r.Post("/admin/security/roles/{roleID}", usersC.SetRoleTwoFactor)
+	r.Post("/admin/users/{userID}/2fa/reset", usersC.ResetUserTwoFactor)
+	r.Post("/admin/users/{userID}/unlock", usersC.UnlockUser)
+
+	// Role Management Routes
+	usersC.Templates.AdminRoles = views.Must(views.ParseFS(
+		templates.FS, "admin-roles.gohtml", "tailwind.gohtml"))
+	r.Get("/admin/roles", usersC.AdminRoles)
+	r.Post("/admin/roles", usersC.CreateRole)
+	r.Post("/admin/roles/{roleID}", usersC.UpdateRole)
+	r.Post("/admin/roles/{roleID}/delete", usersC.DeleteRole)
+
+	// User Management Routes
+	usersC.Templates.AdminUsers = views.Must(views.ParseFS(
+		templates.FS, "admin-users.gohtml", "tailwind.gohtml"))
+	r.Get("/admin/users", usersC.AdminUsers)
+	r.Post("/admin/users/invite", usersC.InviteUser)
+	r.Post("/admin/users/{userID}/role", usersC.ChangeUserRole)
+	r.Post("/admin/users/{userID}/deactivate", usersC.DeactivateUser)
+	r.Post("/admin/users/{userID}/reactivate", usersC.ReactivateUser)
+	r.Post("/admin/users/{userID}/force-reset", usersC.ForcePasswordReset)
+	r.Get("/admin/users/{userID}/delete", usersC.ConfirmDeleteUser)
+	r.Post("/admin/users/{userID}/delete", usersC.DeleteUser)
+	r.Post("/admin/invitations/{invitationID}/resend", usersC.ResendInvitation)
+	r.Post("/admin/invitations/{invitationID}/revoke", usersC.RevokeInvitation)
+
+	// Audit Log Routes
+	usersC.Templates.AdminAudit = views.Must(views.ParseFS(
+		templates.FS, "admin-audit.gohtml", "tailwind.gohtml"))
+	r.Get("/admin/audit", usersC.AdminAudit)
+
 	// Category Management Routes
 	r.Get("/admin/categories", categoriesC.Manage)
 	r.Post("/admin/categories", categoriesC.CreateCategoryForm)
@@ -228,6 +519,15 @@ func main() {
 	r.Get("/users/me", usersC.CurrentUser)
 	r.Post("/users/password", usersC.UpdatePassword)
 	r.Post("/users/email", usersC.UpdateEmail)
+	r.Post("/users/2fa/setup", usersC.BeginTwoFactorSetup)
+	r.Post("/users/2fa/confirm", usersC.ConfirmTwoFactorSetup)
+	r.Post("/users/2fa/recovery-codes", usersC.RegenerateRecoveryCodes)
+	r.Post("/users/2fa/disable", usersC.DisableTwoFactor)
+	r.Post("/users/passkeys/register/begin", usersC.BeginPasskeyRegistration)
+	r.Post("/users/passkeys/register/finish", usersC.FinishPasskeyRegistration)
+	r.Post("/users/passkeys/{passkeyID}/delete", usersC.DeletePasskey)
+	r.Post("/users/identities/{provider}/link", usersC.LinkIdentity)
+	r.Post("/users/identities/{identityID}/unlink", usersC.UnlinkIdentity)
 	r.Post("/users/api-tokens", usersC.CreateAPIToken)
 	r.Post("/users/api-tokens/revoke", usersC.RevokeAPIToken)
 	r.Post("/users/api-tokens/delete", usersC.DeleteAPIToken)
@@ -269,6 +569,17 @@ func main() {
 		r.Post("/from-file", usersC.CreatePostFromFile)
 	})
 
+	// Versioned posts API: JSON errors, validation and ownership checks
+	r.Route("/api/v1/posts", func(r chi.Router) {
+		r.Use(authmw.APIAuthMiddleware(apiToken, &apiTokenService))
+		r.Get("/", usersC.APIListPosts)
+		r.Post("/", usersC.APICreatePost)
+		r.Get("/{postID}", usersC.APIGetPost)
+		r.Put("/{postID}", usersC.APIReplacePost)
+		r.Patch("/{postID}", usersC.APIUpdatePost)
+		r.Delete("/{postID}", usersC.APIDeletePost)
+	})
+
 	r.Route("/api/categories", func(r chi.Router) {
 		r.Use(authmw.APIAuthMiddleware(apiToken, &apiTokenService))
 		r.Get("/", categoriesC.ListCategories)
@@ -279,11 +590,11 @@ func main() {
 	})
 
 	// Define a custom 404 handler
+	notFoundTpl := views.Must(views.ParseFS(templates.FS, "NotFoundPage.gohtml"))
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.WriteHeader(http.StatusNotFound)
-		// You can render a custom 404 page here
-		// For simplicity, let's just return a plain text response
-		http.ServeFile(w, r, "templates/NotFoundPage.gohtml")
+		notFoundTpl.Execute(w, r, nil)
 	})
 
 	sugar.Infof("server listening on %s", *listenAddr)
@@ -330,13 +641,29 @@ func AuthMiddleware(token string) func(http.Handler) http.Handler {
 	}
 }
 
+// getAllPosts returns a page of published posts, filtered like the home
+// feed. The response's NextCursor, passed back as cursor, fetches the next
+// page.
 func getAllPosts(w http.ResponseWriter, r *http.Request) {
 
 	postService := models.PostService{
 		DB: DB,
 	}
 
-	posts, err := postService.GetTopPosts()
+	q, err := models.ParsePostFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 1 || q.Limit > models.MaxPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", models.MaxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	posts, err := postService.GetFeedPage(q)
 	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
@@ -398,7 +725,6 @@ func getFormattedPosts(w http.ResponseWriter, r *http.Request) {
 		}
 		readTime := fmt.Sprintf("%d min read", readingMinutes)
 
-		// Get categories (placeholder for now since we need to implement category fetching)
 		var categories []string
 		for _, cat := range post.Categories {
 			categories = append(categories, cat.Name)
@@ -426,14 +752,26 @@ func getFormattedPosts(w http.ResponseWriter, r *http.Request) {
 }
 
 func getPostByID(w http.ResponseWriter, r *http.Request) {
-	postID := chi.URLParam(r, "postID")
-	post := postID
-	// Fetch post from the database using postID
-	// Implement this logic based on your database schema
-	// Example: post, err := postService.GetPostByID(postID)
-	// Handle errors and send appropriate response
-	// Send the post as JSON response
-	jsonResponse(w, post, http.StatusOK)
+	postID, err := strconv.Atoi(chi.URLParam(r, "postID"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	postService := models.PostService{
+		DB: DB,
+	}
+
+	posts, err := postService.ListPosts(models.PostQuery{ID: postID, PublishedOnly: true})
+	if err != nil {
+		http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) == 0 {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, posts[0], http.StatusOK)
 }
 
 func createPost(w http.ResponseWriter, r *http.Request) {
@@ -452,9 +790,17 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create a new post using the postService
-	post, _ := postService.Create(newPost.UserID, newPost.CategoryID, newPost.Title, newPost.Content, newPost.IsPublished, newPost.Featured, newPost.FeaturedImageURL, newPost.Slug)
+	post, err := postService.Create(newPost.UserID, newPost.CategoryID, newPost.Title, newPost.Content, newPost.IsPublished, newPost.Featured, newPost.FeaturedImageURL, newPost.Slug, false)
+	if errors.Is(err, models.ErrSlugTaken) {
+		http.Error(w, "Slug is already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating post: %v", err)
+		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		return
+	}
 
-	// Handle errors and send appropriate response
 	// Send the created post as JSON response
 	jsonResponse(w, post, http.StatusCreated)
 }
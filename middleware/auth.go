@@ -79,7 +79,9 @@ func RequireRole(minRole int) func(http.Handler) http.Handler {
 	}
 }
 
-// RequirePermission returns middleware that checks for specific permission
+// RequirePermission returns middleware that checks for specific permission.
+// Permissions come from the role_permissions table via models.GetPermissions,
+// so changes made on the roles admin page apply on the next request.
 func RequirePermission(checkPermission func(models.UserPermissions) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
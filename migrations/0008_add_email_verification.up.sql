@@ -0,0 +1,16 @@
+-- Track whether a user's email address has been confirmed
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- Accounts that existed before verification was introduced are trusted
+UPDATE users SET email_verified = true;
+
+-- Pending confirmations, for signups and for email changes (where email holds
+-- the new address until it is confirmed)
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES users(user_id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,32 @@
+-- Administrators can make two-factor authentication mandatory per role
+ALTER TABLE roles ADD COLUMN require_two_factor BOOLEAN NOT NULL DEFAULT false;
+
+-- TOTP secrets. A row with enabled = false is an enrollment in progress.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    confirmed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Single-use recovery codes, stored as bcrypt hashes
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- Sign-ins that passed the password check and are waiting for a second
+-- factor. Only a SHA-256 hash of the challenge token is stored.
+CREATE TABLE IF NOT EXISTS two_factor_challenges (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
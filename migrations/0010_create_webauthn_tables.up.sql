@@ -0,0 +1,31 @@
+-- WebAuthn (passkey) credentials registered by users
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL DEFAULT '',
+    transports TEXT NOT NULL DEFAULT '',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    backup_eligible BOOLEAN NOT NULL DEFAULT false,
+    backup_state BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- In-flight registration and sign-in ceremonies. The browser holds the token
+-- in a cookie; only its SHA-256 hash is stored. user_id is NULL for
+-- discoverable (username-less) sign-in.
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    purpose VARCHAR(16) NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    session_data TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,26 @@
+-- External (OpenID Connect) identities linked to local users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- In-flight authorization requests. The state parameter doubles as the
+-- lookup token; only its SHA-256 hash is stored. link_user_id is set when a
+-- signed-in user is linking a new identity rather than signing in.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    provider VARCHAR(64) NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    link_user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS security_events;
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+DROP TABLE IF EXISTS login_failures;
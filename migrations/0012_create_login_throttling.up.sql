@@ -0,0 +1,27 @@
+-- Failed password sign-ins, counted per account and per client IP to slow
+-- down and lock out guessing. Rows for an email are cleared by a successful
+-- sign-in or an administrator unlock.
+CREATE TABLE IF NOT EXISTS login_failures (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_email ON login_failures(email, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_failures_ip ON login_failures(ip_address, attempted_at);
+
+-- A temporary lockout blocks password sign-in until locked_until.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Security-relevant events such as lockouts and unlocks.
+CREATE TABLE IF NOT EXISTS security_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    event_type VARCHAR(64) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_created_at ON security_events(created_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS role_permissions;
+ALTER TABLE roles DROP COLUMN IF EXISTS permissions_seeded;
+ALTER TABLE roles DROP COLUMN IF EXISTS is_system;
+ALTER TABLE roles DROP COLUMN IF EXISTS description;
@@ -0,0 +1,14 @@
+-- Roles carry a description, and the four built-in roles are marked as
+-- system roles that cannot be deleted.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS is_system BOOLEAN NOT NULL DEFAULT false;
+-- Set once a role's permissions have been written, so the application only
+-- seeds defaults for built-in roles that have never been configured.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS permissions_seeded BOOLEAN NOT NULL DEFAULT false;
+
+-- Permissions granted to each role, by name (e.g. edit_posts).
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
@@ -0,0 +1,5 @@
+-- Deactivated users keep their data but cannot sign in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE;
+-- Set by an administrator to make the user choose a new password before
+-- their next password sign-in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,18 @@
+-- Invitations let administrators onboard users while public signup is
+-- disabled. The account is created when the invitee accepts.
+CREATE TABLE IF NOT EXISTS invitations (
+    invitation_id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    sent_count INTEGER NOT NULL DEFAULT 1,
+    last_sent_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    accepted_user_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations (LOWER(email));
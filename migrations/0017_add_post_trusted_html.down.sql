@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'raw_html';
+ALTER TABLE posts DROP COLUMN IF EXISTS trusted_html;
@@ -0,0 +1,11 @@
+-- Set when a post was last saved by a role allowed to publish raw HTML; such
+-- posts skip the HTML sanitizer when rendered.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS trusted_html BOOLEAN NOT NULL DEFAULT false;
+
+-- Administrators keep raw HTML, and so do the posts they have written.
+INSERT INTO role_permissions (role_id, permission)
+SELECT role_id, 'raw_html' FROM roles WHERE role_id = 2 AND permissions_seeded
+ON CONFLICT DO NOTHING;
+
+UPDATE posts SET trusted_html = true
+WHERE user_id IN (SELECT user_id FROM users WHERE role_id = 2);
@@ -0,0 +1,4 @@
+-- Which renderer a post uses: 'legacy' (regex filters around blackfriday)
+-- or 'ast' (CommonMark/GFM tree). Existing posts stay on legacy until moved.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS render_engine VARCHAR(16) NOT NULL DEFAULT 'legacy'
+    CHECK (render_engine IN ('legacy', 'ast'));
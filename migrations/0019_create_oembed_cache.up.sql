@@ -0,0 +1,12 @@
+-- Embed HTML fetched from oEmbed providers, keyed by the link in the post.
+-- Rendering reads only this table; failed lookups are kept too so the
+-- provider is not asked again until expires_at.
+CREATE TABLE IF NOT EXISTS oembed_cache (
+    url TEXT PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    html TEXT NOT NULL DEFAULT '',
+    failed BOOLEAN NOT NULL DEFAULT FALSE,
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
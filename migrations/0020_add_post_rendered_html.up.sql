@@ -0,0 +1,5 @@
+-- Rendered HTML persisted with the post, and the render cache key
+-- (renderer version and content hash) it was rendered under. HTML whose key
+-- no longer matches is rendered again; saving the post clears both.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendered_html TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendered_key VARCHAR(96);
@@ -0,0 +1,3 @@
+-- Keyset pagination walks posts in this order, newest first, from the
+-- (date, id) position in the cursor.
+CREATE INDEX IF NOT EXISTS idx_posts_feed_order ON posts ((COALESCE(publication_date, created_at)) DESC, post_id DESC);
@@ -0,0 +1,5 @@
+-- Row versions for optimistic concurrency: every save increments the
+-- version, and a save made from an older version is refused as a conflict
+-- instead of overwriting the newer one.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE slides ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
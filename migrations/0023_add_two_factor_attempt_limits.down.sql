@@ -0,0 +1,2 @@
+ALTER TABLE user_totp DROP COLUMN IF EXISTS last_used_step;
+ALTER TABLE two_factor_challenges DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,7 @@
+-- Wrong codes entered against a sign-in challenge; the challenge is
+-- discarded once too many have been tried.
+ALTER TABLE two_factor_challenges ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+
+-- The last TOTP time-step accepted for the user, so a code cannot be used
+-- twice.
+ALTER TABLE user_totp ADD COLUMN IF NOT EXISTS last_used_step BIGINT;
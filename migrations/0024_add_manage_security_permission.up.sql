@@ -0,0 +1,5 @@
+-- Two-factor requirements, second-factor resets and unlocks need their own
+-- permission rather than admin-area access. Administrators keep them.
+INSERT INTO role_permissions (role_id, permission)
+SELECT role_id, 'manage_security' FROM roles WHERE role_id = 2 AND permissions_seeded
+ON CONFLICT DO NOTHING;
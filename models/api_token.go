@@ -107,7 +107,7 @@ func (ats *APITokenService) ValidateToken(token string) (*User, error) {
 		       u.user_id, u.username, u.email, u.role_id
 		FROM api_tokens at
 		JOIN users u ON at.user_id = u.user_id
-		WHERE at.is_active = true
+		WHERE at.is_active = true AND u.deactivated_at IS NULL
 	`
 	
 	rows, err := ats.DB.Query(query)
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit actions. The part before the dot is the kind of object acted on and
+// is used to group actions in the audit log filters.
+const (
+	AuditSignIn       = "auth.sign_in"
+	AuditSignInFailed = "auth.sign_in_failed"
+	AuditSignOut      = "auth.sign_out"
+
+	AuditTokenCreate = "token.create"
+	AuditTokenRevoke = "token.revoke"
+	AuditTokenDelete = "token.delete"
+
+	AuditPostPublish   = "post.publish"
+	AuditPostUnpublish = "post.unpublish"
+	AuditPostDelete    = "post.delete"
+
+	AuditCategoryCreate = "category.create"
+	AuditCategoryUpdate = "category.update"
+	AuditCategoryDelete = "category.delete"
+
+	AuditSlideCreate = "slide.create"
+	AuditSlideUpdate = "slide.update"
+	AuditSlideDelete = "slide.delete"
+
+	AuditUserRoleChange       = "user.role_change"
+	AuditUserDeactivate       = "user.deactivate"
+	AuditUserReactivate       = "user.reactivate"
+	AuditUserForceReset       = "user.force_password_reset"
+	AuditUserDelete           = "user.delete"
+	AuditUserUnlock           = "user.unlock"
+	AuditUserTwoFactorReset   = "user.two_factor_reset"
+	AuditUserTwoFactorDisable = "user.two_factor_disable"
+
+	AuditInvitationCreate = "invitation.create"
+	AuditInvitationResend = "invitation.resend"
+	AuditInvitationRevoke = "invitation.revoke"
+	AuditInvitationAccept = "invitation.accept"
+
+	AuditRoleCreate    = "role.create"
+	AuditRoleUpdate    = "role.update"
+	AuditRoleDelete    = "role.delete"
+	AuditRoleTwoFactor = "role.two_factor"
+)
+
+// AuditActions lists every audit action, for the filter on the audit page.
+var AuditActions = []string{
+	AuditSignIn, AuditSignInFailed, AuditSignOut,
+	AuditTokenCreate, AuditTokenRevoke, AuditTokenDelete,
+	AuditPostPublish, AuditPostUnpublish, AuditPostDelete,
+	AuditCategoryCreate, AuditCategoryUpdate, AuditCategoryDelete,
+	AuditSlideCreate, AuditSlideUpdate, AuditSlideDelete,
+	AuditUserRoleChange, AuditUserDeactivate, AuditUserReactivate, AuditUserForceReset,
+	AuditUserDelete, AuditUserUnlock, AuditUserTwoFactorReset, AuditUserTwoFactorDisable,
+	AuditInvitationCreate, AuditInvitationResend, AuditInvitationRevoke, AuditInvitationAccept,
+	AuditRoleCreate, AuditRoleUpdate, AuditRoleDelete, AuditRoleTwoFactor,
+}
+
+// AuditTargetTypes lists the kinds of object audit events are recorded
+// against.
+var AuditTargetTypes = []string{"user", "api_token", "post", "category", "slide", "role", "invitation"}
+
+// AuditEvent is one entry in the audit log. Actor and target names are
+// copied at the time of the event so the entry stays readable after they
+// are renamed or deleted.
+type AuditEvent struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// ActorID is 0 when nobody was signed in, e.g. a failed sign-in or a
+	// request made with the legacy API token.
+	ActorID     int    `json:"actor_id"`
+	ActorName   string `json:"actor_name"`
+	Action      string `json:"action"`
+	TargetType  string `json:"target_type"`
+	TargetID    string `json:"target_id"`
+	TargetLabel string `json:"target_label"`
+	IPAddress   string `json:"ip_address"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+}
+
+// AuditFilter narrows AuditService.List. Zero values match everything.
+type AuditFilter struct {
+	// Action matches an exact action ("user.delete") or, when it has no
+	// dot, every action on that kind of object ("user").
+	Action string
+	// Actor matches part of the actor's name.
+	Actor      string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	// Limit caps the number of events returned; 0 means no limit.
+	Limit int
+}
+
+// AuditService appends to and reads the audit log. There is deliberately no
+// way to change or delete entries; the table rejects it too.
+type AuditService struct {
+	DB *sql.DB
+}
+
+// Record appends an event.
+func (as *AuditService) Record(e AuditEvent) error {
+	var actor sql.NullInt64
+	if e.ActorID != 0 {
+		actor = sql.NullInt64{Int64: int64(e.ActorID), Valid: true}
+	}
+	_, err := as.DB.Exec(`
+		INSERT INTO audit_events (actor_id, actor_name, action, target_type, target_id, target_label,
+		                          ip_address, before_summary, after_summary)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		actor, e.ActorName, e.Action, e.TargetType, e.TargetID, e.TargetLabel,
+		e.IPAddress, e.Before, e.After)
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	return nil
+}
+
+// List returns events matching filter, newest first.
+func (as *AuditService) List(filter AuditFilter) ([]*AuditEvent, error) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Action != "" {
+		if strings.Contains(filter.Action, ".") {
+			add("action = $%d", filter.Action)
+		} else {
+			add("action LIKE $%d", likeEscaper.Replace(filter.Action)+".%")
+		}
+	}
+	if q := strings.TrimSpace(filter.Actor); q != "" {
+		add("LOWER(actor_name) LIKE $%d", "%"+likeEscaper.Replace(strings.ToLower(q))+"%")
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		add("occurred_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("occurred_at < $%d", filter.Until)
+	}
+
+	query := `
+		SELECT id, occurred_at, COALESCE(actor_id, 0), actor_name, action, target_type, target_id,
+		       target_label, ip_address, before_summary, after_summary
+		FROM audit_events`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY occurred_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := as.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		err := rows.Scan(&e.ID, &e.OccurredAt, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType,
+			&e.TargetID, &e.TargetLabel, &e.IPAddress, &e.Before, &e.After)
+		if err != nil {
+			return nil, fmt.Errorf("list audit events: %w", err)
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
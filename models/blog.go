@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"html/template"
+	"log"
+	"sync"
 	"time"
 
 	"anshumanbiswas.com/blog/internal/render"
@@ -12,12 +14,32 @@ import (
 type BlogService struct {
 	DB       *sql.DB
 	renderer *render.Renderer
+	// postRenderers holds a renderer for each engine, with and without the
+	// sanitizer, for rendering posts the way they were saved.
+	postRenderers map[postRenderKey]*render.Renderer
+	// PersistHTML stores rendered post HTML in the posts table, so it
+	// survives restarts and is shared between instances.
+	PersistHTML bool
+	// persisting holds the IDs of posts whose HTML is being stored.
+	persisting sync.Map
+}
+
+type postRenderKey struct {
+	engine  string
+	trusted bool
 }
 
 func NewBlogService(db *sql.DB) *BlogService {
+	postRenderers := map[postRenderKey]*render.Renderer{}
+	for _, engine := range render.Engines {
+		for _, trusted := range []bool{false, true} {
+			postRenderers[postRenderKey{engine, trusted}] = render.NewRenderer(PostRenderOptions(trusted, engine))
+		}
+	}
 	return &BlogService{
-		DB:       db,
-		renderer: render.NewRenderer(render.DefaultOptions()),
+		DB:            db,
+		renderer:      render.NewRenderer(render.DefaultOptions()),
+		postRenderers: postRenderers,
 	}
 }
 
@@ -25,7 +47,7 @@ func (bs *BlogService) GetBlogPostBySlug(slug string) (*Post, error) {
 	post := Post{}
 	fmt.Printf("DEBUG GetBlogPostBySlug: Looking for slug '%s'\n", slug)
 
-	const query = `SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured FROM posts WHERE slug = $1 LIMIT 1`
+	const query = `SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured, trusted_html, render_engine, COALESCE(rendered_html, ''), COALESCE(rendered_key, '') FROM posts WHERE slug = $1 LIMIT 1`
 	rows, err := bs.DB.Query(query, slug)
 	if err != nil {
 		fmt.Printf("DEBUG GetBlogPostBySlug: DB query failed: %v\n", err)
@@ -33,6 +55,7 @@ func (bs *BlogService) GetBlogPostBySlug(slug string) (*Post, error) {
 	}
 	defer rows.Close()
 
+	var renderedHTML, renderedKey string
 	for rows.Next() {
 		err := rows.Scan(
 			&post.ID,
@@ -47,6 +70,10 @@ func (bs *BlogService) GetBlogPostBySlug(slug string) (*Post, error) {
 			&post.FeaturedImageURL,
 			&post.CreatedAt,
 			&post.Featured,
+			&post.TrustedHTML,
+			&post.RenderEngine,
+			&renderedHTML,
+			&renderedKey,
 		)
 		if err != nil {
 			fmt.Printf("DEBUG GetBlogPostBySlug: Scan failed: %v\n", err)
@@ -74,8 +101,13 @@ func (bs *BlogService) GetBlogPostBySlug(slug string) (*Post, error) {
 		}
 
 		// --- Render ---
-		html := bs.renderer.Render(post.Content)
+		renderer, ok := bs.postRenderers[postRenderKey{post.RenderEngine, post.TrustedHTML}]
+		if !ok {
+			renderer = bs.renderer
+		}
+		html := bs.renderPost(renderer, post.ID, post.Content, renderedHTML, renderedKey)
 		post.ContentHTML = template.HTML(html)
+		post.Outline = render.Outline(html, render.TOCDepth(post.Content))
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration failed: %w", err)
@@ -90,6 +122,40 @@ func (bs *BlogService) GetBlogPostBySlug(slug string) (*Post, error) {
 	return &post, nil
 }
 
+// renderPost returns the post's HTML. With PersistHTML that is the
+// persisted HTML when it was rendered from this content by this renderer,
+// or a fresh render, persisted in the background unless it depends on
+// oEmbed lookups.
+func (bs *BlogService) renderPost(renderer *render.Renderer, id int, content, renderedHTML, renderedKey string) string {
+	if !bs.PersistHTML {
+		return renderer.Render(content)
+	}
+	key := renderer.CacheKey(content).String()
+	if renderedKey == key {
+		return renderedHTML
+	}
+	html := renderer.Render(content)
+	if !render.HasEmbeds(content) {
+		bs.persistRendered(id, html, key)
+	}
+	return html
+}
+
+// persistRendered stores a post's rendered HTML without holding up the
+// view, unless it is already being stored. HTML that lands after an edit
+// keeps the old content's key, so it is rendered again on the next view.
+func (bs *BlogService) persistRendered(id int, html, key string) {
+	if _, busy := bs.persisting.LoadOrStore(id, true); busy {
+		return
+	}
+	go func() {
+		defer bs.persisting.Delete(id)
+		if _, err := bs.DB.Exec(`UPDATE posts SET rendered_html = $1, rendered_key = $2 WHERE post_id = $3`, html, key, id); err != nil {
+			log.Printf("Error persisting rendered HTML for post %d: %v", id, err)
+		}
+	}()
+}
+
 // If you want to expose a preview that returns intermediate stages for debugging:
 func (bs *BlogService) RenderPreviewDebug(raw string) (final string, stages map[string]string) {
 	return bs.renderer.RenderWithDebug(raw, true)
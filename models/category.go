@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Category struct {
@@ -204,6 +206,40 @@ func (cs *CategoryService) GetCategoriesByPostID(postID int) ([]Category, error)
 	return categories, nil
 }
 
+// MissingCategories returns the IDs in ids that name no category.
+func (cs *CategoryService) MissingCategories(ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	rows, err := cs.DB.Query(`SELECT category_id FROM Categories WHERE category_id = ANY($1)`, pq.Array(ids64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up categories: %w", err)
+	}
+	defer rows.Close()
+	found := make(map[int]bool, len(ids))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to look up categories: %w", err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up categories: %w", err)
+	}
+	var missing []int
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
 // AssignCategoriesToPost assigns categories to a post
 func (cs *CategoryService) AssignCategoriesToPost(postID int, categoryIDs []int) error {
 	if len(categoryIDs) == 0 {
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultVerificationDuration is how long an email verification link stays
+// valid.
+const DefaultVerificationDuration = 48 * time.Hour
+
+// EmailVerification is a pending confirmation that UserID owns Email. For a
+// new signup Email is the address the account was created with; for an email
+// change it is the new address, which only takes effect once confirmed.
+type EmailVerification struct {
+	ID        int
+	UserID    int
+	Email     string
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type EmailVerificationService struct {
+	DB *sql.DB
+	// Duration is how long a verification token is valid. Defaults to
+	// DefaultVerificationDuration when zero.
+	Duration time.Duration
+}
+
+func (evs *EmailVerificationService) duration() time.Duration {
+	if evs.Duration <= 0 {
+		return DefaultVerificationDuration
+	}
+	return evs.Duration
+}
+
+// Create issues a verification token for email, replacing any pending
+// verification for the same user.
+func (evs *EmailVerificationService) Create(userID int, email string) (*EmailVerification, error) {
+	email = NormalizeEmail(email)
+
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return nil, fmt.Errorf("create email verification: %w", err)
+	}
+
+	ev := EmailVerification{
+		UserID:    userID,
+		Email:     email,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().UTC().Add(evs.duration()),
+	}
+	err = evs.DB.QueryRow(`
+		INSERT INTO email_verifications (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, token_hash = EXCLUDED.token_hash,
+		    expires_at = EXCLUDED.expires_at, created_at = NOW()
+		RETURNING id`, ev.UserID, ev.Email, ev.TokenHash, ev.ExpiresAt).Scan(&ev.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create email verification: %w", err)
+	}
+	return &ev, nil
+}
+
+// Consume validates token, deletes it, and applies the verified address to
+// the user, marking the account as verified.
+func (evs *EmailVerificationService) Consume(token string) (*EmailVerification, error) {
+	tx, err := evs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+	defer tx.Rollback()
+
+	ev := EmailVerification{TokenHash: hashLookupToken(token)}
+	err = tx.QueryRow(`
+		DELETE FROM email_verifications WHERE token_hash = $1
+		RETURNING id, user_id, email, expires_at`, ev.TokenHash).Scan(
+		&ev.ID, &ev.UserID, &ev.Email, &ev.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("consume email verification: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+	if time.Now().UTC().After(ev.ExpiresAt) {
+		// Commit so the expired token is cleaned up.
+		tx.Commit()
+		return nil, fmt.Errorf("consume email verification: %w", ErrTokenExpired)
+	}
+
+	_, err = tx.Exec(`UPDATE users SET email = $1, email_verified = true WHERE user_id = $2`, ev.Email, ev.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+	return &ev, nil
+}
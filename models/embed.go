@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+// EmbedService stores resolved oEmbed links for the renderer; it
+// implements render.EmbedStore.
+type EmbedService struct {
+	DB *sql.DB
+}
+
+// Embed returns the cached embed for url, expired or not.
+func (es *EmbedService) Embed(url string) (render.Embed, bool, error) {
+	e := render.Embed{URL: url}
+	err := es.DB.QueryRow(`
+		SELECT provider, title, html, failed, expires_at
+		FROM oembed_cache WHERE url = $1`, url).Scan(&e.Provider, &e.Title, &e.HTML, &e.Failed, &e.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return render.Embed{}, false, nil
+		}
+		return render.Embed{}, false, fmt.Errorf("get embed: %w", err)
+	}
+	return e, true, nil
+}
+
+// SaveEmbed records a lookup, replacing any earlier one for the same URL.
+func (es *EmbedService) SaveEmbed(e render.Embed) error {
+	_, err := es.DB.Exec(`
+		INSERT INTO oembed_cache (url, provider, title, html, failed, fetched_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6)
+		ON CONFLICT (url) DO UPDATE SET provider = EXCLUDED.provider, title = EXCLUDED.title,
+			html = EXCLUDED.html, failed = EXCLUDED.failed, fetched_at = NOW(), expires_at = EXCLUDED.expires_at`,
+		e.URL, e.Provider, e.Title, e.HTML, e.Failed, e.Expires)
+	if err != nil {
+		return fmt.Errorf("save embed: %w", err)
+	}
+	return nil
+}
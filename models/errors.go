@@ -0,0 +1,13 @@
+package models
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when a lookup matches no record.
+	ErrNotFound = errors.New("models: resource could not be found")
+	// ErrTokenExpired is returned when a single-use token is past its expiry.
+	ErrTokenExpired = errors.New("models: token has expired")
+	// ErrVersionConflict is returned when a record is saved from a version
+	// older than the one stored, because someone else saved it in between.
+	ErrVersionConflict = errors.New("models: record was changed by someone else")
+)
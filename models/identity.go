@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OIDCLoginStateDuration is how long a user has to complete sign-in at the
+// identity provider.
+const OIDCLoginStateDuration = 10 * time.Minute
+
+// ErrIdentityLinked is returned when an external identity already belongs to
+// a user, or the user already has an identity from that provider.
+var ErrIdentityLinked = errors.New("models: identity already linked")
+
+// LinkedIdentity is an external OpenID Connect identity attached to a local
+// user.
+type LinkedIdentity struct {
+	ID          int
+	UserID      int
+	Provider    string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
+
+// OIDCLoginState is an authorization request waiting for its callback.
+type OIDCLoginState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	// LinkUserID is set when a signed-in user is linking an identity.
+	LinkUserID *int
+}
+
+type IdentityService struct {
+	DB *sql.DB
+}
+
+// UserFor returns the local user linked to the provider's subject and records
+// the sign-in.
+func (is *IdentityService) UserFor(provider, subject string) (*User, error) {
+	var user User
+	err := is.DB.QueryRow(`
+		UPDATE user_identities i SET last_login_at = NOW()
+		FROM users u
+		WHERE u.user_id = i.user_id AND i.provider = $1 AND i.subject = $2
+		RETURNING u.user_id, u.email, u.username, u.role_id, u.email_verified`,
+		provider, subject).Scan(&user.UserID, &user.Email, &user.Username, &user.Role, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("identity user: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("identity user: %w", err)
+	}
+	return &user, nil
+}
+
+// Link attaches an external identity to userID.
+func (is *IdentityService) Link(userID int, provider, subject, email string) error {
+	_, err := is.DB.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())`, userID, provider, subject, email)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return fmt.Errorf("link identity: %w", ErrIdentityLinked)
+		}
+		return fmt.Errorf("link identity: %w", err)
+	}
+	return nil
+}
+
+// Unlink removes one of userID's linked identities.
+func (is *IdentityService) Unlink(userID, identityID int) error {
+	result, err := is.DB.Exec(`DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, identityID, userID)
+	if err != nil {
+		return fmt.Errorf("unlink identity: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("unlink identity: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// ListForUser returns userID's linked identities.
+func (is *IdentityService) ListForUser(userID int) ([]*LinkedIdentity, error) {
+	rows, err := is.DB.Query(`
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY provider`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []*LinkedIdentity
+	for rows.Next() {
+		var li LinkedIdentity
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&li.ID, &li.UserID, &li.Provider, &li.Subject, &li.Email, &li.CreatedAt, &lastLogin); err != nil {
+			return nil, fmt.Errorf("list identities: %w", err)
+		}
+		if lastLogin.Valid {
+			li.LastLoginAt = &lastLogin.Time
+		}
+		identities = append(identities, &li)
+	}
+	return identities, rows.Err()
+}
+
+// CreateLoginState stores an authorization request and returns the token to
+// send as the OAuth2 state parameter.
+func (is *IdentityService) CreateLoginState(state OIDCLoginState) (string, error) {
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return "", fmt.Errorf("create login state: %w", err)
+	}
+	_, err = is.DB.Exec(`
+		INSERT INTO oidc_login_states (token_hash, provider, nonce, code_verifier, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		tokenHash, state.Provider, state.Nonce, state.CodeVerifier, state.LinkUserID,
+		time.Now().UTC().Add(OIDCLoginStateDuration))
+	if err != nil {
+		return "", fmt.Errorf("create login state: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumeLoginState returns and deletes the authorization request identified
+// by token, so each state can only be redeemed once.
+func (is *IdentityService) ConsumeLoginState(token string) (*OIDCLoginState, error) {
+	var state OIDCLoginState
+	var linkUserID sql.NullInt64
+	var expiresAt time.Time
+	err := is.DB.QueryRow(`
+		DELETE FROM oidc_login_states WHERE token_hash = $1
+		RETURNING provider, nonce, code_verifier, link_user_id, expires_at`, hashLookupToken(token)).Scan(
+		&state.Provider, &state.Nonce, &state.CodeVerifier, &linkUserID, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("consume login state: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("consume login state: %w", err)
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return nil, fmt.Errorf("consume login state: %w", ErrTokenExpired)
+	}
+	if linkUserID.Valid {
+		id := int(linkUserID.Int64)
+		state.LinkUserID = &id
+	}
+	return &state, nil
+}
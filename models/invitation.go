@@ -0,0 +1,310 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Invitation statuses, as reported by Invitation.Status.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationExpired  = "expired"
+	InvitationRevoked  = "revoked"
+)
+
+// InvitationDuration is how long an invitation link stays valid.
+const InvitationDuration = 72 * time.Hour
+
+var (
+	// ErrInvitationClosed is returned for invitations that were already
+	// accepted or have been revoked.
+	ErrInvitationClosed = errors.New("models: invitation is no longer open")
+	// ErrEmailTaken is returned when inviting or creating an account for an
+	// address that is already registered.
+	ErrEmailTaken = errors.New("models: email address is already registered")
+)
+
+// Invitation is an administrator's offer of an account with a pre-assigned
+// role. The account is only created when the invitee accepts.
+type Invitation struct {
+	ID        int
+	Email     string
+	RoleID    int
+	RoleName  string
+	InvitedBy string
+	// Token is only set when an invitation is created or resent; the
+	// database only stores its hash.
+	Token      string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	SentCount  int
+	LastSentAt time.Time
+	AcceptedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+// Status reports whether the invitation is pending, accepted, expired or
+// revoked.
+func (inv *Invitation) Status() string {
+	switch {
+	case inv.AcceptedAt.Valid:
+		return InvitationAccepted
+	case inv.RevokedAt.Valid:
+		return InvitationRevoked
+	case time.Now().After(inv.ExpiresAt):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+// Open reports whether the invitation can still be resent or revoked.
+func (inv *Invitation) Open() bool {
+	return !inv.AcceptedAt.Valid && !inv.RevokedAt.Valid
+}
+
+type InvitationService struct {
+	DB *sql.DB
+	// Duration is how long an invitation link is valid. Defaults to
+	// InvitationDuration when zero.
+	Duration time.Duration
+}
+
+func (is *InvitationService) duration() time.Duration {
+	if is.Duration <= 0 {
+		return InvitationDuration
+	}
+	return is.Duration
+}
+
+const invitationSelect = `
+	SELECT i.invitation_id, i.email, i.role_id, COALESCE(r.role_name, ''), COALESCE(u.username, ''),
+	       i.created_at, i.expires_at, i.sent_count, i.last_sent_at, i.accepted_at, i.revoked_at
+	FROM invitations i
+	LEFT JOIN roles r ON r.role_id = i.role_id
+	LEFT JOIN users u ON u.user_id = i.invited_by`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (*Invitation, error) {
+	var inv Invitation
+	err := row.Scan(&inv.ID, &inv.Email, &inv.RoleID, &inv.RoleName, &inv.InvitedBy,
+		&inv.CreatedAt, &inv.ExpiresAt, &inv.SentCount, &inv.LastSentAt, &inv.AcceptedAt, &inv.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Create invites email to join with roleID. Earlier open invitations for the
+// same address are revoked so only the newest link works.
+func (is *InvitationService) Create(email string, roleID, invitedBy int) (*Invitation, error) {
+	email = NormalizeEmail(email)
+
+	var exists bool
+	err := is.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("create invitation: %w", ErrEmailTaken)
+	}
+
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	tx, err := is.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE LOWER(email) = $1 AND accepted_at IS NULL AND revoked_at IS NULL`, email)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	var inviter interface{}
+	if invitedBy > 0 {
+		inviter = invitedBy
+	}
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO invitations (email, role_id, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING invitation_id`,
+		email, roleID, tokenHash, inviter, time.Now().UTC().Add(is.duration())).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	inv, err := scanInvitation(tx.QueryRow(invitationSelect+` WHERE i.invitation_id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	inv.Token = token
+	return inv, nil
+}
+
+// Resend issues a fresh link for an open invitation and restarts its expiry.
+// The previous link stops working.
+func (is *InvitationService) Resend(id int) (*Invitation, error) {
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return nil, fmt.Errorf("resend invitation: %w", err)
+	}
+	result, err := is.DB.Exec(`
+		UPDATE invitations
+		SET token_hash = $2, expires_at = $3, sent_count = sent_count + 1, last_sent_at = NOW()
+		WHERE invitation_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL`,
+		id, tokenHash, time.Now().UTC().Add(is.duration()))
+	if err != nil {
+		return nil, fmt.Errorf("resend invitation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("resend invitation: %w", ErrInvitationClosed)
+	}
+	inv, err := is.ByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("resend invitation: %w", err)
+	}
+	inv.Token = token
+	return inv, nil
+}
+
+// Revoke cancels an open invitation.
+func (is *InvitationService) Revoke(id int) error {
+	result, err := is.DB.Exec(`
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE invitation_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("revoke invitation: %w", ErrInvitationClosed)
+	}
+	return nil
+}
+
+// ByID returns a single invitation.
+func (is *InvitationService) ByID(id int) (*Invitation, error) {
+	inv, err := scanInvitation(is.DB.QueryRow(invitationSelect+` WHERE i.invitation_id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("invitation by id: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("invitation by id: %w", err)
+	}
+	return inv, nil
+}
+
+// Recent lists the newest invitations first.
+func (is *InvitationService) Recent(limit int) ([]*Invitation, error) {
+	rows, err := is.DB.Query(invitationSelect+` ORDER BY i.created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("recent invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("recent invitations: %w", err)
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// Lookup returns the pending invitation for token without accepting it, so
+// the acceptance form can be shown.
+func (is *InvitationService) Lookup(token string) (*Invitation, error) {
+	inv, err := is.find(is.DB, token, false)
+	if err != nil {
+		return nil, fmt.Errorf("lookup invitation: %w", err)
+	}
+	return inv, nil
+}
+
+// Accept creates the invited account with the chosen username and password
+// and marks the invitation accepted. The address counts as verified because
+// the invitee received the link there.
+func (is *InvitationService) Accept(token, username, password string) (*User, error) {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	tx, err := is.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	inv, err := is.find(tx, token, true)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	user := User{
+		Email:         NormalizeEmail(inv.Email),
+		Username:      username,
+		PasswordHash:  string(hashedBytes),
+		Role:          inv.RoleID,
+		EmailVerified: true,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO users (email, username, password, role_id, registration_date, email_verified)
+		VALUES ($1, $2, $3, $4, $5, true) RETURNING user_id`,
+		user.Email, user.Username, user.PasswordHash, user.Role, time.Now().UTC()).Scan(&user.UserID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, fmt.Errorf("accept invitation: %w", ErrEmailTaken)
+		}
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE invitations SET accepted_at = NOW(), accepted_user_id = $2
+		WHERE invitation_id = $1`, inv.ID, user.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	return &user, nil
+}
+
+// find loads the invitation for token and checks that it is still pending.
+// With forUpdate the row is locked until the transaction ends.
+func (is *InvitationService) find(q queryRower, token string, forUpdate bool) (*Invitation, error) {
+	query := invitationSelect + ` WHERE i.token_hash = $1`
+	if forUpdate {
+		query += ` FOR UPDATE OF i`
+	}
+	inv, err := scanInvitation(q.QueryRow(query, hashLookupToken(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	switch inv.Status() {
+	case InvitationAccepted, InvitationRevoked:
+		return nil, ErrInvitationClosed
+	case InvitationExpired:
+		return nil, ErrTokenExpired
+	}
+	return inv, nil
+}
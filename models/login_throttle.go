@@ -0,0 +1,256 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LoginPolicy controls how failed password sign-ins are slowed down and
+// locked out. Failures are counted per account (email) and per client IP.
+type LoginPolicy struct {
+	// Window is how far back failures are counted.
+	Window time.Duration
+	// AccountFreeAttempts and IPFreeAttempts are the failures allowed before
+	// progressive delays start.
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+	// BaseDelay doubles with each further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAccountFailures locks the account for LockoutDuration.
+	MaxAccountFailures int
+	// MaxIPFailures blocks the IP, for every account, for LockoutDuration.
+	MaxIPFailures   int
+	LockoutDuration time.Duration
+}
+
+// DefaultLoginPolicy is used by NewLoginThrottleService.
+var DefaultLoginPolicy = LoginPolicy{
+	Window:              15 * time.Minute,
+	AccountFreeAttempts: 3,
+	IPFreeAttempts:      10,
+	BaseDelay:           2 * time.Second,
+	MaxDelay:            time.Minute,
+	MaxAccountFailures:  10,
+	MaxIPFailures:       50,
+	LockoutDuration:     15 * time.Minute,
+}
+
+// Delay returns the wait imposed after a run of failures, where the first
+// free failures carry no delay.
+func (p LoginPolicy) Delay(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := free + 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// LoginCheck is the outcome of LoginThrottleService.Check.
+type LoginCheck struct {
+	// LockedUntil is set while the account is locked.
+	LockedUntil time.Time
+	// RetryAfter is how long the client must wait before another attempt.
+	RetryAfter time.Duration
+}
+
+// Allowed reports whether a password attempt may be made now.
+func (c LoginCheck) Allowed() bool {
+	return c.LockedUntil.IsZero() && c.RetryAfter <= 0
+}
+
+// LockedAccount is a user whose password sign-in is currently locked.
+type LockedAccount struct {
+	UserID      int
+	Username    string
+	Email       string
+	LockedUntil time.Time
+}
+
+// LoginThrottleService tracks failed password sign-ins and applies the
+// policy. Lockouts only block password sign-in; passkeys and linked accounts
+// still work, and a password reset clears the lock.
+type LoginThrottleService struct {
+	DB     *sql.DB
+	Policy LoginPolicy
+	Events *SecurityEventService
+}
+
+func NewLoginThrottleService(db *sql.DB) *LoginThrottleService {
+	return &LoginThrottleService{
+		DB:     db,
+		Policy: DefaultLoginPolicy,
+		Events: &SecurityEventService{DB: db},
+	}
+}
+
+// Check reports whether a password attempt for email from ip may proceed.
+func (lts *LoginThrottleService) Check(email, ip string) (LoginCheck, error) {
+	email = NormalizeEmail(email)
+	var check LoginCheck
+
+	var lockedUntil sql.NullTime
+	err := lts.DB.QueryRow(`SELECT locked_until FROM users WHERE email = $1`, email).Scan(&lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return check, fmt.Errorf("check login: %w", err)
+	}
+	now := time.Now()
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		check.LockedUntil = lockedUntil.Time
+		return check, nil
+	}
+
+	accountFailures, lastAccount, err := lts.recentFailures("email", email)
+	if err != nil {
+		return check, err
+	}
+	ipFailures, lastIP, err := lts.recentFailures("ip_address", ip)
+	if err != nil {
+		return check, err
+	}
+
+	if ipFailures >= lts.Policy.MaxIPFailures {
+		check.RetryAfter = lastIP.Add(lts.Policy.LockoutDuration).Sub(now)
+		return check, nil
+	}
+	if wait := lastAccount.Add(lts.Policy.Delay(accountFailures, lts.Policy.AccountFreeAttempts)).Sub(now); wait > check.RetryAfter {
+		check.RetryAfter = wait
+	}
+	if wait := lastIP.Add(lts.Policy.Delay(ipFailures, lts.Policy.IPFreeAttempts)).Sub(now); wait > check.RetryAfter {
+		check.RetryAfter = wait
+	}
+	return check, nil
+}
+
+// recentFailures counts failures in the policy window where column matches
+// value, and returns when the latest one happened.
+func (lts *LoginThrottleService) recentFailures(column, value string) (int, time.Time, error) {
+	var count int
+	var last sql.NullTime
+	err := lts.DB.QueryRow(`
+		SELECT COUNT(*), MAX(attempted_at) FROM login_failures
+		WHERE `+column+` = $1 AND attempted_at > $2`,
+		value, time.Now().Add(-lts.Policy.Window)).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("count login failures: %w", err)
+	}
+	return count, last.Time, nil
+}
+
+// RecordFailure stores a failed attempt. When it pushes the account over the
+// limit, the account is locked, a security event recorded, and the locked
+// user returned so the owner can be notified; otherwise the user is nil.
+func (lts *LoginThrottleService) RecordFailure(email, ip string) (*User, error) {
+	email = NormalizeEmail(email)
+	_, err := lts.DB.Exec(`INSERT INTO login_failures (email, ip_address) VALUES ($1, $2)`, email, ip)
+	if err != nil {
+		return nil, fmt.Errorf("record login failure: %w", err)
+	}
+
+	ipFailures, _, err := lts.recentFailures("ip_address", ip)
+	if err != nil {
+		return nil, err
+	}
+	if ipFailures == lts.Policy.MaxIPFailures {
+		detail := fmt.Sprintf("%d failed sign-ins within %s", ipFailures, lts.Policy.Window)
+		if err := lts.Events.Record(0, SecurityEventIPBlocked, ip, detail); err != nil {
+			return nil, err
+		}
+	}
+
+	accountFailures, _, err := lts.recentFailures("email", email)
+	if err != nil {
+		return nil, err
+	}
+	if accountFailures < lts.Policy.MaxAccountFailures {
+		return nil, nil
+	}
+
+	var user User
+	err = lts.DB.QueryRow(`
+		UPDATE users SET locked_until = $2
+		WHERE email = $1 AND (locked_until IS NULL OR locked_until < NOW())
+		RETURNING user_id, username, email, role_id`,
+		email, time.Now().Add(lts.Policy.LockoutDuration)).Scan(&user.UserID, &user.Username, &user.Email, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Unknown email, or already locked.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("lock account: %w", err)
+	}
+	// The count starts over once the lock expires.
+	if _, err := lts.DB.Exec(`DELETE FROM login_failures WHERE email = $1`, email); err != nil {
+		return nil, fmt.Errorf("lock account: %w", err)
+	}
+	detail := fmt.Sprintf("%d failed sign-ins within %s; locked for %s",
+		accountFailures, lts.Policy.Window, lts.Policy.LockoutDuration)
+	if err := lts.Events.Record(user.UserID, SecurityEventAccountLocked, ip, detail); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RecordSuccess clears the failure count for email.
+func (lts *LoginThrottleService) RecordSuccess(email string) error {
+	_, err := lts.DB.Exec(`DELETE FROM login_failures WHERE email = $1`, NormalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("record login success: %w", err)
+	}
+	return nil
+}
+
+// Unlock lifts a lockout on userID and clears its failure count. reason is
+// recorded with the security event.
+func (lts *LoginThrottleService) Unlock(userID int, ip, reason string) error {
+	var email string
+	var lockedUntil sql.NullTime
+	err := lts.DB.QueryRow(`
+		UPDATE users u SET locked_until = NULL
+		FROM (SELECT user_id, locked_until FROM users WHERE user_id = $1) prev
+		WHERE u.user_id = prev.user_id
+		RETURNING u.email, prev.locked_until`, userID).Scan(&email, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("unlock account: %w", ErrNotFound)
+		}
+		return fmt.Errorf("unlock account: %w", err)
+	}
+	if _, err := lts.DB.Exec(`DELETE FROM login_failures WHERE email = $1`, email); err != nil {
+		return fmt.Errorf("unlock account: %w", err)
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return lts.Events.Record(userID, SecurityEventAccountUnlocked, ip, reason)
+	}
+	return nil
+}
+
+// LockedAccounts returns accounts that are locked right now.
+func (lts *LoginThrottleService) LockedAccounts() ([]*LockedAccount, error) {
+	rows, err := lts.DB.Query(`
+		SELECT user_id, username, email, locked_until FROM users
+		WHERE locked_until > NOW()
+		ORDER BY locked_until`)
+	if err != nil {
+		return nil, fmt.Errorf("locked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []*LockedAccount
+	for rows.Next() {
+		var a LockedAccount
+		if err := rows.Scan(&a.UserID, &a.Username, &a.Email, &a.LockedUntil); err != nil {
+			return nil, fmt.Errorf("locked accounts: %w", err)
+		}
+		accounts = append(accounts, &a)
+	}
+	return accounts, rows.Err()
+}
@@ -0,0 +1,357 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	passkeyPurposeRegistration = "registration"
+	passkeyPurposeLogin        = "login"
+
+	// PasskeyCeremonyDuration bounds how long a browser has to answer a
+	// registration or sign-in challenge.
+	PasskeyCeremonyDuration = 5 * time.Minute
+)
+
+// Passkey is a registered WebAuthn credential as shown to its owner.
+type Passkey struct {
+	ID         int
+	UserID     int
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// PasskeyConfig describes the relying party passkeys are bound to.
+type PasskeyConfig struct {
+	// RPID is the site's registrable domain, e.g. "anshumanbiswas.com".
+	RPID string
+	// RPName is shown by the browser during registration.
+	RPName string
+	// Origins are the full origins allowed to complete ceremonies, e.g.
+	// "https://anshumanbiswas.com".
+	Origins []string
+}
+
+// PasskeyService stores WebAuthn credentials and runs the registration and
+// sign-in ceremonies. It sits beside UserService.Authenticate: a successful
+// passkey sign-in yields a *User just as a password check does.
+type PasskeyService struct {
+	DB       *sql.DB
+	WebAuthn *webauthn.WebAuthn
+}
+
+func NewPasskeyService(db *sql.DB, cfg PasskeyConfig) (*PasskeyService, error) {
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.Origins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("passkey config: %w", err)
+	}
+	return &PasskeyService{DB: db, WebAuthn: wa}, nil
+}
+
+// passkeyUser adapts a User and their credentials to webauthn.User.
+type passkeyUser struct {
+	user        *User
+	credentials []webauthn.Credential
+}
+
+func (pu *passkeyUser) WebAuthnID() []byte                         { return passkeyUserHandle(pu.user.UserID) }
+func (pu *passkeyUser) WebAuthnName() string                       { return pu.user.Email }
+func (pu *passkeyUser) WebAuthnDisplayName() string                { return pu.user.Username }
+func (pu *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return pu.credentials }
+func (pu *passkeyUser) WebAuthnIcon() string                       { return "" }
+
+// passkeyUserHandle is the opaque user handle stored on the authenticator.
+func passkeyUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+// List returns the passkeys registered by userID, newest first.
+func (ps *PasskeyService) List(userID int) ([]*Passkey, error) {
+	rows, err := ps.DB.Query(`
+		SELECT id, user_id, name, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var passkeys []*Passkey
+	for rows.Next() {
+		var pk Passkey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&pk.ID, &pk.UserID, &pk.Name, &pk.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("list passkeys: %w", err)
+		}
+		if lastUsed.Valid {
+			pk.LastUsedAt = &lastUsed.Time
+		}
+		passkeys = append(passkeys, &pk)
+	}
+	return passkeys, rows.Err()
+}
+
+// Delete removes one of userID's passkeys.
+func (ps *PasskeyService) Delete(userID, passkeyID int) error {
+	result, err := ps.DB.Exec(`DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, passkeyID, userID)
+	if err != nil {
+		return fmt.Errorf("delete passkey: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("delete passkey: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// BeginRegistration starts a registration ceremony for user. The returned
+// options are passed to navigator.credentials.create(); the token identifies
+// the ceremony when it is finished.
+func (ps *PasskeyService) BeginRegistration(user *User) (*protocol.CredentialCreation, string, error) {
+	pu, err := ps.loadUser(user)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin passkey registration: %w", err)
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.credentials))
+	for _, cred := range pu.credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	creation, session, err := ps.WebAuthn.BeginRegistration(pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin passkey registration: %w", err)
+	}
+
+	token, err := ps.saveCeremony(&user.UserID, passkeyPurposeRegistration, session)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin passkey registration: %w", err)
+	}
+	return creation, token, nil
+}
+
+// FinishRegistration verifies the browser's attestation response in r and
+// stores the new credential under name.
+func (ps *PasskeyService) FinishRegistration(user *User, token, name string, r *http.Request) (*Passkey, error) {
+	session, ceremonyUserID, err := ps.takeCeremony(token, passkeyPurposeRegistration)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey registration: %w", err)
+	}
+	if ceremonyUserID == nil || *ceremonyUserID != user.UserID {
+		return nil, fmt.Errorf("finish passkey registration: %w", ErrNotFound)
+	}
+
+	pu, err := ps.loadUser(user)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey registration: %w", err)
+	}
+	cred, err := ps.WebAuthn.FinishRegistration(pu, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey registration: %w", err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+
+	pk := Passkey{UserID: user.UserID, Name: name}
+	err = ps.DB.QueryRow(`
+		INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type,
+			transports, aaguid, sign_count, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`,
+		user.UserID, name, cred.ID, cred.PublicKey, cred.AttestationType,
+		strings.Join(transports, ","), cred.Authenticator.AAGUID, int64(cred.Authenticator.SignCount),
+		cred.Flags.BackupEligible, cred.Flags.BackupState,
+	).Scan(&pk.ID, &pk.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey registration: %w", err)
+	}
+	return &pk, nil
+}
+
+// BeginLogin starts a discoverable (username-less) sign-in ceremony. The
+// returned options are passed to navigator.credentials.get().
+func (ps *PasskeyService) BeginLogin() (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := ps.WebAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin passkey login: %w", err)
+	}
+	token, err := ps.saveCeremony(nil, passkeyPurposeLogin, session)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin passkey login: %w", err)
+	}
+	return assertion, token, nil
+}
+
+// FinishLogin verifies the browser's assertion response in r and returns the
+// credential's owner.
+func (ps *PasskeyService) FinishLogin(token string, r *http.Request) (*User, error) {
+	session, _, err := ps.takeCeremony(token, passkeyPurposeLogin)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey login: %w", err)
+	}
+
+	var owner *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err := ps.userByCredential(rawID)
+		if err != nil {
+			return nil, err
+		}
+		if string(userHandle) != string(passkeyUserHandle(user.UserID)) {
+			return nil, errors.New("user handle does not match credential owner")
+		}
+		owner, err = ps.loadUser(user)
+		return owner, err
+	}
+
+	cred, err := ps.WebAuthn.FinishDiscoverableLogin(handler, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey login: %w", err)
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, fmt.Errorf("finish passkey login: signature counter went backwards for user %d", owner.user.UserID)
+	}
+
+	_, err = ps.DB.Exec(`
+		UPDATE webauthn_credentials
+		SET sign_count = $1, backup_state = $2, last_used_at = NOW()
+		WHERE credential_id = $3`,
+		int64(cred.Authenticator.SignCount), cred.Flags.BackupState, cred.ID)
+	if err != nil {
+		return nil, fmt.Errorf("finish passkey login: %w", err)
+	}
+	return owner.user, nil
+}
+
+func (ps *PasskeyService) userByCredential(credentialID []byte) (*User, error) {
+	var user User
+	err := ps.DB.QueryRow(`
+		SELECT u.user_id, u.email, u.username, u.role_id, u.email_verified
+		FROM webauthn_credentials c
+		JOIN users u ON u.user_id = c.user_id
+		WHERE c.credential_id = $1`, credentialID).Scan(
+		&user.UserID, &user.Email, &user.Username, &user.Role, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (ps *PasskeyService) loadUser(user *User) (*passkeyUser, error) {
+	rows, err := ps.DB.Query(`
+		SELECT credential_id, public_key, attestation_type, transports, aaguid,
+			sign_count, backup_eligible, backup_state
+		FROM webauthn_credentials
+		WHERE user_id = $1`, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pu := &passkeyUser{user: user}
+	for rows.Next() {
+		var cred webauthn.Credential
+		var transports string
+		var signCount int64
+		err := rows.Scan(&cred.ID, &cred.PublicKey, &cred.AttestationType, &transports,
+			&cred.Authenticator.AAGUID, &signCount, &cred.Flags.BackupEligible, &cred.Flags.BackupState)
+		if err != nil {
+			return nil, err
+		}
+		cred.Authenticator.SignCount = uint32(signCount)
+		for _, t := range strings.Split(transports, ",") {
+			if t != "" {
+				cred.Transport = append(cred.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		pu.credentials = append(pu.credentials, cred)
+	}
+	return pu, rows.Err()
+}
+
+// saveCeremony stores a new pending ceremony, first purging expired ones
+// that browsers started but never finished.
+func (ps *PasskeyService) saveCeremony(userID *int, purpose string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ps.DB.Exec(`DELETE FROM webauthn_ceremonies WHERE expires_at < NOW()`); err != nil {
+		return "", fmt.Errorf("purge expired passkey ceremonies: %w", err)
+	}
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = ps.DB.Exec(`
+		INSERT INTO webauthn_ceremonies (user_id, purpose, token_hash, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		userID, purpose, tokenHash, string(data), time.Now().UTC().Add(PasskeyCeremonyDuration))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// takeCeremony consumes a pending ceremony so each challenge can only be
+// answered once.
+func (ps *PasskeyService) takeCeremony(token, purpose string) (*webauthn.SessionData, *int, error) {
+	var data string
+	var userID sql.NullInt64
+	var expiresAt time.Time
+	err := ps.DB.QueryRow(`
+		DELETE FROM webauthn_ceremonies
+		WHERE token_hash = $1 AND purpose = $2
+		RETURNING user_id, session_data, expires_at`, hashLookupToken(token), purpose).Scan(&userID, &data, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, nil, err
+	}
+	if !userID.Valid {
+		return &session, nil, nil
+	}
+	id := int(userID.Int64)
+	return &session, &id, nil
+}
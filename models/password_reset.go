@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultResetDuration is how long a password reset link stays valid.
+const DefaultResetDuration = 1 * time.Hour
+
+type PasswordReset struct {
+	ID     int
+	UserID int
+	// Token is only set when a reset is created; the database only stores
+	// TokenHash.
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type PasswordResetService struct {
+	DB *sql.DB
+	// Duration is how long a reset token is valid. Defaults to
+	// DefaultResetDuration when zero.
+	Duration time.Duration
+}
+
+func (prs *PasswordResetService) duration() time.Duration {
+	if prs.Duration <= 0 {
+		return DefaultResetDuration
+	}
+	return prs.Duration
+}
+
+// Create issues a reset token for the account registered to email, replacing
+// any outstanding token for that user. It returns ErrNotFound for unknown
+// addresses so callers can avoid revealing which emails are registered.
+func (prs *PasswordResetService) Create(email string) (*PasswordReset, error) {
+	email = NormalizeEmail(email)
+
+	var userID int
+	err := prs.DB.QueryRow(`SELECT user_id FROM users WHERE email = $1`, email).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("create password reset: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("create password reset: %w", err)
+	}
+
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return nil, fmt.Errorf("create password reset: %w", err)
+	}
+
+	reset := PasswordReset{
+		UserID:    userID,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().UTC().Add(prs.duration()),
+	}
+	err = prs.DB.QueryRow(`
+		INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, created_at = NOW()
+		RETURNING id`, reset.UserID, reset.TokenHash, reset.ExpiresAt).Scan(&reset.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create password reset: %w", err)
+	}
+	return &reset, nil
+}
+
+// Lookup returns the user a still-valid reset token belongs to without
+// consuming it, so the reset form can be shown.
+func (prs *PasswordResetService) Lookup(token string) (*User, error) {
+	user, _, err := prs.find(prs.DB, token)
+	return user, err
+}
+
+// Consume validates token, deletes it so it cannot be reused, and sets the
+// user's password to newPassword.
+func (prs *PasswordResetService) Consume(token, newPassword string) (*User, error) {
+	tx, err := prs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("consume password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	user, resetID, err := prs.find(tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE id = $1`, resetID); err != nil {
+		return nil, fmt.Errorf("consume password reset: %w", err)
+	}
+
+	passwordHash, err := hashPassword(newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("consume password reset: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE users SET password = $1, password_reset_required = false WHERE user_id = $2`, passwordHash, user.UserID); err != nil {
+		return nil, fmt.Errorf("consume password reset: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("consume password reset: %w", err)
+	}
+	return user, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (prs *PasswordResetService) find(q queryRower, token string) (*User, int, error) {
+	var user User
+	var resetID int
+	var expiresAt time.Time
+	err := q.QueryRow(`
+		SELECT pr.id, pr.expires_at, u.user_id, u.email, u.username, u.role_id
+		FROM password_resets pr
+		JOIN users u ON u.user_id = pr.user_id
+		WHERE pr.token_hash = $1`, hashLookupToken(token)).Scan(
+		&resetID, &expiresAt, &user.UserID, &user.Email, &user.Username, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, fmt.Errorf("password reset: %w", ErrNotFound)
+		}
+		return nil, 0, fmt.Errorf("password reset: %w", err)
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return nil, 0, fmt.Errorf("password reset: %w", ErrTokenExpired)
+	}
+	return &user, resetID, nil
+}
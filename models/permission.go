@@ -0,0 +1,207 @@
+package models
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Permission names as stored in role_permissions.
+const (
+	PermComment         = "comment"
+	PermViewUnpublished = "view_unpublished"
+	PermEditPosts       = "edit_posts"
+	PermPublishPosts    = "publish_posts"
+	PermManageAllPosts  = "manage_all_posts"
+	PermManageUsers     = "manage_users"
+	PermViewAdmin       = "view_admin"
+	PermManageSecurity  = "manage_security"
+	PermRawHTML         = "raw_html"
+)
+
+// PermissionInfo describes a permission for the role editor.
+type PermissionInfo struct {
+	Name        string
+	Label       string
+	Description string
+}
+
+// AllPermissions lists every permission in display order.
+var AllPermissions = []PermissionInfo{
+	{PermComment, "Comment", "Leave comments on posts"},
+	{PermViewUnpublished, "View drafts", "Read unpublished posts"},
+	{PermEditPosts, "Write posts", "Create posts and edit their own drafts"},
+	{PermPublishPosts, "Publish", "Publish posts and edit published ones"},
+	{PermManageAllPosts, "Manage all posts", "Edit posts written by anyone"},
+	{PermManageUsers, "Manage users", "Manage users and roles"},
+	{PermViewAdmin, "Admin area", "Categories, slides and other admin pages"},
+	{PermManageSecurity, "Security", "Require two-factor sign-in, reset second factors and lift lockouts"},
+	{PermRawHTML, "Raw HTML", "Publish posts whose HTML, including scripts, is not sanitized"},
+}
+
+// Has reports whether the named permission is granted.
+func (p UserPermissions) Has(name string) bool {
+	switch name {
+	case PermComment:
+		return p.CanComment
+	case PermViewUnpublished:
+		return p.CanViewUnpublished
+	case PermEditPosts:
+		return p.CanEditPosts
+	case PermPublishPosts:
+		return p.CanPublishPosts
+	case PermManageAllPosts:
+		return p.CanManageAllPosts
+	case PermManageUsers:
+		return p.CanManageUsers
+	case PermViewAdmin:
+		return p.CanViewAdmin
+	case PermManageSecurity:
+		return p.CanManageSecurity
+	case PermRawHTML:
+		return p.CanPostRawHTML
+	}
+	return false
+}
+
+// Names returns the granted permissions in AllPermissions order.
+func (p UserPermissions) Names() []string {
+	var names []string
+	for _, info := range AllPermissions {
+		if p.Has(info.Name) {
+			names = append(names, info.Name)
+		}
+	}
+	return names
+}
+
+// PermissionsFromNames builds a permission set from names, ignoring unknown
+// ones.
+func PermissionsFromNames(names []string) UserPermissions {
+	var p UserPermissions
+	for _, name := range names {
+		switch name {
+		case PermComment:
+			p.CanComment = true
+		case PermViewUnpublished:
+			p.CanViewUnpublished = true
+		case PermEditPosts:
+			p.CanEditPosts = true
+		case PermPublishPosts:
+			p.CanPublishPosts = true
+		case PermManageAllPosts:
+			p.CanManageAllPosts = true
+		case PermManageUsers:
+			p.CanManageUsers = true
+		case PermViewAdmin:
+			p.CanViewAdmin = true
+		case PermManageSecurity:
+			p.CanManageSecurity = true
+		case PermRawHTML:
+			p.CanPostRawHTML = true
+		}
+	}
+	return p
+}
+
+// PermissionCacheTTL is how long cached permissions are used before they
+// are read again, which bounds how long a role change made on another
+// instance of the site takes to apply on this one.
+const PermissionCacheTTL = 30 * time.Second
+
+// permissionCache holds the role_permissions table in memory. RoleService
+// replaces it on startup and after every role change, and reloads it once
+// it is PermissionCacheTTL old; until it is first loaded the built-in
+// defaults apply.
+var permissionCache struct {
+	sync.RWMutex
+	byRole   map[int]UserPermissions
+	loadedAt time.Time
+	// reload reads the permissions again; nil when they were set directly.
+	reload    func() (map[int]UserPermissions, error)
+	reloading bool
+}
+
+// GetPermissions returns the permissions for a given role
+func GetPermissions(roleID int) UserPermissions {
+	reloadStalePermissions()
+	permissionCache.RLock()
+	p, ok := permissionCache.byRole[roleID]
+	permissionCache.RUnlock()
+	if ok {
+		return p
+	}
+	return DefaultPermissions(roleID)
+}
+
+// reloadStalePermissions reloads the cache when it is older than
+// PermissionCacheTTL. Only one caller reloads; the others keep using the
+// cached permissions meanwhile. When the reload fails, they are kept for
+// another PermissionCacheTTL.
+func reloadStalePermissions() {
+	permissionCache.RLock()
+	stale := permissionCache.reload != nil && !permissionCache.reloading &&
+		time.Since(permissionCache.loadedAt) >= PermissionCacheTTL
+	permissionCache.RUnlock()
+	if !stale {
+		return
+	}
+
+	permissionCache.Lock()
+	reload := permissionCache.reload
+	if reload == nil || permissionCache.reloading {
+		permissionCache.Unlock()
+		return
+	}
+	permissionCache.reloading = true
+	permissionCache.Unlock()
+
+	byRole, err := reload()
+	permissionCache.Lock()
+	if err != nil {
+		log.Printf("Error reloading permissions: %v", err)
+	} else {
+		permissionCache.byRole = byRole
+	}
+	permissionCache.loadedAt = time.Now()
+	permissionCache.reloading = false
+	permissionCache.Unlock()
+}
+
+// SetPermissionCache replaces the cached permissions for all roles. Roles
+// missing from byRole fall back to DefaultPermissions. Permissions set this
+// way are kept until replaced.
+func SetPermissionCache(byRole map[int]UserPermissions) {
+	storePermissions(byRole, nil)
+}
+
+func storePermissions(byRole map[int]UserPermissions, reload func() (map[int]UserPermissions, error)) {
+	permissionCache.Lock()
+	permissionCache.byRole = byRole
+	permissionCache.loadedAt = time.Now()
+	permissionCache.reload = reload
+	permissionCache.Unlock()
+}
+
+// TrustsHTML reports whether content user saves to a post written by
+// authorID skips the HTML sanitizer: only when user is the author and their
+// role has CanPostRawHTML, so an edit by someone else never puts the
+// author's raw HTML live. Saves without a user, such as through the legacy
+// API token, are never trusted.
+func TrustsHTML(user *User, authorID int) bool {
+	return user != nil && user.UserID == authorID && GetPermissions(user.Role).CanPostRawHTML
+}
+
+// CanEditPost reports whether user may open post in the editor and save it.
+// Without CanManageAllPosts only the author's own posts are editable, and
+// without CanPublishPosts only while they are still drafts.
+func CanEditPost(user *User, post *Post) bool {
+	p := GetPermissions(user.Role)
+	if !p.CanEditPosts {
+		return false
+	}
+	if post.UserID != user.UserID && !p.CanManageAllPosts {
+		return false
+	}
+	return p.CanPublishPosts || !post.IsPublished
+}
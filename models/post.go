@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"os"
@@ -9,12 +10,21 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"anshumanbiswas.com/blog/internal/render"
+	"github.com/lib/pq"
+	"golang.org/x/net/html"
 )
 
+// ErrSlugTaken is returned when a post is saved with another post's slug.
+var ErrSlugTaken = errors.New("models: slug is already in use")
+
 type PostsList struct {
 	Posts []Post
+	// NextCursor continues a list from ListPostPage; it is empty on the
+	// last page.
+	NextCursor string `json:",omitempty"`
 }
 
 type Post struct {
@@ -32,7 +42,27 @@ type Post struct {
 	Featured         bool   // Boolean field to mark posts as featured
 	FeaturedImageURL string
 	CreatedAt        string
+	// TrustedHTML is set when the post was last saved by a role with
+	// CanPostRawHTML; its content is then rendered without sanitizing.
+	TrustedHTML bool
+	// RenderEngine is render.EngineLegacy or render.EngineAST.
+	RenderEngine string
+	// Version counts the saves of the post, for optimistic concurrency.
+	Version int
+	// Outline is the post's headings, for the table of contents sidebar.
+	// Only the single-post page fills it in.
+	Outline []render.TOCEntry `json:"outline,omitempty"`
 	Categories       []Category `json:"categories,omitempty"` // New many-to-many categories
+	// Tags are the names of the post's tags. Only ListPosts fills them in.
+	Tags []string `json:"tags,omitempty"`
+	// cursor is the post's position in lists, for ListPostPage.
+	cursor PostCursor
+}
+
+// PublishedAt is the time lists order the post by: its publication date,
+// or its creation time if it has none. Only posts from ListPosts have it.
+func (p Post) PublishedAt() time.Time {
+	return p.cursor.Date
 }
 
 type PostService struct {
@@ -46,209 +76,60 @@ type PostService struct {
 
 // }
 
+// GetTopPosts returns the five newest published posts with their previews
+// rendered, for the home feed.
 func (pp *PostService) GetTopPosts() (*PostsList, error) {
-	list := PostsList{}
-
-	query := `SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured FROM posts WHERE is_published = true ORDER BY created_at DESC LIMIT 5`
-	rows, err := pp.DB.Query(query)
-	if err != nil {
-		return &list, nil
-	}
-
-	for rows.Next() {
-
-		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured)
-		if err != nil {
-			panic(err)
-		}
-
-		// Parse and format CreatedAt
-		t, err := time.Parse(time.RFC3339, post.CreatedAt)
-		if err != nil {
-			fmt.Println(err)
-		}
-		post.CreatedAt = t.Format(time.RFC3339)            // Keep original for JavaScript
-		post.PublicationDate = t.Format("January 2, 2006") // Readable fallback
-
-		// Parse and format PublicationDate if it's different from CreatedAt
-		if post.PublicationDate != "" && post.PublicationDate != post.CreatedAt {
-			pubDate, pubErr := time.Parse(time.RFC3339, post.PublicationDate)
-			if pubErr == nil {
-				post.PublicationDate = pubDate.Format("January 2, 2006")
-			}
-		}
-
-		// Load categories for this post
-		categoriesQuery := `SELECT c.category_id, c.category_name, c.created_at 
-						   FROM categories c 
-						   JOIN post_categories pc ON c.category_id = pc.category_id 
-						   WHERE pc.post_id = $1`
-		categoryRows, catErr := pp.DB.Query(categoriesQuery, post.ID)
-		if catErr == nil {
-			var categories []Category
-			for categoryRows.Next() {
-				var category Category
-				categoryRows.Scan(&category.ID, &category.Name, &category.CreatedAt)
-				categories = append(categories, category)
-			}
-			categoryRows.Close()
-			post.Categories = categories
-		}
-
-		// Build preview from raw content to preserve Markdown list/numbering, then trim for length
-		preview := previewContentRaw(post.Content)
-		post.ContentHTML = template.HTML(RenderContent(preview))
-
-		list.Posts = append(list.Posts, post)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("create post: %w", err)
-	} else {
-		fmt.Printf("Posts fetched successfully! Count: %d\n", len(list.Posts))
-	}
-
-	return &list, nil
+	return pp.GetTopPostsWithPagination(FeedPageSize, 0)
 }
 
+// GetTopPostsWithPagination is GetTopPosts for any page of the feed.
 func (pp *PostService) GetTopPostsWithPagination(limit int, offset int) (*PostsList, error) {
-	list := PostsList{}
-
-	query := `SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured FROM posts WHERE is_published = true ORDER BY created_at DESC LIMIT $1 OFFSET $2`
-	rows, err := pp.DB.Query(query, limit, offset)
+	posts, err := pp.ListPosts(PostQuery{PublishedOnly: true, Limit: limit, Offset: offset})
 	if err != nil {
-		return &list, nil
+		return &PostsList{}, fmt.Errorf("get top posts: %w", err)
 	}
+	return &PostsList{Posts: withPreviews(posts)}, nil
+}
 
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured)
-		if err != nil {
-			panic(err)
-		}
-
-		// Parse and format CreatedAt
-		t, err := time.Parse(time.RFC3339, post.CreatedAt)
-		if err != nil {
-			fmt.Println(err)
-		}
-		post.CreatedAt = t.Format(time.RFC3339)            // Keep original for JavaScript
-		post.PublicationDate = t.Format("January 2, 2006") // Readable fallback
-
-		// Parse and format PublicationDate if it's different from CreatedAt
-		if post.PublicationDate != "" && post.PublicationDate != post.CreatedAt {
-			pubDate, pubErr := time.Parse(time.RFC3339, post.PublicationDate)
-			if pubErr == nil {
-				post.PublicationDate = pubDate.Format("January 2, 2006")
-			}
-		}
-
-		// Build preview from raw content to preserve Markdown list/numbering, then trim for length
-		preview := previewContentRaw(post.Content)
-		post.ContentHTML = template.HTML(RenderContent(preview))
+const (
+	// FeedPageSize is how many posts a page of the home feed shows.
+	FeedPageSize = 5
+	// MaxPageSize caps the page size API clients can ask for.
+	MaxPageSize = 50
+)
 
-		list.Posts = append(list.Posts, post)
+// GetFeedPage returns a page of the home feed: the published posts q's
+// filters select, after q.After, with their previews rendered.
+func (pp *PostService) GetFeedPage(q PostQuery) (*PostsList, error) {
+	q.PublishedOnly = true
+	if q.Limit <= 0 {
+		q.Limit = FeedPageSize
 	}
-
+	page, err := pp.ListPostPage(q)
 	if err != nil {
-		return nil, fmt.Errorf("get paginated posts: %w", err)
-	} else {
-		fmt.Printf("Paginated posts fetched successfully! Limit: %d, Offset: %d\n", limit, offset)
+		return &PostsList{}, fmt.Errorf("get feed page: %w", err)
 	}
-
-	return &list, nil
+	page.Posts = withPreviews(page.Posts)
+	return &page, nil
 }
 
+// GetAllPosts returns every post, drafts included, with excerpts for the
+// admin list.
 func (pp *PostService) GetAllPosts() (*PostsList, error) {
-	list := PostsList{}
-
-	query := `SELECT p.post_id, p.user_id, u.username, p.category_id, p.title, p.content, p.slug, p.publication_date, p.last_edit_date, p.is_published, p.featured_image_url, p.created_at, p.featured 
-			  FROM posts p 
-			  JOIN users u ON p.user_id = u.user_id 
-			  ORDER BY p.created_at DESC`
-	rows, err := pp.DB.Query(query)
+	posts, err := pp.ListPosts(PostQuery{})
 	if err != nil {
-		return &list, err
+		return &PostsList{}, fmt.Errorf("get all posts: %w", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Username, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse and format CreatedAt
-		t, err := time.Parse(time.RFC3339, post.CreatedAt)
-		if err != nil {
-			fmt.Println(err)
-		}
-		post.CreatedAt = t.Format(time.RFC3339)
-		post.PublicationDate = t.Format("January 2, 2006")
-
-		// Parse and format PublicationDate if it's different from CreatedAt
-		if post.PublicationDate != "" && post.PublicationDate != post.CreatedAt {
-			pubDate, pubErr := time.Parse(time.RFC3339, post.PublicationDate)
-			if pubErr == nil {
-				post.PublicationDate = pubDate.Format("January 2, 2006")
-			}
-		}
-
-		post.Content = trimContent(post.Content)
-		list.Posts = append(list.Posts, post)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("get all posts: %w", err)
-	}
-
-	return &list, nil
+	return &PostsList{Posts: withExcerpts(posts)}, nil
 }
 
+// GetPostsByUser is GetAllPosts for one author.
 func (pp *PostService) GetPostsByUser(userID int) (*PostsList, error) {
-	list := PostsList{}
-
-	query := `SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured FROM posts WHERE user_id = $1 ORDER BY created_at DESC`
-	rows, err := pp.DB.Query(query, userID)
+	posts, err := pp.ListPosts(PostQuery{UserID: userID})
 	if err != nil {
-		return &list, err
+		return &PostsList{}, fmt.Errorf("get posts by user: %w", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse and format CreatedAt
-		t, err := time.Parse(time.RFC3339, post.CreatedAt)
-		if err != nil {
-			fmt.Println(err)
-		}
-		post.CreatedAt = t.Format(time.RFC3339)
-		post.PublicationDate = t.Format("January 2, 2006")
-
-		// Parse and format PublicationDate if it's different from CreatedAt
-		if post.PublicationDate != "" && post.PublicationDate != post.CreatedAt {
-			pubDate, pubErr := time.Parse(time.RFC3339, post.PublicationDate)
-			if pubErr == nil {
-				post.PublicationDate = pubDate.Format("January 2, 2006")
-			}
-		}
-
-		post.Content = trimContent(post.Content)
-		list.Posts = append(list.Posts, post)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("get posts by user: %w", err)
-	}
-
-	return &list, nil
+	return &PostsList{Posts: withExcerpts(posts)}, nil
 }
 
 // Function to trim content up to the <more--> tag
@@ -298,90 +179,88 @@ func stripHTML(s string) string {
 	return b.String()
 }
 
-// previewContentRaw returns a trimmed slice of the raw content that preserves
-// Markdown structure (numbers, bullets, headings) by cutting on paragraph/line
-// boundaries rather than stripping formatting markers first.
-func previewContentRaw(content string) string {
-	// Check for read-more markers and find their positions
-	moreIdx1 := strings.Index(content, "<more-->")
-	moreIdx2 := strings.Index(content, "&lt;more--&gt;")
-	
-	// Find the earliest more tag position
-	moreIdx := -1
-	if moreIdx1 != -1 && moreIdx2 != -1 {
-		if moreIdx1 < moreIdx2 {
-			moreIdx = moreIdx1
-		} else {
-			moreIdx = moreIdx2
+// previewChars is about how much text the home feed shows of a post
+// without a read-more marker.
+const previewChars = 150
+
+// readMoreIndex returns where content's read-more marker, raw or escaped,
+// first appears, or -1.
+func readMoreIndex(content string) int {
+	idx := strings.Index(content, "<more-->")
+	if esc := strings.Index(content, "&lt;more--&gt;"); esc != -1 && (idx == -1 || esc < idx) {
+		idx = esc
+	}
+	return idx
+}
+
+// previewHTML renders the home feed's preview of a post: the Markdown
+// before its read-more marker or, without one, the leading blocks of the
+// whole post, which is rendered once through the cache the post page uses.
+func previewHTML(content string, trusted bool, engine string) string {
+	if idx := readMoreIndex(content); idx != -1 {
+		return RenderPostContent(strings.TrimSpace(content[:idx]), trusted, engine)
+	}
+	return leadingBlocks(RenderPostContent(content, trusted, engine), previewChars)
+}
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// leadingBlocks returns the top-level elements of rendered HTML that hold
+// at most max characters of text between them, and always the first.
+func leadingBlocks(rendered string, max int) string {
+	z := html.NewTokenizer(strings.NewReader(rendered))
+	var out, block strings.Builder
+	depth, total, blockText := 0, 0, 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
 		}
-	} else if moreIdx1 != -1 {
-		moreIdx = moreIdx1
-	} else if moreIdx2 != -1 {
-		moreIdx = moreIdx2
-	}
-	
-	// If we found a more tag, use content up to that point
-	if moreIdx != -1 {
-		contentBeforeMore := strings.TrimSpace(content[:moreIdx])
-		// Return the markdown content before the more tag - it will be rendered later
-		return contentBeforeMore
-	}
-	
-	// No more tag found, render the content first then extract preview
-	rendered := RenderContent(content)
-	
-	// Convert rendered HTML to plain text for length calculation
-	plainText := stripHTML(rendered)
-	
-	// If rendered content is short enough, return the original markdown
-	const maxChars = 150
-	if len(plainText) <= maxChars {
-		return strings.TrimSpace(content)
-	}
-	
-	// Find a good breaking point in the original markdown
-	// Look for paragraph breaks, sentence ends, or word boundaries
-	lines := strings.Split(content, "\n")
-	var result strings.Builder
-	var currentLength int
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		block.Write(z.Raw())
+		switch tt {
+		case html.StartTagToken:
+			if name, _ := z.TagName(); !voidElements[string(name)] {
+				depth++
+			}
+		case html.EndTagToken:
+			depth--
+		case html.TextToken:
+			blockText += utf8.RuneCountInString(strings.TrimSpace(string(z.Text())))
+		}
+		if depth > 0 {
 			continue
 		}
-		
-		// Estimate plain text length of this line
-		lineText := stripHTML(RenderContent(line))
-		
-		if currentLength + len(lineText) > maxChars {
+		if out.Len() > 0 && total+blockText > max {
 			break
 		}
-		
-		if result.Len() > 0 {
-			result.WriteString("\n\n")
-		}
-		result.WriteString(line)
-		currentLength += len(lineText)
+		out.WriteString(block.String())
+		total += blockText
+		block.Reset()
+		blockText = 0
 	}
-	
-	return strings.TrimSpace(result.String())
+	return strings.TrimSpace(out.String())
 }
 
-func (pp *PostService) Create(userID int, categoryID int, title, content string, isPublished bool, featured bool, featuredImageURL string, slug string) (*Post, error) {
+// Create saves a new post. trustedHTML is whether its content skips the HTML
+// sanitizer, which TrustsHTML decides.
+func (pp *PostService) Create(userID int, categoryID int, title, content string, isPublished bool, featured bool, featuredImageURL string, slug string, trustedHTML bool) (*Post, error) {
 	timefmt := time.Now()
 	query := `
-		INSERT INTO posts (user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured, featured_image_url, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		INSERT INTO posts (user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured, featured_image_url, created_at, trusted_html)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING post_id
 	`
 	var postID int
 	println(userID, categoryID, title, content, isPublished, featured, featuredImageURL)
 	err := pp.DB.QueryRow(query, userID, categoryID, title, content, slug, timefmt,
-		timefmt, isPublished, featured, featuredImageURL, timefmt).Scan(&postID)
+		timefmt, isPublished, featured, featuredImageURL, timefmt, trustedHTML).Scan(&postID)
 	if err != nil {
 		fmt.Printf("Error: %v", err)
-		return nil, fmt.Errorf("create post: %w", err)
+		return nil, fmt.Errorf("create post: %w", slugConflict(err))
 	}
 	fmt.Println("Post created successfully!")
 	fmt.Println(postID)
@@ -399,24 +278,32 @@ func (pp *PostService) Create(userID int, categoryID int, title, content string,
 		Featured:         featured,
 		FeaturedImageURL: featuredImageURL,
 		CreatedAt:        timefmt.Format("January 2, 2006"),
+		TrustedHTML:      trustedHTML,
 	}, nil
 }
 
 func (pp *PostService) GetByID(id int) (*Post, error) {
 	var post Post
-	row := pp.DB.QueryRow(`SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured FROM posts WHERE post_id=$1`, id)
-	if err := row.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured); err != nil {
+	row := pp.DB.QueryRow(`SELECT post_id, user_id, category_id, title, content, slug, publication_date, last_edit_date, is_published, featured_image_url, created_at, featured, trusted_html, render_engine, version FROM posts WHERE post_id=$1`, id)
+	if err := row.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.Slug, &post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt, &post.Featured, &post.TrustedHTML, &post.RenderEngine, &post.Version); err != nil {
 		return nil, err
 	}
 	return &post, nil
 }
 
-func (pp *PostService) Update(id int, categoryID int, title, content string, isPublished bool, featured bool, featuredImageURL, slug string) error {
+// Update saves a post edited from the given version of it, returning
+// ErrVersionConflict if the post has been saved since or version is not
+// one it had, such as 0 for a form without one. trustedHTML is as for
+// Create.
+func (pp *PostService) Update(id, version int, categoryID int, title, content string, isPublished bool, featured bool, featuredImageURL, slug string, trustedHTML bool) error {
 	// Fetch existing post to detect slug change
 	existing, err := pp.GetByID(id)
 	if err != nil {
 		return err
 	}
+	if version != existing.Version {
+		return fmt.Errorf("update post: %w", ErrVersionConflict)
+	}
 
 	oldSlug := strings.TrimSpace(existing.Slug)
 	newSlug := strings.TrimSpace(slug)
@@ -437,13 +324,131 @@ func (pp *PostService) Update(id int, categoryID int, title, content string, isP
 		featuredImageURL = strings.ReplaceAll(featuredImageURL, oldPrefix, newPrefix)
 	}
 
-	_, err = pp.DB.Exec(`UPDATE posts SET category_id=$1, title=$2, content=$3, slug=$4, last_edit_date=$5, is_published=$6, featured=$7, featured_image_url=$8 WHERE post_id=$9`,
-		categoryID, title, content, newSlug, time.Now(), isPublished, featured, featuredImageURL, id)
+	// Trust is decided again for each save, with the content it applies
+	// to, so an edit by anyone but a trusted author is sanitized even on a
+	// trusted post. The persisted HTML is cleared with it, to be rendered on
+	// the next view. The version is checked again in the update, for a save
+	// that came in since the post was read.
+	result, err := pp.DB.Exec(`UPDATE posts SET category_id=$1, title=$2, content=$3, slug=$4, last_edit_date=$5, is_published=$6, featured=$7, featured_image_url=$8, trusted_html=$11, rendered_html=NULL, rendered_key=NULL, version=version+1 WHERE post_id=$9 AND version=$10`,
+		categoryID, title, content, newSlug, time.Now(), isPublished, featured, featuredImageURL, id, version, trustedHTML)
+	if err != nil {
+		return fmt.Errorf("update post: %w", slugConflict(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("update post: %w", ErrVersionConflict)
+	}
+	return nil
+}
+
+// slugConflict turns the unique violation on posts.slug into ErrSlugTaken.
+func slugConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrSlugTaken
+	}
 	return err
 }
 
-// RenderContent converts markdown content to HTML using the default renderer
+// Delete removes a post with its likes, comments and tags in one
+// transaction. Its categories go with it through the foreign key. Like
+// Update, it returns ErrVersionConflict unless version is the current one.
+func (pp *PostService) Delete(id, version int) error {
+	tx, err := pp.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("delete post: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow(`SELECT version FROM posts WHERE post_id = $1 FOR UPDATE`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("delete post: %w", ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("delete post: %w", err)
+	}
+	if version != current {
+		return fmt.Errorf("delete post: %w", ErrVersionConflict)
+	}
+
+	cleanup := []string{
+		`DELETE FROM likes WHERE post_id = $1`,
+		`DELETE FROM comments WHERE post_id = $1`,
+		`DELETE FROM post_tags WHERE post_id = $1`,
+	}
+	for _, stmt := range cleanup {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("delete post: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM posts WHERE post_id = $1`, id); err != nil {
+		return fmt.Errorf("delete post: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete post: %w", err)
+	}
+	return nil
+}
+
+// RenderSources returns every post with just the fields needed to render it:
+// ID, title, slug, content, trust flag and engine.
+func (pp *PostService) RenderSources() ([]Post, error) {
+	rows, err := pp.DB.Query(`SELECT post_id, title, slug, content, trusted_html, render_engine FROM posts ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("render sources: %w", err)
+	}
+	defer rows.Close()
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.Title, &post.Slug, &post.Content, &post.TrustedHTML, &post.RenderEngine); err != nil {
+			return nil, fmt.Errorf("render sources: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("render sources: %w", err)
+	}
+	return posts, nil
+}
+
+// SetRenderEngine selects the renderer for a post, one of render.Engines.
+func (pp *PostService) SetRenderEngine(id int, engine string) error {
+	if !render.ValidEngine(engine) {
+		return fmt.Errorf("set render engine: unknown engine %q", engine)
+	}
+	if _, err := pp.DB.Exec(`UPDATE posts SET render_engine = $1 WHERE post_id = $2`, engine, id); err != nil {
+		return fmt.Errorf("set render engine: %w", err)
+	}
+	return nil
+}
+
+// RenderContent converts markdown content to sanitized HTML using the
+// default renderer
 func RenderContent(content string) string {
 	renderer := render.NewRenderer(render.DefaultOptions())
 	return renderer.Render(content)
 }
+
+// RenderTrustedContent is RenderContent without the sanitizer, for posts
+// saved by a role with CanPostRawHTML.
+func RenderTrustedContent(content string) string {
+	opt := render.DefaultOptions()
+	opt.SanitizeHTML = false
+	return render.NewRenderer(opt).Render(content)
+}
+
+// PostRenderOptions returns the renderer options for a post with the given
+// trust flag and engine.
+func PostRenderOptions(trusted bool, engine string) render.RendererOptions {
+	opt := render.DefaultOptions()
+	opt.SanitizeHTML = !trusted
+	opt.Engine = engine
+	return opt
+}
+
+// RenderPostContent renders content as a post with the given trust flag and
+// engine would be.
+func RenderPostContent(content string, trusted bool, engine string) string {
+	return render.NewRenderer(PostRenderOptions(trusted, engine)).Render(content)
+}
@@ -0,0 +1,362 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostQuery selects the posts for a list. The zero value is every post,
+// newest first.
+type PostQuery struct {
+	ID            int    // a single post
+	UserID        int    // posts by one author
+	Author        string // posts by the user with this username
+	CategoryID    int    // posts in one category
+	Tag           string // posts with the tag of this name
+	Featured      *bool  // only featured, or only other, posts
+	From, To      time.Time
+	PublishedOnly bool        // leave out drafts
+	After         *PostCursor // posts after this one in the order
+	Limit         int         // 0 for no limit
+	Offset        int
+}
+
+// ErrInvalidCursor is returned for a cursor DecodeCursor cannot read.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PostCursor is a position in the post order: the publication date and the
+// ID break ties between posts published at the same moment.
+type PostCursor struct {
+	Date time.Time
+	ID   int
+}
+
+// cursorDateLayout keeps the timestamp's wall clock to the microsecond,
+// the precision Postgres stores.
+const cursorDateLayout = "2006-01-02T15:04:05.999999"
+
+// Encode returns c as the opaque string clients pass back.
+func (c PostCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Date.Format(cursorDateLayout) + "|" + strconv.Itoa(c.ID)))
+}
+
+// DecodeCursor reads a cursor made by PostCursor.Encode.
+func DecodeCursor(s string) (PostCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return PostCursor{}, ErrInvalidCursor
+	}
+	date, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return PostCursor{}, ErrInvalidCursor
+	}
+	var c PostCursor
+	if c.Date, err = time.Parse(cursorDateLayout, date); err != nil {
+		return PostCursor{}, ErrInvalidCursor
+	}
+	if c.ID, err = strconv.Atoi(id); err != nil || c.ID <= 0 {
+		return PostCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ParsePostFilters reads the list filters shared by the home feed and the
+// posts API from query parameters: category (an ID), tag (a name),
+// author (a username), featured (true or false), from and to
+// (dates, both inclusive) and cursor (from a previous page).
+func ParsePostFilters(v url.Values) (PostQuery, error) {
+	var q PostQuery
+	var err error
+	if s := v.Get("category"); s != "" {
+		if q.CategoryID, err = strconv.Atoi(s); err != nil || q.CategoryID <= 0 {
+			return PostQuery{}, fmt.Errorf("invalid category %q", s)
+		}
+	}
+	q.Tag = strings.TrimSpace(v.Get("tag"))
+	q.Author = strings.TrimSpace(v.Get("author"))
+	if s := v.Get("featured"); s != "" {
+		featured, err := strconv.ParseBool(s)
+		if err != nil {
+			return PostQuery{}, fmt.Errorf("invalid featured %q", s)
+		}
+		q.Featured = &featured
+	}
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse("2006-01-02", s); err != nil {
+			return PostQuery{}, fmt.Errorf("invalid from date %q", s)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		to, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return PostQuery{}, fmt.Errorf("invalid to date %q", s)
+		}
+		q.To = to.AddDate(0, 0, 1)
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := DecodeCursor(s)
+		if err != nil {
+			return PostQuery{}, err
+		}
+		q.After = &c
+	}
+	return q, nil
+}
+
+// Values is the inverse of ParsePostFilters without the cursor, for links
+// that keep the current filters.
+func (q PostQuery) Values() url.Values {
+	v := url.Values{}
+	if q.CategoryID != 0 {
+		v.Set("category", strconv.Itoa(q.CategoryID))
+	}
+	if q.Tag != "" {
+		v.Set("tag", q.Tag)
+	}
+	if q.Author != "" {
+		v.Set("author", q.Author)
+	}
+	if q.Featured != nil {
+		v.Set("featured", strconv.FormatBool(*q.Featured))
+	}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	return v
+}
+
+// postOrder is what lists are sorted on, newest first, with post_id as
+// the tie break. Drafts saved before publication_date had a default fall
+// back to their creation time.
+const postOrder = `COALESCE(p.publication_date, p.created_at)`
+
+// postColumns are the columns scanPost reads, from posts p joined with
+// their author u.
+const postColumns = `p.post_id, p.user_id, u.username, p.category_id, p.title, p.content, p.slug, p.publication_date, p.last_edit_date, p.is_published, p.featured_image_url, p.created_at, p.featured, p.trusted_html, p.render_engine, p.version, ` + postOrder
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPost(row rowScanner) (Post, error) {
+	var post Post
+	err := row.Scan(&post.ID, &post.UserID, &post.Username, &post.CategoryID, &post.Title, &post.Content, &post.Slug,
+		&post.PublicationDate, &post.LastEditDate, &post.IsPublished, &post.FeaturedImageURL, &post.CreatedAt,
+		&post.Featured, &post.TrustedHTML, &post.RenderEngine, &post.Version, &post.cursor.Date)
+	post.cursor.ID = post.ID
+	return post, err
+}
+
+// ListPosts returns the posts q selects with their authors, categories and
+// tags, in three queries however many posts there are.
+func (pp *PostService) ListPosts(q PostQuery) ([]Post, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.ID != 0 {
+		where = append(where, "p.post_id = "+arg(q.ID))
+	}
+	if q.UserID != 0 {
+		where = append(where, "p.user_id = "+arg(q.UserID))
+	}
+	if q.Author != "" {
+		where = append(where, "u.username = "+arg(q.Author))
+	}
+	if q.CategoryID != 0 {
+		n := arg(q.CategoryID)
+		where = append(where, "(p.category_id = "+n+" OR EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.post_id AND pc.category_id = "+n+"))")
+	}
+	if q.Tag != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM post_tags pt JOIN tags t ON t.tag_id = pt.tag_id
+			WHERE pt.post_id = p.post_id AND lower(t.tag_name) = lower(`+arg(q.Tag)+`))`)
+	}
+	if q.Featured != nil {
+		where = append(where, "p.featured = "+arg(*q.Featured))
+	}
+	if !q.From.IsZero() {
+		where = append(where, postOrder+" >= "+arg(q.From.Format(cursorDateLayout))+"::timestamp")
+	}
+	if !q.To.IsZero() {
+		where = append(where, postOrder+" < "+arg(q.To.Format(cursorDateLayout))+"::timestamp")
+	}
+	if q.PublishedOnly {
+		where = append(where, "p.is_published = true")
+	}
+	if q.After != nil {
+		where = append(where, "("+postOrder+", p.post_id) < ("+arg(q.After.Date.Format(cursorDateLayout))+"::timestamp, "+arg(q.After.ID)+")")
+	}
+	query := `SELECT ` + postColumns + ` FROM posts p JOIN users u ON u.user_id = p.user_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + postOrder + " DESC, p.post_id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+	if q.Offset > 0 {
+		query += " OFFSET " + arg(q.Offset)
+	}
+
+	rows, err := pp.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list posts: %w", err)
+	}
+	defer rows.Close()
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list posts: %w", err)
+		}
+		formatListDates(&post)
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list posts: %w", err)
+	}
+	if err := pp.loadCategories(posts); err != nil {
+		return nil, err
+	}
+	if err := pp.loadTags(posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// ListPostPage returns a page of up to q.Limit posts and the cursor for the
+// page after it, which is empty on the last page. Unlike Offset, a cursor
+// neither skips nor repeats posts when new ones are published in between.
+func (pp *PostService) ListPostPage(q PostQuery) (PostsList, error) {
+	if q.Limit <= 0 {
+		return PostsList{}, fmt.Errorf("list post page: limit must be positive")
+	}
+	limit := q.Limit
+	q.Limit++ // one more to know whether there is a next page
+	q.Offset = 0
+	posts, err := pp.ListPosts(q)
+	if err != nil {
+		return PostsList{}, err
+	}
+	page := PostsList{Posts: posts}
+	if len(posts) > limit {
+		page.Posts = posts[:limit]
+		page.NextCursor = posts[limit-1].cursor.Encode()
+	}
+	return page, nil
+}
+
+// loadCategories fills in the categories of posts with one query.
+func (pp *PostService) loadCategories(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(posts))
+	index := make(map[int]int, len(posts))
+	for i, p := range posts {
+		ids[i] = int64(p.ID)
+		index[p.ID] = i
+	}
+	rows, err := pp.DB.Query(`SELECT pc.post_id, c.category_id, c.category_name, c.created_at
+		FROM post_categories pc
+		JOIN categories c ON c.category_id = pc.category_id
+		WHERE pc.post_id = ANY($1)
+		ORDER BY c.category_name ASC`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("load post categories: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID int
+		var c Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name, &c.CreatedAt); err != nil {
+			return fmt.Errorf("load post categories: %w", err)
+		}
+		if i, ok := index[postID]; ok {
+			posts[i].Categories = append(posts[i].Categories, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load post categories: %w", err)
+	}
+	return nil
+}
+
+// loadTags fills in the tag names of posts with one query.
+func (pp *PostService) loadTags(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(posts))
+	index := make(map[int]int, len(posts))
+	for i, p := range posts {
+		ids[i] = int64(p.ID)
+		index[p.ID] = i
+	}
+	rows, err := pp.DB.Query(`SELECT DISTINCT pt.post_id, t.tag_name
+		FROM post_tags pt
+		JOIN tags t ON t.tag_id = pt.tag_id
+		WHERE pt.post_id = ANY($1)
+		ORDER BY t.tag_name ASC`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("load post tags: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID int
+		var name string
+		if err := rows.Scan(&postID, &name); err != nil {
+			return fmt.Errorf("load post tags: %w", err)
+		}
+		if i, ok := index[postID]; ok {
+			posts[i].Tags = append(posts[i].Tags, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load post tags: %w", err)
+	}
+	return nil
+}
+
+// formatListDates sets the dates lists show: CreatedAt stays RFC3339 for
+// scripts and PublicationDate is the creation date for reading.
+func formatListDates(post *Post) {
+	t, err := time.Parse(time.RFC3339, post.CreatedAt)
+	if err != nil {
+		log.Printf("post %d: created_at %q: %v", post.ID, post.CreatedAt, err)
+	}
+	post.CreatedAt = t.Format(time.RFC3339)
+	post.PublicationDate = t.Format("January 2, 2006")
+}
+
+// withPreviews renders each post's preview, as the home feed shows it, into
+// ContentHTML.
+func withPreviews(posts []Post) []Post {
+	for i := range posts {
+		posts[i].ContentHTML = template.HTML(previewHTML(posts[i].Content, posts[i].TrustedHTML, posts[i].RenderEngine))
+	}
+	return posts
+}
+
+// withExcerpts cuts each post's Content down to its excerpt, as the admin
+// lists show it.
+func withExcerpts(posts []Post) []Post {
+	for i := range posts {
+		posts[i].Content = trimContent(posts[i].Content)
+	}
+	return posts
+}
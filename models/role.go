@@ -2,7 +2,11 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
 // Role constants for the 4 different user roles
@@ -14,17 +18,30 @@ const (
 )
 
 type Role struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID               int             `json:"id"`
+	Name             string          `json:"name"`
+	Description      string          `json:"description"`
+	IsSystem         bool            `json:"is_system"`
+	RequireTwoFactor bool            `json:"require_two_factor"`
+	Permissions      UserPermissions `json:"permissions"`
 }
 
+var (
+	// ErrSystemRole is returned when deleting one of the built-in roles.
+	ErrSystemRole = errors.New("models: built-in roles cannot be deleted")
+	// ErrRoleInUse is returned when deleting a role that users still have.
+	ErrRoleInUse = errors.New("models: role is assigned to users")
+	// ErrRoleNameTaken is returned when a role name is already in use.
+	ErrRoleNameTaken = errors.New("models: role name already exists")
+)
+
 type RoleService struct {
 	DB *sql.DB
 }
 
 // GetAllRoles returns all available roles
 func (rs *RoleService) GetAllRoles() ([]*Role, error) {
-	query := `SELECT role_id, role_name FROM roles ORDER BY role_id`
+	query := `SELECT role_id, role_name, description, is_system, require_two_factor FROM roles ORDER BY role_id`
 	
 	rows, err := rs.DB.Query(query)
 	if err != nil {
@@ -35,13 +52,27 @@ func (rs *RoleService) GetAllRoles() ([]*Role, error) {
 	var roles []*Role
 	for rows.Next() {
 		role := &Role{}
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.IsSystem, &role.RequireTwoFactor)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan role: %w", err)
 		}
 		roles = append(roles, role)
 	}
-	
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch roles: %w", err)
+	}
+
+	perms, err := rs.loadPermissions()
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if p, ok := perms[role.ID]; ok {
+			role.Permissions = p
+		} else {
+			role.Permissions = DefaultPermissions(role.ID)
+		}
+	}
 	return roles, nil
 }
 
@@ -63,19 +94,24 @@ type UserPermissions struct {
 	CanComment          bool
 	CanViewUnpublished  bool
 	CanEditPosts        bool
+	CanPublishPosts     bool
 	CanManageAllPosts   bool
 	CanManageUsers      bool
 	CanViewAdmin        bool
+	CanManageSecurity   bool // two-factor requirements, resets and unlocks
+	CanPostRawHTML      bool // posts skip the HTML sanitizer
 }
 
-// GetPermissions returns the permissions for a given role
-func GetPermissions(roleID int) UserPermissions {
+// DefaultPermissions returns the built-in permissions for the four system
+// roles. They seed the role_permissions table and apply until it is loaded.
+func DefaultPermissions(roleID int) UserPermissions {
 	switch roleID {
 	case RoleCommenter:
 		return UserPermissions{
 			CanComment:          true,
 			CanViewUnpublished:  false,
 			CanEditPosts:        false,
+			CanPublishPosts:     false,
 			CanManageAllPosts:   false,
 			CanManageUsers:      false,
 			CanViewAdmin:        false,
@@ -85,15 +121,19 @@ func GetPermissions(roleID int) UserPermissions {
 			CanComment:          true,
 			CanViewUnpublished:  true,
 			CanEditPosts:        true,
+			CanPublishPosts:     true,
 			CanManageAllPosts:   true,
 			CanManageUsers:      true,
 			CanViewAdmin:        true,
+			CanManageSecurity:   true,
+			CanPostRawHTML:      true,
 		}
 	case RoleEditor:
 		return UserPermissions{
 			CanComment:          true,
 			CanViewUnpublished:  true,
 			CanEditPosts:        true,
+			CanPublishPosts:     true,
 			CanManageAllPosts:   false,
 			CanManageUsers:      false,
 			CanViewAdmin:        false,
@@ -103,19 +143,27 @@ func GetPermissions(roleID int) UserPermissions {
 			CanComment:          true,
 			CanViewUnpublished:  true,
 			CanEditPosts:        false,
+			CanPublishPosts:     false,
 			CanManageAllPosts:   false,
 			CanManageUsers:      false,
 			CanViewAdmin:        false,
 		}
 	default:
 		// Default to commenter permissions for unknown roles
-		return GetPermissions(RoleCommenter)
+		return DefaultPermissions(RoleCommenter)
 	}
 }
 
 // IsAdmin checks if a role has admin privileges
 func IsAdmin(roleID int) bool {
-	return roleID == RoleAdministrator
+	return GetPermissions(roleID).CanViewAdmin
+}
+
+// CanManageSecurity checks if a role can change two-factor requirements,
+// reset second factors and unlock accounts. Seeing the admin area is not
+// enough.
+func CanManageSecurity(roleID int) bool {
+	return GetPermissions(roleID).CanManageSecurity
 }
 
 // CanEditPosts checks if a role can edit posts
@@ -128,4 +176,237 @@ func CanEditPosts(roleID int) bool {
 func CanViewUnpublished(roleID int) bool {
 	permissions := GetPermissions(roleID)
 	return permissions.CanViewUnpublished
-}
\ No newline at end of file
+}
+// loadPermissions reads role_permissions grouped by role.
+func (rs *RoleService) loadPermissions() (map[int]UserPermissions, error) {
+	rows, err := rs.DB.Query(`SELECT role_id, permission FROM role_permissions`)
+	if err != nil {
+		return nil, fmt.Errorf("load permissions: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[int][]string)
+	for rows.Next() {
+		var roleID int
+		var name string
+		if err := rows.Scan(&roleID, &name); err != nil {
+			return nil, fmt.Errorf("load permissions: %w", err)
+		}
+		names[roleID] = append(names[roleID], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load permissions: %w", err)
+	}
+
+	// Roles without any rows still get an entry, so a custom role with no
+	// permissions doesn't fall back to the defaults.
+	perms := make(map[int]UserPermissions)
+	roleRows, err := rs.DB.Query(`SELECT role_id FROM roles WHERE permissions_seeded`)
+	if err != nil {
+		return nil, fmt.Errorf("load permissions: %w", err)
+	}
+	defer roleRows.Close()
+	for roleRows.Next() {
+		var roleID int
+		if err := roleRows.Scan(&roleID); err != nil {
+			return nil, fmt.Errorf("load permissions: %w", err)
+		}
+		perms[roleID] = PermissionsFromNames(names[roleID])
+	}
+	return perms, roleRows.Err()
+}
+
+// RefreshPermissions reloads the permission cache used by GetPermissions,
+// which from then on reloads itself once it is PermissionCacheTTL old.
+func (rs *RoleService) RefreshPermissions() error {
+	perms, err := rs.loadPermissions()
+	if err != nil {
+		return err
+	}
+	storePermissions(perms, rs.loadPermissions)
+	return nil
+}
+
+// SeedDefaultPermissions writes DefaultPermissions for built-in roles whose
+// permissions have never been stored, marks them as system roles, and loads
+// the cache. It is safe to call on every start.
+func (rs *RoleService) SeedDefaultPermissions() error {
+	for _, roleID := range []int{RoleCommenter, RoleAdministrator, RoleEditor, RoleViewer} {
+		tx, err := rs.DB.Begin()
+		if err != nil {
+			return fmt.Errorf("seed permissions: %w", err)
+		}
+		result, err := tx.Exec(`
+			UPDATE roles SET is_system = true, permissions_seeded = true
+			WHERE role_id = $1 AND NOT permissions_seeded`, roleID)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("seed permissions: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			if err := setRolePermissions(tx, roleID, DefaultPermissions(roleID)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("seed permissions: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("seed permissions: %w", err)
+		}
+	}
+	return rs.RefreshPermissions()
+}
+
+func setRolePermissions(tx *sql.Tx, roleID int, perms UserPermissions) error {
+	if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_id = $1`, roleID); err != nil {
+		return err
+	}
+	for _, name := range perms.Names() {
+		if _, err := tx.Exec(`INSERT INTO role_permissions (role_id, permission) VALUES ($1, $2)`, roleID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRole returns a single role with its permissions.
+func (rs *RoleService) GetRole(roleID int) (*Role, error) {
+	role := &Role{}
+	err := rs.DB.QueryRow(`
+		SELECT role_id, role_name, description, is_system, require_two_factor
+		FROM roles WHERE role_id = $1`, roleID).Scan(
+		&role.ID, &role.Name, &role.Description, &role.IsSystem, &role.RequireTwoFactor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("get role: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("get role: %w", err)
+	}
+	role.Permissions = GetPermissions(roleID)
+	return role, nil
+}
+
+// CreateRole adds a custom role with the given permissions.
+func (rs *RoleService) CreateRole(name, description string, perms UserPermissions) (*Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("create role: name is required")
+	}
+	tx, err := rs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkRoleName(tx, name, 0); err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	role := &Role{Name: name, Description: description, Permissions: perms}
+	err = tx.QueryRow(`
+		INSERT INTO roles (role_name, description, permissions_seeded)
+		VALUES ($1, $2, true) RETURNING role_id`, name, description).Scan(&role.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	if err := setRolePermissions(tx, role.ID, perms); err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	if err := rs.RefreshPermissions(); err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	return role, nil
+}
+
+// UpdateRole renames a role and replaces its permissions. The administrator
+// role always keeps every permission so the site cannot be locked out.
+func (rs *RoleService) UpdateRole(roleID int, name, description string, perms UserPermissions) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("update role: name is required")
+	}
+	if roleID == RoleAdministrator {
+		perms = DefaultPermissions(RoleAdministrator)
+	}
+	tx, err := rs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkRoleName(tx, name, roleID); err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	result, err := tx.Exec(`
+		UPDATE roles SET role_name = $2, description = $3, permissions_seeded = true
+		WHERE role_id = $1`, roleID, name, description)
+	if err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("update role: %w", ErrNotFound)
+	}
+	if err := setRolePermissions(tx, roleID, perms); err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	return rs.RefreshPermissions()
+}
+
+// DeleteRole removes a custom role that no user has.
+func (rs *RoleService) DeleteRole(roleID int) error {
+	var isSystem bool
+	err := rs.DB.QueryRow(`SELECT is_system FROM roles WHERE role_id = $1`, roleID).Scan(&isSystem)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("delete role: %w", ErrNotFound)
+		}
+		return fmt.Errorf("delete role: %w", err)
+	}
+	if isSystem {
+		return fmt.Errorf("delete role: %w", ErrSystemRole)
+	}
+	_, err = rs.DB.Exec(`DELETE FROM roles WHERE role_id = $1`, roleID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return fmt.Errorf("delete role: %w", ErrRoleInUse)
+		}
+		return fmt.Errorf("delete role: %w", err)
+	}
+	return rs.RefreshPermissions()
+}
+
+// UserCounts returns how many users have each role.
+func (rs *RoleService) UserCounts() (map[int]int, error) {
+	rows, err := rs.DB.Query(`SELECT role_id, COUNT(*) FROM users WHERE role_id IS NOT NULL GROUP BY role_id`)
+	if err != nil {
+		return nil, fmt.Errorf("role user counts: %w", err)
+	}
+	defer rows.Close()
+	counts := make(map[int]int)
+	for rows.Next() {
+		var roleID, n int
+		if err := rows.Scan(&roleID, &n); err != nil {
+			return nil, fmt.Errorf("role user counts: %w", err)
+		}
+		counts[roleID] = n
+	}
+	return counts, rows.Err()
+}
+
+func checkRoleName(tx *sql.Tx, name string, exceptID int) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE LOWER(role_name) = LOWER($1) AND role_id <> $2)`,
+		name, exceptID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrRoleNameTaken
+	}
+	return nil
+}
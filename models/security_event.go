@@ -0,0 +1,70 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Security event types.
+const (
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPBlocked       = "ip_blocked"
+)
+
+// SecurityEvent records something administrators may need to investigate,
+// such as an account being locked after repeated failed sign-ins.
+type SecurityEvent struct {
+	ID int
+	// UserID is 0 for events not tied to an account (e.g. a blocked IP).
+	UserID    int
+	Username  string
+	EventType string
+	IPAddress string
+	Detail    string
+	CreatedAt time.Time
+}
+
+type SecurityEventService struct {
+	DB *sql.DB
+}
+
+// Record stores an event. Pass userID 0 when no account is involved.
+func (ses *SecurityEventService) Record(userID int, eventType, ip, detail string) error {
+	var uid sql.NullInt64
+	if userID != 0 {
+		uid = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	_, err := ses.DB.Exec(`
+		INSERT INTO security_events (user_id, event_type, ip_address, detail)
+		VALUES ($1, $2, $3, $4)`, uid, eventType, ip, detail)
+	if err != nil {
+		return fmt.Errorf("record security event: %w", err)
+	}
+	return nil
+}
+
+// Recent returns the latest events, newest first.
+func (ses *SecurityEventService) Recent(limit int) ([]*SecurityEvent, error) {
+	rows, err := ses.DB.Query(`
+		SELECT e.id, COALESCE(e.user_id, 0), COALESCE(u.username, ''), e.event_type, e.ip_address, e.detail, e.created_at
+		FROM security_events e
+		LEFT JOIN users u ON u.user_id = e.user_id
+		ORDER BY e.created_at DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("recent security events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*SecurityEvent
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.EventType, &e.IPAddress, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("recent security events: %w", err)
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
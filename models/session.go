@@ -3,7 +3,6 @@ package models
 import (
 	"database/sql"
 	"fmt"
-	"strings"
 
 	"anshumanbiswas.com/blog/rand"
 	"golang.org/x/crypto/bcrypt"
@@ -81,15 +80,18 @@ func (ss *SessionService) GetSession(userID int) (int, error) {
 	row := ss.DB.QueryRow(query, userID)
 	err := row.Scan(&session.ID, &session.UserID, &session.TokenHash, &session.CreatedAt)
 
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		fmt.Errorf("User %d does not exist. Cannot create token: %w", userID, err)
+		return 0, fmt.Errorf("get session for user %d: %w", userID, err)
 	}
 	return session.ID, nil
 }
 
 func (ss *SessionService) User(token string, email string) (*User, error) {
 
-	email = strings.ToLower(email)
+	email = NormalizeEmail(email)
 
 	user := User{}
 	session := Session{
@@ -100,7 +102,7 @@ func (ss *SessionService) User(token string, email string) (*User, error) {
 		SELECT s.id, s.token_hash, u.user_id, u.username, u.role_id
 		FROM users AS u
 		INNER JOIN sessions AS s ON u.user_id = s.user_id
-		WHERE u.email LIKE $1`, email)
+		WHERE u.email LIKE $1 AND u.deactivated_at IS NULL`, email)
 
 	var dbUserID int
 	err := row.Scan(&session.ID, &session.TokenHash, &dbUserID, &user.Username, &user.Role)
@@ -119,7 +121,7 @@ func (ss *SessionService) User(token string, email string) (*User, error) {
 
 func (ss *SessionService) Logout(email string) {
 
-	email = strings.ToLower(email)
+	email = NormalizeEmail(email)
 
 	ss.DB.QueryRow(`DELETE FROM sessions WHERE user_id IN (SELECT user_id FROM users WHERE email LIKE $1)`, email)
 
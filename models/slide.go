@@ -27,6 +27,7 @@ type Slide struct {
 	CreatedAt       string
 	UpdatedAt       string
 	RelativeTime    string              // For displaying "10 months ago"
+	Version         int                 // Counts saves, for optimistic concurrency
 	Categories      []Category `json:"categories,omitempty"`
 }
 
@@ -87,13 +88,13 @@ func (ss *SlideService) Create(userID int, title, slug, content string, isPublis
 
 // GetBySlug retrieves a slide by its slug
 func (ss *SlideService) GetBySlug(slug string) (*Slide, error) {
-	query := `SELECT slide_id, user_id, title, slug, content_file_path, is_published, created_at, updated_at 
+	query := `SELECT slide_id, user_id, title, slug, content_file_path, is_published, created_at, updated_at, version 
 			  FROM Slides WHERE slug = $1`
 	
 	var slide Slide
 	err := ss.DB.QueryRow(query, slug).Scan(
 		&slide.ID, &slide.UserID, &slide.Title, &slide.Slug, &slide.ContentFilePath,
-		&slide.IsPublished, &slide.CreatedAt, &slide.UpdatedAt)
+		&slide.IsPublished, &slide.CreatedAt, &slide.UpdatedAt, &slide.Version)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -117,13 +118,13 @@ func (ss *SlideService) GetBySlug(slug string) (*Slide, error) {
 
 // GetByID retrieves a slide by its ID
 func (ss *SlideService) GetByID(id int) (*Slide, error) {
-	query := `SELECT slide_id, user_id, title, slug, content_file_path, is_published, created_at, updated_at 
+	query := `SELECT slide_id, user_id, title, slug, content_file_path, is_published, created_at, updated_at, version 
 			  FROM Slides WHERE slide_id = $1`
 	
 	var slide Slide
 	err := ss.DB.QueryRow(query, id).Scan(
 		&slide.ID, &slide.UserID, &slide.Title, &slide.Slug, &slide.ContentFilePath,
-		&slide.IsPublished, &slide.CreatedAt, &slide.UpdatedAt)
+		&slide.IsPublished, &slide.CreatedAt, &slide.UpdatedAt, &slide.Version)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -211,13 +212,18 @@ func (ss *SlideService) GetAllSlides() (*SlidesList, error) {
 	return &list, nil
 }
 
-// Update updates an existing slide
-func (ss *SlideService) Update(slideID int, title, slug, content string, isPublished bool, categoryIDs []int) error {
+// Update updates an existing slide edited from the given version of it,
+// returning ErrVersionConflict if it has been saved since or version is not
+// one it had.
+func (ss *SlideService) Update(slideID, version int, title, slug, content string, isPublished bool, categoryIDs []int) error {
 	// Get current slide to access file path
 	currentSlide, err := ss.GetByID(slideID)
 	if err != nil {
 		return err
 	}
+	if version != currentSlide.Version {
+		return fmt.Errorf("failed to update slide: %w", ErrVersionConflict)
+	}
 
 	// Sanitize slug
 	if slug == "" {
@@ -226,19 +232,23 @@ func (ss *SlideService) Update(slideID int, title, slug, content string, isPubli
 		slug = sanitizeSlug(slug)
 	}
 
-	// Update content file
-	if err := os.WriteFile(currentSlide.ContentFilePath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to update content file: %v", err)
-	}
-
-	// Update database record
-	query := `UPDATE Slides SET title = $1, slug = $2, is_published = $3, updated_at = CURRENT_TIMESTAMP 
-			  WHERE slide_id = $4`
+	// Update database record first: the version check there keeps a save
+	// that lost the race from writing the content file
+	query := `UPDATE Slides SET title = $1, slug = $2, is_published = $3, updated_at = CURRENT_TIMESTAMP, version = version + 1 
+			  WHERE slide_id = $4 AND version = $5`
 	
-	_, err = ss.DB.Exec(query, title, slug, isPublished, slideID)
+	result, err := ss.DB.Exec(query, title, slug, isPublished, slideID, version)
 	if err != nil {
 		return fmt.Errorf("failed to update slide: %v", err)
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to update slide: %w", ErrVersionConflict)
+	}
+
+	// Update content file
+	if err := os.WriteFile(currentSlide.ContentFilePath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to update content file: %v", err)
+	}
 
 	// Update categories
 	if err := ss.UpdateCategories(slideID, categoryIDs); err != nil {
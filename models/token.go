@@ -0,0 +1,24 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"anshumanbiswas.com/blog/rand"
+)
+
+// newLookupToken returns a random token and its SHA-256 hash. Unlike session
+// tokens, single-use tokens sent by email are looked up by hash, so they use a
+// deterministic digest instead of bcrypt.
+func newLookupToken() (token string, tokenHash string, err error) {
+	token, err = rand.String(rand.SessionTokenBytes)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashLookupToken(token), nil
+}
+
+func hashLookupToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.URLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,474 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"anshumanbiswas.com/blog/rand"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes are issued on enrollment.
+	RecoveryCodeCount = 10
+	// TwoFactorChallengeDuration is how long a user has to enter their code
+	// after a successful password check.
+	TwoFactorChallengeDuration = 5 * time.Minute
+	// MaxTwoFactorAttempts is how many codes can be tried against one
+	// challenge before it is discarded and the password has to be entered
+	// again.
+	MaxTwoFactorAttempts = 5
+
+	// totpPeriod is the TOTP time-step, as used by totp.Validate.
+	totpPeriod = 30
+)
+
+var (
+	// ErrInvalidTwoFactorCode is returned when a TOTP or recovery code does
+	// not match, or a TOTP code has already been used.
+	ErrInvalidTwoFactorCode = errors.New("models: invalid two-factor code")
+	// ErrTooManyTwoFactorAttempts is returned once a challenge has used up
+	// its attempts.
+	ErrTooManyTwoFactorAttempts = errors.New("models: too many two-factor attempts")
+)
+
+// TOTPEnrollment is a pending TOTP secret shown to the user while they add it
+// to their authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	URL    string
+	// QRCode is a data: URI of a PNG QR code encoding URL.
+	QRCode template.URL
+}
+
+type TwoFactorService struct {
+	DB *sql.DB
+	// Issuer is the account label shown in authenticator apps.
+	Issuer string
+}
+
+// IsEnabled reports whether the user has completed TOTP enrollment.
+func (tfs *TwoFactorService) IsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := tfs.DB.QueryRow(`SELECT enabled FROM user_totp WHERE user_id = $1`, userID).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("two-factor status: %w", err)
+	}
+	return enabled, nil
+}
+
+// EnabledUserIDs returns the set of users with TOTP enabled.
+func (tfs *TwoFactorService) EnabledUserIDs() (map[int]bool, error) {
+	rows, err := tfs.DB.Query(`SELECT user_id FROM user_totp WHERE enabled = true`)
+	if err != nil {
+		return nil, fmt.Errorf("two-factor users: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("two-factor users: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// BeginEnrollment generates a new secret for user and stores it as pending.
+// Any existing pending secret is replaced; an enabled one is left untouched.
+func (tfs *TwoFactorService) BeginEnrollment(user *User) (*TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      tfs.issuer(),
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("begin two-factor enrollment: %w", err)
+	}
+
+	result, err := tfs.DB.Exec(`
+		INSERT INTO user_totp (user_id, secret, enabled)
+		VALUES ($1, $2, false)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW()
+		WHERE user_totp.enabled = false`, user.UserID, key.Secret())
+	if err != nil {
+		return nil, fmt.Errorf("begin two-factor enrollment: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("begin two-factor enrollment: already enabled")
+	}
+
+	qr, err := qrDataURI(key)
+	if err != nil {
+		return nil, fmt.Errorf("begin two-factor enrollment: %w", err)
+	}
+	return &TOTPEnrollment{Secret: key.Secret(), URL: key.URL(), QRCode: qr}, nil
+}
+
+// PendingEnrollment returns the not-yet-confirmed enrollment for user, so the
+// setup page can be re-rendered after a wrong code without changing secrets.
+func (tfs *TwoFactorService) PendingEnrollment(user *User) (*TOTPEnrollment, error) {
+	var secret string
+	err := tfs.DB.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1 AND enabled = false`, user.UserID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("pending enrollment: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("pending enrollment: %w", err)
+	}
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + tfs.issuer() + ":" + user.Email,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {tfs.issuer()},
+		}.Encode(),
+	}
+	key, err := otp.NewKeyFromURL(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("pending enrollment: %w", err)
+	}
+	qr, err := qrDataURI(key)
+	if err != nil {
+		return nil, fmt.Errorf("pending enrollment: %w", err)
+	}
+	return &TOTPEnrollment{Secret: secret, URL: key.URL(), QRCode: qr}, nil
+}
+
+// ConfirmEnrollment enables TOTP for the user once they prove their
+// authenticator produces valid codes, and returns a fresh set of recovery
+// codes. The plain codes are only available here; only hashes are stored.
+func (tfs *TwoFactorService) ConfirmEnrollment(userID int, code string) ([]string, error) {
+	var secret string
+	err := tfs.DB.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1 AND enabled = false`, userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("confirm two-factor: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	step, ok := totpStep(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, fmt.Errorf("confirm two-factor: %w", ErrInvalidTwoFactorCode)
+	}
+
+	tx, err := tfs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_totp SET enabled = true, confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1`, userID, step)
+	if err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("confirm two-factor: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify checks code against the user's TOTP secret, falling back to their
+// unused recovery codes. A matching recovery code is burned, and a TOTP code
+// is refused if its time-step, or a later one, was already accepted.
+func (tfs *TwoFactorService) Verify(userID int, code string) error {
+	var secret string
+	err := tfs.DB.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1 AND enabled = true`, userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("verify two-factor: %w", ErrNotFound)
+		}
+		return fmt.Errorf("verify two-factor: %w", err)
+	}
+	if step, ok := totpStep(secret, normalizeCode(code), time.Now()); ok {
+		result, err := tfs.DB.Exec(`
+			UPDATE user_totp SET last_used_step = $2
+			WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)`, userID, step)
+		if err != nil {
+			return fmt.Errorf("verify two-factor: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("verify two-factor: code already used: %w", ErrInvalidTwoFactorCode)
+		}
+		return nil
+	}
+
+	rows, err := tfs.DB.Query(`SELECT id, code_hash FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("verify two-factor: %w", err)
+	}
+	defer rows.Close()
+
+	recovery := normalizeRecoveryCode(code)
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return fmt.Errorf("verify two-factor: %w", err)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(recovery)) == nil {
+			rows.Close()
+			result, err := tfs.DB.Exec(`UPDATE recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`, id)
+			if err != nil {
+				return fmt.Errorf("verify two-factor: %w", err)
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				break // burned concurrently
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("verify two-factor: %w", ErrInvalidTwoFactorCode)
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has.
+func (tfs *TwoFactorService) RemainingRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := tfs.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+// RegenerateRecoveryCodes invalidates the user's recovery codes and returns a
+// new set.
+func (tfs *TwoFactorService) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := tfs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("regenerate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable removes the user's TOTP secret and recovery codes. This is also the
+// administrator "reset 2FA" action.
+func (tfs *TwoFactorService) Disable(userID int) error {
+	tx, err := tfs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("disable two-factor: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RoleRequiresTwoFactor reports whether administrators have made 2FA
+// mandatory for roleID.
+func (tfs *TwoFactorService) RoleRequiresTwoFactor(roleID int) (bool, error) {
+	var required bool
+	err := tfs.DB.QueryRow(`SELECT require_two_factor FROM roles WHERE role_id = $1`, roleID).Scan(&required)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("role two-factor requirement: %w", err)
+	}
+	return required, nil
+}
+
+// SetRoleRequirement makes 2FA mandatory (or optional) for roleID.
+func (tfs *TwoFactorService) SetRoleRequirement(roleID int, required bool) error {
+	_, err := tfs.DB.Exec(`UPDATE roles SET require_two_factor = $1 WHERE role_id = $2`, required, roleID)
+	if err != nil {
+		return fmt.Errorf("set role two-factor requirement: %w", err)
+	}
+	return nil
+}
+
+// CreateChallenge records that userID passed the password check and must now
+// present a second factor. The returned token identifies the challenge.
+func (tfs *TwoFactorService) CreateChallenge(userID int) (string, error) {
+	token, tokenHash, err := newLookupToken()
+	if err != nil {
+		return "", fmt.Errorf("create two-factor challenge: %w", err)
+	}
+	_, err = tfs.DB.Exec(`
+		INSERT INTO two_factor_challenges (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`, userID, tokenHash, time.Now().UTC().Add(TwoFactorChallengeDuration))
+	if err != nil {
+		return "", fmt.Errorf("create two-factor challenge: %w", err)
+	}
+	return token, nil
+}
+
+// ChallengeUser returns the user a pending challenge belongs to.
+func (tfs *TwoFactorService) ChallengeUser(token string) (*User, error) {
+	var user User
+	var expiresAt time.Time
+	err := tfs.DB.QueryRow(`
+		SELECT c.expires_at, u.user_id, u.email, u.username, u.role_id
+		FROM two_factor_challenges c
+		JOIN users u ON u.user_id = c.user_id
+		WHERE c.token_hash = $1`, hashLookupToken(token)).Scan(
+		&expiresAt, &user.UserID, &user.Email, &user.Username, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("two-factor challenge: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("two-factor challenge: %w", err)
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return nil, fmt.Errorf("two-factor challenge: %w", ErrTokenExpired)
+	}
+	return &user, nil
+}
+
+// UseChallengeAttempt counts a code about to be checked against a pending
+// challenge and returns how many attempts are left after it. It is called
+// before the code is checked so that concurrent guesses cannot exceed
+// MaxTwoFactorAttempts; once they are used up the challenge is deleted and
+// ErrTooManyTwoFactorAttempts returned.
+func (tfs *TwoFactorService) UseChallengeAttempt(token string) (int, error) {
+	var attempts int
+	err := tfs.DB.QueryRow(`
+		UPDATE two_factor_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND attempts < $2
+		RETURNING attempts`, hashLookupToken(token), MaxTwoFactorAttempts).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := tfs.DeleteChallenge(token); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("two-factor attempt: %w", ErrTooManyTwoFactorAttempts)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("two-factor attempt: %w", err)
+	}
+	return MaxTwoFactorAttempts - attempts, nil
+}
+
+// DeleteChallenge removes a challenge once it has been completed, along with
+// any of the user's expired challenges.
+func (tfs *TwoFactorService) DeleteChallenge(token string) error {
+	_, err := tfs.DB.Exec(`DELETE FROM two_factor_challenges WHERE token_hash = $1 OR expires_at < NOW()`, hashLookupToken(token))
+	if err != nil {
+		return fmt.Errorf("delete two-factor challenge: %w", err)
+	}
+	return nil
+}
+
+func (tfs *TwoFactorService) issuer() string {
+	if tfs.Issuer == "" {
+		return "Anshuman Biswas Blog"
+	}
+	return tfs.Issuer
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, string(hash)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code formatted as xxxxx-xxxxx from an unambiguous
+// lowercase alphabet. Random bytes past the last whole multiple of the
+// alphabet's length are skipped, so every character is equally likely.
+func newRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	const limit = 256 - 256%len(alphabet)
+	out := make([]byte, 0, 11)
+	for len(out) < cap(out) {
+		b, err := rand.Bytes(16)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if int(c) >= limit || len(out) == cap(out) {
+				continue
+			}
+			if len(out) == 5 {
+				out = append(out, '-')
+			}
+			out = append(out, alphabet[int(c)%len(alphabet)])
+		}
+	}
+	return string(out), nil
+}
+
+// totpStep returns the time-step code belongs to, if it is a valid code for
+// secret at now. Like totp.Validate, it accepts the steps either side of the
+// current one to allow for clock drift.
+func totpStep(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if ok, err := totp.ValidateCustom(code, secret, time.Unix(step*totpPeriod, 0), opts); err == nil && ok {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func normalizeCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(normalizeCode(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+func qrDataURI(key *otp.Key) (template.URL, error) {
+	img, err := key.Image(220, 220)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -17,14 +18,21 @@ type User struct {
 	PasswordHash     string
 	RegistrationDate string
 	Role             int
+	EmailVerified    bool
 }
 
 type UserService struct {
 	DB *sql.DB
 }
 
+// NormalizeEmail is the form email addresses are stored and looked up in:
+// trimmed and lowercased.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func (us *UserService) Create(email, username, password string, role_id int) (*User, error) {
-	email = strings.ToLower(email)
+	email = NormalizeEmail(email)
 
 	hashedBytes, err := bcrypt.GenerateFromPassword(
 		[]byte(password), bcrypt.DefaultCost)
@@ -56,13 +64,13 @@ func (us *UserService) Create(email, username, password string, role_id int) (*U
 }
 
 func (us UserService) Authenticate(email, password string) (*User, error) {
-	email = strings.ToLower(email)
+	email = NormalizeEmail(email)
 	user := User{
 		Email: email,
 	}
 
-	row := us.DB.QueryRow(`SELECT user_id, username, password, role_id FROM users WHERE email=$1`, email)
-	err := row.Scan(&user.UserID, &user.Username, &user.PasswordHash, &user.Role)
+	row := us.DB.QueryRow(`SELECT user_id, username, password, role_id, email_verified FROM users WHERE email=$1`, email)
+	err := row.Scan(&user.UserID, &user.Username, &user.PasswordHash, &user.Role, &user.EmailVerified)
 	if err != nil {
 		return nil, fmt.Errorf("authenticate: %w", err)
 	}
@@ -126,7 +134,7 @@ func (us *UserService) UpdatePassword(userID int, newPassword string) error {
 }
 
 func (us *UserService) UpdateEmail(userID int, newEmail string) error {
-	newEmail = strings.ToLower(newEmail)
+	newEmail = NormalizeEmail(newEmail)
 	
 	_, err := us.DB.Exec("UPDATE Users SET email = $1 WHERE user_id = $2", newEmail, userID)
 	if err != nil {
@@ -135,3 +143,66 @@ func (us *UserService) UpdateEmail(userID int, newEmail string) error {
 
 	return nil
 }
+
+// MarkEmailVerified flags the user's current email address as confirmed.
+// Used for accounts created by administrators, whose address is trusted.
+func (us *UserService) MarkEmailVerified(userID int) error {
+	_, err := us.DB.Exec("UPDATE Users SET email_verified = true WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("mark email verified: %w", err)
+	}
+	return nil
+}
+
+// CreateExternal creates a user who signs in through an external identity
+// provider. The account has no usable password until one is set through the
+// password reset flow.
+func (us *UserService) CreateExternal(email, username string, roleID int, emailVerified bool) (*User, error) {
+	email = NormalizeEmail(email)
+
+	user := User{
+		Email:         email,
+		Username:      username,
+		Role:          roleID,
+		EmailVerified: emailVerified,
+	}
+	err := us.DB.QueryRow(`
+		INSERT INTO Users (email, username, password, role_id, registration_date, email_verified)
+		VALUES ($1, $2, '', $3, $4, $5) RETURNING user_id`,
+		email, username, roleID, time.Now().UTC(), emailVerified).Scan(&user.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("create external user: %w", err)
+	}
+	return &user, nil
+}
+
+// ByEmail looks up a user by email address.
+func (us *UserService) ByEmail(email string) (*User, error) {
+	user := User{Email: NormalizeEmail(email)}
+	err := us.DB.QueryRow(`SELECT user_id, username, role_id, email_verified FROM users WHERE email = $1`,
+		user.Email).Scan(&user.UserID, &user.Username, &user.Role, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user by email: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("user by email: %w", err)
+	}
+	return &user, nil
+}
+
+// UpdateRole changes the user's role.
+func (us *UserService) UpdateRole(userID, roleID int) error {
+	_, err := us.DB.Exec("UPDATE Users SET role_id = $1 WHERE user_id = $2", roleID, userID)
+	if err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedBytes), nil
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Statuses accepted by UserFilter.Status.
+const (
+	UserStatusActive      = "active"
+	UserStatusDeactivated = "deactivated"
+)
+
+// ErrReassignTarget is returned when a deleted user's content cannot be
+// handed to the chosen user.
+var ErrReassignTarget = errors.New("models: invalid user to reassign content to")
+
+// ManagedUser is a row on the user administration page.
+type ManagedUser struct {
+	UserID                int
+	Username              string
+	Email                 string
+	RoleID                int
+	RoleName              string
+	RegisteredAt          time.Time
+	EmailVerified         bool
+	Deactivated           bool
+	PasswordResetRequired bool
+	Locked                bool
+	PostCount             int
+	SlideCount            int
+}
+
+// UserFilter narrows UserService.Search. Zero values match everything.
+type UserFilter struct {
+	// Query matches part of the username or email.
+	Query  string
+	RoleID int
+	Status string
+}
+
+// AccountStatus holds the administrative flags checked at sign-in.
+type AccountStatus struct {
+	Deactivated           bool
+	PasswordResetRequired bool
+}
+
+// managedUserSelect is the column list shared by Search and
+// ManagedUserByID; it is scanned by scanManagedUser.
+const managedUserSelect = `
+	SELECT u.user_id, u.username, u.email, COALESCE(u.role_id, 0), COALESCE(r.role_name, ''),
+	       u.registration_date, u.email_verified, u.deactivated_at IS NOT NULL,
+	       u.password_reset_required, COALESCE(u.locked_until > NOW(), false),
+	       (SELECT COUNT(*) FROM posts p WHERE p.user_id = u.user_id),
+	       (SELECT COUNT(*) FROM slides s WHERE s.user_id = u.user_id)
+	FROM users u
+	LEFT JOIN roles r ON r.role_id = u.role_id`
+
+func scanManagedUser(row interface{ Scan(...interface{}) error }) (*ManagedUser, error) {
+	var mu ManagedUser
+	var registered sql.NullTime
+	err := row.Scan(&mu.UserID, &mu.Username, &mu.Email, &mu.RoleID, &mu.RoleName,
+		&registered, &mu.EmailVerified, &mu.Deactivated,
+		&mu.PasswordResetRequired, &mu.Locked, &mu.PostCount, &mu.SlideCount)
+	if err != nil {
+		return nil, err
+	}
+	mu.RegisteredAt = registered.Time
+	return &mu, nil
+}
+
+// Search lists users matching filter, ordered by username.
+func (us *UserService) Search(filter UserFilter) ([]*ManagedUser, error) {
+	pattern := ""
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		pattern = "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+	}
+	rows, err := us.DB.Query(managedUserSelect+`
+		WHERE ($1 = '' OR LOWER(u.email) LIKE $1 OR LOWER(u.username) LIKE $1)
+		  AND ($2 = 0 OR u.role_id = $2)
+		  AND ($3 = ''
+		       OR ($3 = 'active' AND u.deactivated_at IS NULL)
+		       OR ($3 = 'deactivated' AND u.deactivated_at IS NOT NULL))
+		ORDER BY LOWER(u.username), u.user_id`,
+		pattern, filter.RoleID, filter.Status)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*ManagedUser
+	for rows.Next() {
+		mu, err := scanManagedUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("search users: %w", err)
+		}
+		users = append(users, mu)
+	}
+	return users, rows.Err()
+}
+
+// likeEscaper escapes LIKE wildcards in user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ManagedUserByID returns a single user as shown on the administration page.
+func (us *UserService) ManagedUserByID(userID int) (*ManagedUser, error) {
+	mu, err := scanManagedUser(us.DB.QueryRow(managedUserSelect+` WHERE u.user_id = $1`, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("managed user: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("managed user: %w", err)
+	}
+	return mu, nil
+}
+
+// Status returns the administrative flags for userID.
+func (us *UserService) Status(userID int) (AccountStatus, error) {
+	var status AccountStatus
+	err := us.DB.QueryRow(`
+		SELECT deactivated_at IS NOT NULL, password_reset_required
+		FROM users WHERE user_id = $1`, userID).Scan(&status.Deactivated, &status.PasswordResetRequired)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return status, fmt.Errorf("account status: %w", ErrNotFound)
+		}
+		return status, fmt.Errorf("account status: %w", err)
+	}
+	return status, nil
+}
+
+// SetActive deactivates or reactivates userID. Deactivating also ends the
+// user's sessions.
+func (us *UserService) SetActive(userID int, active bool) error {
+	query := `UPDATE users SET deactivated_at = NOW() WHERE user_id = $1 AND deactivated_at IS NULL`
+	if active {
+		query = `UPDATE users SET deactivated_at = NULL WHERE user_id = $1`
+	}
+	if _, err := us.DB.Exec(query, userID); err != nil {
+		return fmt.Errorf("set user active: %w", err)
+	}
+	if !active {
+		if _, err := us.DB.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("set user active: %w", err)
+		}
+	}
+	return nil
+}
+
+// RequirePasswordReset makes userID choose a new password before their next
+// password sign-in and ends their sessions.
+func (us *UserService) RequirePasswordReset(userID int) error {
+	result, err := us.DB.Exec(`UPDATE users SET password_reset_required = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("require password reset: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("require password reset: %w", ErrNotFound)
+	}
+	if _, err := us.DB.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("require password reset: %w", err)
+	}
+	return nil
+}
+
+// Delete removes userID after handing their posts, slides and drafts to
+// reassignTo. Their comments are kept without an author and their likes are
+// removed. Everything happens in one transaction.
+func (us *UserService) Delete(userID, reassignTo int) error {
+	if reassignTo == userID {
+		return fmt.Errorf("delete user: %w", ErrReassignTarget)
+	}
+	tx, err := us.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	defer tx.Rollback()
+
+	var targetActive bool
+	err = tx.QueryRow(`SELECT deactivated_at IS NULL FROM users WHERE user_id = $1`, reassignTo).Scan(&targetActive)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("delete user: %w", ErrReassignTarget)
+		}
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if !targetActive {
+		return fmt.Errorf("delete user: %w", ErrReassignTarget)
+	}
+
+	reassign := []string{
+		`UPDATE posts SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE slides SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE drafts SET user_id = $2 WHERE user_id = $1`,
+	}
+	for _, stmt := range reassign {
+		if _, err := tx.Exec(stmt, userID, reassignTo); err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+	}
+	cleanup := []string{
+		`UPDATE comments SET user_id = NULL WHERE user_id = $1`,
+		`DELETE FROM likes WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+	}
+	for _, stmt := range cleanup {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("delete user: %w", ErrNotFound)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+// Package sso implements OpenID Connect sign-in against one or more external
+// identity providers: discovery, the authorization code flow with PKCE, and ID
+// token validation. It knows nothing about local accounts; controllers map the
+// returned Identity onto users.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/rand"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrNonceMismatch is returned when the ID token was not issued for the
+// authorization request that started the sign-in.
+var ErrNonceMismatch = errors.New("sso: id token nonce does not match")
+
+// ProviderConfig configures one identity provider.
+type ProviderConfig struct {
+	// Name identifies the provider in URLs (/auth/{name}/login) and in
+	// linked identities. It must not change once users have signed in.
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// GroupsClaim is the ID token claim listing the user's groups.
+	GroupsClaim string
+	// RoleMapping maps IdP group names to local role IDs.
+	RoleMapping map[string]int
+	// DefaultRole is used when none of the user's groups are mapped.
+	DefaultRole int
+	// SyncRoles re-applies RoleMapping on every sign-in, making the IdP the
+	// source of truth for roles. Otherwise it only applies on first login.
+	SyncRoles bool
+}
+
+// Identity is a user as asserted by a validated ID token.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+	Groups        []string
+}
+
+// Provider is a configured identity provider. Discovery happens on first
+// use, so an unreachable IdP does not prevent the server from starting.
+type Provider struct {
+	Config ProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewProvider(cfg ProviderConfig) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.DefaultRole == 0 {
+		cfg.DefaultRole = models.RoleCommenter
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	return &Provider{Config: cfg}
+}
+
+func (p *Provider) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.Config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", p.Config.Name, err)
+	}
+	p.provider = provider
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.Config.ClientID})
+	return p.provider, p.verifier, nil
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	scopes := append([]string{oidc.ScopeOpenID}, p.Config.Scopes...)
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// NewLoginSecrets returns a fresh nonce and PKCE code verifier for an
+// authorization request.
+func NewLoginSecrets() (nonce, codeVerifier string, err error) {
+	nonce, err = rand.String(32)
+	if err != nil {
+		return "", "", err
+	}
+	return nonce, oauth2.GenerateVerifier(), nil
+}
+
+// AuthCodeURL returns the IdP URL to send the browser to. The caller keeps
+// state, nonce and verifier until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, codeVerifier string) (string, error) {
+	provider, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider, redirectURL).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(codeVerifier),
+	), nil
+}
+
+// Exchange redeems an authorization code and validates the returned ID token.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, nonce, codeVerifier string) (*Identity, error) {
+	provider, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth2Config(provider, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("exchange code: no id_token in token response")
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("id token claims: %w", err)
+	}
+	identity := &Identity{
+		Provider:      p.Config.Name,
+		Subject:       idToken.Subject,
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		Username:      stringClaim(claims, "preferred_username"),
+		Groups:        stringsClaim(claims, p.Config.GroupsClaim),
+	}
+
+	// Some providers keep profile claims out of the ID token.
+	if identity.Email == "" {
+		info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err == nil && info.Subject == identity.Subject {
+			identity.Email = info.Email
+			identity.EmailVerified = info.EmailVerified
+		}
+	}
+	identity.Email = models.NormalizeEmail(identity.Email)
+	return identity, nil
+}
+
+// RoleFor returns the role identity should have according to the provider's
+// group mapping. When several groups match, the most privileged role wins.
+func (p *Provider) RoleFor(identity *Identity) int {
+	role := p.Config.DefaultRole
+	for _, group := range identity.Groups {
+		mapped, ok := p.Config.RoleMapping[group]
+		if ok && rolePrecedence(mapped) > rolePrecedence(role) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func rolePrecedence(roleID int) int {
+	switch roleID {
+	case models.RoleAdministrator:
+		return 4
+	case models.RoleEditor:
+		return 3
+	case models.RoleViewer:
+		return 2
+	case models.RoleCommenter:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(cfgs []ProviderConfig) *Registry {
+	reg := &Registry{providers: make(map[string]*Provider)}
+	for _, cfg := range cfgs {
+		reg.providers[cfg.Name] = NewProvider(cfg)
+	}
+	return reg
+}
+
+// Get returns the provider called name.
+func (reg *Registry) Get(name string) (*Provider, bool) {
+	if reg == nil {
+		return nil, false
+	}
+	p, ok := reg.providers[name]
+	return p, ok
+}
+
+// Providers returns all providers sorted by name, for rendering sign-in
+// buttons.
+func (reg *Registry) Providers() []*Provider {
+	if reg == nil {
+		return nil
+	}
+	list := make([]*Provider, 0, len(reg.providers))
+	for _, p := range reg.providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Config.Name < list[j].Config.Name })
+	return list
+}
+
+// ConfigsFromEnv reads provider configuration from the environment.
+// OIDC_PROVIDERS lists provider names; each is configured with
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and optionally
+// _DISPLAY_NAME, _SCOPES, _GROUPS_CLAIM, _ROLE_MAP, _DEFAULT_ROLE and
+// _SYNC_ROLES.
+func ConfigsFromEnv() ([]ProviderConfig, error) {
+	var cfgs []ProviderConfig
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		env := func(key string) string { return os.Getenv(prefix + key) }
+
+		cfg := ProviderConfig{
+			Name:         name,
+			DisplayName:  env("DISPLAY_NAME"),
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			Scopes:       splitList(env("SCOPES")),
+			GroupsClaim:  env("GROUPS_CLAIM"),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("sso: %sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+
+		mapping, err := ParseRoleMap(env("ROLE_MAP"))
+		if err != nil {
+			return nil, fmt.Errorf("sso: %sROLE_MAP: %w", prefix, err)
+		}
+		cfg.RoleMapping = mapping
+		if v := env("DEFAULT_ROLE"); v != "" {
+			if cfg.DefaultRole, err = ParseRole(v); err != nil {
+				return nil, fmt.Errorf("sso: %sDEFAULT_ROLE: %w", prefix, err)
+			}
+		}
+		cfg.SyncRoles, _ = strconv.ParseBool(env("SYNC_ROLES"))
+
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// ParseRoleMap parses "group:role,group:role" where role is a role name
+// (administrator, editor, viewer, commenter) or ID.
+func ParseRoleMap(s string) (map[string]int, error) {
+	mapping := make(map[string]int)
+	for _, pair := range splitList(s) {
+		group, role, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected group:role, got %q", pair)
+		}
+		roleID, err := ParseRole(role)
+		if err != nil {
+			return nil, err
+		}
+		mapping[strings.TrimSpace(group)] = roleID
+	}
+	return mapping, nil
+}
+
+// ParseRole accepts a role name or numeric ID.
+func ParseRole(s string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "administrator", "admin":
+		return models.RoleAdministrator, nil
+	case "editor":
+		return models.RoleEditor, nil
+	case "viewer":
+		return models.RoleViewer, nil
+	case "commenter":
+		return models.RoleCommenter, nil
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || rolePrecedence(id) == 0 {
+		return 0, fmt.Errorf("unknown role %q", s)
+	}
+	return id, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func boolClaim(claims map[string]interface{}, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// stringsClaim reads a claim that may be a list of strings or a single
+// (possibly space-separated) string.
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		return strings.Fields(v)
+	}
+	return nil
+}
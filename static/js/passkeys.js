@@ -0,0 +1,87 @@
+// Passkey (WebAuthn) registration and sign-in helpers.
+//
+// The server sends ceremony options with binary fields encoded as base64url
+// strings; the browser API wants ArrayBuffers, and the responses are sent
+// back base64url-encoded. The CSRF header is added by the fetch wrapper in
+// tailwind.gohtml.
+(function () {
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+        const binary = atob(padded);
+        const bytes = new Uint8Array(binary.length);
+        for (let i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes.buffer;
+    }
+
+    function toBase64URL(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        for (let i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    async function postJSON(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', 'Accept': 'application/json' },
+            body: body === undefined ? undefined : JSON.stringify(body),
+            credentials: 'same-origin'
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.error || 'Request failed');
+        }
+        return data;
+    }
+
+    function supported() {
+        return !!(window.PublicKeyCredential && navigator.credentials);
+    }
+
+    async function register(name) {
+        const options = await postJSON('/users/passkeys/register/begin');
+        const publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        publicKey.user.id = toBuffer(publicKey.user.id);
+        (publicKey.excludeCredentials || []).forEach(c => { c.id = toBuffer(c.id); });
+
+        const credential = await navigator.credentials.create({ publicKey });
+        return postJSON('/users/passkeys/register/finish?name=' + encodeURIComponent(name || ''), {
+            id: credential.id,
+            rawId: toBase64URL(credential.rawId),
+            type: credential.type,
+            response: {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                attestationObject: toBase64URL(credential.response.attestationObject),
+                transports: credential.response.getTransports ? credential.response.getTransports() : []
+            }
+        });
+    }
+
+    async function signIn() {
+        const options = await postJSON('/signin/passkey/begin');
+        const publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        (publicKey.allowCredentials || []).forEach(c => { c.id = toBuffer(c.id); });
+
+        const credential = await navigator.credentials.get({ publicKey });
+        return postJSON('/signin/passkey/finish', {
+            id: credential.id,
+            rawId: toBase64URL(credential.rawId),
+            type: credential.type,
+            response: {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                authenticatorData: toBase64URL(credential.response.authenticatorData),
+                signature: toBase64URL(credential.response.signature),
+                userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : null
+            }
+        });
+    }
+
+    window.Passkeys = { supported, register, signIn };
+})();
@@ -0,0 +1,118 @@
+package gotests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/mail"
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/views"
+)
+
+type adminUsersData struct {
+	Email           string
+	LoggedIn        bool
+	Username        string
+	IsAdmin         bool
+	SignupDisabled  bool
+	Description     string
+	CurrentPage     string
+	Flash           string
+	Users           []*models.ManagedUser
+	Invitations     []*models.Invitation
+	Roles           []*models.Role
+	Filter          models.UserFilter
+	CurrentUserID   int
+	UserPermissions models.UserPermissions
+	DeleteUser      *models.ManagedUser
+	ReassignTo      []*models.ManagedUser
+}
+
+func renderAdminUsers(t *testing.T, data adminUsersData) string {
+	t.Helper()
+	tpl, err := views.ParseFS(templates.FS, "admin-users.gohtml", "tailwind.gohtml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	data.LoggedIn = true
+	data.IsAdmin = true
+	data.UserPermissions = models.DefaultPermissions(models.RoleAdministrator)
+	data.Roles = []*models.Role{
+		{ID: models.RoleAdministrator, Name: "Administrator"},
+		{ID: models.RoleEditor, Name: "Editor"},
+	}
+	rec := httptest.NewRecorder()
+	tpl.Execute(rec, httptest.NewRequest("GET", "/admin/users", nil), data)
+	return rec.Body.String()
+}
+
+func TestAdminUsersTemplate_ListsUsersWithActions(t *testing.T) {
+	body := renderAdminUsers(t, adminUsersData{
+		CurrentUserID: 1,
+		Filter:        models.UserFilter{Status: models.UserStatusDeactivated},
+		Users: []*models.ManagedUser{
+			{UserID: 1, Username: "admin", Email: "admin@example.com", RoleID: models.RoleAdministrator, RoleName: "Administrator", EmailVerified: true},
+			{UserID: 7, Username: "gone", Email: "gone@example.com", RoleID: models.RoleEditor, RoleName: "Editor", Deactivated: true, PostCount: 3},
+		},
+	})
+
+	if !strings.Contains(body, `action="/admin/users/7/reactivate"`) {
+		t.Errorf("deactivated user should offer reactivation")
+	}
+	if strings.Contains(body, `action="/admin/users/7/deactivate"`) {
+		t.Errorf("deactivated user should not offer deactivation")
+	}
+	if !strings.Contains(body, `href="/admin/users/7/delete"`) {
+		t.Errorf("other users should be deletable")
+	}
+	for _, action := range []string{"/admin/users/1/role", "/admin/users/1/deactivate", "/admin/users/1/delete"} {
+		if strings.Contains(body, action) {
+			t.Errorf("signed-in administrator should not be offered %s", action)
+		}
+	}
+	if !strings.Contains(body, `value="deactivated" selected`) {
+		t.Errorf("status filter not preserved")
+	}
+}
+
+func TestAdminUsersTemplate_DeleteConfirmation(t *testing.T) {
+	body := renderAdminUsers(t, adminUsersData{
+		CurrentUserID: 1,
+		DeleteUser:    &models.ManagedUser{UserID: 7, Username: "author", Email: "author@example.com", PostCount: 4, SlideCount: 2},
+		ReassignTo: []*models.ManagedUser{
+			{UserID: 1, Username: "admin", Email: "admin@example.com"},
+		},
+	})
+
+	if !strings.Contains(body, `action="/admin/users/7/delete"`) {
+		t.Fatalf("delete form not rendered")
+	}
+	if !strings.Contains(body, "4 post(s) and 2 slide deck(s)") {
+		t.Errorf("content counts not shown")
+	}
+	if !strings.Contains(body, `<option value="1" selected>`) {
+		t.Errorf("signed-in administrator should be the default reassignment target")
+	}
+	if strings.Contains(body, `action="/admin/users/invite"`) {
+		t.Errorf("invite form should be hidden while confirming a delete")
+	}
+}
+
+func TestRender_InvitationTemplates(t *testing.T) {
+	text, html, err := mail.Render("invitation", map[string]string{
+		"Email":     "invitee@example.com",
+		"URL":       "http://localhost/reset-password?token=inv",
+		"ExpiresIn": "3 days",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(text, "http://localhost/reset-password?token=inv") || !strings.Contains(text, "3 days") {
+		t.Errorf("text body missing link or expiry: %s", text)
+	}
+	if !strings.Contains(html, "invitee@example.com") || !strings.Contains(html, "<html") {
+		t.Errorf("html body not rendered with layout: %s", html)
+	}
+}
@@ -0,0 +1,66 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func TestAdmonitions_BothSyntaxes(t *testing.T) {
+	src := "> [!WARNING]\n> Back up **first**.\n>\n> ```sh\n> pg_dump blog > blog.sql\n> ```\n\n" +
+		":::tip Pro <tip>\nUse `make dev`.\n:::\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<div class="admonition admonition-warning p-4 my-4 border-s-4 border-amber-500 bg-amber-50 dark:border-amber-400 dark:bg-amber-900/20"><p class="admonition-title font-semibold mb-2">Warning</p>`,
+			"<p>Back up <strong>first</strong>.</p>",
+			`<pre><code class="language-sh">pg_dump blog &gt; blog.sql`,
+			`<div class="admonition admonition-tip`,
+			`<p class="admonition-title font-semibold mb-2">Pro &lt;tip&gt;</p>`,
+			"<p>Use <code>make dev</code>.</p>",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+		if strings.Contains(out, "<blockquote") || strings.Contains(out, ":::") {
+			t.Errorf("%s: admonition left as source:\n%s", engine, out)
+		}
+	}
+}
+
+func TestAdmonitions_NestedAndIgnored(t *testing.T) {
+	src := "::::note\nOuter\n\n:::danger\nInner\n:::\n::::\n\n> [!BOGUS]\n> A quote.\n\n```\n:::info\n:::\n```\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		inner := strings.Index(out, "admonition-danger")
+		if inner < 0 || strings.Index(out, "admonition-note") > inner || strings.Count(out, `class="admonition `) != 2 {
+			t.Errorf("%s: containers not nested:\n%s", engine, out)
+		}
+		if !strings.Contains(out, "<blockquote") || !strings.Contains(out, ":::info") {
+			t.Errorf("%s: unknown kind or code was converted:\n%s", engine, out)
+		}
+	}
+}
+
+func TestFootnotesAndDefinitionLists(t *testing.T) {
+	src := "Goroutines are cheap.[^cost]\n\nChannel\n: A typed conduit.\n\n[^cost]: A few KB of stack each.\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<sup`,
+			`href="#user-content-fn:`,
+			`<div class="footnotes mt-8 pt-4 text-sm text-gray-600 dark:text-gray-400">`,
+			`href="#user-content-fnref:`,
+			`class="footnote-return"`,
+			`<dl class="my-4">`,
+			`<dt class="font-semibold mt-2">Channel</dt>`,
+			`<dd class="ms-6 mb-2 text-gray-700 dark:text-gray-300">A typed conduit.</dd>`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package gotests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/views"
+)
+
+func TestAuditActions_Grouped(t *testing.T) {
+	seen := map[string]bool{}
+	types := map[string]bool{}
+	for _, tt := range models.AuditTargetTypes {
+		types[tt] = true
+	}
+	for _, a := range models.AuditActions {
+		if seen[a] {
+			t.Errorf("duplicate audit action %q", a)
+		}
+		seen[a] = true
+		group, _, ok := strings.Cut(a, ".")
+		if !ok || group == "" {
+			t.Errorf("audit action %q has no group prefix", a)
+		}
+	}
+	if len(types) != len(models.AuditTargetTypes) {
+		t.Errorf("duplicate audit target types: %v", models.AuditTargetTypes)
+	}
+}
+
+func TestAdminAuditTemplate(t *testing.T) {
+	tpl, err := views.ParseFS(templates.FS, "admin-audit.gohtml", "tailwind.gohtml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	type page struct {
+		Email           string
+		LoggedIn        bool
+		Username        string
+		IsAdmin         bool
+		SignupDisabled  bool
+		Description     string
+		CurrentPage     string
+		Events          []*models.AuditEvent
+		Actions         []string
+		Filter          models.AuditFilter
+		From            string
+		To              string
+		TargetTypes     []string
+		ExportCSV       string
+		ExportJSON      string
+		Truncated       bool
+		UserPermissions models.UserPermissions
+	}
+
+	rec := httptest.NewRecorder()
+	tpl.Execute(rec, httptest.NewRequest("GET", "/admin/audit?action=user.delete&from=2024-01-02", nil), page{
+		LoggedIn:    true,
+		IsAdmin:     true,
+		Actions:     models.AuditActions,
+		TargetTypes: models.AuditTargetTypes,
+		Filter:      models.AuditFilter{Action: models.AuditUserDelete},
+		From:        "2024-01-02",
+		ExportCSV:   "/admin/audit?action=user.delete&format=csv&from=2024-01-02",
+		Events: []*models.AuditEvent{{
+			ID: 1, OccurredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), ActorName: "admin",
+			Action: models.AuditUserDelete, TargetType: "user", TargetID: "7",
+			TargetLabel: "<b>gone@example.com</b>", IPAddress: "203.0.113.9", Before: "Editor",
+		}},
+	})
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`<option value="user.delete" selected>`,
+		`value="2024-01-02"`,
+		`href="/admin/audit?action=user.delete&amp;format=csv&amp;from=2024-01-02"`,
+		"2024-01-02 03:04:05",
+		"&lt;b&gt;gone@example.com&lt;/b&gt;",
+		"user #7",
+		"203.0.113.9",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("audit page missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package gotests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authmw "anshumanbiswas.com/blog/middleware"
+	"github.com/gorilla/csrf"
+)
+
+func csrfTestHandler() http.Handler {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return authmw.CSRFProtect(key, false)(ok)
+}
+
+func TestCSRF_RejectsFormPostWithoutToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/users/password", strings.NewReader("new_password=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	csrfTestHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for POST without token, got %d", rec.Code)
+	}
+}
+
+func TestCSRF_AllowsSafeMethods(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+	rec := httptest.NewRecorder()
+	csrfTestHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for GET, got %d", rec.Code)
+	}
+}
+
+func TestCSRF_ExemptsBearerTokenRequests(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer some-api-token")
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	csrfTestHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected bearer request to skip CSRF check, got %d", rec.Code)
+	}
+}
+
+func TestCSRF_AcceptsHeaderToken(t *testing.T) {
+	h := csrfTestHandler()
+
+	// Fetch a page first to obtain the CSRF cookie and a masked token.
+	var token string
+	issue := authmw.CSRFProtect([]byte("0123456789abcdef0123456789abcdef"), false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = csrf.Token(r)
+	}))
+	getRec := httptest.NewRecorder()
+	issue.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "/admin/posts/new", nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/preview", strings.NewReader("content=hi"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(authmw.CSRFHeader, token)
+	for _, c := range getRec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with valid header token, got %d", rec.Code)
+	}
+}
+
+// tokenPost issues a CSRF cookie and token from a GET, then builds a form
+// POST that carries both, as a browser would after loading the page.
+func tokenPost(t *testing.T, secure bool) *http.Request {
+	t.Helper()
+	var token string
+	issue := authmw.CSRFProtect([]byte("0123456789abcdef0123456789abcdef"), secure)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = csrf.Token(r)
+	}))
+	getRec := httptest.NewRecorder()
+	issue.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "https://example.com/admin/posts/new", nil))
+
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/admin/preview", strings.NewReader("content=hi"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(authmw.CSRFHeader, token)
+	for _, c := range getRec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestCSRF_SecureChecksReferer(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := authmw.CSRFProtect(key, true)(ok)
+
+	cases := []struct {
+		name    string
+		referer string
+		want    int
+	}{
+		{"same origin", "https://example.com/admin/posts/new", http.StatusOK},
+		{"missing", "", http.StatusForbidden},
+		{"foreign", "https://evil.example/page", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tokenPost(t, true)
+			if tc.referer != "" {
+				req.Header.Set("Referer", tc.referer)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("Referer %q: expected %d, got %d", tc.referer, tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCSRF_RejectsForeignOriginWithValidToken(t *testing.T) {
+	for _, secure := range []bool{true, false} {
+		key := []byte("0123456789abcdef0123456789abcdef")
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := tokenPost(t, secure)
+		req.Header.Set("Origin", "https://evil.example")
+		rec := httptest.NewRecorder()
+		authmw.CSRFProtect(key, secure)(ok).ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("secure=%v: expected 403 for a foreign Origin, got %d", secure, rec.Code)
+		}
+	}
+}
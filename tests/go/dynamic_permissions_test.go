@@ -0,0 +1,167 @@
+package gotests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	authmw "anshumanbiswas.com/blog/middleware"
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/views"
+)
+
+const guestAuthorRole = 5
+
+// withPermissionCache installs cached permissions for the duration of a test.
+func withPermissionCache(t *testing.T, byRole map[int]models.UserPermissions) {
+	t.Helper()
+	models.SetPermissionCache(byRole)
+	t.Cleanup(func() { models.SetPermissionCache(nil) })
+}
+
+func TestPermissions_NamesRoundTrip(t *testing.T) {
+	for _, roleID := range []int{models.RoleCommenter, models.RoleAdministrator, models.RoleEditor, models.RoleViewer} {
+		p := models.DefaultPermissions(roleID)
+		if got := models.PermissionsFromNames(p.Names()); !reflect.DeepEqual(got, p) {
+			t.Errorf("role %d: round trip gave %+v, want %+v", roleID, got, p)
+		}
+	}
+	if p := models.PermissionsFromNames([]string{"edit_posts", "not_a_permission"}); p.Names()[0] != models.PermEditPosts || len(p.Names()) != 1 {
+		t.Errorf("unknown names should be ignored: %+v", p)
+	}
+}
+
+func TestPermissions_CacheOverridesDefaults(t *testing.T) {
+	withPermissionCache(t, map[int]models.UserPermissions{
+		models.RoleViewer: {CanComment: true},
+		guestAuthorRole:   models.PermissionsFromNames([]string{models.PermComment, models.PermEditPosts}),
+	})
+
+	if models.GetPermissions(models.RoleViewer).CanViewUnpublished {
+		t.Errorf("cached viewer permissions should replace the defaults")
+	}
+	if !models.GetPermissions(models.RoleEditor).CanPublishPosts {
+		t.Errorf("roles missing from the cache should keep their defaults")
+	}
+	guest := models.GetPermissions(guestAuthorRole)
+	if !guest.CanEditPosts || guest.CanPublishPosts || models.IsAdmin(guestAuthorRole) {
+		t.Errorf("unexpected guest author permissions: %+v", guest)
+	}
+}
+
+func TestCanManageSecurity_NotImpliedByAdminArea(t *testing.T) {
+	withPermissionCache(t, map[int]models.UserPermissions{
+		guestAuthorRole: models.PermissionsFromNames([]string{models.PermViewAdmin}),
+	})
+	if !models.IsAdmin(guestAuthorRole) || models.CanManageSecurity(guestAuthorRole) {
+		t.Errorf("admin area access should not grant security settings")
+	}
+	if !models.CanManageSecurity(models.RoleAdministrator) {
+		t.Errorf("administrators should manage security")
+	}
+}
+
+func TestCanEditPost_GuestAuthorOnlyOwnDrafts(t *testing.T) {
+	withPermissionCache(t, map[int]models.UserPermissions{
+		guestAuthorRole: models.PermissionsFromNames([]string{models.PermEditPosts}),
+	})
+	guest := &models.User{UserID: 7, Role: guestAuthorRole}
+	editor := &models.User{UserID: 8, Role: models.RoleEditor}
+	admin := &models.User{UserID: 1, Role: models.RoleAdministrator}
+
+	ownDraft := &models.Post{UserID: 7}
+	ownPublished := &models.Post{UserID: 7, IsPublished: true}
+	othersDraft := &models.Post{UserID: 9}
+
+	if !models.CanEditPost(guest, ownDraft) {
+		t.Errorf("guest author should edit their own draft")
+	}
+	if models.CanEditPost(guest, ownPublished) {
+		t.Errorf("guest author should not edit a published post")
+	}
+	if models.CanEditPost(guest, othersDraft) {
+		t.Errorf("guest author should not edit someone else's draft")
+	}
+	if !models.CanEditPost(editor, &models.Post{UserID: 8, IsPublished: true}) {
+		t.Errorf("editor should edit their own published posts")
+	}
+	if models.CanEditPost(editor, othersDraft) {
+		t.Errorf("editor without manage_all_posts should not edit others' posts")
+	}
+	if !models.CanEditPost(admin, othersDraft) {
+		t.Errorf("admin should edit any post")
+	}
+}
+
+func TestRequirePermission_UsesCachedPermissions(t *testing.T) {
+	handler := authmw.RequirePermission(func(p models.UserPermissions) bool { return p.CanPublishPosts })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	serve := func(role int) int {
+		req := httptest.NewRequest("POST", "/publish", nil)
+		req = req.WithContext(context.WithValue(req.Context(), authmw.UserContextKey, &models.User{UserID: 1, Role: role}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(models.RoleEditor); code != http.StatusNoContent {
+		t.Fatalf("editor should pass with default permissions, got %d", code)
+	}
+	withPermissionCache(t, map[int]models.UserPermissions{
+		models.RoleEditor: models.PermissionsFromNames([]string{models.PermEditPosts}),
+	})
+	if code := serve(models.RoleEditor); code != http.StatusForbidden {
+		t.Fatalf("editor should be refused once publish_posts is removed, got %d", code)
+	}
+}
+
+func TestAdminRolesTemplate_RendersPermissionMatrix(t *testing.T) {
+	tpl, err := views.ParseFS(templates.FS, "admin-roles.gohtml", "tailwind.gohtml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	data := struct {
+		Email           string
+		LoggedIn        bool
+		Username        string
+		IsAdmin         bool
+		SignupDisabled  bool
+		Description     string
+		CurrentPage     string
+		Flash           string
+		Roles           []*models.Role
+		UserCounts      map[int]int
+		Permissions     []models.PermissionInfo
+		AdminRoleID     int
+		UserPermissions models.UserPermissions
+	}{
+		LoggedIn: true,
+		IsAdmin:  true,
+		Roles: []*models.Role{
+			{ID: models.RoleAdministrator, Name: "Administrator", IsSystem: true, Permissions: models.DefaultPermissions(models.RoleAdministrator)},
+			{ID: guestAuthorRole, Name: "Guest Author", Permissions: models.PermissionsFromNames([]string{models.PermEditPosts})},
+		},
+		UserCounts:      map[int]int{models.RoleAdministrator: 1},
+		Permissions:     models.AllPermissions,
+		AdminRoleID:     models.RoleAdministrator,
+		UserPermissions: models.DefaultPermissions(models.RoleAdministrator),
+	}
+	rec := httptest.NewRecorder()
+	tpl.Execute(rec, httptest.NewRequest("GET", "/admin/roles", nil), data)
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `value="Guest Author"`) {
+		t.Fatalf("custom role not rendered")
+	}
+	if !strings.Contains(body, `action="/admin/roles/5/delete"`) {
+		t.Errorf("custom role should be deletable")
+	}
+	if strings.Contains(body, `action="/admin/roles/2/delete"`) {
+		t.Errorf("built-in role should not be deletable")
+	}
+}
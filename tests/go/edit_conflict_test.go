@@ -0,0 +1,300 @@
+package gotests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/controllers"
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/utils"
+	"anshumanbiswas.com/blog/views"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDiffText(t *testing.T) {
+	saved := "# Title\r\n\r\nFirst paragraph.\r\n\r\nSecond paragraph.\r\n"
+	if d := render.DiffText(saved, strings.ReplaceAll(saved, "\r\n", "\n")); d != nil {
+		t.Errorf("line endings alone differ: %v", d)
+	}
+	yours := "# Title\n\nFirst paragraph, edited.\n\nSecond paragraph.\n"
+	want := []render.DiffLine{
+		{Op: " ", Text: "# Title"},
+		{Op: " ", Text: ""},
+		{Op: "-", Text: "First paragraph."},
+		{Op: "+", Text: "First paragraph, edited."},
+		{Op: " ", Text: ""},
+		{Op: " ", Text: "Second paragraph."},
+		{Op: "~", Text: "…"},
+	}
+	got := render.DiffText(saved, yours)
+	if len(got) != len(want) {
+		t.Fatalf("diff = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiffText_LongTexts(t *testing.T) {
+	var saved, yours []string
+	for i := 0; i < 3000; i++ {
+		line := fmt.Sprintf("line %d", i)
+		saved = append(saved, line)
+		if i%100 == 50 {
+			line = fmt.Sprintf("edited %d", i)
+		}
+		yours = append(yours, line)
+	}
+	var got []string
+	for _, l := range render.DiffText(strings.Join(saved, "\n"), strings.Join(yours, "\n")) {
+		if l.Op == "-" || l.Op == "+" {
+			got = append(got, l.Op+l.Text)
+		}
+	}
+	if len(got) != 60 {
+		t.Fatalf("%d changed lines, want 60", len(got))
+	}
+	for i := 0; i < 30; i++ {
+		n := i*100 + 50
+		if got[2*i] != fmt.Sprintf("-line %d", n) || got[2*i+1] != fmt.Sprintf("+edited %d", n) {
+			t.Fatalf("change %d = %q, %q", i, got[2*i], got[2*i+1])
+		}
+	}
+}
+
+func TestPostService_UpdateRejectsStaleVersion(t *testing.T) {
+	db, _ := countingDB(t)
+	posts := &models.PostService{DB: db}
+	created, _ := testPost(t, db)
+	post, err := posts.GetByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(version int, content string) error {
+		return posts.Update(post.ID, version, post.CategoryID, post.Title, content, false, false, "", post.Slug, false)
+	}
+
+	if err := save(post.Version, "First save.\n"); err != nil {
+		t.Fatalf("save over the current version: %v", err)
+	}
+	if err := save(post.Version, "Second save.\n"); !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("save over a stale version: %v, want ErrVersionConflict", err)
+	}
+	saved, err := posts.GetByID(post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Content != "First save.\n" || saved.Version != post.Version+1 {
+		t.Errorf("saved version %d with %q", saved.Version, saved.Content)
+	}
+	if err := save(0, "Overwrite.\n"); !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("save without a version: %v, want ErrVersionConflict", err)
+	}
+}
+
+func TestSlideService_UpdateRejectsStaleVersion(t *testing.T) {
+	db, _ := countingDB(t)
+	// Slide content is written under static/ in the working directory.
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	slides := &models.SlideService{DB: db}
+	user := testEditor(t, db)
+	created, err := slides.Create(user.UserID, "Test slides", user.Username, "<section>Saved</section>", false, nil)
+	if err != nil {
+		t.Fatalf("create slide: %v", err)
+	}
+	t.Cleanup(func() { slides.Delete(created.ID) })
+	slide, err := slides.GetByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(version int, content string) error {
+		return slides.Update(slide.ID, version, slide.Title, slide.Slug, content, false, nil)
+	}
+
+	if err := save(slide.Version, "<section>First</section>"); err != nil {
+		t.Fatalf("save over the current version: %v", err)
+	}
+	if err := save(slide.Version, "<section>Second</section>"); !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("save over a stale version: %v, want ErrVersionConflict", err)
+	}
+	saved, err := slides.GetByID(slide.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved.ContentHTML) != "<section>First</section>" || saved.Version != slide.Version+1 {
+		t.Errorf("saved version %d with %q", saved.Version, saved.ContentHTML)
+	}
+	if err := save(0, "<section>Overwrite</section>"); !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("save without a version: %v, want ErrVersionConflict", err)
+	}
+}
+
+func TestPostsAPI_IfMatch(t *testing.T) {
+	db, _ := countingDB(t)
+	post, user := testPost(t, db)
+	h := postsAPIWith(controllers.Users{
+		PostService:     &models.PostService{DB: db},
+		CategoryService: &models.CategoryService{DB: db},
+	}, user)
+	path := fmt.Sprintf("/api/v1/posts/%d", post.ID)
+	do := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("GET", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET: %d %s", rec.Code, rec.Body)
+	}
+	fetched := rec.Header().Get("ETag")
+	if !strings.HasPrefix(fetched, `"v`) {
+		t.Fatalf("GET ETag = %q", fetched)
+	}
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		for _, ifMatch := range []string{"", "*"} {
+			if rec = do(method, ifMatch, `{"title":"Unversioned"}`); rec.Code != http.StatusPreconditionRequired {
+				t.Errorf("%s with If-Match %q: %d %s", method, ifMatch, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	rec = do("PATCH", fetched, `{"title":"First edit"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag: %d %s", rec.Code, rec.Body)
+	}
+	current := rec.Header().Get("ETag")
+	if current == fetched || current == "" {
+		t.Fatalf("ETag after a save = %q, fetched %q", current, fetched)
+	}
+
+	rec = do("PATCH", fetched, `{"title":"Second edit"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("PATCH with a stale ETag: %d %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != current {
+		t.Errorf("409 ETag = %q, want the current %q", got, current)
+	}
+	var conflict struct {
+		Error controllers.APIError `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &conflict)
+	if e := conflict.Error; e.Code != "version_conflict" || `"v`+strconv.Itoa(e.CurrentVersion)+`"` != current ||
+		len(e.Changed) != 1 || e.Changed[0] != "title" {
+		t.Errorf("409 body = %s", rec.Body)
+	}
+	if rec = do("DELETE", fetched, ""); rec.Code != http.StatusConflict {
+		t.Errorf("DELETE with a stale ETag: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do("GET", "", "")
+	var got struct {
+		Title string `json:"title"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.Title != "First edit" || rec.Header().Get("ETag") != current {
+		t.Errorf("after the refused edits: title %q, ETag %q", got.Title, rec.Header().Get("ETag"))
+	}
+}
+
+func TestUpdatePost_StaleVersionShowsConflict(t *testing.T) {
+	db, _ := countingDB(t)
+	post, user := testPost(t, db)
+	posts := &models.PostService{DB: db}
+	saved, err := posts.GetByID(post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Someone else saves after the editor was opened at saved.Version.
+	if err := posts.Update(post.ID, saved.Version, saved.CategoryID, saved.Title, "theirs\n", false, false, "", saved.Slug, false); err != nil {
+		t.Fatal(err)
+	}
+	sessions := &models.SessionService{DB: db}
+	session, err := sessions.Create(user.UserID)
+	if err != nil || session == nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	u := controllers.Users{
+		SessionService:  sessions,
+		PostService:     posts,
+		CategoryService: &models.CategoryService{DB: db},
+	}
+	u.Templates.EditConflict = views.Must(views.ParseFS(templates.FS, "edit-conflict.gohtml", "tailwind.gohtml"))
+	r := chi.NewRouter()
+	r.Post("/admin/posts/{postID}", u.UpdatePost)
+
+	action := fmt.Sprintf("/admin/posts/%d", post.ID)
+	form := url.Values{
+		"title":      {"My title"},
+		"content":    {"mine\n"},
+		"categories": {strconv.Itoa(saved.CategoryID)},
+		"version":    {strconv.Itoa(saved.Version)},
+	}
+	req := httptest.NewRequest("POST", action, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: utils.CookieSession, Value: session.Token})
+	req.AddCookie(&http.Cookie{Name: utils.CookieUserEmail, Value: user.Email})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409", rec.Code)
+	}
+	page := html.UnescapeString(rec.Body.String())
+	version := fmt.Sprintf(`name="version" value="%d"`, saved.Version+1)
+	for _, want := range []string{
+		`action="` + action + `"`,
+		version,
+		`name="title" value="My title"`,
+		`name="categories" value="` + strconv.Itoa(saved.CategoryID) + `"`,
+		`<textarea name="content"`,
+		`name="content" value="mine`,
+		`href="` + action + `/edit"`,
+		"- theirs",
+		"+ mine",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("conflict page lacks %q", want)
+		}
+	}
+	if n := strings.Count(page, version); n != 2 {
+		t.Errorf("%d forms save over the current version, want both", n)
+	}
+	if current, _ := posts.GetByID(post.ID); current.Content != "theirs\n" {
+		t.Errorf("the stale save overwrote the post: %q", current.Content)
+	}
+
+	// A form without a version cannot save over whatever is stored.
+	form.Set("version", "")
+	req = httptest.NewRequest("POST", action, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: utils.CookieSession, Value: session.Token})
+	req.AddCookie(&http.Cookie{Name: utils.CookieUserEmail, Value: user.Email})
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("save without a version: status %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,89 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func renderHighlighted(engine, content string) string {
+	opt := render.DefaultOptions()
+	opt.Engine = engine
+	opt.Filters = map[string]bool{render.FilterHighlight: true}
+	return render.NewRenderer(opt).Render(content)
+}
+
+func TestHighlight_OffByDefault(t *testing.T) {
+	out := render.NewRenderer(render.DefaultOptions()).Render("```go {2}\nx := 1\n```\n")
+	if !strings.Contains(out, `<pre><code class="language-go">x := 1`) || strings.Contains(out, "chroma") {
+		t.Errorf("code should be left for the browser:\n%s", out)
+	}
+}
+
+func TestHighlight_RangesTitleAndLineNumbers(t *testing.T) {
+	src := "```go {2-3} title=\"cmd/main_test.go\"\npackage main\nvar a = \"<b>\"\nvar b = 2\nvar c = 3\n```\n"
+	for _, engine := range render.Engines {
+		out := renderHighlighted(engine, src)
+		for _, want := range []string{
+			`<figure class="code-block"><figcaption class="code-title">cmd/main_test.go</figcaption><pre class="chroma"><code>`,
+			`<span class="line"><span class="ln">1</span><span class="cl"><span class="kn">package</span>`,
+			`<span class="line hl"><span class="ln">2</span>`,
+			`<span class="line hl"><span class="ln">3</span>`,
+			`<span class="line"><span class="ln">4</span>`,
+			`&lt;b&gt;`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+		if strings.Contains(out, "{2-3}") {
+			t.Errorf("%s: meta leaked into the code:\n%s", engine, out)
+		}
+	}
+}
+
+func TestHighlight_NoLineNumbers(t *testing.T) {
+	out := renderHighlighted(render.EngineLegacy, "```python nolinenos\na = 1\nb = 2\n```\n")
+	if strings.Contains(out, `class="ln"`) || !strings.Contains(out, `class="chroma"`) {
+		t.Errorf("line numbers should be off:\n%s", out)
+	}
+}
+
+func TestHighlight_DiffBlocks(t *testing.T) {
+	src := "```diff-go\n-a := 1\n+a := 2\n b := 3\n```\n"
+	for _, engine := range render.Engines {
+		out := renderHighlighted(engine, src)
+		for _, want := range []string{
+			`<span class="line gd"><span class="ln">1</span><span class="dm">-</span><span class="cl"><span class="nx">a</span>`,
+			`<span class="line gi"><span class="ln">2</span><span class="dm">+</span>`,
+			`<span class="line"><span class="ln">3</span><span class="dm"> </span>`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestHighlight_CodeFirstLineIsNotMeta(t *testing.T) {
+	out := renderHighlighted(render.EngineLegacy, "```js\n{ a: 1 }\n```\n")
+	if !strings.Contains(out, "a") || !strings.Contains(out, `<span class="p">{</span>`) {
+		t.Errorf("first line of code was dropped:\n%s", out)
+	}
+}
+
+func TestHighlightCSS(t *testing.T) {
+	css, err := render.HighlightCSS("github", "github-dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\n/* Keyword */ .chroma .k {", "/* Keyword */ .dark .chroma .k {", ".code-block .line.hl"} {
+		if !strings.Contains(css, want) {
+			t.Errorf("stylesheet is missing %q", want)
+		}
+	}
+	if _, err := render.HighlightCSS("no-such-theme", "github-dark"); err == nil {
+		t.Errorf("unknown themes should be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+package gotests
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/views"
+)
+
+func TestInvitation_Status(t *testing.T) {
+	now := time.Now()
+	set := sql.NullTime{Time: now, Valid: true}
+	tests := []struct {
+		name string
+		inv  models.Invitation
+		want string
+		open bool
+	}{
+		{"pending", models.Invitation{ExpiresAt: now.Add(time.Hour)}, models.InvitationPending, true},
+		{"expired", models.Invitation{ExpiresAt: now.Add(-time.Minute)}, models.InvitationExpired, true},
+		{"accepted", models.Invitation{ExpiresAt: now.Add(-time.Hour), AcceptedAt: set}, models.InvitationAccepted, false},
+		{"revoked", models.Invitation{ExpiresAt: now.Add(time.Hour), RevokedAt: set}, models.InvitationRevoked, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.inv.Status(); got != tt.want {
+				t.Errorf("Status() = %q, want %q", got, tt.want)
+			}
+			if got := tt.inv.Open(); got != tt.open {
+				t.Errorf("Open() = %t, want %t", got, tt.open)
+			}
+		})
+	}
+}
+
+func TestAdminUsersTemplate_ListsInvitations(t *testing.T) {
+	now := time.Now()
+	body := renderAdminUsers(t, adminUsersData{
+		CurrentUserID: 1,
+		Invitations: []*models.Invitation{
+			{ID: 3, Email: "pending@example.com", RoleName: "Editor", ExpiresAt: now.Add(time.Hour), LastSentAt: now, SentCount: 2},
+			{ID: 4, Email: "joined@example.com", RoleName: "Editor", ExpiresAt: now, LastSentAt: now, SentCount: 1,
+				AcceptedAt: sql.NullTime{Time: now, Valid: true}},
+		},
+	})
+
+	if !strings.Contains(body, `action="/admin/invitations/3/resend"`) || !strings.Contains(body, `action="/admin/invitations/3/revoke"`) {
+		t.Errorf("pending invitation should offer resend and revoke")
+	}
+	if strings.Contains(body, `/admin/invitations/4/`) {
+		t.Errorf("accepted invitation should not offer actions")
+	}
+	if !strings.Contains(body, "(2 times)") {
+		t.Errorf("resend count not shown")
+	}
+}
+
+func TestAcceptInvitationTemplate(t *testing.T) {
+	tpl, err := views.ParseFS(templates.FS, "accept-invitation.gohtml", "tailwind.gohtml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	type page struct {
+		Email           string
+		LoggedIn        bool
+		SignupDisabled  bool
+		IsAdmin         bool
+		Description     string
+		CurrentPage     string
+		Username        string
+		Message         string
+		Token           string
+		InvalidReason   string
+		RoleName        string
+		UserPermissions models.UserPermissions
+	}
+
+	rec := httptest.NewRecorder()
+	tpl.Execute(rec, httptest.NewRequest("GET", "/accept-invitation?token=abc", nil), page{
+		Email: "new@example.com", RoleName: "Editor", Token: "abc", SignupDisabled: true,
+	})
+	body := rec.Body.String()
+	if !strings.Contains(body, `name="token" value="abc"`) || !strings.Contains(body, "new@example.com") {
+		t.Errorf("acceptance form not rendered:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	tpl.Execute(rec, httptest.NewRequest("GET", "/accept-invitation?token=old", nil), page{
+		InvalidReason: "This invitation has expired.",
+	})
+	body = rec.Body.String()
+	if !strings.Contains(body, "This invitation has expired.") || strings.Contains(body, `action="/accept-invitation"`) {
+		t.Errorf("invalid invitation should show the reason instead of the form")
+	}
+}
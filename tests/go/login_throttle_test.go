@@ -0,0 +1,88 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/mail"
+	"anshumanbiswas.com/blog/models"
+)
+
+func TestLoginPolicy_ProgressiveDelay(t *testing.T) {
+	p := models.LoginPolicy{BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second}
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{7, 16 * time.Second},
+		{8, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, c := range cases {
+		if got := p.Delay(c.failures, 3); got != c.want {
+			t.Errorf("Delay(%d, 3) = %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
+
+func TestLoginPolicy_DefaultsLockBeforeDelaysGetLong(t *testing.T) {
+	p := models.DefaultLoginPolicy
+	if p.MaxAccountFailures <= p.AccountFreeAttempts {
+		t.Fatalf("account lockout must come after the free attempts")
+	}
+	if p.MaxIPFailures <= p.MaxAccountFailures {
+		t.Fatalf("an IP should be able to fail more often than a single account")
+	}
+	if d := p.Delay(p.MaxAccountFailures-1, p.AccountFreeAttempts); d > p.MaxDelay || d == 0 {
+		t.Fatalf("unexpected delay before lockout: %s", d)
+	}
+}
+
+func TestLoginCheck_Allowed(t *testing.T) {
+	if !(models.LoginCheck{}).Allowed() {
+		t.Errorf("zero check should allow the attempt")
+	}
+	if !(models.LoginCheck{RetryAfter: -time.Second}).Allowed() {
+		t.Errorf("an elapsed delay should allow the attempt")
+	}
+	if (models.LoginCheck{RetryAfter: time.Second}).Allowed() {
+		t.Errorf("a pending delay should block the attempt")
+	}
+	if (models.LoginCheck{LockedUntil: time.Now().Add(time.Minute)}).Allowed() {
+		t.Errorf("a locked account should block the attempt")
+	}
+}
+
+func TestRender_AccountLockedTemplates(t *testing.T) {
+	text, html, err := mail.Render("account_locked", map[string]string{
+		"Email":     "owner@example.com",
+		"URL":       "http://localhost/forgot-password?email=owner%40example.com",
+		"ExpiresIn": "15 minutes",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(text, "15 minutes") || !strings.Contains(text, "/forgot-password") {
+		t.Errorf("text body missing duration or link: %s", text)
+	}
+	if !strings.Contains(html, "owner@example.com") || !strings.Contains(html, "<html") {
+		t.Errorf("html body not rendered with layout: %s", html)
+	}
+}
+
+func TestAuthenticate_NormalizesEmailAsTheThrottleDoes(t *testing.T) {
+	db, _ := countingDB(t)
+	user := testEditor(t, db)
+	typed := "  " + strings.ToUpper(user.Email) + " "
+	if got := models.NormalizeEmail(typed); got != user.Email {
+		t.Fatalf("NormalizeEmail(%q) = %q, want %q", typed, got, user.Email)
+	}
+	if _, err := (models.UserService{DB: db}).Authenticate(typed, "correct horse battery"); err != nil {
+		t.Errorf("sign in as %q: %v", typed, err)
+	}
+}
@@ -0,0 +1,120 @@
+package gotests
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/mail"
+)
+
+// fakeSMTP is a minimal MailHog-style SMTP stand-in that accepts a single
+// message and hands its raw DATA section back over a channel.
+func fakeSMTP(t *testing.T) (host string, port int, messages <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		reply := func(line string) {
+			rw.WriteString(line + "\r\n")
+			rw.Flush()
+		}
+		reply("220 localhost fake SMTP")
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var body strings.Builder
+				for {
+					l, err := rw.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					body.WriteString(l)
+				}
+				out <- body.String()
+				reply("250 OK queued")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func TestSMTPSender_DeliversMultipartMessage(t *testing.T) {
+	host, port, messages := fakeSMTP(t)
+	sender := &mail.SMTPSender{Config: mail.SMTPConfig{Host: host, Port: port, From: "blog@example.com"}}
+	es := mail.NewEmailService(sender)
+
+	err := es.SendPasswordReset("reader@example.com", "http://localhost/reset-password?token=abc123", time.Hour)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	select {
+	case msg := <-messages:
+		for _, want := range []string{
+			"To: reader@example.com",
+			"From: blog@example.com",
+			"multipart/alternative",
+			"text/plain",
+			"text/html",
+			"token=3Dabc123", // quoted-printable encoding of "token=abc123"
+			"1 hour",
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("message missing %q:\n%s", want, msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestRender_EmailVerificationTemplates(t *testing.T) {
+	text, html, err := mail.Render("email_verification", map[string]string{
+		"Email":     "new@example.com",
+		"URL":       "http://localhost/verify-email?token=xyz",
+		"ExpiresIn": "2 days",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(text, "http://localhost/verify-email?token=xyz") {
+		t.Errorf("text body missing link: %s", text)
+	}
+	if !strings.Contains(html, "new@example.com") || !strings.Contains(html, "<html") {
+		t.Errorf("html body not rendered with layout: %s", html)
+	}
+}
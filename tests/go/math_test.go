@@ -0,0 +1,108 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func renderWithEngine(engine, content string) string {
+	opt := render.DefaultOptions()
+	opt.Engine = engine
+	return render.NewRenderer(opt).Render(content)
+}
+
+func TestMath_InlineAndDisplay(t *testing.T) {
+	src := "Euler: $e^{i\\pi} + 1 = 0$.\n\n$$\n\\sum_{k=1}^{n} k = \\frac{n(n+1)}{2}\n$$\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<math><semantics><mrow><msup><mi>e</mi><mrow><mi>i</mi><mi>π</mi></mrow></msup><mo>+</mo><mn>1</mn>`,
+			`<annotation encoding="application/x-tex">e^{i\pi} + 1 = 0</annotation>`,
+			`<div class="math-display"><math display="block">`,
+			`<munderover><mo largeop="true" movablelimits="true">∑</mo><mrow><mi>k</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover>`,
+			`<mfrac><mrow><mi>n</mi><mo>(</mo>`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestMath_FenceAndEnvironments(t *testing.T) {
+	src := "```math\nf(x) = \\begin{cases} 1 & x \\ge 0 \\\\ 0 & \\text{otherwise} \\end{cases}\n```\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		want := `<mrow><mo fence="true" stretchy="true">{</mo><mtable columnalign="left"><mtr><mtd><mn>1</mn></mtd><mtd><mi>x</mi><mo>≥</mo><mn>0</mn></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow>`
+		if !strings.Contains(out, want) || strings.Contains(out, "language-math") {
+			t.Errorf("%s: math fence not rendered:\n%s", engine, out)
+		}
+	}
+}
+
+// The MathML must come through the emphasis filter untouched: TeX is full
+// of underscores and asterisks.
+func TestMath_NotRewrittenAsEmphasis(t *testing.T) {
+	src := "Compare $a_1 * b_2 * c_3$ with *real* emphasis.\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		if !strings.Contains(out, `<msub><mi>a</mi><mn>1</mn></msub><mo>∗</mo><msub><mi>b</mi><mn>2</mn></msub>`) ||
+			!strings.Contains(out, "<em>real</em>") {
+			t.Errorf("%s: unexpected output:\n%s", engine, out)
+		}
+		if strings.Contains(out, "<em>1") || strings.Contains(out, "<em> b") {
+			t.Errorf("%s: formula was rewritten as emphasis:\n%s", engine, out)
+		}
+	}
+}
+
+func TestMath_LeavesCodeAndPricesAlone(t *testing.T) {
+	src := "It costs $5 and $10 today.\n\nRun `echo $HOME$` now.\n\n```sh\necho \"$a$\"\n```\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		if strings.Contains(out, "<math") {
+			t.Errorf("%s: dollars outside math became MathML:\n%s", engine, out)
+		}
+		for _, want := range []string{"costs $5 and $10 today", "<code>echo $HOME$</code>", "echo &#34;$a$&#34;"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestMath_FallsBackToSource(t *testing.T) {
+	src := "Broken $\\frac{1}{$ and $\\unknown{x}$ stay readable.\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<code class="math-tex" title="Could not render formula: unknown command \unknown">$\unknown{x}$</code>`,
+			`$\frac{1}{$`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestMath_ScriptsAreEscaped(t *testing.T) {
+	out := renderWithEngine(render.EngineLegacy, "$<script>alert(1)</script>$")
+	if strings.Contains(out, "<script") || !strings.Contains(out, "<mo>&lt;</mo>") {
+		t.Errorf("markup in TeX should be text:\n%s", out)
+	}
+}
+
+func TestMath_FilterCanBeDisabled(t *testing.T) {
+	for _, engine := range render.Engines {
+		opt := render.DefaultOptions()
+		opt.Engine = engine
+		opt.Filters = map[string]bool{render.FilterMath: false}
+		out := render.NewRenderer(opt).Render("Inline $x^2$ here.\n")
+		if strings.Contains(out, "<math") || !strings.Contains(out, "$x^2$") {
+			t.Errorf("%s: math should be off:\n%s", engine, out)
+		}
+	}
+}
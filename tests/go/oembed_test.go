@@ -0,0 +1,156 @@
+package gotests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+// memEmbeds is an in-memory render.EmbedStore.
+type memEmbeds struct {
+	mu sync.Mutex
+	m  map[string]render.Embed
+}
+
+func (s *memEmbeds) Embed(url string) (render.Embed, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.m[url]
+	return e, ok, nil
+}
+
+func (s *memEmbeds) SaveEmbed(e render.Embed) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[e.URL] = e
+	return nil
+}
+
+const vimeoLink = "https://vimeo.com/76979871"
+
+// fakeVimeo starts a resolver whose Vimeo provider is a local server
+// answering with body and status, and installs it for the oembed filter.
+func fakeVimeo(t *testing.T, status int, body string) (*render.OEmbedResolver, *memEmbeds, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("url") != vimeoLink {
+			http.Error(w, "unexpected url", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	store := &memEmbeds{m: map[string]render.Embed{}}
+	res := render.NewOEmbedResolver(store)
+	res.Providers = nil
+	for _, p := range render.OEmbedProviders {
+		if p.Name == "Vimeo" {
+			p.Endpoint = srv.URL
+		}
+		res.Providers = append(res.Providers, p)
+	}
+	render.SetOEmbedResolver(res)
+	t.Cleanup(func() { render.SetOEmbedResolver(nil) })
+	return res, store, &calls
+}
+
+const vimeoResponse = `{"type":"video","title":"The New Vimeo Player","provider_name":"Vimeo",` +
+	`"html":"<iframe src=\"https://player.vimeo.com/video/76979871\" width=\"640\" height=\"360\" allowfullscreen></iframe><script>alert(1)</script>"}`
+
+func TestOEmbed_LinkCardWithoutCache(t *testing.T) {
+	src := vimeoLink + "\n\n[Gist](https://gist.github.com/octocat/6cad326836d38bd3a7ae)\n\nhttps://example.com/page\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<div class="embed-card"><a href="https://vimeo.com/76979871" target="_blank" rel="noopener"><span class="embed-provider">Vimeo</span>`,
+			`<span class="embed-provider">GitHub Gist</span>`,
+			`<a href="https://example.com/page">`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestOEmbed_ResolvedEmbedIsSanitized(t *testing.T) {
+	res, store, _ := fakeVimeo(t, http.StatusOK, vimeoResponse)
+	e, err := res.Resolve(context.Background(), vimeoLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(e.HTML, "<script") || e.Title != "The New Vimeo Player" {
+		t.Errorf("cached embed = %+v", e)
+	}
+	if cached, ok, _ := store.Embed(vimeoLink); !ok || time.Until(cached.Expires) < 24*time.Hour {
+		t.Errorf("embed not cached with the TTL: %+v", cached)
+	}
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, "Watch this:\n\n"+vimeoLink+"\n")
+		want := `<div class="embed embed-vimeo"><iframe src="https://player.vimeo.com/video/76979871" width="640" height="360" allowfullscreen=""></iframe></div>`
+		if !strings.Contains(out, want) || strings.Contains(out, "alert") {
+			t.Errorf("%s: unexpected output:\n%s", engine, out)
+		}
+	}
+}
+
+// Rendering only queues the lookup; the worker fetches it once.
+func TestOEmbed_RenderQueuesFetch(t *testing.T) {
+	res, store, calls := fakeVimeo(t, http.StatusOK, vimeoResponse)
+	for i := 0; i < 3; i++ {
+		if out := renderWithEngine(render.EngineLegacy, vimeoLink); !strings.Contains(out, "embed-card") {
+			t.Fatalf("first renders should show the card:\n%s", out)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go res.Run(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok, _ := store.Embed(vimeoLink); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("embed was not fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("provider called %d times, want 1", n)
+	}
+	if out := renderWithEngine(render.EngineLegacy, vimeoLink); !strings.Contains(out, `class="embed embed-vimeo"`) {
+		t.Errorf("embed not used after fetch:\n%s", out)
+	}
+}
+
+func TestOEmbed_Failures(t *testing.T) {
+	res, store, _ := fakeVimeo(t, http.StatusNotFound, "")
+	if _, err := res.Resolve(context.Background(), vimeoLink); err == nil {
+		t.Fatal("expected an error from the provider")
+	}
+	e, ok, _ := store.Embed(vimeoLink)
+	if !ok || !e.Failed || time.Until(e.Expires) > 2*time.Hour {
+		t.Errorf("failure not cached for the retry period: %+v", e)
+	}
+	if out := renderWithEngine(render.EngineAST, vimeoLink); !strings.Contains(out, "embed-card") {
+		t.Errorf("failed lookup should show the card:\n%s", out)
+	}
+
+	// A refresh that fails keeps the last good embed.
+	_ = store.SaveEmbed(render.Embed{URL: vimeoLink, Provider: "Vimeo", HTML: "<p>old embed</p>", Expires: time.Now().Add(-time.Minute)})
+	_, _ = res.Resolve(context.Background(), vimeoLink)
+	if e, _, _ := store.Embed(vimeoLink); e.Failed || e.HTML != "<p>old embed</p>" || !e.Expires.After(time.Now()) {
+		t.Errorf("stale embed lost: %+v", e)
+	}
+}
@@ -0,0 +1,80 @@
+package gotests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/models"
+)
+
+func TestNewPasskeyService_ValidatesRelyingParty(t *testing.T) {
+	_, err := models.NewPasskeyService(nil, models.PasskeyConfig{
+		RPID:    "localhost",
+		RPName:  "Blog",
+		Origins: []string{"http://localhost:22222"},
+	})
+	if err != nil {
+		t.Fatalf("expected valid config to be accepted, got %v", err)
+	}
+
+	_, err = models.NewPasskeyService(nil, models.PasskeyConfig{
+		RPName:  "Blog",
+		Origins: []string{"http://localhost:22222"},
+	})
+	if err == nil {
+		t.Fatalf("expected config without an RP ID to be rejected")
+	}
+}
+
+func TestPasskeyService_DiscoverableLoginOptions(t *testing.T) {
+	ps, err := models.NewPasskeyService(nil, models.PasskeyConfig{
+		RPID:    "localhost",
+		RPName:  "Blog",
+		Origins: []string{"http://localhost:22222"},
+	})
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	// BeginLogin stores the ceremony, so exercise the underlying options only.
+	assertion, session, err := ps.WebAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if assertion.Response.RelyingPartyID != "localhost" {
+		t.Fatalf("unexpected rpId %q", assertion.Response.RelyingPartyID)
+	}
+	if session.UserID != nil {
+		t.Fatalf("discoverable login should not be bound to a user")
+	}
+}
+
+func TestPasskeyService_BeginLoginPurgesExpiredCeremonies(t *testing.T) {
+	db, _ := countingDB(t)
+	ps, err := models.NewPasskeyService(db, models.PasskeyConfig{
+		RPID:    "localhost",
+		RPName:  "Blog",
+		Origins: []string{"http://localhost:22222"},
+	})
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	stale := fmt.Sprintf("abandoned-%d", time.Now().UnixNano())
+	_, err = db.Exec(`
+		INSERT INTO webauthn_ceremonies (purpose, token_hash, session_data, expires_at)
+		VALUES ('login', $1, '{}', NOW() - INTERVAL '1 minute')`, stale)
+	if err != nil {
+		t.Fatalf("insert abandoned ceremony: %v", err)
+	}
+
+	// The new ceremony expires on its own and a later one purges it.
+	if _, _, err := ps.BeginLogin(); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM webauthn_ceremonies WHERE token_hash = $1`, stale).Scan(&n)
+	if n != 0 {
+		t.Errorf("abandoned ceremony was not purged")
+	}
+}
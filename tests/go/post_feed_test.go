@@ -0,0 +1,107 @@
+package gotests
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/controllers"
+	"anshumanbiswas.com/blog/models"
+)
+
+func TestPostCursor_RoundTrip(t *testing.T) {
+	c := models.PostCursor{Date: time.Date(2024, 3, 9, 14, 5, 7, 123456000, time.UTC), ID: 42}
+	got, err := models.DecodeCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Date.Equal(c.Date) || got.ID != c.ID {
+		t.Errorf("decoded %+v, want %+v", got, c)
+	}
+	for _, bad := range []string{"", "not base64!", "MjAyNC0wMy0wOQ", c.Encode() + "!"} {
+		if _, err := models.DecodeCursor(bad); !errors.Is(err, models.ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestParsePostFilters(t *testing.T) {
+	v := url.Values{}
+	v.Set("category", "3")
+	v.Set("tag", "Go")
+	v.Set("author", "anshuman")
+	v.Set("featured", "true")
+	v.Set("from", "2024-01-01")
+	v.Set("to", "2024-01-31")
+	q, err := models.ParsePostFilters(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.CategoryID != 3 || q.Tag != "Go" || q.Author != "anshuman" || q.Featured == nil || !*q.Featured {
+		t.Errorf("filters = %+v", q)
+	}
+	// to is inclusive: posts on the 31st are still in range.
+	if want := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC); !q.To.Equal(want) {
+		t.Errorf("To = %v, want %v", q.To, want)
+	}
+	if got := q.Values().Encode(); got != v.Encode() {
+		t.Errorf("Values() = %q, want %q", got, v.Encode())
+	}
+
+	for _, bad := range []string{"category=x", "featured=maybe", "from=01/02/2024", "to=2024-13-01", "cursor=abc"} {
+		v, _ := url.ParseQuery(bad)
+		if _, err := models.ParsePostFilters(v); err == nil {
+			t.Errorf("ParsePostFilters(%q) accepted", bad)
+		}
+	}
+}
+
+func TestListPostPage_CursorsDoNotRepeat(t *testing.T) {
+	db, _ := countingDB(t)
+	posts := &models.PostService{DB: db}
+
+	seen := map[int]bool{}
+	q := models.PostQuery{PublishedOnly: true, Limit: 2}
+	for page := 0; page < 50; page++ {
+		list, err := posts.ListPostPage(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range list.Posts {
+			if seen[p.ID] {
+				t.Fatalf("post %d appeared on two pages", p.ID)
+			}
+			seen[p.ID] = true
+		}
+		if list.NextCursor == "" {
+			return
+		}
+		c, err := models.DecodeCursor(list.NextCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.After = &c
+	}
+}
+
+func TestFeed_DatabaseFailureIs500(t *testing.T) {
+	// Nothing listens on port 1, so every query fails.
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=blog dbname=blog sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	u := controllers.Users{PostService: &models.PostService{DB: db}}
+
+	for path, h := range map[string]http.HandlerFunc{"/": u.Home, "/api/posts/load-more": u.LoadMorePosts} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status %d, want 500", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package gotests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/models"
+	"github.com/lib/pq"
+)
+
+// countingConnector counts the statements run on its connections. They
+// do not implement the Queryer interfaces, so database/sql prepares every
+// query through Prepare.
+type countingConnector struct {
+	driver.Connector
+	queries int64
+}
+
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn: conn, queries: &c.queries}, nil
+}
+
+type countingConn struct {
+	conn    driver.Conn
+	queries *int64
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt64(c.queries, 1)
+	return c.conn.Prepare(query)
+}
+func (c *countingConn) Close() error              { return c.conn.Close() }
+func (c *countingConn) Begin() (driver.Tx, error) { return c.conn.Begin() }
+
+// countingDB connects like setupTestDB, skipping the test when there is
+// no database.
+func countingDB(t *testing.T) (*sql.DB, *countingConnector) {
+	t.Helper()
+	env := func(key, fallback string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		env("PG_HOST", "127.0.0.1"), env("PG_PORT", "5433"), env("PG_USER", "blog"), env("PG_PASSWORD", "1234"), env("PG_DB", "blog"))
+	base, err := pq.NewConnector(dsn)
+	if err != nil {
+		t.Skipf("no database: %v", err)
+	}
+	connector := &countingConnector{Connector: base}
+	db := sql.OpenDB(connector)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Skipf("no database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, connector
+}
+
+func TestListPosts_ConstantQueries(t *testing.T) {
+	db, counter := countingDB(t)
+	posts := &models.PostService{DB: db}
+
+	for _, q := range []models.PostQuery{{Limit: 1}, {}, {PublishedOnly: true, Limit: 5}} {
+		atomic.StoreInt64(&counter.queries, 0)
+		list, err := posts.ListPosts(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int64(3)
+		if len(list) == 0 {
+			want = 1 // no categories or tags to load
+		}
+		if n := atomic.LoadInt64(&counter.queries); n != want {
+			t.Errorf("%+v: %d posts took %d queries, want %d", q, len(list), n, want)
+		}
+		for _, p := range list {
+			if p.Username == "" {
+				t.Errorf("post %d has no author", p.ID)
+			}
+		}
+	}
+}
+
+// testEditor creates an editor, removing them when the test ends.
+func testEditor(t *testing.T, db *sql.DB) *models.User {
+	t.Helper()
+	name := fmt.Sprintf("editor%d", time.Now().UnixNano())
+	user, err := (&models.UserService{DB: db}).Create(name+"@example.com", name, "correct horse battery", models.RoleEditor)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM sessions WHERE user_id = $1`, user.UserID)
+		db.Exec(`DELETE FROM users WHERE user_id = $1`, user.UserID)
+	})
+	return user
+}
+
+// testPost creates a draft post in a new category by a new editor,
+// removing all three when the test ends.
+func testPost(t *testing.T, db *sql.DB) (*models.Post, *models.User) {
+	t.Helper()
+	user := testEditor(t, db)
+	categories := &models.CategoryService{DB: db}
+	category, err := categories.Create(user.Username)
+	if err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM categories WHERE category_id = $1`, category.ID) })
+	post, err := (&models.PostService{DB: db}).Create(user.UserID, category.ID, "Test post", "Saved content.\n", false, false, "", user.Username, false)
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM post_tags WHERE post_id = $1`, post.ID)
+		db.Exec(`DELETE FROM posts WHERE post_id = $1`, post.ID)
+	})
+	if err := categories.AssignCategoriesToPost(post.ID, []int{category.ID}); err != nil {
+		t.Fatalf("assign category: %v", err)
+	}
+	return post, user
+}
+
+func TestListPosts_LoadsTags(t *testing.T) {
+	db, _ := countingDB(t)
+	post, _ := testPost(t, db)
+	for _, name := range []string{"web", "go"} {
+		var tagID int
+		if err := db.QueryRow(`INSERT INTO tags (tag_name) VALUES ($1) RETURNING tag_id`, name).Scan(&tagID); err != nil {
+			t.Fatalf("create tag: %v", err)
+		}
+		t.Cleanup(func() {
+			db.Exec(`DELETE FROM post_tags WHERE tag_id = $1`, tagID)
+			db.Exec(`DELETE FROM tags WHERE tag_id = $1`, tagID)
+		})
+		if _, err := db.Exec(`INSERT INTO post_tags (post_id, tag_id) VALUES ($1, $2)`, post.ID, tagID); err != nil {
+			t.Fatalf("tag post: %v", err)
+		}
+	}
+
+	list, err := (&models.PostService{DB: db}).ListPosts(models.PostQuery{ID: post.ID})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list = %v, %v", list, err)
+	}
+	if got := strings.Join(list[0].Tags, ","); got != "go,web" {
+		t.Errorf("tags = %q, want go,web", got)
+	}
+}
+
+func TestGetFeedPage_PreviewRendersOnce(t *testing.T) {
+	db, _ := countingDB(t)
+	post, user := testPost(t, db)
+	posts := &models.PostService{DB: db}
+	first := strings.Repeat("Opening words. ", 12)
+	content := first + "\n\n" + strings.Repeat("More words. ", 10) + "\n\n- a list\n- of items\n"
+	if err := posts.Update(post.ID, post.Version, post.CategoryID, post.Title, content, true, false, "", post.Slug, false); err != nil {
+		t.Fatal(err)
+	}
+	cache := render.NewRenderCache(10)
+	render.SetRenderCache(cache)
+	defer render.SetRenderCache(nil)
+
+	page, err := posts.GetFeedPage(models.PostQuery{UserID: user.UserID})
+	if err != nil || len(page.Posts) != 1 {
+		t.Fatalf("feed: %v, %+v", err, page)
+	}
+	// The first paragraph is kept though it alone is over the limit.
+	preview := string(page.Posts[0].ContentHTML)
+	if !strings.Contains(preview, strings.TrimSpace(first)) || strings.Contains(preview, "More words") || strings.Contains(preview, "<ul") {
+		t.Errorf("preview = %s", preview)
+	}
+	if n := cache.Stats().Entries; n != 1 {
+		t.Errorf("%d renders cached, want the post's one", n)
+	}
+}
@@ -0,0 +1,149 @@
+package gotests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/controllers"
+	authmw "anshumanbiswas.com/blog/middleware"
+	"anshumanbiswas.com/blog/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// postsAPI routes the v1 posts endpoints to controllers without services,
+// which is enough for requests rejected before reaching the database.
+func postsAPI(user *models.User) http.Handler {
+	return postsAPIWith(controllers.Users{}, user)
+}
+
+// postsAPIWith routes the v1 posts endpoints to u, as user.
+func postsAPIWith(u controllers.Users, user *models.User) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), authmw.UserContextKey, user))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Post("/api/v1/posts", u.APICreatePost)
+	r.Get("/api/v1/posts/{postID}", u.APIGetPost)
+	r.Patch("/api/v1/posts/{postID}", u.APIUpdatePost)
+	r.Delete("/api/v1/posts/{postID}", u.APIDeletePost)
+	return r
+}
+
+func apiError(t *testing.T, h http.Handler, method, path, body string) (int, controllers.APIError) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: Content-Type %q, body %s", method, path, ct, rec.Body)
+	}
+	var resp struct {
+		Error controllers.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: %v in %s", method, path, err, rec.Body)
+	}
+	if resp.Error.Status != rec.Code {
+		t.Errorf("%s %s: body status %d, response %d", method, path, resp.Error.Status, rec.Code)
+	}
+	return rec.Code, resp.Error
+}
+
+func TestPostsAPI_WritesNeedAnEditor(t *testing.T) {
+	for name, user := range map[string]*models.User{
+		"legacy token": nil,
+		"commenter":    {UserID: 5, Role: models.RoleCommenter},
+	} {
+		h := postsAPI(user)
+		for _, req := range [][3]string{
+			{"POST", "/api/v1/posts", `{"title":"x"}`},
+			{"PATCH", "/api/v1/posts/1", `{"title":"x"}`},
+			{"DELETE", "/api/v1/posts/1", ""},
+		} {
+			code, e := apiError(t, h, req[0], req[1], req[2])
+			if code != http.StatusForbidden || e.Code != "forbidden" {
+				t.Errorf("%s: %s %s = %d %+v", name, req[0], req[1], code, e)
+			}
+		}
+	}
+}
+
+func TestPostsAPI_BadRequests(t *testing.T) {
+	h := postsAPI(&models.User{UserID: 2, Role: models.RoleEditor})
+	for _, tc := range []struct {
+		method, path, body, code string
+	}{
+		{"GET", "/api/v1/posts/abc", "", "invalid_id"},
+		{"POST", "/api/v1/posts", `{"title":`, "invalid_json"},
+		{"POST", "/api/v1/posts", `{"title":"x","author":"me"}`, "invalid_json"},
+	} {
+		if code, e := apiError(t, h, tc.method, tc.path, tc.body); e.Code != tc.code || code >= 500 {
+			t.Errorf("%s %s %s = %d %+v, want %s", tc.method, tc.path, tc.body, code, e, tc.code)
+		}
+	}
+}
+
+func TestPostsAPI_FieldErrors(t *testing.T) {
+	h := postsAPI(&models.User{UserID: 2, Role: models.RoleEditor})
+	body := `{"title":"  ","content":"","slug":"Not A Slug","featured_image_url":"javascript:alert(1)","render_engine":"word","category_ids":[3,3]}`
+	code, e := apiError(t, h, "POST", "/api/v1/posts", body)
+	if code != http.StatusUnprocessableEntity || e.Code != "validation_failed" {
+		t.Fatalf("got %d %+v", code, e)
+	}
+	for _, field := range []string{"title", "content", "slug", "featured_image_url", "render_engine", "category_ids"} {
+		if e.Fields[field] == "" {
+			t.Errorf("no error for %s in %v", field, e.Fields)
+		}
+	}
+	if len(e.Fields) != 6 {
+		t.Errorf("unexpected field errors: %v", e.Fields)
+	}
+}
+
+func TestCreatePostFromFile_AuthorIsTokenUser(t *testing.T) {
+	db, _ := countingDB(t)
+	existing, editor := testPost(t, db)
+	posts := &models.PostService{DB: db}
+	u := controllers.Users{PostService: posts}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("title", editor.Username+" from file")
+	form.WriteField("user_id", strconv.Itoa(existing.UserID+1))
+	form.WriteField("category_id", strconv.Itoa(existing.CategoryID))
+	part, _ := form.CreateFormFile("file", "post.md")
+	part.Write([]byte("Uploaded.\n"))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/api/posts/from-file", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(context.WithValue(req.Context(), authmw.UserContextKey, editor))
+	rec := httptest.NewRecorder()
+	u.CreatePostFromFile(rec, req)
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.ID == 0 {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM posts WHERE post_id = $1`, resp.ID) })
+	post, err := posts.GetByID(resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.UserID != editor.UserID {
+		t.Errorf("author = %d, want the token's user %d", post.UserID, editor.UserID)
+	}
+}
@@ -0,0 +1,91 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+	"anshumanbiswas.com/blog/models"
+)
+
+func renderAST(content string, filters map[string]bool) string {
+	opt := render.DefaultOptions()
+	opt.Engine = render.EngineAST
+	opt.Filters = filters
+	return render.NewRenderer(opt).Render(content)
+}
+
+func TestASTEngine_Transforms(t *testing.T) {
+	src := "Intro <more--> text\n\n- [x] done\n- item\n\n1. one\n\n> quote\n\n" +
+		"https://www.youtube.com/watch?v=abc_123\n\n![Alt](/static/a.png)\n\n```mermaid\ngraph TD; A-->B\n```\n"
+	out := renderAST(src, nil)
+	for _, want := range []string{
+		`<p>Intro  text</p>`,
+		`<ul class="list-disc pl-2">`,
+		`<li class="task-item"><input type="checkbox" disabled="" checked="" class="mr-2 align-middle">done</li>`,
+		`<li class="mb-2">item</li>`,
+		`<ol class="list-decimal pl-2">`,
+		`<blockquote class="p-4 my-4`,
+		`<iframe src="https://www.youtube.com/embed/abc_123"`,
+		`<a href="/static/a.png" data-lightbox="article-images" rel="lightbox[article-images]"><img src="/static/a.png" alt="Alt"></a>`,
+		`<div class="mermaid">graph TD; A--&gt;B`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestASTEngine_LeavesCodeAlone(t *testing.T) {
+	src := "Use `a*b*c` here.\n\n```html\n<br>\n<div>- not a list</div>\n| a | b |\n```\n"
+	out := renderAST(src, nil)
+	if !strings.Contains(out, "<code>a*b*c</code>") {
+		t.Errorf("inline code was rewritten:\n%s", out)
+	}
+	if !strings.Contains(out, "&lt;br&gt;\n&lt;div&gt;- not a list&lt;/div&gt;\n| a | b |") {
+		t.Errorf("code block was rewritten:\n%s", out)
+	}
+}
+
+func TestASTEngine_FiltersSwitchTransformsOff(t *testing.T) {
+	out := renderAST("https://youtu.be/abc123\n\n- item\n", map[string]bool{
+		render.FilterYouTube:     false,
+		render.FilterListClasses: false,
+	})
+	if strings.Contains(out, "<iframe") || strings.Contains(out, "list-disc") {
+		t.Errorf("disabled transforms still ran:\n%s", out)
+	}
+}
+
+func TestRenderPostContent_Engines(t *testing.T) {
+	src := "Hello <script>alert(1)</script>\n"
+	for _, engine := range render.Engines {
+		if out := models.RenderPostContent(src, false, engine); strings.Contains(out, "<script") {
+			t.Errorf("%s: untrusted post kept script:\n%s", engine, out)
+		}
+		if out := models.RenderPostContent(src, true, engine); !strings.Contains(out, "<script>") {
+			t.Errorf("%s: trusted post lost script:\n%s", engine, out)
+		}
+	}
+	if render.ValidEngine("markdown") || !render.ValidEngine(render.EngineAST) {
+		t.Errorf("ValidEngine accepts the wrong names")
+	}
+}
+
+func TestCompareOutput(t *testing.T) {
+	if diff := render.CompareOutput("<p>a</p>\n<p>b</p>", "<p>a</p>  <p>b</p>\n"); diff != nil {
+		t.Errorf("whitespace between tags should not count: %v", diff)
+	}
+
+	before := "<h1>T</h1>\n<p>1</p>\n<p>2</p>\n<p>3</p>\n<p>4</p>\n<p>5</p>\n<p>old</p>"
+	after := "<h1>T</h1>\n<p>1</p>\n<p>2</p>\n<p>3</p>\n<p>4</p>\n<p>5</p>\n<p>new</p>"
+	diff := render.CompareOutput(before, after)
+	var got []string
+	for _, l := range diff {
+		got = append(got, l.Op+l.Text)
+	}
+	want := []string{"~…", " <p>4</p>", " <p>5</p>", "-<p>old</p>", "+<p>new</p>"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
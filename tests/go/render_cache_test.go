@@ -0,0 +1,78 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func TestRenderCache_LRU(t *testing.T) {
+	c := render.NewRenderCache(2)
+	r := render.NewRenderer(render.DefaultOptions())
+	a, b, d := r.CacheKey("a"), r.CacheKey("b"), r.CacheKey("d")
+	c.Add(a, "<p>a</p>", 0)
+	c.Add(b, "<p>b</p>", 0)
+	if _, ok := c.Get(a); !ok {
+		t.Fatal("a should be cached")
+	}
+	c.Add(d, "<p>d</p>", 0) // evicts b, the least recently used
+	if _, ok := c.Get(b); ok {
+		t.Error("b should have been evicted")
+	}
+	if html, ok := c.Get(d); !ok || html != "<p>d</p>" {
+		t.Errorf("d = %q, %v", html, ok)
+	}
+	want := render.CacheStats{Hits: 2, Misses: 1, Evictions: 1, Entries: 2, Capacity: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestRenderCache_KeyedByContentAndOptions(t *testing.T) {
+	cache := render.NewRenderCache(10)
+	render.SetRenderCache(cache)
+	defer render.SetRenderCache(nil)
+
+	opt := render.DefaultOptions()
+	sanitized := render.NewRenderer(opt)
+	opt.SanitizeHTML = false
+	trusted := render.NewRenderer(opt)
+	opt.Engine = render.EngineAST
+	ast := render.NewRenderer(opt)
+
+	src := "Hello <span onclick=\"x()\">there</span>\n"
+	first := sanitized.Render(src)
+	if again := sanitized.Render(src); again != first {
+		t.Errorf("cached HTML differs:\n%s\n%s", first, again)
+	}
+	if out := trusted.Render(src); !strings.Contains(out, "onclick") {
+		t.Errorf("trusted render served the sanitized HTML:\n%s", out)
+	}
+	ast.Render(src)
+	sanitized.Render(src + "More.\n")
+	if s := cache.Stats(); s.Hits != 1 || s.Misses != 4 || s.Entries != 4 {
+		t.Errorf("stats = %+v, want 1 hit and 4 misses", s)
+	}
+
+	// Keys are stable across renderers built with the same options.
+	if render.NewRenderer(render.DefaultOptions()).CacheKey(src) != sanitized.CacheKey(src) {
+		t.Error("cache key changed between identical renderers")
+	}
+	if k := sanitized.CacheKey(src).String(); !strings.HasPrefix(k, "v") || len(k) > 96 {
+		t.Errorf("key %q does not fit the rendered_key column", k)
+	}
+}
+
+func TestRenderCache_HasEmbeds(t *testing.T) {
+	for src, want := range map[string]bool{
+		"See https://vimeo.com/76979871 for the talk.":                 true,
+		"[gist](https://gist.github.com/octocat/6cad326836d38bd3a7ae)": true,
+		"Just https://example.com/page and text.":                      false,
+		"No links.": false,
+	} {
+		if got := render.HasEmbeds(src); got != want {
+			t.Errorf("HasEmbeds(%q) = %v, want %v", src, got, want)
+		}
+	}
+}
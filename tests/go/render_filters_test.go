@@ -0,0 +1,118 @@
+package gotests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func filterNames(filters []render.Filter) []string {
+	var names []string
+	for _, f := range filters {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestRenderFilters_BuiltinOrder(t *testing.T) {
+	pre := filterNames(render.DefaultRegistry.Pipeline(render.StagePre, nil))
+	wantPre := []string{"normalize", "admonitions", "math", "shortcodes", "strip_style_snippets", "more_tag", "unwrap_list_containers",
+		"list_separation", "loose_markdown_html", "inline_pipe_tables", "fences"}
+	if !reflect.DeepEqual(pre, wantPre) {
+		t.Errorf("pre filters = %v, want %v", pre, wantPre)
+	}
+	post := filterNames(render.DefaultRegistry.Pipeline(render.StagePost, nil))
+	wantPost := []string{"mermaid", "task_list", "youtube", "list_classes", "blockquote_classes",
+		"inline_emphasis", "lightbox", "oembed", "toc", "shortcode_html", "admonition_html"}
+	if !reflect.DeepEqual(post, wantPost) {
+		t.Errorf("post filters = %v, want %v", post, wantPost)
+	}
+}
+
+func TestRenderFilters_ConstraintsAndRegistrationOrder(t *testing.T) {
+	reg := render.NewRegistry()
+	add := func(name string, after, before []string) {
+		t.Helper()
+		err := reg.Register(render.Registration{
+			Filter: render.NewFilter(name, render.StagePost, func(s string) string { return s + name + ";" }),
+			After:  after,
+			Before: before,
+		})
+		if err != nil {
+			t.Fatalf("register %s: %v", name, err)
+		}
+	}
+	add("a", nil, nil)
+	add("b", nil, nil)
+	add("c", nil, []string{"a"})
+	add("d", []string{"missing"}, nil)
+
+	got := filterNames(reg.Pipeline(render.StagePost, nil))
+	if want := []string{"b", "c", "a", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+
+	if err := reg.Register(render.Registration{Filter: render.NewFilter("a", render.StagePost, strings.ToUpper)}); err == nil {
+		t.Errorf("duplicate names should be rejected")
+	}
+	err := reg.Register(render.Registration{
+		Filter: render.NewFilter("e", render.StagePost, strings.ToUpper),
+		After:  []string{"a"},
+		Before: []string{"c"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("cyclic constraints should be rejected, got %v", err)
+	}
+	if len(reg.Names()) != 4 {
+		t.Errorf("rejected filters should not be registered: %v", reg.Names())
+	}
+}
+
+func TestRenderFilters_PluginRunsInPipeline(t *testing.T) {
+	reg := render.NewRegistry()
+	reg.Register(render.Registration{Filter: render.NewFilter("shout", render.StagePre, strings.ToUpper)})
+	reg.Register(render.Registration{
+		Filter:   render.NewFilter("signature", render.StagePost, func(s string) string { return s + "<p>-- sig</p>" }),
+		Disabled: true,
+	})
+
+	r := render.NewRenderer(render.RendererOptions{Registry: reg})
+	if got := r.Render("hello"); !strings.Contains(got, "<p>HELLO</p>") || strings.Contains(got, "sig") {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	r = render.NewRenderer(render.RendererOptions{Registry: reg, Filters: map[string]bool{"shout": false, "signature": true}})
+	got, stages := r.RenderWithDebug("hello", true)
+	if !strings.Contains(got, "<p>hello</p>") || !strings.Contains(got, "-- sig") {
+		t.Errorf("configuration should override defaults, got %q", got)
+	}
+	if _, ok := stages["02_signature"]; !ok {
+		t.Errorf("debug stages should be keyed by filter name: %v", stages)
+	}
+}
+
+func TestRenderFilters_DisableBuiltin(t *testing.T) {
+	opt := render.DefaultOptions()
+	opt.Filters = map[string]bool{render.FilterYouTube: false}
+	out := render.NewRenderer(opt).Render("https://www.youtube.com/watch?v=abc123\n")
+	if strings.Contains(out, "<iframe") {
+		t.Errorf("youtube filter should be off:\n%s", out)
+	}
+}
+
+func TestRenderFilters_ParseConfig(t *testing.T) {
+	got, err := render.DefaultRegistry.ParseFilterConfig(" youtube=off, lightbox=on ,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := map[string]bool{"youtube": false, "lightbox": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"youtube", "nope=off", "youtube=maybe"} {
+		if _, err := render.DefaultRegistry.ParseFilterConfig(bad); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
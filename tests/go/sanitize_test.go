@@ -0,0 +1,104 @@
+package gotests
+
+import (
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/models"
+)
+
+func TestRenderContent_SanitizesUnsafeHTML(t *testing.T) {
+	in := "Hello <script>alert(1)</script>\n\n" +
+		`<img src="/static/a.png" onerror="alert(2)">` + "\n\n" +
+		`<a href="javascript:alert(3)">click</a>` + "\n\n" +
+		`<iframe src="https://evil.example/frame"></iframe>` + "\n"
+	out := models.RenderContent(in)
+
+	for _, banned := range []string{"<script", "alert(1)", "onerror", "javascript:", "evil.example"} {
+		if strings.Contains(out, banned) {
+			t.Errorf("sanitized output still contains %q:\n%s", banned, out)
+		}
+	}
+	if !strings.Contains(out, `src="/static/a.png"`) {
+		t.Errorf("safe image attributes should survive:\n%s", out)
+	}
+}
+
+func TestRenderContent_KeepsFilterOutput(t *testing.T) {
+	in := `<ul><li class="task-item"><input type="checkbox" disabled checked class="mr-2 align-middle">done</li></ul>` + "\n\n" +
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ\n\n" +
+		"```mermaid\ngraph TD; A-->B\n```\n\n" +
+		`<div class="image-gallery"><img src="/static/b.png" alt="B"></div>` + "\n\n" +
+		"![pic](/static/c.png)\n\n" +
+		`<h2 id="section">Section</h2>` + "\n"
+	out := models.RenderContent(in)
+
+	for _, want := range []string{
+		`<input type="checkbox" disabled="" checked="" class="mr-2 align-middle">`,
+		`<iframe src="https://www.youtube.com/embed/dQw4w9WgXcQ"`,
+		`allowfullscreen`,
+		`<div class="mermaid">`,
+		`data-lightbox="article-images"`,
+		`rel="lightbox[article-images]"`,
+		`<a href="/static/c.png" data-lightbox="article-images" rel="lightbox[article-images]"><img src="/static/c.png" alt="pic"`,
+		`<h2 id="user-content-section">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("sanitized output lost %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderContent_DropsForeignClassesAndIDs(t *testing.T) {
+	in := `<div class="fixed inset-0 z-50 admonition" id="login">Sign in</div>` + "\n\n" +
+		`<h2 id="intro" class="navbar">Intro</h2>` + "\n\n" +
+		`<p id="user-content-p">See <a href="#intro">the intro</a>.</p>` + "\n"
+	out := models.RenderContent(in)
+
+	for _, banned := range []string{"fixed", "inset-0", "z-50", "navbar", `id="login"`, `id="user-content-p"`} {
+		if strings.Contains(out, banned) {
+			t.Errorf("sanitized output still contains %q:\n%s", banned, out)
+		}
+	}
+	for _, want := range []string{`<div class="admonition">`, `<h2 id="user-content-intro">`, `href="#user-content-intro"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("sanitized output lost %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderTrustedContent_SkipsSanitizer(t *testing.T) {
+	in := "<script>window.answer = 42</script>\n"
+	if out := models.RenderTrustedContent(in); !strings.Contains(out, "<script>window.answer = 42</script>") {
+		t.Errorf("trusted content should keep raw HTML:\n%s", out)
+	}
+}
+
+func TestRawHTMLPermission_Defaults(t *testing.T) {
+	if !models.DefaultPermissions(models.RoleAdministrator).CanPostRawHTML {
+		t.Errorf("administrators should be trusted with raw HTML by default")
+	}
+	for _, role := range []int{models.RoleEditor, models.RoleViewer, models.RoleCommenter} {
+		if models.DefaultPermissions(role).CanPostRawHTML {
+			t.Errorf("role %d should not bypass the sanitizer by default", role)
+		}
+	}
+	p := models.PermissionsFromNames([]string{models.PermRawHTML})
+	if !p.CanPostRawHTML || !p.Has(models.PermRawHTML) {
+		t.Errorf("raw_html permission did not round-trip")
+	}
+}
+
+func TestTrustsHTML_OnlyTheAuthor(t *testing.T) {
+	admin := &models.User{UserID: 1, Role: models.RoleAdministrator}
+	editor := &models.User{UserID: 2, Role: models.RoleEditor}
+	if !models.TrustsHTML(admin, admin.UserID) {
+		t.Errorf("an administrator's own post should be trusted")
+	}
+	if models.TrustsHTML(admin, editor.UserID) {
+		t.Errorf("an administrator's edit should not trust an editor's raw HTML")
+	}
+	if models.TrustsHTML(editor, editor.UserID) || models.TrustsHTML(nil, editor.UserID) {
+		t.Errorf("only roles with raw HTML may be trusted")
+	}
+}
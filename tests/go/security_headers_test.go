@@ -0,0 +1,152 @@
+package gotests
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/controllers"
+	authmw "anshumanbiswas.com/blog/middleware"
+	"anshumanbiswas.com/blog/templates"
+	"anshumanbiswas.com/blog/views"
+)
+
+func serveWithSecurity(cfg authmw.SecurityConfig, h http.HandlerFunc) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	authmw.SecurityHeaders(cfg)(h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestSecurityHeaders_Defaults(t *testing.T) {
+	var nonce string
+	rec := serveWithSecurity(authmw.DefaultSecurityConfig(), func(w http.ResponseWriter, r *http.Request) {
+		nonce = authmw.CSPNonce(r)
+	})
+	h := rec.Header()
+
+	for name, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"X-Frame-Options":           "SAMEORIGIN",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if h.Get("Content-Security-Policy") != "" {
+		t.Errorf("default mode should only report, not enforce")
+	}
+	policy := h.Get("Content-Security-Policy-Report-Only")
+	if nonce == "" || !strings.Contains(policy, "'nonce-"+nonce+"'") {
+		t.Errorf("policy should carry the request nonce %q: %s", nonce, policy)
+	}
+	if !strings.Contains(policy, "report-uri "+authmw.CSPReportPath) {
+		t.Errorf("policy should report to %s: %s", authmw.CSPReportPath, policy)
+	}
+}
+
+func TestSecurityHeaders_NonceChangesPerRequest(t *testing.T) {
+	cfg := authmw.DefaultSecurityConfig()
+	cfg.CSPMode = authmw.CSPEnforce
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	first := serveWithSecurity(cfg, noop).Header().Get("Content-Security-Policy")
+	second := serveWithSecurity(cfg, noop).Header().Get("Content-Security-Policy")
+	if first == "" || first == second {
+		t.Errorf("each request needs its own nonce:\n%s\n%s", first, second)
+	}
+}
+
+func TestSecurityHeaders_CSPOff(t *testing.T) {
+	cfg := authmw.DefaultSecurityConfig()
+	cfg.CSPMode = authmw.CSPOff
+	cfg.HSTSMaxAge = 0
+	var nonce string
+	rec := serveWithSecurity(cfg, func(w http.ResponseWriter, r *http.Request) {
+		nonce = authmw.CSPNonce(r)
+	})
+	h := rec.Header()
+	if h.Get("Content-Security-Policy") != "" || h.Get("Content-Security-Policy-Report-Only") != "" || nonce != "" {
+		t.Errorf("CSP should be absent when off")
+	}
+	if h.Get("Strict-Transport-Security") != "" {
+		t.Errorf("HSTS should be absent with a zero max age")
+	}
+	if h.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("other headers should still be set")
+	}
+}
+
+func TestSecurityHeaders_NonceReachesTemplates(t *testing.T) {
+	tpl := views.Must(views.ParseFS(templates.FS, "NotFoundPage.gohtml"))
+	var nonce string
+	rec := serveWithSecurity(authmw.DefaultSecurityConfig(), func(w http.ResponseWriter, r *http.Request) {
+		nonce = authmw.CSPNonce(r)
+		tpl.Execute(w, r, nil)
+	})
+	if !strings.Contains(rec.Body.String(), `<script nonce="`+nonce+`">`) {
+		t.Errorf("inline script should carry the nonce %q", nonce)
+	}
+}
+
+// The nonce only covers <script> elements, so an enforced policy blocks
+// inline event handlers.
+func TestTemplates_NoInlineEventHandlers(t *testing.T) {
+	handler := regexp.MustCompile(`\son[a-z]+\s*=\s*["']`)
+	names, err := fs.Glob(templates.FS, "*.gohtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		src, err := fs.ReadFile(templates.FS, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, line := range strings.Split(string(src), "\n") {
+			if m := handler.FindString(line); m != "" {
+				t.Errorf("%s:%d: inline handler %q", name, i+1, strings.TrimSpace(m))
+			}
+		}
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"report-uri", "application/csp-report",
+			`{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"inline","violated-directive":"script-src"}}`,
+			http.StatusNoContent},
+		{"reporting api", "application/reports+json",
+			`[{"type":"csp-violation","body":{"documentURL":"https://example.com/","blockedURL":"https://evil.example/x.js","effectiveDirective":"script-src-elem"}}]`,
+			http.StatusNoContent},
+		{"malformed", "application/csp-report", `not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, authmw.CSPReportPath, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			controllers.CSPReport(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRF_ExemptsCSPReports(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, authmw.CSPReportPath, strings.NewReader(`{"csp-report":{}}`))
+	req.Header.Set("Content-Type", "application/csp-report")
+	rec := httptest.NewRecorder()
+	csrfTestHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected CSP report to skip CSRF check, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package gotests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+func TestShortcodes_BuiltIns(t *testing.T) {
+	src := "{{< gist octocat 6cad326836d38bd3a7ae file=\"hello.go\" >}}\n\n" +
+		"{{< figure src=\"/static/uploads/chart.png\" caption=\"Requests per second\" width=600 >}}\n\n" +
+		"{{< slide intro-to-go >}}\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{
+			`<a href="https://gist.github.com/octocat/6cad326836d38bd3a7ae#file-hello-go"`,
+			`<figure class="figure"><img src="/static/uploads/chart.png" alt="Requests per second" loading="lazy" width="600"><figcaption>Requests per second</figcaption></figure>`,
+			`<iframe src="/slides/intro-to-go"`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+		if strings.Contains(out, "{{<") || strings.Contains(out, "shortcode:") {
+			t.Errorf("%s: shortcode left in output:\n%s", engine, out)
+		}
+	}
+}
+
+func TestShortcodes_CalloutWrapsMarkdown(t *testing.T) {
+	src := "{{< callout warning title=\"Before you start\" >}}\nBack up **first**.\n{{< /callout >}}\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		want := `<div class="callout callout-warning"><p class="callout-title">Before you start</p>`
+		if !strings.Contains(out, want) || !strings.Contains(out, "<p>Back up <strong>first</strong>.</p>") ||
+			!strings.HasSuffix(strings.TrimSpace(out), "</div>") {
+			t.Errorf("%s: unexpected callout:\n%s", engine, out)
+		}
+	}
+}
+
+func TestShortcodes_NotExpandedInCode(t *testing.T) {
+	src := "Write `{{< slide my-deck >}}` to embed.\n\n```\n{{< callout >}}x{{< /callout >}}\n```\n"
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		if strings.Contains(out, "<iframe") || strings.Contains(out, `class="callout`) {
+			t.Errorf("%s: shortcode in code was expanded:\n%s", engine, out)
+		}
+		if !strings.Contains(out, "{{&lt; slide my-deck &gt;}}") {
+			t.Errorf("%s: code lost its text:\n%s", engine, out)
+		}
+	}
+	if errs := render.ShortcodeErrors(src); len(errs) != 0 {
+		t.Errorf("errors for code = %v", errs)
+	}
+}
+
+func TestShortcodes_Errors(t *testing.T) {
+	src := "Intro\n\n{{< figure caption=x >}}\n\n{{< nope >}}\n\n{{< slide Bad_Slug >}}\n\n{{< callout >}}open\n"
+	errs := render.ShortcodeErrors(src)
+	want := []render.ShortcodeError{
+		{Line: 3, Shortcode: "figure", Message: `missing argument "src"`},
+		{Line: 5, Shortcode: "nope", Message: "unknown shortcode"},
+		{Line: 7, Shortcode: "slide", Message: `argument "deck": "Bad_Slug" is not valid`},
+		{Line: 9, Shortcode: "callout", Message: "missing {{< /callout >}}"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v, want %v", errs, want)
+	}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("error %d = %+v, want %+v", i, errs[i], want[i])
+		}
+	}
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		if !strings.Contains(out, `<code class="shortcode-error" title="unknown shortcode">{{&lt; nope &gt;}}</code>`) {
+			t.Errorf("%s: error not shown as source:\n%s", engine, out)
+		}
+	}
+}
+
+func TestShortcodes_Register(t *testing.T) {
+	err := render.RegisterShortcode(render.Shortcode{
+		Name: "test-badge",
+		Args: []render.ShortcodeArg{
+			{Name: "label", Required: true},
+			{Name: "count", Kind: render.ArgInt, Default: "1"},
+			{Name: "new", Kind: render.ArgBool},
+		},
+		Render: func(args render.ShortcodeArgs) (string, error) {
+			if args.Int("count") > 99 {
+				return "", errors.New("count too large")
+			}
+			out := `<span class="badge">` + args.String("label") + " " + strings.Repeat("*", args.Int("count"))
+			if args.Bool("new") {
+				out += " new"
+			}
+			return out + "</span>", nil
+		},
+		Classes: []string{"badge"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := render.RegisterShortcode(render.Shortcode{Name: "test-badge", Render: func(render.ShortcodeArgs) (string, error) { return "", nil }}); err == nil {
+		t.Error("duplicate registration should fail")
+	}
+	if err := render.RegisterShortcode(render.Shortcode{Name: "test-empty"}); err == nil {
+		t.Error("a shortcode without Render or Wrap should fail")
+	}
+
+	out := renderWithEngine(render.EngineLegacy, `A {{< test-badge "Go tips" count=3 new=true >}} B {{< test-badge x >}}`)
+	for _, want := range []string{`<span class="badge">Go tips *** new</span>`, `<span class="badge">x *</span>`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	for src, msg := range map[string]string{
+		`{{< test-badge x count=many >}}`: `argument "count": "many" is not an integer`,
+		`{{< test-badge x count=100 >}}`:  "count too large",
+		`{{< test-badge x y z w >}}`:      "too many arguments",
+		`{{< test-badge x size=2 >}}`:     `unknown argument "size"`,
+	} {
+		errs := render.ShortcodeErrors(src)
+		if len(errs) != 1 || errs[0].Message != msg {
+			t.Errorf("%s: errors = %v, want %q", src, errs, msg)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gotests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/sso"
+	"anshumanbiswas.com/blog/sso/ssotest"
+)
+
+const ssoRedirectURL = "http://blog.test/auth/mock/callback"
+
+// authorize runs the browser leg against the mock IdP and returns the code
+// and state it redirects back with.
+func authorize(t *testing.T, p *sso.Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), ssoRedirectURL, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("auth url: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("nonce") != nonce {
+		t.Fatalf("auth url missing PKCE or nonce: %s", authURL)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect from authorize, got %d", resp.StatusCode)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func newMockSSO(t *testing.T) (*ssotest.Provider, *sso.Provider) {
+	t.Helper()
+	idp := ssotest.NewProvider("blog", "secret")
+	t.Cleanup(idp.Close)
+	p := sso.NewProvider(sso.ProviderConfig{
+		Name:         "mock",
+		Issuer:       idp.Issuer(),
+		ClientID:     "blog",
+		ClientSecret: "secret",
+		RoleMapping:  map[string]int{"blog-admins": models.RoleAdministrator, "blog-editors": models.RoleEditor},
+	})
+	return idp, p
+}
+
+func TestSSO_CodeFlowWithPKCE(t *testing.T) {
+	idp, p := newMockSSO(t)
+	idp.SetUser(ssotest.User{Subject: "u-1", Email: "Jane@Example.com", EmailVerified: true, Name: "Jane", Groups: []string{"blog-editors"}})
+
+	nonce, verifier, err := sso.NewLoginSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorize(t, p, "state-1", nonce, verifier)
+	if state != "state-1" {
+		t.Fatalf("state not echoed back: %q", state)
+	}
+
+	identity, err := p.Exchange(context.Background(), ssoRedirectURL, code, nonce, verifier)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if identity.Subject != "u-1" || identity.Email != "jane@example.com" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	if got := p.RoleFor(identity); got != models.RoleEditor {
+		t.Fatalf("expected editor role from groups, got %d", got)
+	}
+}
+
+func TestSSO_RejectsWrongCodeVerifier(t *testing.T) {
+	_, p := newMockSSO(t)
+	nonce, verifier, _ := sso.NewLoginSecrets()
+	code, _ := authorize(t, p, "s", nonce, verifier)
+
+	_, otherVerifier, _ := sso.NewLoginSecrets()
+	if _, err := p.Exchange(context.Background(), ssoRedirectURL, code, nonce, otherVerifier); err == nil {
+		t.Fatalf("expected exchange with the wrong PKCE verifier to fail")
+	}
+}
+
+func TestSSO_RejectsNonceMismatch(t *testing.T) {
+	_, p := newMockSSO(t)
+	nonce, verifier, _ := sso.NewLoginSecrets()
+	code, _ := authorize(t, p, "s", nonce, verifier)
+
+	_, err := p.Exchange(context.Background(), ssoRedirectURL, code, "some-other-nonce", verifier)
+	if !errors.Is(err, sso.ErrNonceMismatch) {
+		t.Fatalf("expected nonce mismatch, got %v", err)
+	}
+}
+
+func TestSSO_RoleForPicksMostPrivilegedGroup(t *testing.T) {
+	_, p := newMockSSO(t)
+	cases := []struct {
+		groups []string
+		want   int
+	}{
+		{nil, models.RoleCommenter},
+		{[]string{"unmapped"}, models.RoleCommenter},
+		{[]string{"blog-editors", "blog-admins"}, models.RoleAdministrator},
+	}
+	for _, c := range cases {
+		if got := p.RoleFor(&sso.Identity{Groups: c.groups}); got != c.want {
+			t.Errorf("groups %v: expected role %d, got %d", c.groups, c.want, got)
+		}
+	}
+}
+
+func TestSSO_ConfigsFromEnv(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "corp, google")
+	t.Setenv("OIDC_CORP_ISSUER", "https://idp.corp.example")
+	t.Setenv("OIDC_CORP_CLIENT_ID", "blog")
+	t.Setenv("OIDC_CORP_ROLE_MAP", "admins:administrator, writers:editor, readers:4")
+	t.Setenv("OIDC_CORP_DEFAULT_ROLE", "viewer")
+	t.Setenv("OIDC_CORP_SYNC_ROLES", "true")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "blog-google")
+
+	cfgs, err := sso.ConfigsFromEnv()
+	if err != nil {
+		t.Fatalf("configs: %v", err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(cfgs))
+	}
+	corp := cfgs[0]
+	if corp.RoleMapping["admins"] != models.RoleAdministrator || corp.RoleMapping["writers"] != models.RoleEditor ||
+		corp.RoleMapping["readers"] != models.RoleViewer {
+		t.Fatalf("unexpected role mapping: %v", corp.RoleMapping)
+	}
+	if corp.DefaultRole != models.RoleViewer || !corp.SyncRoles {
+		t.Fatalf("unexpected defaults: %+v", corp)
+	}
+
+	t.Setenv("OIDC_CORP_ROLE_MAP", "admins:superuser")
+	if _, err := sso.ConfigsFromEnv(); err == nil {
+		t.Fatalf("expected unknown role to be rejected")
+	}
+}
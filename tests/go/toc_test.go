@@ -0,0 +1,101 @@
+package gotests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"anshumanbiswas.com/blog/internal/render"
+)
+
+const tocSource = "[TOC depth=2]\n\n## Intro\n\ntext\n\n### Deep *dive*\n\n#### Too deep\n\n## Intro\n"
+
+func TestTOC_AnchorsAndUniqueIDs(t *testing.T) {
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, tocSource)
+		for _, want := range []string{
+			`<h2 id="user-content-intro">Intro<a`,
+			`href="#user-content-intro"`,
+			`<h3 id="user-content-deep-dive">Deep <em>dive</em><a`,
+			`<h2 id="user-content-intro-1">Intro<a`,
+			`class="heading-anchor"`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q in:\n%s", engine, want, out)
+			}
+		}
+	}
+}
+
+func TestTOC_ManyRepeatedHeadings(t *testing.T) {
+	// A heading that already looks like a numbered repeat takes that number.
+	src := "## Intro 1\n\n" + strings.Repeat("## Intro\n\n", 5000)
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, src)
+		for _, want := range []string{`id="user-content-intro-1">Intro 1<a`, `id="user-content-intro">Intro<a`, `id="user-content-intro-2">Intro<a`, `id="user-content-intro-4999">Intro<a`, `id="user-content-intro-5000">Intro<a`} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q", engine, want)
+			}
+		}
+		if strings.Count(out, `id="user-content-intro-2"`) != 1 || strings.Contains(out, `id="user-content-intro-5001"`) {
+			t.Errorf("%s: heading IDs are not unique", engine)
+		}
+	}
+}
+
+func TestTOC_MarkerPlacesContentsToDepth(t *testing.T) {
+	for _, engine := range render.Engines {
+		out := renderWithEngine(engine, tocSource)
+		want := `<nav class="toc"><p class="toc-title">Contents</p><ul>` +
+			`<li class="toc-depth-0"><a href="#user-content-intro">Intro</a></li>` +
+			`<li class="toc-depth-1"><a href="#user-content-deep-dive">Deep dive</a></li>` +
+			`<li class="toc-depth-0"><a href="#user-content-intro-1">Intro</a></li></ul></nav>`
+		if !strings.Contains(out, want) {
+			t.Errorf("%s: missing TOC in:\n%s", engine, out)
+		}
+		if strings.Contains(out, "[TOC") {
+			t.Errorf("%s: marker left in output:\n%s", engine, out)
+		}
+	}
+}
+
+func TestTOC_Outline(t *testing.T) {
+	out := renderWithEngine(render.EngineLegacy, tocSource)
+	got := render.Outline(out, render.TOCDepth(tocSource))
+	want := []render.TOCEntry{
+		{Level: 2, Depth: 0, ID: "user-content-intro", Text: "Intro"},
+		{Level: 3, Depth: 1, ID: "user-content-deep-dive", Text: "Deep dive"},
+		{Level: 2, Depth: 0, ID: "user-content-intro-1", Text: "Intro"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outline = %+v, want %+v", got, want)
+	}
+	if n := len(render.Outline(out, render.DefaultTOCDepth)); n != 4 {
+		t.Errorf("default depth should include h4, got %d entries", n)
+	}
+}
+
+func TestTOC_Depth(t *testing.T) {
+	for src, want := range map[string]int{
+		"no marker":                          render.DefaultTOCDepth,
+		"[TOC]":                              render.DefaultTOCDepth,
+		"[TOC depth=1]":                      1,
+		"<!-- toc depth=4 -->\n\n## A\n":     4,
+		"Use [TOC depth=9] for nothing here": render.DefaultTOCDepth,
+	} {
+		if got := render.TOCDepth(src); got != want {
+			t.Errorf("TOCDepth(%q) = %d, want %d", src, got, want)
+		}
+	}
+}
+
+// Headings from pasted HTML get IDs in the legacy engine too, and IDs the
+// sanitizer would drop are replaced.
+func TestTOC_LegacyHTMLHeadings(t *testing.T) {
+	out := renderWithEngine(render.EngineLegacy, "<h2>Pasted heading</h2>\n\n## Café & co\n")
+	for _, want := range []string{`<h2 id="user-content-pasted-heading">`, `<h2 id="user-content-caf-co">`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package gotests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"anshumanbiswas.com/blog/controllers"
+	"anshumanbiswas.com/blog/models"
+	"anshumanbiswas.com/blog/utils"
+	"github.com/pquerna/otp/totp"
+)
+
+// enrolledUser creates a user with TOTP enabled and returns them with their
+// secret, their recovery codes and the time whose code confirmed the
+// enrollment.
+func enrolledUser(t *testing.T, tfs *models.TwoFactorService) (*models.User, string, []string, time.Time) {
+	t.Helper()
+	users := &models.UserService{DB: tfs.DB}
+	name := fmt.Sprintf("tfa%d", time.Now().UnixNano())
+	user, err := users.Create(name+"@example.com", name, "correct horse battery", models.RoleEditor)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { tfs.DB.Exec(`DELETE FROM users WHERE user_id = $1`, user.UserID) })
+
+	enrollment, err := tfs.BeginEnrollment(user)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	now := time.Now()
+	code, err := totp.GenerateCode(enrollment.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := tfs.ConfirmEnrollment(user.UserID, code)
+	if err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+	return user, enrollment.Secret, recovery, now
+}
+
+func TestTwoFactorVerify_TOTPCodesAreSingleUse(t *testing.T) {
+	db, _ := countingDB(t)
+	tfs := &models.TwoFactorService{DB: db}
+	user, secret, _, enrolled := enrolledUser(t, tfs)
+
+	// The code that confirmed the enrollment is spent.
+	spent, _ := totp.GenerateCode(secret, enrolled)
+	if err := tfs.Verify(user.UserID, spent); !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		t.Fatalf("code from the enrollment step: %v", err)
+	}
+
+	// The next step is within the allowed drift, and entered with spaces.
+	next, _ := totp.GenerateCode(secret, enrolled.Add(30*time.Second))
+	if err := tfs.Verify(user.UserID, " "+next[:3]+" "+next[3:]+" "); err != nil {
+		t.Fatalf("next step: %v", err)
+	}
+	if err := tfs.Verify(user.UserID, next); !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed code: %v", err)
+	}
+	stale, _ := totp.GenerateCode(secret, time.Now().Add(-10*time.Minute))
+	if err := tfs.Verify(user.UserID, stale); !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		t.Fatalf("code from ten minutes ago: %v", err)
+	}
+}
+
+var recoveryCodeRe = regexp.MustCompile(`^[a-hj-km-np-z2-9]{5}-[a-hj-km-np-z2-9]{5}$`)
+
+func TestTwoFactorVerify_RecoveryCodesAreSingleUse(t *testing.T) {
+	db, _ := countingDB(t)
+	tfs := &models.TwoFactorService{DB: db}
+	user, _, recovery, _ := enrolledUser(t, tfs)
+	if len(recovery) != models.RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(recovery))
+	}
+	for _, code := range recovery {
+		if !recoveryCodeRe.MatchString(code) {
+			t.Errorf("recovery code %q is not xxxxx-xxxxx", code)
+		}
+	}
+
+	// Case, spaces and the dash don't matter.
+	typed := " " + strings.ToUpper(strings.ReplaceAll(recovery[0], "-", " ")) + " "
+	if err := tfs.Verify(user.UserID, typed); err != nil {
+		t.Fatalf("recovery code %q: %v", typed, err)
+	}
+	if err := tfs.Verify(user.UserID, recovery[0]); !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		t.Fatalf("reused recovery code: %v", err)
+	}
+	if n, err := tfs.RemainingRecoveryCodes(user.UserID); err != nil || n != models.RecoveryCodeCount-1 {
+		t.Fatalf("remaining = %d, %v", n, err)
+	}
+	if err := tfs.Verify(user.UserID, recovery[1]); err != nil {
+		t.Fatalf("second recovery code: %v", err)
+	}
+}
+
+func TestTwoFactorChallenge_AttemptCap(t *testing.T) {
+	db, _ := countingDB(t)
+	tfs := &models.TwoFactorService{DB: db}
+	user, _, _, _ := enrolledUser(t, tfs)
+
+	token, err := tfs.CreateChallenge(user.UserID)
+	if err != nil {
+		t.Fatalf("create challenge: %v", err)
+	}
+	for want := models.MaxTwoFactorAttempts - 1; want >= 0; want-- {
+		remaining, err := tfs.UseChallengeAttempt(token)
+		if err != nil || remaining != want {
+			t.Fatalf("remaining = %d, %v; want %d", remaining, err, want)
+		}
+	}
+	if _, err := tfs.UseChallengeAttempt(token); !errors.Is(err, models.ErrTooManyTwoFactorAttempts) {
+		t.Fatalf("attempt past the cap: %v", err)
+	}
+	if _, err := tfs.ChallengeUser(token); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("challenge survived the cap: %v", err)
+	}
+}
+
+func TestDisableTwoFactor_IsAudited(t *testing.T) {
+	db, _ := countingDB(t)
+	tfs := &models.TwoFactorService{DB: db}
+	user, _, _, _ := enrolledUser(t, tfs)
+	sessions := &models.SessionService{DB: db}
+	session, err := sessions.Create(user.UserID)
+	if err != nil || session == nil {
+		t.Fatalf("create session: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM sessions WHERE user_id = $1`, user.UserID)
+		db.Exec(`DELETE FROM audit_events WHERE target_type = 'user' AND target_id = $1`, strconv.Itoa(user.UserID))
+	})
+	audit := &models.AuditService{DB: db}
+	u := controllers.Users{
+		SessionService:   sessions,
+		UserService:      &models.UserService{DB: db},
+		TwoFactorService: tfs,
+		AuditService:     audit,
+	}
+
+	form := url.Values{"password": {"correct horse battery"}}
+	req := httptest.NewRequest("POST", "/users/2fa/disable", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: utils.CookieSession, Value: session.Token})
+	req.AddCookie(&http.Cookie{Name: utils.CookieUserEmail, Value: user.Email})
+	u.DisableTwoFactor(httptest.NewRecorder(), req)
+
+	if enabled, _ := tfs.IsEnabled(user.UserID); enabled {
+		t.Fatal("two-factor authentication is still enabled")
+	}
+	events, err := audit.List(models.AuditFilter{Action: models.AuditUserTwoFactorDisable, TargetID: strconv.Itoa(user.UserID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ActorID != user.UserID {
+		t.Errorf("audit events = %+v, want one by the user", events)
+	}
+}
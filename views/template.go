@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 
+	authmw "anshumanbiswas.com/blog/middleware"
 	"github.com/gorilla/csrf"
 )
 
@@ -33,6 +34,12 @@ func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
 			"csrfField": func() template.HTML {
 				return `<input type="hidden" />`
 			},
+			"csrfToken": func() string {
+				return ""
+			},
+			"cspNonce": func() string {
+				return ""
+			},
 			"contains": func(s, substr string) bool {
 				return strings.Contains(s, substr)
 			},
@@ -92,6 +99,12 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data interface
 			"csrfField": func() template.HTML {
 				return csrf.TemplateField(r)
 			},
+			"csrfToken": func() string {
+				return csrf.Token(r)
+			},
+			"cspNonce": func() string {
+				return authmw.CSPNonce(r)
+			},
 			"contains": func(s, substr string) bool {
 				return strings.Contains(s, substr)
 			},